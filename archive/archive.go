@@ -0,0 +1,210 @@
+// SPDX-License-Identifier: MIT
+
+// Package archive reads and writes feed archives, to move feeds between bots without replicating over the network.
+//
+// An archive is newline-delimited JSON. The first line is the Header, which lists the feeds in it.
+// Each following line is an Entry, either a message in the encoding it is verified from
+// (the same that createHistoryStream sends) or a blob.
+// Messages of a feed are in order but the feeds can be interleaved.
+package archive
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+
+	"github.com/pkg/errors"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/bendybutt"
+	"go.cryptoscope.co/ssb/message/multimsg"
+)
+
+// Format and Version identify an archive
+const (
+	Format  = "ssb-archive"
+	Version = 1
+)
+
+// Header is the first line of an archive
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+
+	Feeds []Feed `json:"feeds"`
+}
+
+// Feed says which messages of a feed are in the archive
+type Feed struct {
+	ID   string `json:"id"`
+	From int64  `json:"from"` // sequence of the first message
+	To   int64  `json:"to"`   // sequence of the last message
+}
+
+// Entry is one message or blob
+type Entry struct {
+	Feed     string `json:"feed,omitempty"`
+	Sequence int64  `json:"sequence,omitempty"`
+
+	// the message as it was signed, JSON formats use Message, binary ones Binary
+	Message json.RawMessage `json:"message,omitempty"`
+	Binary  []byte          `json:"binary,omitempty"`
+
+	Blob string `json:"blob,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// IsBlob returns true if the entry holds a blob instead of a message
+func (e Entry) IsBlob() bool { return e.Blob != "" }
+
+// Transfer returns the message in the form message.NewVerifySink expects for the format of its feed
+func (e Entry) Transfer() interface{} {
+	if e.Binary != nil {
+		return e.Binary
+	}
+	return e.Message
+}
+
+// Writer writes an archive
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter writes the header to w
+func NewWriter(w io.Writer, feeds []Feed) (*Writer, error) {
+	enc := json.NewEncoder(w)
+	err := enc.Encode(Header{
+		Format:  Format,
+		Version: Version,
+		Feeds:   feeds,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "archive: failed to write header")
+	}
+	return &Writer{enc: enc}, nil
+}
+
+// WriteMessage adds msg to the archive
+func (aw *Writer) WriteMessage(msg refs.Message) error {
+	data, binary, err := Encode(msg)
+	if err != nil {
+		return err
+	}
+	e := Entry{
+		Feed:     msg.Author().Ref(),
+		Sequence: msg.Seq(),
+	}
+	if binary {
+		e.Binary = data
+	} else {
+		e.Message = data
+	}
+	return errors.Wrapf(aw.enc.Encode(e), "archive: failed to write message %s", msg.Key().Ref())
+}
+
+// WriteBlob adds the blob ref with its content data to the archive
+func (aw *Writer) WriteBlob(ref *refs.BlobRef, data []byte) error {
+	err := aw.enc.Encode(Entry{Blob: ref.Ref(), Data: data})
+	return errors.Wrapf(err, "archive: failed to write blob %s", ref.Ref())
+}
+
+// Reader reads an archive
+type Reader struct {
+	Header Header
+
+	dec *json.Decoder
+}
+
+// NewReader reads and checks the header from r
+func NewReader(r io.Reader) (*Reader, error) {
+	ar := Reader{dec: json.NewDecoder(r)}
+	if err := ar.dec.Decode(&ar.Header); err != nil {
+		return nil, errors.Wrap(err, "archive: failed to read header")
+	}
+	if ar.Header.Format != Format {
+		return nil, errors.Errorf("archive: not an archive (format:%q)", ar.Header.Format)
+	}
+	if ar.Header.Version != Version {
+		return nil, errors.Errorf("archive: unsupported version %d", ar.Header.Version)
+	}
+	return &ar, nil
+}
+
+// Next returns the next entry or io.EOF at the end of the archive
+func (ar *Reader) Next() (*Entry, error) {
+	var e Entry
+	err := ar.dec.Decode(&e)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "archive: broken entry")
+	}
+	if e.IsBlob() == (e.Feed != "") {
+		return nil, errors.Errorf("archive: entry needs to be either a message or a blob")
+	}
+	return &e, nil
+}
+
+// Encode returns msg in the encoding it is verified from and if that is binary
+func Encode(msg refs.Message) ([]byte, bool, error) {
+	if mm, ok := msg.(*multimsg.MultiMessage); ok {
+		msg = mm.Message
+	}
+
+	algo := msg.Author().Algo
+	switch algo {
+	case refs.RefAlgoFeedSSB1:
+		return msg.ValueContentJSON(), false, nil
+
+	case refs.RefAlgoFeedGabby:
+		tr, ok := msg.(*gabbygrove.Transfer)
+		if !ok {
+			return nil, false, errors.Errorf("archive: expected gabbygrove transfer - got %T", msg)
+		}
+		data, err := tr.MarshalCBOR()
+		return data, true, errors.Wrap(err, "archive: failed to marshal transfer")
+
+	case ssb.RefAlgoFeedBendyButt:
+		bb, ok := msg.(*bendybutt.Message)
+		if !ok {
+			return nil, false, errors.Errorf("archive: expected bendybutt message - got %T", msg)
+		}
+		return bb.Raw(), true, nil
+
+	default:
+		ff, ok := ssb.GetFeedFormat(algo)
+		if !ok {
+			return nil, false, errors.Errorf("archive: unsupported feed format %s", algo)
+		}
+		data, err := ff.Encode(msg)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "archive: failed to encode %s message", algo)
+		}
+		return data, ff.Binary(), nil
+	}
+}
+
+var blobRefs = regexp.MustCompile(`&[A-Za-z0-9+/]{43}=\.sha256`)
+
+// BlobRefs returns the blobs that content mentions, without duplicates
+func BlobRefs(content []byte) []*refs.BlobRef {
+	var (
+		found []*refs.BlobRef
+		seen  = make(map[string]struct{})
+	)
+	for _, m := range blobRefs.FindAll(content, -1) {
+		if _, has := seen[string(m)]; has {
+			continue
+		}
+		seen[string(m)] = struct{}{}
+		ref, err := refs.ParseBlobRef(string(m))
+		if err != nil {
+			continue
+		}
+		found = append(found, ref)
+	}
+	return found
+}
@@ -0,0 +1,69 @@
+// SPDX-License-Identifier: MIT
+
+package archive
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReader(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	var buf bytes.Buffer
+	_, err := NewWriter(&buf, []Feed{{ID: "@p13zSAiOpguI9nsawkGijsnMfWmFd5rlUNpzekEE+vI=.ed25519", From: 1, To: 2}})
+	r.NoError(err)
+	buf.WriteString(`{"blob":"&Ou364gh9oMmjRDUaUKeXlVZzYiEdjEz00NEGXaRtnrQ=.sha256","data":"aGk="}` + "\n")
+	buf.WriteString(`{"feed":"@p13zSAiOpguI9nsawkGijsnMfWmFd5rlUNpzekEE+vI=.ed25519","sequence":1,"message":{"sequence":1}}` + "\n")
+	buf.WriteString(`{"feed":"@p13zSAiOpguI9nsawkGijsnMfWmFd5rlUNpzekEE+vI=.ed25519","sequence":2,"binary":"AQI="}` + "\n")
+	buf.WriteString(`{"sequence":3}` + "\n")
+
+	ar, err := NewReader(&buf)
+	r.NoError(err)
+	r.Len(ar.Header.Feeds, 1)
+	a.EqualValues(2, ar.Header.Feeds[0].To)
+
+	e, err := ar.Next()
+	r.NoError(err)
+	a.True(e.IsBlob())
+	a.Equal([]byte("hi"), e.Data)
+
+	e, err = ar.Next()
+	r.NoError(err)
+	a.False(e.IsBlob())
+	a.Equal(`{"sequence":1}`, string(e.Transfer().(json.RawMessage)))
+
+	e, err = ar.Next()
+	r.NoError(err)
+	a.Equal([]byte{1, 2}, e.Transfer())
+
+	_, err = ar.Next()
+	a.Error(err, "neither message nor blob")
+
+	_, err = NewReader(strings.NewReader(`{"format":"ssb-archive","version":2}`))
+	a.Error(err)
+
+	ar, err = NewReader(strings.NewReader(`{"format":"ssb-archive","version":1}`))
+	r.NoError(err)
+	_, err = ar.Next()
+	a.Equal(io.EOF, err)
+}
+
+func TestBlobRefs(t *testing.T) {
+	a := assert.New(t)
+
+	const blob = "&Ou364gh9oMmjRDUaUKeXlVZzYiEdjEz00NEGXaRtnrQ=.sha256"
+	content := []byte(`{"type":"post","text":"![a](` + blob + `) and again ` + blob + `","mentions":[{"link":"%Ou364gh9oMmjRDUaUKeXlVZzYiEdjEz00NEGXaRtnrQ=.sha256"}]}`)
+
+	found := BlobRefs(content)
+	if a.Len(found, 1) {
+		a.Equal(blob, found[0].Ref())
+	}
+	a.Len(BlobRefs([]byte(`{"type":"post","text":"nothing"}`)), 0)
+}
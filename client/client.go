@@ -36,6 +36,9 @@ type Client struct {
 	closer io.Closer
 
 	appKeyBytes []byte
+
+	// as is the local identity of the bot to use, see WithIdentity
+	as string
 }
 
 func newClientWithOptions(opts []Option) (*Client, error) {
@@ -174,7 +177,7 @@ func (c Client) Close() error {
 }
 
 func (c Client) Whoami() (*refs.FeedRef, error) {
-	v, err := c.Async(c.rootCtx, message.WhoamiReply{}, muxrpc.Method{"whoami"})
+	v, err := c.Async(c.rootCtx, message.WhoamiReply{}, muxrpc.Method{"whoami"}, c.asArgs()...)
 	if err != nil {
 		return nil, errors.Wrap(err, "ssbClient: whoami failed")
 	}
@@ -279,7 +282,7 @@ func (c Client) NamesImageFor(ref refs.FeedRef) (*refs.BlobRef, error) {
 }
 
 func (c Client) Publish(v interface{}) (*refs.MessageRef, error) {
-	v, err := c.Async(c.rootCtx, "str", muxrpc.Method{"publish"}, v)
+	v, err := c.Async(c.rootCtx, "str", muxrpc.Method{"publish"}, append([]interface{}{v}, c.asArgs()...)...)
 	if err != nil {
 		return nil, errors.Wrap(err, "ssbClient: publish call failed")
 	}
@@ -299,7 +302,8 @@ func (c Client) PrivatePublish(v interface{}, recps ...*refs.FeedRef) (*refs.Mes
 		}
 		recpRefs[i] = ref.Ref()
 	}
-	v, err := c.Async(c.rootCtx, "str", muxrpc.Method{"private", "publish"}, v, recpRefs)
+	args := append([]interface{}{v, recpRefs}, c.asArgs()...)
+	v, err := c.Async(c.rootCtx, "str", muxrpc.Method{"private", "publish"}, args...)
 	if err != nil {
 		return nil, errors.Wrap(err, "ssbClient: private.publish call failed")
 	}
@@ -312,7 +316,7 @@ func (c Client) PrivatePublish(v interface{}, recps ...*refs.FeedRef) (*refs.Mes
 }
 
 func (c Client) PrivateRead() (luigi.Source, error) {
-	src, err := c.Source(c.rootCtx, refs.KeyValueRaw{}, muxrpc.Method{"private", "read"})
+	src, err := c.Source(c.rootCtx, refs.KeyValueRaw{}, muxrpc.Method{"private", "read"}, c.asArgs()...)
 	if err != nil {
 		return nil, errors.Wrap(err, "ssbClient: private.read query failed")
 	}
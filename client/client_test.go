@@ -25,6 +25,7 @@ import (
 	"go.cryptoscope.co/ssb/network"
 	"go.cryptoscope.co/ssb/plugins2"
 	"go.cryptoscope.co/ssb/plugins2/tangles"
+	"go.cryptoscope.co/ssb/repo"
 	"go.cryptoscope.co/ssb/sbot"
 	refs "go.mindeco.de/ssb-refs"
 )
@@ -513,3 +514,83 @@ func TestTangles(t *testing.T) {
 	r.NoError(srv.Close())
 	r.NoError(<-srvErrc)
 }
+
+func TestIdentities(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	srvRepo := filepath.Join("testrun", t.Name(), "serv")
+	os.RemoveAll(srvRepo)
+	srvLog := testutils.NewRelativeTimeLogger(nil)
+
+	// a second identity next to the main keypair
+	botKP, err := repo.NewKeyPair(repo.New(srvRepo), "bot", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	srv, err := sbot.New(
+		sbot.WithInfo(srvLog),
+		sbot.WithRepoPath(srvRepo),
+		sbot.WithListenAddr(":0"),
+		sbot.LateOption(sbot.WithUNIXSocket()),
+	)
+	r.NoError(err, "sbot srv init failed")
+	a.Equal([]string{"bot"}, srv.Identities())
+
+	sock := filepath.Join(srvRepo, "socket")
+	own, err := client.NewUnix(sock)
+	r.NoError(err)
+	asBot, err := client.NewUnix(sock, client.WithIdentity("bot"))
+	r.NoError(err)
+	asBotRef, err := client.NewUnix(sock, client.WithIdentity(botKP.Id.Ref()))
+	r.NoError(err)
+	nobody, err := client.NewUnix(sock, client.WithIdentity("nobody"))
+	r.NoError(err)
+
+	ref, err := own.Whoami()
+	r.NoError(err)
+	a.Equal(srv.KeyPair.Id.Ref(), ref.Ref())
+
+	for _, c := range []*client.Client{asBot, asBotRef} {
+		ref, err = c.Whoami()
+		r.NoError(err)
+		a.Equal(botKP.Id.Ref(), ref.Ref())
+	}
+
+	_, err = nobody.Whoami()
+	a.Error(err)
+	_, err = nobody.Publish(map[string]interface{}{"type": "test"})
+	a.Error(err)
+
+	authorOf := func(ref *refs.MessageRef) *refs.FeedRef {
+		msg, err := srv.Get(*ref)
+		r.NoError(err)
+		return msg.Author()
+	}
+
+	ref1, err := asBot.Publish(map[string]interface{}{"type": "test", "i": 1})
+	r.NoError(err)
+	a.True(authorOf(ref1).Equal(botKP.Id))
+
+	keys, err := asBotRef.PublishAfter(ref1, map[string]interface{}{"type": "test", "i": 2})
+	r.NoError(err)
+	r.Len(keys, 1)
+	a.True(authorOf(keys[0]).Equal(botKP.Id))
+
+	ref2, err := own.Publish(map[string]interface{}{"type": "test", "i": 3})
+	r.NoError(err)
+	a.True(authorOf(ref2).Equal(srv.KeyPair.Id))
+
+	// the sbot side of it
+	kp, err := srv.KeyPairFor(botKP.Id.Ref())
+	r.NoError(err)
+	a.True(kp.Id.Equal(botKP.Id))
+	stranger, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	_, err = srv.KeyPairFor(stranger.Id.Ref())
+	a.Error(err, "not a local identity")
+
+	for _, c := range []*client.Client{own, asBot, asBotRef, nobody} {
+		a.NoError(c.Close())
+	}
+	srv.Shutdown()
+	r.NoError(srv.Close())
+}
@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// InviteCreateArgs are the options of invite.create
+type InviteCreateArgs struct {
+	// how many times the invite can be used
+	Uses uint `json:"uses"`
+
+	// a note to organize invites (also posted when used)
+	Note string `json:"note,omitempty"`
+
+	// after how many seconds the invite expires (0 uses the default of the bot)
+	ExpiresIn uint `json:"expiresIn,omitempty"`
+}
+
+// InviteCreate asks the bot to create an invite and returns the invite code
+func (c Client) InviteCreate(args InviteCreateArgs) (string, error) {
+	v, err := c.Async(c.rootCtx, "str", muxrpc.Method{"invite", "create"}, args)
+	if err != nil {
+		return "", errors.Wrap(err, "ssbClient: invite.create failed")
+	}
+	code, ok := v.(string)
+	if !ok {
+		return "", errors.Errorf("ssbClient: wrong reply type: %T", v)
+	}
+	return code, nil
+}
+
+// InviteAcceptReply are the messages the bot published after redeeming an invite
+type InviteAcceptReply struct {
+	// Follow is the contact message for the peer that created the invite
+	Follow *refs.MessageRef `json:"follow"`
+
+	// Pub is the pub message with the address of that peer
+	Pub *refs.MessageRef `json:"pub"`
+}
+
+// InviteAccept lets the bot redeem the invite code of another peer.
+// The bot follows that peer, publishes its address and connects to it.
+func (c Client) InviteAccept(code string) (*InviteAcceptReply, error) {
+	v, err := c.Async(c.rootCtx, json.RawMessage{}, muxrpc.Method{"invite", "accept"}, code)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: invite.accept failed")
+	}
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("ssbClient: wrong reply type: %T", v)
+	}
+	var reply InviteAcceptReply
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return nil, errors.Wrap(err, "ssbClient: invalid invite.accept reply")
+	}
+	return &reply, nil
+}
+
+// Invite is an invite of the bot, as returned by invite.list
+type Invite struct {
+	// ID is the public key of the invite, for InviteRevoke.
+	// It is only known for invites that were created with the current version of the bot (or used since).
+	ID *refs.FeedRef `json:"id,omitempty"`
+
+	// Status is one of active, used, expired or revoked
+	Status string `json:"status"`
+
+	Uses uint   `json:"uses"`
+	Used uint   `json:"used"`
+	Note string `json:"note,omitempty"`
+
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+
+	Redemptions []InviteRedemption `json:"redemptions,omitempty"`
+}
+
+// InviteRedemption is a use of an invite
+type InviteRedemption struct {
+	Feed *refs.FeedRef `json:"feed"`
+	At   time.Time     `json:"at"`
+}
+
+// InviteList returns the invites the bot created that can still be used, or all of them.
+func (c Client) InviteList(all bool) ([]Invite, error) {
+	arg := map[string]bool{"all": all}
+	v, err := c.Async(c.rootCtx, json.RawMessage{}, muxrpc.Method{"invite", "list"}, arg)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: invite.list failed")
+	}
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("ssbClient: wrong reply type: %T", v)
+	}
+	var invites []Invite
+	if err := json.Unmarshal(raw, &invites); err != nil {
+		return nil, errors.Wrap(err, "ssbClient: invalid invite.list reply")
+	}
+	return invites, nil
+}
+
+// InviteRevoke makes an invite that was created by the bot unusable.
+// idOrCode is either the id from invite.list or the invite code itself.
+func (c Client) InviteRevoke(idOrCode string) error {
+	_, err := c.Async(c.rootCtx, "str", muxrpc.Method{"invite", "revoke"}, idOrCode)
+	return errors.Wrap(err, "ssbClient: invite.revoke failed")
+}
@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package client_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func TestInviteCreateAndAccept(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	newBot := func(name string) *sbot.Sbot {
+		repoPath := filepath.Join("testrun", t.Name(), name)
+		os.RemoveAll(repoPath)
+
+		bot, err := sbot.New(
+			sbot.WithInfo(testutils.NewRelativeTimeLogger(nil)),
+			sbot.WithRepoPath(repoPath),
+			sbot.WithListenAddr("127.0.0.1:0"),
+			sbot.LateOption(sbot.WithUNIXSocket()),
+		)
+		r.NoError(err, "sbot %s init failed", name)
+
+		go func() {
+			err := bot.Network.Serve(context.TODO())
+			if err != nil {
+				t.Log(name, "serve exited:", err)
+			}
+		}()
+		return bot
+	}
+
+	pub := newBot("pub")
+	alice := newBot("alice")
+
+	pubClient, err := client.NewUnix(filepath.Join("testrun", t.Name(), "pub", "socket"))
+	r.NoError(err)
+	aliceClient, err := client.NewUnix(filepath.Join("testrun", t.Name(), "alice", "socket"))
+	r.NoError(err)
+
+	code, err := pubClient.InviteCreate(client.InviteCreateArgs{Uses: 1, Note: "for alice"})
+	r.NoError(err)
+	t.Log("invite:", code)
+
+	reply, err := aliceClient.InviteAccept(code)
+	r.NoError(err)
+	r.NotNil(reply.Follow)
+	r.NotNil(reply.Pub)
+
+	// the invite is used up
+	_, err = aliceClient.InviteAccept(code)
+	a.Error(err)
+
+	// revoked invites can't be used
+	code, err = pubClient.InviteCreate(client.InviteCreateArgs{Uses: 5})
+	r.NoError(err)
+	r.NoError(pubClient.InviteRevoke(code))
+	_, err = aliceClient.InviteAccept(code)
+	a.Error(err)
+
+	invites, err := pubClient.InviteList(false)
+	r.NoError(err)
+	a.Len(invites, 0, "no usable invites")
+
+	invites, err = pubClient.InviteList(true)
+	r.NoError(err)
+	r.Len(invites, 2)
+	statuses := map[string]client.Invite{}
+	for _, inv := range invites {
+		statuses[inv.Status] = inv
+	}
+	used, ok := statuses["used"]
+	r.True(ok, "no used invite: %+v", invites)
+	a.Equal("for alice", used.Note)
+	a.EqualValues(1, used.Used)
+	r.Len(used.Redemptions, 1)
+	a.True(used.Redemptions[0].Feed.Equal(alice.KeyPair.Id))
+	_, ok = statuses["revoked"]
+	a.True(ok, "no revoked invite: %+v", invites)
+
+	a.NoError(pubClient.Close())
+	a.NoError(aliceClient.Close())
+	pub.Shutdown()
+	alice.Shutdown()
+	r.NoError(pub.Close())
+	r.NoError(alice.Close())
+}
@@ -37,3 +37,20 @@ func WithSHSAppKey(appKey string) Option {
 		return nil
 	}
 }
+
+// WithIdentity makes whoami, publish and private calls use another local identity of the bot (like the --as flag of sbotcli).
+// as is the nick of its keypair in the repo of the bot or its feed reference.
+func WithIdentity(as string) Option {
+	return func(c *Client) error {
+		c.as = as
+		return nil
+	}
+}
+
+// asArgs is the options argument that selects the identity, if one is set
+func (c Client) asArgs() []interface{} {
+	if c.as == "" {
+		return nil
+	}
+	return []interface{}{map[string]interface{}{"as": c.as}}
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+
+package client
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// PublishBatch publishes the contents as consecutive messages with publish.batch, either all of them or none, see ssb.BatchPublisher
+func (c Client) PublishBatch(contents ...interface{}) ([]*refs.MessageRef, error) {
+	return c.publishBatch(contents, nil)
+}
+
+// PublishAfter is like PublishBatch but fails if previous isn't the newest message of the feed (nil for an empty feed)
+func (c Client) PublishAfter(previous *refs.MessageRef, contents ...interface{}) ([]*refs.MessageRef, error) {
+	var prev interface{} // null for an empty feed
+	if previous != nil {
+		prev = previous.Ref()
+	}
+	return c.publishBatch(contents, map[string]interface{}{"previous": prev})
+}
+
+func (c Client) publishBatch(contents []interface{}, opts map[string]interface{}) ([]*refs.MessageRef, error) {
+	if contents == nil {
+		contents = []interface{}{}
+	}
+	if c.as != "" {
+		if opts == nil {
+			opts = make(map[string]interface{})
+		}
+		opts["as"] = c.as
+	}
+	args := []interface{}{contents}
+	if opts != nil {
+		args = append(args, opts)
+	}
+
+	v, err := c.Async(c.rootCtx, json.RawMessage{}, muxrpc.Method{"publish", "batch"}, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssbClient: publish.batch call failed")
+	}
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		return nil, errors.Errorf("ssbClient: wrong reply type: %T", v)
+	}
+	var resp []string
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, errors.Wrap(err, "ssbClient: invalid publish.batch reply")
+	}
+
+	keys := make([]*refs.MessageRef, len(resp))
+	for i, r := range resp {
+		keys[i], err = refs.ParseMessageRef(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse new message reference: %q", r)
+		}
+	}
+	return keys, nil
+}
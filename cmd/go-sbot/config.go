@@ -0,0 +1,97 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	refs "go.mindeco.de/ssb-refs"
+
+	mksbot "go.cryptoscope.co/ssb/sbot"
+)
+
+// settingsFile is the JSON of the settings file, the fields that are not set keep the value of the command line flag
+type settingsFile struct {
+	Hops    *uint    `json:"hops"`
+	Promisc *bool    `json:"promisc"`
+	Allow   []string `json:"allow"`
+}
+
+// readSettings reads the JSON settings file that is passed with -config, it is re-read on SIGHUP:
+//
+//	{
+//	  "hops": 2,
+//	  "promisc": false,
+//	  "allow": ["@feed.ed25519"]
+//	}
+//
+// Keys that are not in the file keep the value of the command line flag.
+func readSettings(path string) (mksbot.Settings, error) {
+	set := mksbot.Settings{
+		Hops:    flagHops,
+		Promisc: flagPromisc,
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return set, fmt.Errorf("config: failed to read settings file: %w", err)
+	}
+
+	var file settingsFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return set, fmt.Errorf("config: failed to decode settings file: %w", err)
+	}
+
+	if file.Hops != nil {
+		set.Hops = *file.Hops
+	}
+	if file.Promisc != nil {
+		set.Promisc = *file.Promisc
+	}
+	for _, a := range file.Allow {
+		ref, err := refs.ParseFeedRef(a)
+		if err != nil {
+			return set, fmt.Errorf("config: invalid feed to allow: %w", err)
+		}
+		set.AllowList = append(set.AllowList, ref)
+	}
+	return set, nil
+}
+
+// parsePartialPolicy makes a replication policy from the -partial* flags.
+// hops is either a single hop count or a range like 2-3.
+func parsePartialPolicy(hops string, latest int, types string) (mksbot.ReplicationPolicy, error) {
+	var pol mksbot.ReplicationPolicy
+
+	minStr, maxStr := hops, hops
+	if dash := strings.Index(hops, "-"); dash > 0 {
+		minStr, maxStr = hops[:dash], hops[dash+1:]
+	}
+	var err error
+	pol.MinHops, err = strconv.Atoi(strings.TrimSpace(minStr))
+	if err != nil {
+		return pol, fmt.Errorf("partialhops: invalid hop count: %w", err)
+	}
+	pol.MaxHops, err = strconv.Atoi(strings.TrimSpace(maxStr))
+	if err != nil {
+		return pol, fmt.Errorf("partialhops: invalid hop count: %w", err)
+	}
+
+	pol.Latest = latest
+	for _, t := range strings.Split(types, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			pol.Types = append(pol.Types, t)
+		}
+	}
+	if pol.Latest <= 0 && len(pol.Types) == 0 {
+		return pol, fmt.Errorf("partialhops: needs -partiallatest or -partialtypes")
+	}
+	return pol, nil
+}
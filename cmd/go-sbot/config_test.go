@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadSettings(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	testPath := filepath.Join(".", "testrun", t.Name())
+	r.NoError(os.RemoveAll(testPath))
+	r.NoError(os.MkdirAll(testPath, 0700))
+
+	flagHops, flagPromisc = 1, true
+
+	cfgPath := filepath.Join(testPath, "sbot.json")
+	r.NoError(ioutil.WriteFile(cfgPath, []byte(`{
+  "hops": 3,
+  "allow": [
+    "@p13zSAiOpguI9nsawkGijsnMfWmFd5rlUNpzekEE+vI=.ed25519",
+    "@uOReuhnb9+mWQtH4AuBbkrn6AHl7tTAiLOZFfqvNRCI=.ed25519"
+  ]
+}`), 0600))
+
+	set, err := readSettings(cfgPath)
+	r.NoError(err)
+	a.Equal(uint(3), set.Hops)
+	a.True(set.Promisc, "not in the file, keeps the flag value")
+	r.Len(set.AllowList, 2)
+	a.Equal("@p13zSAiOpguI9nsawkGijsnMfWmFd5rlUNpzekEE+vI=.ed25519", set.AllowList[0].Ref())
+
+	for _, bad := range []string{
+		"hops=2",
+		`{"hops": -1}`,
+		`{"promisc": "maybe"}`,
+		`{"allow": ["@nope"]}`,
+		`{"colour": "blue"}`,
+	} {
+		r.NoError(ioutil.WriteFile(cfgPath, []byte(bad+"\n"), 0600))
+		_, err = readSettings(cfgPath)
+		a.Error(err, "expected %q to fail", bad)
+	}
+
+	_, err = readSettings(filepath.Join(testPath, "missing.json"))
+	a.Error(err)
+}
+
+func TestParsePartialPolicy(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	pol, err := parsePartialPolicy("2-3", 0, "about, contact,")
+	r.NoError(err)
+	a.Equal(2, pol.MinHops)
+	a.Equal(3, pol.MaxHops)
+	a.Equal([]string{"about", "contact"}, pol.Types)
+
+	pol, err = parsePartialPolicy("2", 100, "")
+	r.NoError(err)
+	a.Equal(2, pol.MinHops)
+	a.Equal(2, pol.MaxHops)
+	a.Equal(100, pol.Latest)
+
+	_, err = parsePartialPolicy("2", 0, "")
+	a.Error(err, "neither latest nor types")
+	_, err = parsePartialPolicy("two", 10, "")
+	a.Error(err)
+	_, err = parsePartialPolicy("1-x", 10, "")
+	a.Error(err)
+}
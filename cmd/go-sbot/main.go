@@ -12,7 +12,9 @@ import (
 	"os/signal"
 	"os/user"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -20,7 +22,6 @@ import (
 	_ "net/http/pprof"
 
 	"github.com/cryptix/go/logging"
-	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/margaret"
@@ -32,11 +33,12 @@ import (
 	"go.cryptoscope.co/ssb/internal/ctxutils"
 	"go.cryptoscope.co/ssb/internal/testutils"
 	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/network/netaddr"
 	"go.cryptoscope.co/ssb/plugins2"
 	"go.cryptoscope.co/ssb/plugins2/bytype"
 	"go.cryptoscope.co/ssb/plugins2/names"
 	"go.cryptoscope.co/ssb/plugins2/tangles"
-	"go.cryptoscope.co/ssb/repo"
 	mksbot "go.cryptoscope.co/ssb/sbot"
 )
 
@@ -54,13 +56,42 @@ var (
 
 	flagDecryptPrivate  bool
 	flagDisableUNIXSock bool
+	flagBIPF            bool
+	flagPublishForks    bool
+	flagNetworks        string
 
 	listenAddr string
 	wsLisAddr  string
+	wsToken    string
+	wsNoauth   string
+	wsOrigins  string
 	debugAddr  string
 	repoDir    string
 	dbgLogDir  string
 
+	socks5Addr    string
+	flagSocks5All bool
+	onionAddr     string
+
+	pubAddr      string
+	inviteExpiry time.Duration
+
+	quotaStreams   int
+	quotaMsgRate   float64
+	quotaBandwidth int
+
+	verifyWorkers int
+
+	sneakernetDir   string
+	sneakernetEvery time.Duration
+
+	partialHops   string
+	partialLatest int
+	partialTypes  string
+
+	configFile   string
+	drainTimeout time.Duration
+
 	// helper
 	log        logging.Interface
 	checkFatal = logging.CheckFatal
@@ -94,6 +125,7 @@ func initFlags() {
 	flag.BoolVar(&flagPromisc, "promisc", false, "bypass graph auth and fetch remote's feed")
 
 	flag.StringVar(&appKey, "shscap", "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", "secret-handshake app-key (or capability)")
+	flag.StringVar(&flagNetworks, "networks", "", "also join these comma separated networks from networks.json in the repo, each with its own app key, hmac key, listen address and logs")
 	flag.StringVar(&hmacSec, "hmac", "", "if set, sign with hmac hash of msg, instead of plain message object, using this key")
 
 	flag.StringVar(&listenAddr, "l", ":8008", "address to listen on")
@@ -101,9 +133,36 @@ func initFlags() {
 	flag.BoolVar(&flagEnDiscov, "localdiscov", false, "enable connecting to incomming UDP brodcasts")
 
 	flag.StringVar(&wsLisAddr, "wslis", ":8989", "address to listen on for ssb-ws connections")
+	flag.StringVar(&wsToken, "wstoken", "", "if set, HTTP and websocket clients can make calls with this token (as the bots own feed) at /rpc and /rpc/ws")
+	flag.StringVar(&wsNoauth, "wsnoauth", "", "if set, serve muxrpc over websockets without secret-handshake on this loopback address (full access, like the unix socket)")
+	flag.StringVar(&wsOrigins, "wsorigins", "", "comma separated list of browser origins that may connect to the websocket and the gateway (* for all)")
+	flag.StringVar(&socks5Addr, "socks5", "", "dial onion addresses through this SOCKS5 proxy (like tor on localhost:9050)")
+	flag.BoolVar(&flagSocks5All, "socks5all", false, "dial all connections through the -socks5 proxy, not just onion addresses")
+	flag.StringVar(&onionAddr, "onion", "", "host:port of the onion service that forwards to -l, used in invites and announced in a pub message")
+
+	flag.StringVar(&pubAddr, "pub", "", "run as a pub: announce this public host:port and put it into invites")
+	flag.DurationVar(&inviteExpiry, "inviteexpiry", 0, "with -pub: how long invites are valid, if invite.create doesn't say otherwise (0: forever)")
+
+	flag.IntVar(&quotaStreams, "maxstreams", 0, "how many streams a single peer can have open at once (0: unlimited)")
+	flag.Float64Var(&quotaMsgRate, "maxmsgrate", 0, "how many stream messages per second can pass a single connection (0: unlimited)")
+	flag.IntVar(&quotaBandwidth, "maxbandwidth", 0, "bytes per second a single connection can transfer (0: unlimited)")
 
-	flag.BoolVar(&flagDecryptPrivate, "decryptprivate", false, "store which messages can be decrypted")
+	flag.IntVar(&verifyWorkers, "verifyworkers", 0, "verify fetched messages with this many goroutines (0: one by one)")
+
+	flag.StringVar(&sneakernetDir, "sneakernet", "", "exchange feeds and wanted blobs with other bots through this directory (like a folder on a USB drive), whenever it exists")
+	flag.DurationVar(&sneakernetEvery, "sneakernetinterval", time.Minute, "how often to sync with the -sneakernet directory")
+
+	flag.StringVar(&partialHops, "partialhops", "", "only replicate parts of the feeds in this hop range (like 2 or 2-3), see -partiallatest and -partialtypes")
+	flag.IntVar(&partialLatest, "partiallatest", 0, "with -partialhops: only replicate the newest N messages")
+	flag.StringVar(&partialTypes, "partialtypes", "", "with -partialhops: only replicate messages of these comma separated types (like about,contact)")
+
+	flag.StringVar(&configFile, "config", "", "JSON file with hops, promisc and allow settings, which is re-read on SIGHUP (see readSettings)")
+	flag.DurationVar(&drainTimeout, "draintimeout", 30*time.Second, "how long to wait for running fetches and indexing on shutdown")
+
+	flag.BoolVar(&flagDecryptPrivate, "decryptprivate", false, "store which messages can be decrypted by the local identities")
 	flag.BoolVar(&flagDisableUNIXSock, "nounixsock", false, "disable the UNIX socket RPC interface")
+	flag.BoolVar(&flagPublishForks, "publishforks", false, "publish a fork-proof message when a feed is found to be forked")
+	flag.BoolVar(&flagBIPF, "bipf", false, "store new messages in the receive log as bipf (use ssb-migrate-log -bipf to convert the existing ones)")
 
 	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "where to put the log and indexes")
 
@@ -161,10 +220,19 @@ func runSbot() error {
 		return errors.Wrap(err, "application key")
 	}
 
+	settings := mksbot.Settings{Hops: flagHops, Promisc: flagPromisc}
+	if configFile != "" {
+		settings, err = readSettings(configFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	startDebug()
 	opts := []mksbot.Option{
-		mksbot.WithHops(flagHops),
-		mksbot.WithPromisc(flagPromisc),
+		mksbot.WithHops(settings.Hops),
+		mksbot.WithPromisc(settings.Promisc),
+		mksbot.WithAllowList(settings.AllowList...),
 		mksbot.WithInfo(log),
 		mksbot.WithAppKey(ak),
 		mksbot.WithRepoPath(repoDir),
@@ -172,43 +240,82 @@ func runSbot() error {
 		mksbot.EnableAdvertismentBroadcasts(flagEnAdv),
 		mksbot.EnableAdvertismentDialing(flagEnDiscov),
 		mksbot.WithWebsocketAddress(wsLisAddr),
+		mksbot.UseBIPFStorage(flagBIPF),
+		mksbot.WithForkProofPublishing(flagPublishForks),
+		mksbot.WithVerifyWorkers(verifyWorkers),
 	}
 
-	if !flagDisableUNIXSock {
-		opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
+	if sneakernetDir != "" {
+		opts = append(opts, mksbot.WithSneakernet(sneakernetDir, sneakernetEvery))
+	}
+
+	if wsToken != "" {
+		opts = append(opts, mksbot.WithGatewayToken(wsToken, nil))
+	}
+	if wsOrigins != "" {
+		opts = append(opts, mksbot.WithWebsocketOrigins(strings.Split(wsOrigins, ",")...))
 	}
 
-	if flagDecryptPrivate {
-		// TODO: refactor into plugins2
-		r := repo.New(repoDir)
-		kpsByPath, err := repo.AllKeyPairs(r)
+	if socks5Addr != "" {
+		networks := []string{netaddr.OnionNetwork}
+		if flagSocks5All {
+			networks = append(networks, "tcp")
+		}
+		opts = append(opts, mksbot.WithSOCKS5Proxy(socks5Addr, networks...))
+	}
+	if onionAddr != "" {
+		host, portStr, err := net.SplitHostPort(onionAddr)
+		if err != nil {
+			return errors.Wrap(err, "sbot: invalid onion address")
+		}
+		port, err := strconv.Atoi(portStr)
 		if err != nil {
-			return errors.Wrap(err, "sbot: failed to open all keypairs in repo")
+			return errors.Wrap(err, "sbot: invalid onion port")
 		}
+		opts = append(opts, mksbot.WithOnionAddress(host, port))
+	}
 
-		var kps []*ssb.KeyPair
-		for _, v := range kpsByPath {
-			kps = append(kps, v)
+	if pubAddr != "" {
+		host, portStr, err := net.SplitHostPort(pubAddr)
+		if err != nil {
+			return errors.Wrap(err, "sbot: invalid pub address")
 		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return errors.Wrap(err, "sbot: invalid pub port")
+		}
+		opts = append(opts, mksbot.EnablePubMode(host, port, inviteExpiry))
+	}
 
-		defKP, err := repo.DefaultKeyPair(r)
+	if quotaStreams > 0 || quotaMsgRate > 0 || quotaBandwidth > 0 {
+		opts = append(opts, mksbot.WithPeerQuotas(network.PeerQuotas{
+			MaxStreams:        quotaStreams,
+			MessagesPerSecond: quotaMsgRate,
+			BytesPerSecond:    quotaBandwidth,
+		}))
+	}
+
+	if partialHops != "" {
+		pol, err := parsePartialPolicy(partialHops, partialLatest, partialTypes)
 		if err != nil {
-			return errors.Wrap(err, "sbot: failed to open default keypair")
+			return err
 		}
-		kps = append(kps, defKP)
+		opts = append(opts, mksbot.WithReplicationPolicies(pol))
+	}
 
-		mlogPriv := multilogs.NewPrivateRead(kitlog.With(log, "module", "privLogs"), kps...)
+	if !flagDisableUNIXSock {
+		opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
+	}
 
-		opts = append(opts, mksbot.LateOption(mksbot.MountMultiLog("privLogs", mlogPriv.OpenRoaring)))
+	if wsNoauth != "" {
+		opts = append(opts, mksbot.LateOption(mksbot.WithNoauthWebsocket(wsNoauth)))
 	}
 
+	// every local identity reads the private messages it can decrypt
+	opts = append(opts, mksbot.EnablePrivateUnboxing(flagDecryptPrivate))
+
 	if flagFatBot {
-		opts = append(opts,
-			mksbot.LateOption(mksbot.MountSimpleIndex("get", indexes.OpenGet)), // todo muxrpc plugin is hardcoded
-			mksbot.LateOption(mksbot.MountPlugin(&tangles.Plugin{}, plugins2.AuthMaster)),
-			mksbot.LateOption(mksbot.MountPlugin(&names.Plugin{}, plugins2.AuthMaster)),
-			mksbot.LateOption(mksbot.MountPlugin(&bytype.Plugin{}, plugins2.AuthMaster)),
-		)
+		opts = append(opts, fatBotOptions()...)
 	}
 
 	if dbgLogDir != "" {
@@ -250,23 +357,70 @@ func runSbot() error {
 		return errors.Wrap(err, "scuttlebot")
 	}
 
+	// the other networks only run when the bot serves
+	var netBots []*mksbot.Sbot
+	if flagNetworks != "" && flagFSCK == "" && !flagReindex && !flagCleanup {
+		netBots, err = openNetworks(flagNetworks, settings)
+		if err != nil {
+			sbot.Shutdown()
+			sbot.Close()
+			return err
+		}
+	}
+
 	c := make(chan os.Signal)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		sig := <-c
-		level.Warn(log).Log("event", "killed", "msg", "received signal, shutting down", "signal", sig.String())
+		level.Warn(log).Log("event", "killed", "msg", "received signal, draining", "signal", sig.String(), "timeout", drainTimeout)
+
+		// a second signal skips the draining
+		go func() {
+			sig := <-c
+			level.Warn(log).Log("event", "killed", "msg", "received second signal, exiting", "signal", sig.String())
+			os.Exit(1)
+		}()
+
+		// all bots drain at the same time, each with its own timeout, so a slow one doesn't eat up the time of the others
+		var drained sync.WaitGroup
+		drain := func(bot *mksbot.Sbot) {
+			defer drained.Done()
+			drainCtx, drainCancel := context.WithTimeout(context.Background(), drainTimeout)
+			defer drainCancel()
+			checkAndLog(bot.Drain(drainCtx))
+		}
+		drained.Add(1 + len(netBots))
+		go drain(sbot)
+		for _, nb := range netBots {
+			go drain(nb)
+		}
+		drained.Wait()
 		cancel()
-		sbot.Shutdown()
-		time.Sleep(2 * time.Second)
-
-		err := sbot.Close()
-		checkAndLog(err)
-
-		time.Sleep(2 * time.Second)
 		os.Exit(0)
 	}()
 	logging.SetCloseChan(c)
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if configFile == "" {
+				level.Warn(log).Log("event", "reload", "msg", "no -config file to reload")
+				continue
+			}
+			set, err := readSettings(configFile)
+			if err != nil {
+				level.Error(log).Log("event", "reload", "err", err)
+				continue
+			}
+			for _, bot := range append([]*mksbot.Sbot{sbot}, netBots...) {
+				if err := bot.Reload(set); err != nil {
+					level.Error(log).Log("event", "reload", "err", err)
+				}
+			}
+		}
+	}()
+
 	id := sbot.KeyPair.Id
 	uf, ok := sbot.GetMultiLog(multilogs.IndexNameFeeds)
 	if !ok {
@@ -406,9 +560,16 @@ func runSbot() error {
 	}
 
 	level.Info(log).Log("event", "serving", "ID", id.Ref(), "addr", listenAddr, "version", Version, "build", Build)
+	for _, nb := range netBots {
+		go serveNetwork(ctx, nb)
+	}
 	for {
 		// Note: This is where the serving starts ;)
 		err = sbot.Network.Serve(ctx, HandlerWithLatency(muxrpcSummary))
+		if errors.Cause(err) == network.ErrDraining {
+			<-sbot.Drained()
+			return nil
+		}
 		if err != nil {
 			level.Warn(log).Log("event", "sbot node.Serve returned", "err", err)
 		}
@@ -422,6 +583,16 @@ func runSbot() error {
 	}
 }
 
+// fatBotOptions are the additional indexes and plugins of -fatbot
+func fatBotOptions() []mksbot.Option {
+	return []mksbot.Option{
+		mksbot.LateOption(mksbot.MountSimpleIndex("get", indexes.OpenGet)), // todo muxrpc plugin is hardcoded
+		mksbot.LateOption(mksbot.MountPlugin(&tangles.Plugin{}, plugins2.AuthMaster)),
+		mksbot.LateOption(mksbot.MountPlugin(&names.Plugin{}, plugins2.AuthMaster)),
+		mksbot.LateOption(mksbot.MountPlugin(&bytype.Plugin{}, plugins2.AuthMaster)),
+	}
+}
+
 func main() {
 	if err := runSbot(); err != nil {
 		fmt.Fprintf(os.Stderr, "go-sbot: %s\n", err)
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/repo"
+	mksbot "go.cryptoscope.co/ssb/sbot"
+)
+
+// openNetworks starts a bot for each of the comma separated network profiles in names (see repo.NetworkProfile),
+// next to the one of the main network. Each listens on the address of its profile and keeps its logs in the namespace of it.
+// The settings that aren't tied to the main network are the same for all of them.
+func openNetworks(names string, settings mksbot.Settings) ([]*mksbot.Sbot, error) {
+	r := repo.New(repoDir)
+	profiles, err := repo.LoadNetworkProfiles(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var bots []*mksbot.Sbot
+	closeAll := func() {
+		for _, bot := range bots {
+			bot.Shutdown()
+			bot.Close()
+		}
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		p, ok := repo.FindNetworkProfile(profiles, name)
+		if !ok {
+			closeAll()
+			return nil, fmt.Errorf("no network %q in %s", name, r.GetPath(repo.NetworksFile))
+		}
+		if p.ListenAddr == "" {
+			closeAll()
+			return nil, fmt.Errorf("network %s has no listenAddr", p.Name)
+		}
+
+		opts := []mksbot.Option{
+			mksbot.WithHops(settings.Hops),
+			mksbot.WithPromisc(settings.Promisc),
+			mksbot.WithAllowList(settings.AllowList...),
+			mksbot.WithInfo(kitlog.With(log, "network", p.Name)),
+			mksbot.WithRepoPath(repoDir),
+			mksbot.WithNetworkProfile(p),
+			mksbot.UseBIPFStorage(flagBIPF),
+			mksbot.WithForkProofPublishing(flagPublishForks),
+			mksbot.WithVerifyWorkers(verifyWorkers),
+		}
+		if !flagDisableUNIXSock {
+			opts = append(opts, mksbot.LateOption(mksbot.WithUNIXSocket()))
+		}
+		if flagFatBot {
+			opts = append(opts, fatBotOptions()...)
+		}
+
+		bot, err := mksbot.New(opts...)
+		if err != nil {
+			closeAll()
+			return nil, errors.Wrapf(err, "network %s", p.Name)
+		}
+		bots = append(bots, bot)
+		level.Info(log).Log("event", "network opened", "network", p.Name, "ID", bot.KeyPair.Id.Ref(), "addr", p.ListenAddr)
+	}
+	return bots, nil
+}
+
+// serveNetwork is the serve loop of the bot of another network, like the one of the main bot
+func serveNetwork(ctx context.Context, bot *mksbot.Sbot) {
+	for {
+		err := bot.Network.Serve(ctx, HandlerWithLatency(muxrpcSummary))
+		if errors.Cause(err) == network.ErrDraining {
+			return
+		}
+		if err != nil {
+			level.Warn(log).Log("event", "network node.Serve returned", "id", bot.KeyPair.Id.Ref(), "err", err)
+		}
+		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/forks"
+)
+
+var forksCmd = &cli.Command{
+	Name:  "forks",
+	Usage: "list the feeds that forked and are not replicated anymore",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "json", Usage: "print the proofs with both messages as JSON"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, json.RawMessage{}, muxrpc.Method{"forks", "list"})
+		if err != nil {
+			return errors.Wrap(err, "forks: async call failed.")
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return errors.Errorf("forks: unexpected reply type %T", v)
+		}
+
+		if ctx.Bool("json") {
+			_, err = os.Stdout.Write(append(raw, '\n'))
+			return err
+		}
+
+		var proofs []forks.Proof
+		if err := json.Unmarshal(raw, &proofs); err != nil {
+			return errors.Wrap(err, "forks: invalid reply")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(w, "FEED\tSEQ\tDETECTED\tFROM\tPUBLISHED")
+		for _, p := range proofs {
+			from, published := "-", "-"
+			if p.From != nil {
+				from = p.From.Ref()
+			}
+			if p.Published != nil {
+				published = p.Published.Ref()
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n",
+				p.Feed.Ref(),
+				p.Sequence,
+				humanize.Time(p.Detected),
+				from,
+				published,
+			)
+		}
+		return w.Flush()
+	},
+}
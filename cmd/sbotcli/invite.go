@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v2"
+
+	ssbClient "go.cryptoscope.co/ssb/client"
+)
+
+var inviteCmd = &cli.Command{
+	Name:  "invite",
+	Usage: "create, accept and manage invites",
+	Subcommands: []*cli.Command{
+		inviteCreateCmd,
+		inviteAcceptCmd,
+		inviteListCmd,
+		inviteRevokeCmd,
+	},
+}
+
+var inviteCreateCmd = &cli.Command{
+	Name:  "create",
+	Usage: "create an invite code for others to use",
+	Flags: []cli.Flag{
+		&cli.UintFlag{Name: "uses", Value: 1, Usage: "how many times the invite can be used"},
+		&cli.StringFlag{Name: "note", Usage: "a note to organize invites (also posted when used)"},
+		&cli.DurationFlag{Name: "expires", Usage: "how long the invite is valid (0: the default of the bot)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		code, err := client.InviteCreate(ssbClient.InviteCreateArgs{
+			Uses:      ctx.Uint("uses"),
+			Note:      ctx.String("note"),
+			ExpiresIn: uint(ctx.Duration("expires").Seconds()),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println(code)
+		return nil
+	},
+}
+
+var inviteAcceptCmd = &cli.Command{
+	Name:  "accept",
+	Usage: "redeem the invite code of a pub, follow it and add it to the address book",
+	Action: func(ctx *cli.Context) error {
+		code := ctx.Args().Get(0)
+		if code == "" {
+			return errors.New("invite accept: invite code argument can't be empty")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		reply, err := client.InviteAccept(code)
+		if err != nil {
+			return err
+		}
+		log.Log("event", "invite accepted", "follow", reply.Follow.Ref(), "pub", reply.Pub.Ref())
+		return nil
+	},
+}
+
+var inviteListCmd = &cli.Command{
+	Name:  "list",
+	Usage: "list the invites that can still be used",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "all", Usage: "also list the used, expired and revoked ones"},
+		&cli.BoolFlag{Name: "json", Usage: "print the full records (with redemptions) as JSON"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		invites, err := client.InviteList(ctx.Bool("all"))
+		if err != nil {
+			return errors.Wrap(err, "invite list failed")
+		}
+
+		if ctx.Bool("json") {
+			return json.NewEncoder(os.Stdout).Encode(invites)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tUSED\tCREATED\tEXPIRES\tNOTE")
+		for _, inv := range invites {
+			id := "-"
+			if inv.ID != nil {
+				id = inv.ID.Ref()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\t%s\n",
+				id,
+				inv.Status,
+				inv.Used, inv.Uses,
+				formatInviteTime(inv.Created),
+				formatInviteTime(inv.Expires),
+				inv.Note,
+			)
+		}
+		return w.Flush()
+	},
+}
+
+func formatInviteTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return humanize.Time(t)
+}
+
+var inviteRevokeCmd = &cli.Command{
+	Name:  "revoke",
+	Usage: "make an invite unusable, by the id from the list or by its code",
+	Action: func(ctx *cli.Context) error {
+		idOrCode := ctx.Args().Get(0)
+		if idOrCode == "" {
+			return errors.New("invite revoke: id or invite code argument can't be empty")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		if err := client.InviteRevoke(idOrCode); err != nil {
+			return err
+		}
+		log.Log("event", "invite revoked")
+		return nil
+	},
+}
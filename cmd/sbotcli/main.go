@@ -31,6 +31,7 @@ import (
 	"go.cryptoscope.co/ssb"
 	ssbClient "go.cryptoscope.co/ssb/client"
 	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/repo"
 	refs "go.mindeco.de/ssb-refs"
 	"golang.org/x/crypto/ed25519"
 	cli "gopkg.in/urfave/cli.v2"
@@ -50,6 +51,7 @@ var (
 
 	keyFileFlag  = cli.StringFlag{Name: "key,k", Value: "unset"}
 	unixSockFlag = cli.StringFlag{Name: "unixsock", Usage: "if set, unix socket is used instead of tcp"}
+	repoFlag     = cli.StringFlag{Name: "repo", Usage: "repo of the sbot, for the network profiles that --shscap can name"}
 )
 
 func init() {
@@ -58,6 +60,7 @@ func init() {
 
 	keyFileFlag.Value = filepath.Join(u.HomeDir, ".ssb-go", "secret")
 	unixSockFlag.Value = filepath.Join(u.HomeDir, ".ssb-go", "socket")
+	repoFlag.Value = filepath.Join(u.HomeDir, ".ssb-go")
 
 	log = term.NewColorLogger(os.Stdout, kitlog.NewLogfmtLogger, colorFn)
 }
@@ -68,12 +71,14 @@ var app = cli.App{
 	Version: "alpha4",
 
 	Flags: []cli.Flag{
-		&cli.StringFlag{Name: "shscap", Value: "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", Usage: "shs key"},
+		&cli.StringFlag{Name: "shscap", Value: "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=", Usage: "shs key, or the name of a network in the networks.json of --repo"},
 		&cli.StringFlag{Name: "addr", Value: "localhost:8008", Usage: "tcp address of the sbot to connect to (or listen on)"},
 		&cli.StringFlag{Name: "remoteKey", Value: "", Usage: "the remote pubkey you are connecting to (by default the local key)"},
 		&keyFileFlag,
 		&unixSockFlag,
+		&repoFlag,
 		&cli.BoolFlag{Name: "verbose,vv", Usage: "print muxrpc packets"},
+		&cli.StringFlag{Name: "as", Usage: "nick or feed of another local identity of the bot to use for whoami, publish and private"},
 	},
 
 	Before: initClient,
@@ -87,6 +92,10 @@ var app = cli.App{
 		replicateUptoCmd,
 		callCmd,
 		connectCmd,
+		peersCmd,
+		forksCmd,
+		inviteCmd,
+		shutdownCmd,
 		queryCmd,
 		privateCmd,
 		publishCmd,
@@ -141,9 +150,39 @@ func initClient(ctx *cli.Context) error {
 }
 
 func newClient(ctx *cli.Context) (*ssbClient.Client, error) {
-	sockPath := ctx.String("unixsock")
+	var (
+		sockPath = ctx.String("unixsock")
+		keyFile  = ctx.String("key")
+		addr     = ctx.String("addr")
+		appKey   = ctx.String("shscap")
+	)
+
+	// the socket, secret and address of another network of the bot, unless they are set explicitly
+	if ctx.IsSet("shscap") {
+		r := repo.New(ctx.String("repo"))
+		profiles, err := repo.LoadNetworkProfiles(r)
+		if err != nil {
+			return nil, err
+		}
+		if p, ok := repo.FindNetworkProfile(profiles, appKey); ok {
+			netRepo := p.Repo(r)
+			if !ctx.IsSet("unixsock") {
+				sockPath = netRepo.GetPath("socket")
+			}
+			if !ctx.IsSet("key") {
+				keyFile = netRepo.GetPath("secret")
+			}
+			if !ctx.IsSet("addr") && p.ListenAddr != "" {
+				addr = p.ListenAddr
+			}
+			appKey = base64.StdEncoding.EncodeToString(p.AppKey)
+		}
+	}
+
 	if sockPath != "" {
-		client, err := ssbClient.NewUnix(sockPath, ssbClient.WithContext(longctx))
+		client, err := ssbClient.NewUnix(sockPath,
+			ssbClient.WithContext(longctx),
+			ssbClient.WithIdentity(ctx.String("as")))
 		if err != nil {
 			return nil, errors.Wrap(err, "unix-path based client init failed")
 		}
@@ -151,7 +190,7 @@ func newClient(ctx *cli.Context) (*ssbClient.Client, error) {
 	}
 
 	// Assume TCP connection
-	localKey, err := ssb.LoadKeyPair(ctx.String("key"))
+	localKey, err := ssb.LoadKeyPair(keyFile)
 	if err != nil {
 		return nil, err
 	}
@@ -168,15 +207,16 @@ func newClient(ctx *cli.Context) (*ssbClient.Client, error) {
 		copy(remotePubKey, rpk)
 	}
 
-	plainAddr, err := net.ResolveTCPAddr("tcp", ctx.String("addr"))
+	plainAddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return nil, errors.Wrapf(err, "int: failed to resolve TCP address")
 	}
 
 	shsAddr := netwrap.WrapAddr(plainAddr, secretstream.Addr{PubKey: remotePubKey})
 	client, err := ssbClient.NewTCP(localKey, shsAddr,
-		ssbClient.WithSHSAppKey(ctx.String("shscap")),
-		ssbClient.WithContext(longctx))
+		ssbClient.WithSHSAppKey(appKey),
+		ssbClient.WithContext(longctx),
+		ssbClient.WithIdentity(ctx.String("as")))
 	if err != nil {
 		return nil, errors.Wrapf(err, "init: failed to connect to %s", shsAddr.String())
 	}
@@ -184,6 +224,14 @@ func newClient(ctx *cli.Context) (*ssbClient.Client, error) {
 	return client, nil
 }
 
+// withAs appends the {as: nick} option of the --as flag to the arguments of a call
+func withAs(ctx *cli.Context, args ...interface{}) []interface{} {
+	if as := ctx.String("as"); as != "" {
+		args = append(args, map[string]interface{}{"as": as})
+	}
+	return args
+}
+
 func getStreamArgs(ctx *cli.Context) message.CreateHistArgs {
 	var ref *refs.FeedRef
 	if id := ctx.String("id"); id != "" {
@@ -286,8 +334,37 @@ var connectCmd = &cli.Command{
 	},
 }
 
+var shutdownCmd = &cli.Command{
+	Name:  "shutdown",
+	Usage: "let the bot finish its running work and stop it",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{Name: "timeout", Value: 30 * time.Second, Usage: "close the bot anyway after this time"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var val interface{}
+		val, err = client.Async(longctx, val, muxrpc.Method{"ctrl", "shutdown"}, ctx.Duration("timeout").Seconds())
+		if err != nil {
+			return errors.Wrapf(err, "shutdown: async call failed.")
+		}
+		log.Log("event", "shutdown reply")
+		goon.Dump(val)
+		return nil
+	},
+}
+
 var blockCmd = &cli.Command{
-	Name: "block",
+	Name:  "block",
+	Usage: "block the feeds that are read from stdin (one per line)",
+	Subcommands: []*cli.Command{
+		blockListsCmd,
+		blockSubscribeCmd,
+		blockUnsubscribeCmd,
+	},
 	Action: func(ctx *cli.Context) error {
 		client, err := newClient(ctx)
 		if err != nil {
@@ -317,6 +394,71 @@ var blockCmd = &cli.Command{
 	},
 }
 
+var blockListsCmd = &cli.Command{
+	Name:  "lists",
+	Usage: "list the feeds whose blocks are applied",
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var val interface{}
+		val, err = client.Async(longctx, val, muxrpc.Method{"ctrl", "blockLists"})
+		if err != nil {
+			return err
+		}
+		log.Log("event", "blockLists reply")
+		goon.Dump(val)
+		return nil
+	},
+}
+
+var blockSubscribeCmd = &cli.Command{
+	Name:  "subscribe",
+	Usage: "apply the blocks of the passed feeds, too",
+	Action: func(ctx *cli.Context) error {
+		return updateBlockLists(ctx, true)
+	},
+}
+
+var blockUnsubscribeCmd = &cli.Command{
+	Name:  "unsubscribe",
+	Usage: "stop applying the blocks of the passed feeds",
+	Action: func(ctx *cli.Context) error {
+		return updateBlockLists(ctx, false)
+	},
+}
+
+func updateBlockLists(ctx *cli.Context, subscribe bool) error {
+	if ctx.Args().Len() < 1 {
+		return errors.New("block: need at least one feed argument")
+	}
+
+	var lists = make(map[string]bool)
+	for _, arg := range ctx.Args().Slice() {
+		fr, err := refs.ParseFeedRef(arg)
+		if err != nil {
+			return err
+		}
+		lists[fr.Ref()] = subscribe
+	}
+
+	client, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	var val interface{}
+	val, err = client.Async(longctx, val, muxrpc.Method{"ctrl", "subscribeBlockList"}, lists)
+	if err != nil {
+		return err
+	}
+	log.Log("event", "subscribeBlockList reply")
+	goon.Dump(val)
+	return nil
+}
+
 var queryCmd = &cli.Command{
 	Name:   "qry",
 	Action: todo, //query,
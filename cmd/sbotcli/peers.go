@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"gopkg.in/urfave/cli.v2"
+
+	"go.cryptoscope.co/ssb/peerstats"
+)
+
+var peersCmd = &cli.Command{
+	Name:  "peers",
+	Usage: "list the connection history of all known peers",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "json", Usage: "print the full records as JSON"},
+		&cli.StringFlag{Name: "sort", Value: "seen", Usage: "order by seen, connections, failures, received or latency"},
+	},
+	Action: func(ctx *cli.Context) error {
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		v, err := client.Async(longctx, json.RawMessage{}, muxrpc.Method{"status", "peers"})
+		if err != nil {
+			return errors.Wrap(err, "peers: async call failed.")
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return errors.Errorf("peers: unexpected reply type %T", v)
+		}
+
+		if ctx.Bool("json") {
+			_, err = os.Stdout.Write(append(raw, '\n'))
+			return err
+		}
+
+		var peers []peerstats.Peer
+		if err := json.Unmarshal(raw, &peers); err != nil {
+			return errors.Wrap(err, "peers: invalid reply")
+		}
+
+		var less func(a, b peerstats.Peer) bool
+		switch ctx.String("sort") {
+		case "seen":
+			less = func(a, b peerstats.Peer) bool { return a.LastSeen.After(b.LastSeen) }
+		case "connections":
+			less = func(a, b peerstats.Peer) bool { return a.Connections > b.Connections }
+		case "failures":
+			less = func(a, b peerstats.Peer) bool { return failures(a) > failures(b) }
+		case "received":
+			less = func(a, b peerstats.Peer) bool { return a.TotalReceived() > b.TotalReceived() }
+		case "latency":
+			less = func(a, b peerstats.Peer) bool { return a.Latency < b.Latency }
+		default:
+			return errors.Errorf("peers: unknown sort order %q", ctx.String("sort"))
+		}
+		sort.SliceStable(peers, func(i, j int) bool { return less(peers[i], peers[j]) })
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(w, "PEER\tLAST SEEN\tCONNS\tFAILURES\tRX\tTX\tMSGS\tLATENCY\tADDR")
+		for _, p := range peers {
+			lastSeen := "never"
+			if !p.LastSeen.IsZero() {
+				lastSeen = humanize.Time(p.LastSeen)
+			}
+			latency := "-"
+			if p.LatencySamples > 0 {
+				latency = p.Latency.Round(time.Millisecond).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				p.ID.Ref(),
+				lastSeen,
+				p.Connections,
+				formatFailures(p),
+				humanize.Bytes(p.BytesRx),
+				humanize.Bytes(p.BytesTx),
+				p.TotalReceived(),
+				latency,
+				p.Addr,
+			)
+		}
+		return w.Flush()
+	},
+}
+
+func failures(p peerstats.Peer) uint {
+	var n uint
+	for _, cnt := range p.Failures {
+		n += cnt
+	}
+	return n
+}
+
+// formatFailures prints the failure counts by reason, like dial:3,handshake:1
+func formatFailures(p peerstats.Peer) string {
+	if len(p.Failures) == 0 {
+		return "0"
+	}
+	var parts []string
+	for reason, cnt := range p.Failures {
+		parts = append(parts, fmt.Sprintf("%s:%d", reason, cnt))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -4,6 +4,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 
 	"github.com/pkg/errors"
@@ -18,6 +19,7 @@ var publishCmd = &cli.Command{
 	Usage: "p",
 	Subcommands: []*cli.Command{
 		publishRawCmd,
+		publishBatchCmd,
 		publishPostCmd,
 		publishAboutCmd,
 		publishContactCmd,
@@ -43,7 +45,7 @@ var publishRawCmd = &cli.Command{
 		}
 
 		type reply map[string]interface{}
-		v, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, content)
+		v, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, withAs(ctx, content)...)
 		if err != nil {
 			return errors.Wrapf(err, "publish call failed.")
 		}
@@ -53,6 +55,47 @@ var publishRawCmd = &cli.Command{
 	},
 }
 
+var publishBatchCmd = &cli.Command{
+	Name:      "batch",
+	UsageText: "reads a JSON list of contents from stdin and publishes them as consecutive messages, all or none",
+	Flags: []cli.Flag{
+		&cli.StringFlag{Name: "previous", Usage: "only publish if this is the newest message of the feed (empty for an empty feed)"},
+	},
+	Action: func(ctx *cli.Context) error {
+		var contents []interface{}
+		err := json.NewDecoder(os.Stdin).Decode(&contents)
+		if err != nil {
+			return errors.Wrapf(err, "publish/batch: invalid json list from stdin")
+		}
+
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+
+		var keys []*refs.MessageRef
+		if ctx.IsSet("previous") {
+			var prev *refs.MessageRef
+			if p := ctx.String("previous"); p != "" {
+				prev, err = refs.ParseMessageRef(p)
+				if err != nil {
+					return errors.Wrap(err, "publish/batch: invalid previous")
+				}
+			}
+			keys, err = client.PublishAfter(prev, contents...)
+		} else {
+			keys, err = client.PublishBatch(contents...)
+		}
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			fmt.Println(k.Ref())
+		}
+		return nil
+	},
+}
+
 var publishPostCmd = &cli.Command{
 	Name:      "post",
 	ArgsUsage: "text of the post",
@@ -86,10 +129,10 @@ var publishPostCmd = &cli.Command{
 		var v interface{}
 		if recps := ctx.StringSlice("recps"); len(recps) > 0 {
 			v, err = client.Async(longctx, reply{},
-				muxrpc.Method{"private", "publish"}, arg, recps)
+				muxrpc.Method{"private", "publish"}, withAs(ctx, arg, recps)...)
 		} else {
 			v, err = client.Async(longctx, reply{},
-				muxrpc.Method{"publish"}, arg)
+				muxrpc.Method{"publish"}, withAs(ctx, arg)...)
 		}
 		if err != nil {
 			return errors.Wrapf(err, "publish call failed.")
@@ -147,10 +190,10 @@ var publishVoteCmd = &cli.Command{
 		var v interface{}
 		if recps := ctx.StringSlice("recps"); len(recps) > 0 {
 			v, err = client.Async(longctx, reply{},
-				muxrpc.Method{"private", "publish"}, arg, recps)
+				muxrpc.Method{"private", "publish"}, withAs(ctx, arg, recps)...)
 		} else {
 			v, err = client.Async(longctx, reply{},
-				muxrpc.Method{"publish"}, arg)
+				muxrpc.Method{"publish"}, withAs(ctx, arg)...)
 		}
 		if err != nil {
 			return errors.Wrapf(err, "publish call failed.")
@@ -195,7 +238,7 @@ var publishAboutCmd = &cli.Command{
 		}
 
 		type reply map[string]interface{}
-		v, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, arg)
+		v, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, withAs(ctx, arg)...)
 		if err != nil {
 			return errors.Wrapf(err, "publish call failed.")
 		}
@@ -235,7 +278,7 @@ var publishContactCmd = &cli.Command{
 		}
 
 		type reply map[string]interface{}
-		v, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, arg)
+		v, err := client.Async(longctx, reply{}, muxrpc.Method{"publish"}, withAs(ctx, arg)...)
 		if err != nil {
 			return errors.Wrapf(err, "publish call failed.")
 		}
@@ -12,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/ssb/message"
 	refs "go.mindeco.de/ssb-refs"
 	cli "gopkg.in/urfave/cli.v2"
 )
@@ -120,7 +121,10 @@ var privateReadCmd = &cli.Command{
 			return err
 		}
 
-		var args = getStreamArgs(ctx)
+		var args = struct {
+			message.CreateHistArgs
+			As string `json:"as,omitempty"`
+		}{getStreamArgs(ctx), ctx.String("as")}
 		src, err := client.Source(longctx, mapMsg{}, muxrpc.Method{"private", "read"}, args)
 		if err != nil {
 			return errors.Wrap(err, "source stream call failed")
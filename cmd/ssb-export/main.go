@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: MIT
+
+// ssb-export writes the stored messages of some feeds as an archive, which ssb-import reads.
+// The bot of the repo can't be running while it does.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func check(err error) {
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	fmt.Fprintln(os.Stderr, "occurred at")
+	debug.PrintStack()
+	os.Exit(1)
+}
+
+func main() {
+	withBlobs := flag.Bool("blobs", false, "add the blobs the messages mention")
+	out := flag.String("o", "-", "where to write the archive")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s (-blobs, -o archive) <repo> <@feed=>... (- reads them from stdin)\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	var feeds []*refs.FeedRef
+	for _, arg := range args[1:] {
+		if arg != "-" {
+			fr, err := ssb.ParseFeedRef(arg)
+			check(errors.Wrapf(err, "failed to parse %q argument", arg))
+			feeds = append(feeds, fr)
+			continue
+		}
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			line := s.Text()
+			fr, err := ssb.ParseFeedRef(line)
+			check(errors.Wrapf(err, "failed to parse %q line", line))
+			feeds = append(feeds, fr)
+		}
+		check(errors.Wrap(s.Err(), "stdin scanner failed"))
+	}
+
+	w := os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		check(err)
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+
+	bot, err := sbot.New(
+		sbot.WithRepoPath(args[0]),
+		sbot.DisableNetworkNode())
+	check(errors.Wrap(err, "failed to open bot"))
+
+	err = bot.ExportFeeds(bw, feeds, *withBlobs)
+	check(err)
+	check(bw.Flush())
+
+	bot.Shutdown()
+	check(bot.Close())
+}
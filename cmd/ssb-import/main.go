@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+
+// ssb-import adds the messages and blobs of an archive from ssb-export to a repo.
+// The messages are verified like replicated ones. The bot of the repo can't be running while it does.
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/sbot"
+)
+
+func check(err error) {
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	fmt.Fprintln(os.Stderr, "occurred at")
+	debug.PrintStack()
+	os.Exit(1)
+}
+
+func main() {
+	hmacSec := flag.String("hmac", "", "if set, messages are signed with the hmac of this key (base64), like go-sbot -hmac")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 || len(args) > 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s (-hmac key) <repo> [archive] (default stdin)\n", os.Args[0])
+		os.Exit(1)
+	}
+
+	var in io.Reader = os.Stdin
+	if len(args) == 2 && args[1] != "-" {
+		f, err := os.Open(args[1])
+		check(err)
+		defer f.Close()
+		in = f
+	}
+
+	opts := []sbot.Option{
+		sbot.WithRepoPath(args[0]),
+		sbot.DisableNetworkNode(),
+	}
+	if *hmacSec != "" {
+		hk, err := base64.StdEncoding.DecodeString(*hmacSec)
+		check(errors.Wrap(err, "hmac key is not base64"))
+		opts = append(opts, sbot.WithHMACSigning(hk))
+	}
+
+	bot, err := sbot.New(opts...)
+	check(errors.Wrap(err, "failed to open bot"))
+
+	// what was imported before an error stays
+	sum, importErr := bot.ImportFeed(bufio.NewReader(in))
+	log.Printf("imported %d messages and %d blobs, %d messages were already stored", sum.Messages, sum.Blobs, sum.Skipped)
+
+	bot.Shutdown()
+	check(bot.Close())
+	check(importErr)
+}
@@ -1,17 +1,24 @@
 // SPDX-License-Identifier: MIT
 
+// ssb-keygen creates and manages the keypairs of a repo.
+// The name - is the main keypair (secret), all others are in the secrets folder.
+// A name that is also a command needs the new command to create it.
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
 
 	"github.com/pkg/errors"
 
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/sbot"
 	refs "go.mindeco.de/ssb-refs"
 )
 
@@ -31,6 +38,9 @@ func fail(err error) {
 var (
 	repoDir  string
 	feedAlgo string
+	encrypt  bool
+
+	jsSecret string
 )
 
 func init() {
@@ -39,19 +49,36 @@ func init() {
 
 	flag.StringVar(&repoDir, "repo", filepath.Join(u.HomeDir, ".ssb-go"), "where to store the key")
 	flag.StringVar(&feedAlgo, "format", refs.RefAlgoFeedSSB1, "format to use")
+	flag.BoolVar(&encrypt, "encrypt", false, "encrypt new and imported secrets with a passphrase (from SSB_PASSPHRASE, SSB_PASSPHRASE_FILE or the terminal)")
+
+	jsSecret = filepath.Join(u.HomeDir, ".ssb", "secret")
 
 	flag.Parse()
 
 }
 
-func main() {
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: %s (-format=algo, -repo=location, -encrypt) <command>
+
+  <name>                     create a new keypair
+  new <name>                 same, for names that are also commands
+  list                       list the keypairs and their feeds
+  import <name> [file]       import a secret file (default %s)
+  import-mnemonic <name>     make the keypair from the 24 BIP39 words on stdin
+  export <name>              write the secret to stdout
+  encrypt <name>             encrypt the secret with a (new) passphrase
+  decrypt <name>             store the secret without a passphrase
+  migrate <old> <new>        publish a pointer from the old feed to the new one (the bot can't be running)
+
+`, os.Args[0], jsSecret)
+	flag.PrintDefaults()
+	os.Exit(1)
+}
 
+func main() {
 	args := flag.Args()
-
-	if len(args) != 1 {
-		fmt.Fprintf(os.Stderr, "usage: %s (-format=algo, -repo=location) <name>", os.Args[0])
-		flag.PrintDefaults()
-		os.Exit(1)
+	if len(args) < 1 {
+		usage()
 	}
 
 	if feedAlgo != refs.RefAlgoFeedSSB1 && feedAlgo != refs.RefAlgoFeedGabby { //  enums would be nice
@@ -60,8 +87,143 @@ func main() {
 
 	r := repo.New(repoDir)
 
-	kp, err := repo.NewKeyPair(r, args[0], feedAlgo)
-	check(err)
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "list":
+		infos, err := repo.ListKeyPairs(r)
+		check(err)
+		for _, info := range infos {
+			enc := ""
+			if info.Encrypted {
+				enc = " (encrypted)"
+			}
+			fmt.Printf("%s\t%s%s\n", info.Name, info.ID, enc)
+		}
+
+	case "import":
+		if len(args) < 1 || len(args) > 2 {
+			usage()
+		}
+		fname := jsSecret
+		if len(args) == 2 {
+			fname = args[1]
+		}
+		kp, err := ssb.LoadKeyPairForImport(fname, nil)
+		if _, isEnc := errors.Cause(err).(ssb.ErrKeyPairEncrypted); isEnc {
+			pass, perr := repo.DefaultPassphrase()
+			check(perr)
+			kp, err = ssb.LoadKeyPairForImport(fname, pass)
+		}
+		check(errors.Wrap(err, "failed to load secret"))
+		check(repo.ImportKeyPair(r, args[0], kp, newPassphrase()))
+		fmt.Println(kp.Id.Ref())
+
+	case "import-mnemonic":
+		if len(args) != 1 {
+			usage()
+		}
+		fmt.Fprintf(os.Stderr, "enter the %d words, end with ctrl+d:\n", repo.MnemonicWords)
+		words, err := ioutil.ReadAll(os.Stdin)
+		check(err)
+		seed, err := repo.MnemonicSeed(string(words))
+		check(err)
+		kp, err := ssb.NewKeyPair(seed)
+		check(err)
+		kp.Id.Algo = feedAlgo
+		check(repo.ImportKeyPair(r, args[0], kp, newPassphrase()))
+		fmt.Println(kp.Id.Ref())
+
+	case "export":
+		if len(args) != 1 {
+			usage()
+		}
+		kp, err := repo.LoadKeyPairWithPassphrase(r, args[0], repo.DefaultPassphrase)
+		check(err)
+		if pass := newPassphrase(); pass != nil {
+			check(ssb.EncodeKeyPairAsEncryptedJSON(kp, os.Stdout, pass))
+		} else {
+			check(ssb.EncodeKeyPairAsJSON(kp, os.Stdout))
+		}
+
+	case "encrypt", "decrypt":
+		if len(args) != 1 {
+			usage()
+		}
+		var (
+			current = repo.DefaultPassphrase
+			pass    []byte
+		)
+		if cmd == "encrypt" {
+			// the environment has the new one
+			current = repo.PromptPassphrase("current passphrase: ")
+			encrypt = true
+			pass = newPassphrase()
+		}
+		check(repo.EncryptKeyPair(r, args[0], current, pass))
+
+	case "migrate":
+		if len(args) != 2 {
+			usage()
+		}
+		bot, err := sbot.New(
+			sbot.WithRepoPath(repoDir),
+			sbot.DisableNetworkNode())
+		check(errors.Wrap(err, "failed to open bot"))
+
+		ref, err := bot.MigrateFeed(identity(args[0]), identity(args[1]))
+		check(err)
+		fmt.Println(ref.Ref())
+
+		bot.Shutdown()
+		check(bot.Close())
+
+	case "new":
+		if len(args) != 1 {
+			usage()
+		}
+		newKeyPair(r, args[0])
+
+	default:
+		if len(args) != 0 {
+			usage()
+		}
+		newKeyPair(r, cmd)
+	}
+}
 
+func newKeyPair(r repo.Interface, name string) {
+	kp, err := ssb.NewKeyPair(nil)
+	check(err)
+	kp.Id.Algo = feedAlgo
+	check(repo.ImportKeyPair(r, name, kp, newPassphrase()))
 	fmt.Println(kp.Id.Ref())
 }
+
+// identity translates - to the main identity of the bot
+func identity(name string) string {
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// newPassphrase returns nil if -encrypt isn't set.
+// Otherwise it takes the passphrase from the environment or asks twice.
+func newPassphrase() []byte {
+	if !encrypt {
+		return nil
+	}
+	if pass, err := repo.PassphraseFromEnv(); err == nil {
+		return pass
+	}
+	pass, err := repo.PromptPassphrase("new passphrase: ")()
+	check(err)
+	again, err := repo.PromptPassphrase("repeat passphrase: ")()
+	check(err)
+	if !bytes.Equal(pass, again) {
+		check(errors.New("passphrases don't match"))
+	}
+	if len(pass) == 0 {
+		check(errors.New("empty passphrase"))
+	}
+	return pass
+}
@@ -3,6 +3,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"runtime/debug"
@@ -30,13 +31,17 @@ func fail(err error) {
 }
 
 func main() {
+	var toBIPF bool
+	flag.BoolVar(&toBIPF, "bipf", false, "also convert the receive log to bipf storage")
+	flag.Parse()
+
 	logging.SetupLogging(nil)
 	logger := logging.Logger("migrate")
-	if len(os.Args) != 2 {
-		fmt.Fprintln(os.Stderr, "usage: migrate2 <repo>")
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: ssb-migrate-log [-bipf] <repo>")
 		os.Exit(1)
 	}
-	repoDir := os.Args[1]
+	repoDir := flag.Arg(0)
 
 	repo := repo.New(repoDir)
 	didUpgrade, err := migrations.UpgradeToMultiMessage(logger, repo)
@@ -55,4 +60,10 @@ func main() {
 		err = sbot.Close()
 		check(err)
 	}
+
+	if toBIPF {
+		// the sequences stay the same, so the indexes don't need to be rebuilt
+		_, err := migrations.UpgradeToBIPF(logger, repo)
+		check(errors.Wrap(err, "BotInit: bipf migration failed"))
+	}
 }
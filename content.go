@@ -0,0 +1,289 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// ContentValidator checks the content of a message before it is published.
+// It gets the whole content object, including the type field.
+type ContentValidator func(content json.RawMessage) error
+
+// ErrInvalidContent is returned by ValidateContent if the content doesn't match what is registered for its type
+type ErrInvalidContent struct {
+	Type   string
+	Reason string
+}
+
+func (e ErrInvalidContent) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("ssb: invalid content: %s", e.Reason)
+	}
+	return fmt.Sprintf("ssb: invalid %q content: %s", e.Type, e.Reason)
+}
+
+var contentTypes = struct {
+	sync.RWMutex
+	byType map[string]ContentValidator
+}{
+	byType: map[string]ContentValidator{
+		"post":    validatePost,
+		"contact": validateContact,
+		"about":   validateAbout,
+		"vote":    validateVote,
+		"pub":     validatePub,
+
+		ContentTypeFeedMoved: validateFeedMoved,
+	},
+}
+
+// RegisterContentType adds a validator for the content of messages with the type typ.
+// Applications use it for their own types, usually in an init function. The builtin types (post, contact, about, vote, pub and feed/moved) can't be replaced.
+func RegisterContentType(typ string, v ContentValidator) error {
+	if typ == "" || v == nil {
+		return errors.New("ssb: content type needs a name and a validator")
+	}
+	contentTypes.Lock()
+	defer contentTypes.Unlock()
+	if _, has := contentTypes.byType[typ]; has {
+		return errors.Errorf("ssb: content type %s already registered", typ)
+	}
+	contentTypes.byType[typ] = v
+	return nil
+}
+
+// ContentTypes returns the sorted names of all the types that have a validator
+func ContentTypes() []string {
+	contentTypes.RLock()
+	defer contentTypes.RUnlock()
+	types := make([]string, 0, len(contentTypes.byType))
+	for t := range contentTypes.byType {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// ValidateContent checks content against the validator of its type.
+// Encrypted content ([]byte or string), content that isn't an object and objects without or with an unknown type pass unchecked.
+func ValidateContent(content interface{}) error {
+	var raw json.RawMessage
+	switch c := content.(type) {
+	case json.RawMessage:
+		raw = c
+	case []byte, string:
+		return nil
+	default:
+		var err error
+		raw, err = json.Marshal(content)
+		if err != nil {
+			return errors.Wrap(err, "ssb: failed to encode content")
+		}
+	}
+
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), []byte("{")) {
+		return nil
+	}
+
+	var typed struct {
+		Type json.RawMessage `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &typed); err != nil {
+		return ErrInvalidContent{Reason: err.Error()}
+	}
+	if typed.Type == nil {
+		return nil
+	}
+	var typ string
+	if err := json.Unmarshal(typed.Type, &typ); err != nil {
+		return ErrInvalidContent{Reason: "type is not a string"}
+	}
+
+	contentTypes.RLock()
+	v, has := contentTypes.byType[typ]
+	contentTypes.RUnlock()
+	if !has {
+		return nil
+	}
+	if err := v(raw); err != nil {
+		return ErrInvalidContent{Type: typ, Reason: err.Error()}
+	}
+	return nil
+}
+
+// builtin types, these are the ones the bot indexes itself
+
+func validatePost(content json.RawMessage) error {
+	var post struct {
+		Text     *string         `json:"text"`
+		Root     *string         `json:"root"`
+		Branch   json.RawMessage `json:"branch"`
+		Mentions json.RawMessage `json:"mentions"`
+	}
+	if err := json.Unmarshal(content, &post); err != nil {
+		return err
+	}
+	if post.Text == nil {
+		return errors.New("text is missing")
+	}
+	if post.Root != nil {
+		if _, err := refs.ParseMessageRef(*post.Root); err != nil {
+			return errors.Wrap(err, "root is not a message reference")
+		}
+	}
+	if isSet(post.Branch) {
+		// a single reference or a list of them
+		var branches []string
+		if err := json.Unmarshal(post.Branch, &branches); err != nil {
+			var single string
+			if err := json.Unmarshal(post.Branch, &single); err != nil {
+				return errors.New("branch is neither a reference nor a list of them")
+			}
+			branches = []string{single}
+		}
+		for i, b := range branches {
+			if _, err := refs.ParseMessageRef(b); err != nil {
+				return errors.Wrapf(err, "branch %d is not a message reference", i)
+			}
+		}
+	}
+	if isSet(post.Mentions) {
+		var mentions []json.RawMessage
+		if err := json.Unmarshal(post.Mentions, &mentions); err != nil {
+			return errors.New("mentions is not a list")
+		}
+	}
+	return nil
+}
+
+func validateContact(content json.RawMessage) error {
+	var contact struct {
+		Contact   *string `json:"contact"`
+		Following *bool   `json:"following"`
+		Blocking  *bool   `json:"blocking"`
+	}
+	if err := json.Unmarshal(content, &contact); err != nil {
+		return err
+	}
+	if contact.Contact == nil {
+		return errors.New("contact is missing")
+	}
+	if _, err := ParseFeedRef(*contact.Contact); err != nil {
+		return errors.Wrap(err, "contact is not a feed reference")
+	}
+	return nil
+}
+
+func validateAbout(content json.RawMessage) error {
+	var about struct {
+		About       *string         `json:"about"`
+		Name        *string         `json:"name"`
+		Description *string         `json:"description"`
+		Image       json.RawMessage `json:"image"`
+	}
+	if err := json.Unmarshal(content, &about); err != nil {
+		return err
+	}
+	if about.About == nil {
+		return errors.New("about is missing")
+	}
+	if err := parseAnyRef(*about.About); err != nil {
+		return errors.Wrap(err, "about is not a reference")
+	}
+	if isSet(about.Image) {
+		// either the blob reference or an object with it as link
+		var img string
+		if err := json.Unmarshal(about.Image, &img); err != nil {
+			var obj struct {
+				Link string `json:"link"`
+			}
+			if err := json.Unmarshal(about.Image, &obj); err != nil {
+				return errors.New("image is neither a reference nor an object with a link")
+			}
+			img = obj.Link
+		}
+		if _, err := refs.ParseBlobRef(img); err != nil {
+			return errors.Wrap(err, "image is not a blob reference")
+		}
+	}
+	return nil
+}
+
+func validateVote(content json.RawMessage) error {
+	var vote struct {
+		Vote *struct {
+			Link       *string  `json:"link"`
+			Value      *float64 `json:"value"`
+			Expression *string  `json:"expression"`
+		} `json:"vote"`
+	}
+	if err := json.Unmarshal(content, &vote); err != nil {
+		return err
+	}
+	if vote.Vote == nil {
+		return errors.New("vote is missing")
+	}
+	if vote.Vote.Link == nil {
+		return errors.New("vote.link is missing")
+	}
+	if err := parseAnyRef(*vote.Vote.Link); err != nil {
+		return errors.Wrap(err, "vote.link is not a reference")
+	}
+	if vote.Vote.Value == nil {
+		return errors.New("vote.value is missing")
+	}
+	return nil
+}
+
+func validatePub(content json.RawMessage) error {
+	var pub struct {
+		Address *struct {
+			Host *string `json:"host"`
+			Port *int    `json:"port"`
+			Key  *string `json:"key"`
+		} `json:"address"`
+	}
+	if err := json.Unmarshal(content, &pub); err != nil {
+		return err
+	}
+	if pub.Address == nil {
+		return errors.New("address is missing")
+	}
+	addr := pub.Address
+	if addr.Host == nil || *addr.Host == "" {
+		return errors.New("address.host is missing")
+	}
+	if addr.Port == nil || *addr.Port <= 0 || *addr.Port > 65535 {
+		return errors.New("address.port is missing or out of range")
+	}
+	if addr.Key == nil {
+		return errors.New("address.key is missing")
+	}
+	if _, err := ParseFeedRef(*addr.Key); err != nil {
+		return errors.Wrap(err, "address.key is not a feed reference")
+	}
+	return nil
+}
+
+// parseAnyRef accepts feed (including the registered formats), message and blob references
+func parseAnyRef(str string) error {
+	if strings.HasPrefix(str, "@") {
+		_, err := ParseFeedRef(str)
+		return err
+	}
+	_, err := refs.ParseRef(str)
+	return err
+}
+
+func isSet(v json.RawMessage) bool {
+	return len(v) > 0 && !bytes.Equal(v, []byte("null"))
+}
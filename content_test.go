@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateContent(t *testing.T) {
+	const (
+		feed = "@p13zSAiOpguI9nsawkGijsnMfWmFd5rlUNpzekEE+vI=.ed25519"
+		msg  = "%Ou364gh9oMmjRDUaUKeXlVZzYiEdjEz00NEGXaRtnrQ=.sha256"
+		blob = "&Ou364gh9oMmjRDUaUKeXlVZzYiEdjEz00NEGXaRtnrQ=.sha256"
+	)
+
+	type tcase struct {
+		content interface{}
+		valid   bool
+		typ     string
+	}
+	cases := []tcase{
+		// not checked
+		{"c2VjcmV0.box", true, ""},
+		{[]byte("box1:c2VjcmV0"), true, ""},
+		{23, true, ""},
+		{map[string]interface{}{"test": 1}, true, ""},
+		{map[string]interface{}{"type": "unknown", "text": 1}, true, ""},
+		{map[string]interface{}{"type": 1}, false, ""},
+
+		{map[string]interface{}{"type": "post", "text": "hello"}, true, ""},
+		{json.RawMessage(`{"type":"post","text":"hello","root":"` + msg + `","branch":["` + msg + `"],"mentions":[]}`), true, ""},
+		{map[string]interface{}{"type": "post", "text": "hello", "branch": msg}, true, ""},
+		{map[string]interface{}{"type": "post"}, false, "post"},
+		{map[string]interface{}{"type": "post", "text": 42}, false, "post"},
+		{map[string]interface{}{"type": "post", "text": "hi", "root": feed}, false, "post"},
+		{map[string]interface{}{"type": "post", "text": "hi", "mentions": "nope"}, false, "post"},
+
+		{map[string]interface{}{"type": "contact", "contact": feed, "following": true}, true, ""},
+		{map[string]interface{}{"type": "contact"}, false, "contact"},
+		{map[string]interface{}{"type": "contact", "contact": msg}, false, "contact"},
+		{map[string]interface{}{"type": "contact", "contact": feed, "following": "yes"}, false, "contact"},
+
+		{map[string]interface{}{"type": "about", "about": feed, "name": "alice", "image": blob}, true, ""},
+		{map[string]interface{}{"type": "about", "about": msg, "image": map[string]interface{}{"link": blob}}, true, ""},
+		{map[string]interface{}{"type": "about", "name": "alice"}, false, "about"},
+		{map[string]interface{}{"type": "about", "about": feed, "image": feed}, false, "about"},
+
+		{map[string]interface{}{"type": "vote", "vote": map[string]interface{}{"link": msg, "value": 1}}, true, ""},
+		{map[string]interface{}{"type": "vote", "vote": map[string]interface{}{"value": 1}}, false, "vote"},
+		{map[string]interface{}{"type": "vote", "vote": map[string]interface{}{"link": msg}}, false, "vote"},
+
+		{map[string]interface{}{"type": "pub", "address": map[string]interface{}{"host": "example.org", "port": 8008, "key": feed}}, true, ""},
+		{map[string]interface{}{"type": "pub", "address": map[string]interface{}{"host": "example.org", "key": feed}}, false, "pub"},
+	}
+
+	for i, tc := range cases {
+		err := ValidateContent(tc.content)
+		if tc.valid {
+			assert.NoError(t, err, "case %d", i)
+			continue
+		}
+		if !assert.Error(t, err, "case %d", i) {
+			continue
+		}
+		invalid, ok := errors.Cause(err).(ErrInvalidContent)
+		if assert.True(t, ok, "case %d: wrong error %T", i, err) {
+			assert.Equal(t, tc.typ, invalid.Type, "case %d", i)
+		}
+	}
+}
+
+func TestRegisterContentType(t *testing.T) {
+	r := require.New(t)
+
+	r.Error(RegisterContentType("post", func(json.RawMessage) error { return nil }), "replaced builtin")
+	r.Error(RegisterContentType("", func(json.RawMessage) error { return nil }))
+
+	r.NoError(RegisterContentType("test-counter", func(content json.RawMessage) error {
+		var c struct {
+			Count int `json:"count"`
+		}
+		if err := json.Unmarshal(content, &c); err != nil {
+			return err
+		}
+		if c.Count < 0 {
+			return errors.New("count can't be negative")
+		}
+		return nil
+	}))
+	r.Contains(ContentTypes(), "test-counter")
+	r.Error(RegisterContentType("test-counter", func(json.RawMessage) error { return nil }), "registered twice")
+
+	r.NoError(ValidateContent(map[string]interface{}{"type": "test-counter", "count": 3}))
+
+	err := ValidateContent(map[string]interface{}{"type": "test-counter", "count": -1})
+	r.Error(err)
+	r.EqualError(err, `ssb: invalid "test-counter" content: count can't be negative`)
+}
+
+func TestFeedMoved(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	oldKey, err := NewKeyPair(nil)
+	r.NoError(err)
+	newKey, err := NewKeyPair(nil)
+	r.NoError(err)
+
+	moved := NewFeedMoved(oldKey.Id, newKey)
+	r.NoError(moved.Verify())
+	r.NoError(ValidateContent(moved))
+
+	// someone else can't claim the new feed
+	stolen := NewFeedMoved(oldKey.Id, oldKey)
+	stolen.To = newKey.Id.Ref()
+	a.Error(stolen.Verify())
+
+	err = ValidateContent(stolen)
+	r.Error(err)
+	invalid, ok := errors.Cause(err).(ErrInvalidContent)
+	r.True(ok)
+	a.Equal(ContentTypeFeedMoved, invalid.Type)
+
+	// it signs the pointer, not just the new feed
+	other, err := NewKeyPair(nil)
+	r.NoError(err)
+	changed := moved
+	changed.From = other.Id.Ref()
+	a.Error(changed.Verify())
+
+	a.Error(NewFeedMoved(newKey.Id, newKey).Verify(), "moved to itself")
+}
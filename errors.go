@@ -32,6 +32,14 @@ func IsMessageUnusable(err error) bool {
 	if is {
 		return true
 	}
+	_, is = cause.(ErrMessageTooLong)
+	if is {
+		return true
+	}
+	_, is = cause.(ErrNonCanonical)
+	if is {
+		return true
+	}
 	_, is = cause.(*json.SyntaxError)
 	return is
 }
@@ -57,6 +65,26 @@ func (ewt ErrWrongType) Error() string {
 	return fmt.Sprintf("ErrWrongType: want: %s has: %s", ewt.want, ewt.has)
 }
 
+// ErrMessageTooLong is returned for messages that are longer than the protocol allows.
+// For legacy messages Length counts UTF-16 code units of the signed message, like JSON.stringify in JS does.
+type ErrMessageTooLong struct {
+	Length, Max int
+}
+
+func (e ErrMessageTooLong) Error() string {
+	return fmt.Sprintf("ssb: message too long (%d, allowed are %d)", e.Length, e.Max)
+}
+
+// ErrNonCanonical is returned for received messages that verify but aren't encoded the way other implementations expect,
+// storing them would only spread messages that they reject
+type ErrNonCanonical struct {
+	Reason string
+}
+
+func (e ErrNonCanonical) Error() string {
+	return "ssb: message not canonically encoded: " + e.Reason
+}
+
 var ErrUnuspportedFormat = errors.Errorf("ssb: unsupported format")
 
 // ErrWrongSequence is returned if there is a glitch on the current
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+// ContentTypeFeedMoved is the type of FeedMoved messages
+const ContentTypeFeedMoved = "feed/moved"
+
+// FeedMoved is published on an old feed to point to the one that replaces it, after the key was rotated.
+// The message itself is signed by the old key, Proof is the signature of the new key over the pointer.
+// So only someone who holds both keys can publish it. Readers still need to check that the author of the message is From.
+type FeedMoved struct {
+	Type  string `json:"type"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Proof string `json:"proof"`
+}
+
+// NewFeedMoved returns the content that points from the old feed to the new one and signs it with the new key
+func NewFeedMoved(from *refs.FeedRef, to *KeyPair) FeedMoved {
+	fm := FeedMoved{
+		Type: ContentTypeFeedMoved,
+		From: from.Ref(),
+		To:   to.Id.Ref(),
+	}
+	sig := ed25519.Sign(to.Pair.Secret, fm.signedBytes())
+	fm.Proof = base64.StdEncoding.EncodeToString(sig) + ".sig.ed25519"
+	return fm
+}
+
+func (fm FeedMoved) signedBytes() []byte {
+	return []byte(fm.Type + ":" + fm.From + ":" + fm.To)
+}
+
+// Verify checks that both feeds are valid and different and that Proof was made by the key of To
+func (fm FeedMoved) Verify() error {
+	if fm.Type != ContentTypeFeedMoved {
+		return errors.Errorf("wrong type: %q", fm.Type)
+	}
+	from, err := ParseFeedRef(fm.From)
+	if err != nil {
+		return errors.Wrap(err, "from is not a feed reference")
+	}
+	to, err := ParseFeedRef(fm.To)
+	if err != nil {
+		return errors.Wrap(err, "to is not a feed reference")
+	}
+	if from.Equal(to) {
+		return errors.New("from and to are the same feed")
+	}
+	if !strings.HasSuffix(fm.Proof, ".sig.ed25519") {
+		return errors.New("proof is not an ed25519 signature")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(fm.Proof, ".sig.ed25519"))
+	if err != nil {
+		return errors.Wrap(err, "proof is not base64")
+	}
+	if len(to.ID) != ed25519.PublicKeySize || !ed25519.Verify(to.ID, fm.signedBytes(), sig) {
+		return errors.New("proof was not signed by the new feed")
+	}
+	return nil
+}
+
+func validateFeedMoved(content json.RawMessage) error {
+	var fm FeedMoved
+	if err := json.Unmarshal(content, &fm); err != nil {
+		return err
+	}
+	return fm.Verify()
+}
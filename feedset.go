@@ -43,14 +43,14 @@ func (fs *StrFeedSet) AddRef(ref *refs.FeedRef) error {
 
 	copied := ref.Copy()
 
-	fs.set[copied.StoredAddr()] = struct{}{}
+	fs.set[StoredAddr(copied)] = struct{}{}
 	return nil
 }
 
 func (fs *StrFeedSet) Delete(ref *refs.FeedRef) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	delete(fs.set, ref.StoredAddr())
+	delete(fs.set, StoredAddr(ref))
 	return nil
 }
 
@@ -68,12 +68,7 @@ func (fs StrFeedSet) List() ([]*refs.FeedRef, error) {
 	i := 0
 
 	for feed := range fs.set {
-		var sr refs.StorageRef
-		err := sr.Unmarshal([]byte(feed))
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to decode map entry")
-		}
-		got, err := sr.FeedRef()
+		got, err := FeedRefFromStoredAddr(feed)
 		if err != nil {
 			return nil, errors.Wrap(err, "failed to make ref from map entry")
 		}
@@ -87,6 +82,6 @@ func (fs StrFeedSet) List() ([]*refs.FeedRef, error) {
 func (fs StrFeedSet) Has(ref *refs.FeedRef) bool {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
-	_, has := fs.set[ref.StoredAddr()]
+	_, has := fs.set[StoredAddr(ref)]
 	return has
 }
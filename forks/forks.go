@@ -0,0 +1,221 @@
+// SPDX-License-Identifier: MIT
+
+// Package forks keeps the proofs of forked feeds.
+// A proof are two validly signed messages of the same author with the same sequence but different keys.
+// Forked feeds are not replicated any further.
+package forks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	refs "go.mindeco.de/ssb-refs"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Proof is the evidence that a feed forked
+type Proof struct {
+	Feed     *refs.FeedRef `json:"feed"`
+	Sequence int64         `json:"sequence"`
+
+	// Messages are the two signed messages with the same sequence, the stored one first
+	Messages [2]json.RawMessage `json:"messages"`
+
+	// Keys are the references of Messages, in the same order
+	Keys [2]*refs.MessageRef `json:"keys,omitempty"`
+
+	// From is the peer that sent the diverging message (if known)
+	From *refs.FeedRef `json:"from,omitempty"`
+
+	// Sigs are the signatures of Messages, from their feed format (see ssb.MessageSignature).
+	// They are empty for formats that don't provide them.
+	Sigs [2]string `json:"signatures"`
+
+	Detected time.Time `json:"detected"`
+
+	// Published is the key of the fork-proof message, if one was published
+	Published *refs.MessageRef `json:"published,omitempty"`
+}
+
+// Signatures returns the signatures of the two messages, in the same order.
+// Proofs of feeds whose format doesn't provide signatures can't be published and return an error.
+func (p Proof) Signatures() ([2]string, error) {
+	if p.Sigs[0] != "" && p.Sigs[1] != "" {
+		return p.Sigs, nil
+	}
+	if p.Feed == nil {
+		return p.Sigs, fmt.Errorf("forks: proof without feed")
+	}
+	if p.Feed.Algo != refs.RefAlgoFeedSSB1 {
+		return p.Sigs, fmt.Errorf("forks: no signatures for the messages of %s", p.Feed.Ref())
+	}
+
+	// proofs of legacy feeds that were stored before Sigs have them in the message JSON
+	var sigs [2]string
+	for i, m := range p.Messages {
+		var signed struct {
+			Signature string `json:"signature"`
+		}
+		if err := json.Unmarshal(m, &signed); err != nil {
+			return sigs, fmt.Errorf("forks: invalid message %d (%w)", i, err)
+		}
+		if signed.Signature == "" {
+			return sigs, fmt.Errorf("forks: message %d has no signature", i)
+		}
+		sigs[i] = signed.Signature
+	}
+	return sigs, nil
+}
+
+// Store persists the proofs in the repo, one per feed
+type Store struct {
+	mu sync.Mutex
+	kv *kv.DB
+
+	onFork func(Proof)
+}
+
+// Open opens the store of repo r
+func Open(r repo.Interface) (*Store, error) {
+	db, err := repo.OpenMKV(r.GetPath("forks"))
+	if err != nil {
+		return nil, fmt.Errorf("forks: failed to open key-value database (%w)", err)
+	}
+	return &Store{kv: db}, nil
+}
+
+// Close closes the underlying key-value database
+func (s *Store) Close() error { return s.kv.Close() }
+
+// OnFork sets a function that is called (in its own goroutine) for each newly detected fork
+func (s *Store) OnFork(fn func(Proof)) {
+	s.mu.Lock()
+	s.onFork = fn
+	s.mu.Unlock()
+}
+
+// Add stores the proof p, if there isn't one for that feed yet. It returns true if the fork is new.
+func (s *Store) Add(p Proof) (bool, error) {
+	if p.Feed == nil {
+		return false, fmt.Errorf("forks: proof without feed")
+	}
+	if len(p.Messages[0]) == 0 || len(p.Messages[1]) == 0 {
+		return false, fmt.Errorf("forks: proof needs two messages")
+	}
+	if p.Detected.IsZero() {
+		p.Detected = time.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := []byte(ssb.StoredAddr(p.Feed))
+	has, err := s.kv.Get(nil, key)
+	if err != nil {
+		return false, fmt.Errorf("forks: failed to check for existing proof (%w)", err)
+	}
+	if has != nil {
+		return false, nil
+	}
+
+	if err := s.put(p); err != nil {
+		return false, err
+	}
+	if s.onFork != nil {
+		go s.onFork(p)
+	}
+	return true, nil
+}
+
+// SetPublished notes the key of the published fork-proof message of feed
+func (s *Store) SetPublished(feed *refs.FeedRef, msg *refs.MessageRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, err := s.get(feed)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("forks: no proof for %s", feed.Ref())
+	}
+	p.Published = msg
+	return s.put(*p)
+}
+
+// Has returns true if feed forked. Errors are treated as not forked.
+func (s *Store) Has(feed *refs.FeedRef) bool {
+	p, err := s.Get(feed)
+	return err == nil && p != nil
+}
+
+// Get returns the proof for feed, or nil if it didn't fork
+func (s *Store) Get(feed *refs.FeedRef) (*Proof, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(feed)
+}
+
+// List returns all the proofs, the most recently detected first
+func (s *Store) List() ([]Proof, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var all []Proof
+	iter, err := s.kv.SeekFirst()
+	if err == io.EOF {
+		return all, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("forks: failed to iterate proofs (%w)", err)
+	}
+
+	for {
+		_, data, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("forks: failed to get next proof (%w)", err)
+		}
+
+		var p Proof
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("forks: invalid proof (%w)", err)
+		}
+		all = append(all, p)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].Detected.After(all[j].Detected) })
+	return all, nil
+}
+
+func (s *Store) get(feed *refs.FeedRef) (*Proof, error) {
+	data, err := s.kv.Get(nil, []byte(ssb.StoredAddr(feed)))
+	if err != nil {
+		return nil, fmt.Errorf("forks: failed to load proof (%w)", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var p Proof
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("forks: invalid proof (%w)", err)
+	}
+	return &p, nil
+}
+
+func (s *Store) put(p Proof) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("forks: failed to encode proof (%w)", err)
+	}
+	if err := s.kv.Set([]byte(ssb.StoredAddr(p.Feed)), data); err != nil {
+		return fmt.Errorf("forks: failed to store proof (%w)", err)
+	}
+	return nil
+}
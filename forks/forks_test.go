@@ -0,0 +1,123 @@
+// SPDX-License-Identifier: MIT
+
+package forks
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestStore(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	st, err := Open(tRepo)
+	r.NoError(err)
+
+	forked := &refs.FeedRef{ID: bytes.Repeat([]byte("f"), 32), Algo: refs.RefAlgoFeedSSB1}
+	other := &refs.FeedRef{ID: bytes.Repeat([]byte("o"), 32), Algo: refs.RefAlgoFeedSSB1}
+	fine := &refs.FeedRef{ID: bytes.Repeat([]byte("n"), 32), Algo: refs.RefAlgoFeedSSB1}
+
+	notified := make(chan Proof, 2)
+	st.OnFork(func(p Proof) { notified <- p })
+
+	_, err = st.Add(Proof{Feed: forked, Sequence: 3})
+	a.Error(err, "proof without messages")
+
+	now := time.Now()
+	p := Proof{
+		Feed:     forked,
+		Sequence: 3,
+		Messages: [2]json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`{"b":2}`)},
+		Detected: now.Add(-time.Hour),
+	}
+	isNew, err := st.Add(p)
+	r.NoError(err)
+	a.True(isNew)
+
+	select {
+	case got := <-notified:
+		a.Equal(int64(3), got.Sequence)
+	case <-time.After(time.Second):
+		t.Fatal("not notified")
+	}
+
+	// the first proof is kept
+	p.Sequence = 5
+	isNew, err = st.Add(p)
+	r.NoError(err)
+	a.False(isNew)
+
+	p.Feed, p.Detected = other, now
+	_, err = st.Add(p)
+	r.NoError(err)
+
+	// survives a restart
+	r.NoError(st.Close())
+	st, err = Open(tRepo)
+	r.NoError(err)
+	defer st.Close()
+
+	a.True(st.Has(forked))
+	a.False(st.Has(fine))
+
+	got, err := st.Get(forked)
+	r.NoError(err)
+	r.NotNil(got)
+	a.Equal(int64(3), got.Sequence)
+	a.Nil(got.Published)
+
+	key := &refs.MessageRef{Hash: bytes.Repeat([]byte("k"), 32), Algo: refs.RefAlgoMessageSSB1}
+	r.NoError(st.SetPublished(forked, key))
+	a.Error(st.SetPublished(fine, key))
+
+	lst, err := st.List()
+	r.NoError(err)
+	r.Len(lst, 2)
+	a.True(lst[0].Feed.Equal(other), "most recent first")
+	a.True(lst[1].Feed.Equal(forked))
+	r.NotNil(lst[1].Published)
+	a.True(lst[1].Published.Equal(*key))
+}
+
+func TestProofSignatures(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	legacyFeed := &refs.FeedRef{ID: bytes.Repeat([]byte("l"), 32), Algo: refs.RefAlgoFeedSSB1}
+	gabbyFeed := &refs.FeedRef{ID: bytes.Repeat([]byte("g"), 32), Algo: refs.RefAlgoFeedGabby}
+
+	// the ones from the feed format
+	p := Proof{Feed: gabbyFeed, Sigs: [2]string{"a.sig.ed25519", "b.sig.ed25519"}}
+	sigs, err := p.Signatures()
+	r.NoError(err)
+	a.Equal(p.Sigs, sigs)
+
+	// formats without signatures can't be published
+	p.Sigs = [2]string{}
+	p.Messages = [2]json.RawMessage{json.RawMessage(`{"signature":"x"}`), json.RawMessage(`{"signature":"y"}`)}
+	_, err = p.Signatures()
+	a.Error(err)
+
+	// older proofs of legacy feeds have them in the messages
+	p.Feed = legacyFeed
+	sigs, err = p.Signatures()
+	r.NoError(err)
+	a.Equal([2]string{"x", "y"}, sigs)
+
+	p.Messages[1] = json.RawMessage(`{}`)
+	_, err = p.Signatures()
+	a.Error(err)
+}
@@ -0,0 +1,368 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// TODO: move these to ssb-refs once it knows how to parse and store them
+const (
+	// RefAlgoFeedBendyButt is the suffix of metafeed references (see message/bendybutt), like @<key>.bbfeed-v1
+	RefAlgoFeedBendyButt = "bbfeed-v1"
+
+	// RefAlgoMessageBendyButt is the suffix of message references on a metafeed, like %<hash>.bbmsg-v1
+	RefAlgoMessageBendyButt = "bbmsg-v1"
+)
+
+// storedAddrBendyButt prefixes the public key of metafeeds in the multilogs.
+// The resulting address has the same length as the storage references of ssb-refs but a type byte they don't use.
+const storedAddrBendyButt byte = 0xbb
+
+// StoredAddr returns the address of the feed in the multilogs, like ref.StoredAddr()
+// but it also knows about metafeeds and the registered feed formats, which ssb-refs doesn't support.
+func StoredAddr(ref *refs.FeedRef) librarian.Addr {
+	if ref.Algo == RefAlgoFeedBendyButt {
+		return librarian.Addr(append([]byte{storedAddrBendyButt}, ref.ID...))
+	}
+	if ff, has := getRegisteredFeedFormat(ref.Algo); has {
+		return librarian.Addr(append([]byte{ff.StorageType()}, ref.ID...))
+	}
+	return ref.StoredAddr()
+}
+
+// FeedRefFromStoredAddr is the inverse of StoredAddr
+func FeedRefFromStoredAddr(addr librarian.Addr) (*refs.FeedRef, error) {
+	if len(addr) == 33 {
+		algo := ""
+		if addr[0] == storedAddrBendyButt {
+			algo = RefAlgoFeedBendyButt
+		} else if ff, has := GetFeedFormatByStorageType(addr[0]); has && !isBuiltinStorageType(addr[0]) {
+			algo = ff.Algo()
+		}
+		if algo != "" {
+			return &refs.FeedRef{
+				ID:   []byte(addr[1:]),
+				Algo: algo,
+			}, nil
+		}
+	}
+	var sr refs.StorageRef
+	if err := sr.Unmarshal([]byte(addr)); err != nil {
+		return nil, errors.Wrap(err, "failed to decode stored address")
+	}
+	return sr.FeedRef()
+}
+
+// ParseFeedRef is like refs.ParseFeedRef but also accepts metafeed references and the registered feed formats
+func ParseFeedRef(str string) (*refs.FeedRef, error) {
+	dot := strings.LastIndex(str, ".")
+	if dot < 0 {
+		return refs.ParseFeedRef(str)
+	}
+	algo := str[dot+1:]
+	if _, registered := getRegisteredFeedFormat(algo); !registered && algo != RefAlgoFeedBendyButt {
+		return refs.ParseFeedRef(str)
+	}
+	if !strings.HasPrefix(str, "@") {
+		return nil, errors.Errorf("ssb: invalid %s feed reference: %q", algo, str)
+	}
+	id, err := base64.StdEncoding.DecodeString(str[1:dot])
+	if err != nil {
+		return nil, errors.Wrapf(err, "ssb: invalid %s feed reference: %q", algo, str)
+	}
+	if len(id) != 32 {
+		return nil, errors.Errorf("ssb: invalid %s feed reference length: %d", algo, len(id))
+	}
+	return &refs.FeedRef{ID: id, Algo: algo}, nil
+}
+
+// FeedFormat is a feed format that can be plugged into the receive log, the verification of replicated messages,
+// publishing and createHistoryStream, without changing those for every new format.
+// Implementations register themselves through RegisterFeedFormat, usually in an init function of their package.
+// The builtin formats (legacy, gabbygrove and metafeeds) are registered by the message/multimsg package, which owns their storage.
+type FeedFormat interface {
+	// Algo is the suffix of the feed references
+	Algo() string
+
+	// StorageType tags the messages of this format in the receive log and prefixes the public key in the multilogs.
+	// Values below 0x10 are reserved for the builtin formats, see BuiltinStorageType.
+	StorageType() byte
+
+	// Binary formats are sent as raw bytes over createHistoryStream, the others as JSON
+	Binary() bool
+
+	// Encode returns the transfer encoding of the message, which is also used to store it
+	Encode(refs.Message) ([]byte, error)
+
+	// Decode is the inverse of Encode and doesn't check signatures
+	Decode([]byte) (refs.Message, error)
+
+	// Verify decodes a message that was received from a peer and checks its signature. hmacKey is optional.
+	Verify(data []byte, hmacKey *[32]byte) (refs.Message, error)
+
+	// NewCreator returns a FeedCreator to publish new messages on the feed of kp
+	NewCreator(kp *KeyPair) (FeedCreator, error)
+}
+
+// SignatureFormat is implemented by the feed formats that can tell the signature of the author of a message,
+// base64 encoded with the .sig.ed25519 suffix like the ones of legacy messages.
+// Fork proofs are only published for feeds of these formats.
+type SignatureFormat interface {
+	Signature(refs.Message) (string, error)
+}
+
+// MessageSignature returns the signature of the author of msg, from the registered format of its feed (see SignatureFormat).
+// Wrappers like the stored messages of the receive log are unwrapped first.
+func MessageSignature(msg refs.Message) (string, error) {
+	if w, ok := msg.(interface{ Unwrap() refs.Message }); ok {
+		msg = w.Unwrap()
+	}
+	algo := msg.Author().Algo
+	ff, has := GetFeedFormat(algo)
+	if !has {
+		return "", errors.Errorf("ssb: unsupported feed format: %s", algo)
+	}
+	sf, ok := ff.(SignatureFormat)
+	if !ok {
+		return "", errors.Errorf("ssb: feed format %s doesn't provide signatures", algo)
+	}
+	return sf.Signature(msg)
+}
+
+// FeedCreator creates and signs new messages for one feed
+type FeedCreator interface {
+	Create(content interface{}, prev *refs.MessageRef, seq int64) (refs.Message, error)
+
+	// WithHMAC signs the messages for a different network
+	WithHMAC(key []byte) error
+
+	// WithNowTimestamps sets the claimed timestamp of new messages to the current time
+	WithNowTimestamps(yes bool)
+}
+
+// BuiltinStorageType returns the storage type of the builtin format algo in the receive log.
+// Their feeds keep the multilog addresses of ssb-refs (and metafeeds their own), so only the registered formats use it there.
+func BuiltinStorageType(algo string) (byte, bool) {
+	switch algo {
+	case refs.RefAlgoFeedSSB1:
+		return 1, true
+	case refs.RefAlgoFeedGabby:
+		return 2, true
+	case RefAlgoFeedBendyButt:
+		return 3, true
+	}
+	return 0, false
+}
+
+func isBuiltinStorageType(st byte) bool { return st < 0x10 }
+
+var feedFormats = struct {
+	sync.RWMutex
+	byAlgo map[string]FeedFormat
+	byType map[byte]FeedFormat
+
+	// the algos in the order they were registered, the builtin ones first
+	algos    []string
+	identity []string
+}{
+	byAlgo: make(map[string]FeedFormat),
+	byType: make(map[byte]FeedFormat),
+
+	algos:    []string{refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby, RefAlgoFeedBendyButt},
+	identity: []string{refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby},
+}
+
+// FeedFormats lists the feed formats this implementation can verify, store and serve
+func FeedFormats() []string {
+	feedFormats.RLock()
+	defer feedFormats.RUnlock()
+	return append([]string(nil), feedFormats.algos...)
+}
+
+// IdentityFormats are the feed formats a peer can connect with.
+// Metafeeds only exist to announce other feeds and are never used to connect.
+func IdentityFormats() []string {
+	feedFormats.RLock()
+	defer feedFormats.RUnlock()
+	return append([]string(nil), feedFormats.identity...)
+}
+
+// RegisterFeedFormat makes ff available and adds it to FeedFormats and IdentityFormats.
+// The builtin formats need to use their BuiltinStorageType, the others one above it.
+func RegisterFeedFormat(ff FeedFormat) error {
+	feedFormats.Lock()
+	defer feedFormats.Unlock()
+
+	algo, st := ff.Algo(), ff.StorageType()
+	if builtin, isBuiltin := BuiltinStorageType(algo); isBuiltin {
+		if st != builtin {
+			return errors.Errorf("ssb: builtin format %s needs storage type %x, not %x", algo, builtin, st)
+		}
+	} else if isBuiltinStorageType(st) || st == storedAddrBendyButt {
+		return errors.Errorf("ssb: storage type %x of %s is reserved", st, algo)
+	}
+	if _, known := feedFormats.byAlgo[algo]; known {
+		return errors.Errorf("ssb: feed format %s already registered", algo)
+	}
+	if other, taken := feedFormats.byType[st]; taken {
+		return errors.Errorf("ssb: storage type %x of %s already used by %s", st, algo, other.Algo())
+	}
+
+	feedFormats.byAlgo[algo] = ff
+	feedFormats.byType[st] = ff
+	if _, isBuiltin := BuiltinStorageType(algo); !isBuiltin {
+		feedFormats.algos = append(feedFormats.algos, algo)
+		feedFormats.identity = append(feedFormats.identity, algo)
+	}
+	return nil
+}
+
+// GetFeedFormat returns the registered format with the algo suffix
+func GetFeedFormat(algo string) (FeedFormat, bool) {
+	feedFormats.RLock()
+	defer feedFormats.RUnlock()
+	ff, has := feedFormats.byAlgo[algo]
+	return ff, has
+}
+
+// getRegisteredFeedFormat is like GetFeedFormat but leaves out the builtin formats, which have their own references and addresses
+func getRegisteredFeedFormat(algo string) (FeedFormat, bool) {
+	ff, has := GetFeedFormat(algo)
+	if !has || isBuiltinStorageType(ff.StorageType()) {
+		return nil, false
+	}
+	return ff, true
+}
+
+// GetFeedFormatByStorageType returns the registered format with the storage type
+func GetFeedFormatByStorageType(st byte) (FeedFormat, bool) {
+	feedFormats.RLock()
+	defer feedFormats.RUnlock()
+	ff, has := feedFormats.byType[st]
+	return ff, has
+}
+
+// PeerFormats is what a remote peer told us about itself after the secret handshake.
+// Peers that don't support the negotiation (like the JS implementation) leave it empty.
+type PeerFormats struct {
+	// Publishes are the formats of the feeds the peer publishes with its key
+	Publishes []string `json:"publishes,omitempty"`
+
+	// Replicates are the formats the peer can verify and store
+	Replicates []string `json:"replicates,omitempty"`
+}
+
+// CanReplicate returns true if the peer told us that it supports the format.
+// The second return value is false if the peer didn't tell us anything about it's formats.
+func (pf PeerFormats) CanReplicate(format string) (bool, bool) {
+	if len(pf.Replicates) == 0 {
+		return false, false
+	}
+	for _, f := range pf.Replicates {
+		if f == format {
+			return true, true
+		}
+	}
+	return false, true
+}
+
+// FormatTracker remembers the negotiated PeerFormats of remote peers by their public key.
+// The secret handshake only proves the ownership of a key, not in which format the peer publishes,
+// so this is used to pick the right feed reference for a connection.
+type FormatTracker struct {
+	mu    sync.Mutex
+	peers map[string]PeerFormats
+
+	store FormatStore // optional
+}
+
+// FormatStore keeps the negotiated formats of a FormatTracker between restarts, see repo.OpenPeerFormats
+type FormatStore interface {
+	// All returns the stored formats by the public key of the peer
+	All() (map[string]PeerFormats, error)
+
+	// Put stores the formats of the peer with the public key
+	Put(pubKey []byte, pf PeerFormats) error
+}
+
+// NewFormatTracker returns an empty FormatTracker that only keeps the formats in memory
+func NewFormatTracker() *FormatTracker {
+	return &FormatTracker{
+		peers: make(map[string]PeerFormats),
+	}
+}
+
+// LoadFormatTracker returns a FormatTracker with the formats that are in store and saves new ones to it
+func LoadFormatTracker(store FormatStore) (*FormatTracker, error) {
+	peers, err := store.All()
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb: failed to load peer formats")
+	}
+	return &FormatTracker{
+		peers: peers,
+		store: store,
+	}, nil
+}
+
+// Set stores the formats of the peer with the public key of remote.
+// They are only written to the store if they changed.
+func (ft *FormatTracker) Set(remote *refs.FeedRef, pf PeerFormats) error {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	key := string(remote.ID)
+	old, has := ft.peers[key]
+	ft.peers[key] = pf
+	if ft.store == nil || (has && sameFormats(old.Publishes, pf.Publishes) && sameFormats(old.Replicates, pf.Replicates)) {
+		return nil
+	}
+	return ft.store.Put(remote.ID, pf)
+}
+
+func sameFormats(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Get returns the formats of the peer with the public key of remote, if they were negotiated before
+func (ft *FormatTracker) Get(remote *refs.FeedRef) (PeerFormats, bool) {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	pf, has := ft.peers[string(remote.ID)]
+	return pf, has
+}
+
+// Candidates returns the feed references the public key of remote might publish.
+// The negotiated formats come first and if nothing is known about the peer, all the IdentityFormats in order.
+func (ft *FormatTracker) Candidates(remote *refs.FeedRef) []*refs.FeedRef {
+	pf, has := ft.Get(remote)
+	formats := IdentityFormats()
+	if has && len(pf.Publishes) > 0 {
+		formats = pf.Publishes
+	}
+
+	var candidates = make([]*refs.FeedRef, 0, len(formats))
+	for _, algo := range formats {
+		if algo == RefAlgoFeedBendyButt {
+			continue
+		}
+		candidates = append(candidates, &refs.FeedRef{
+			ID:   remote.ID,
+			Algo: algo,
+		})
+	}
+	return candidates
+}
@@ -0,0 +1,149 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+func TestFormatTrackerCandidates(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := NewKeyPair(nil)
+	r.NoError(err)
+
+	ft := NewFormatTracker()
+
+	// nothing known, try all of them
+	cands := ft.Candidates(kp.Id)
+	identityFormats := IdentityFormats()
+	r.Len(cands, len(identityFormats))
+	for i, c := range cands {
+		r.Equal(identityFormats[i], c.Algo)
+		r.Equal(kp.Id.ID, c.ID)
+	}
+
+	// the gabby algo is used by the key after negotiation
+	ggRef := &refs.FeedRef{ID: kp.Id.ID, Algo: refs.RefAlgoFeedGabby}
+	r.NoError(ft.Set(ggRef, PeerFormats{
+		Publishes:  []string{refs.RefAlgoFeedGabby},
+		Replicates: FeedFormats(),
+	}))
+
+	cands = ft.Candidates(kp.Id)
+	r.Len(cands, 1)
+	r.True(cands[0].Equal(ggRef))
+
+	pf, has := ft.Get(kp.Id)
+	r.True(has)
+	yes, known := pf.CanReplicate(refs.RefAlgoFeedGabby)
+	r.True(known)
+	r.True(yes)
+
+	_, known = PeerFormats{}.CanReplicate(refs.RefAlgoFeedGabby)
+	r.False(known)
+}
+
+type memFormatStore map[string]PeerFormats
+
+func (m memFormatStore) All() (map[string]PeerFormats, error) {
+	all := make(map[string]PeerFormats, len(m))
+	for k, pf := range m {
+		all[k] = pf
+	}
+	return all, nil
+}
+
+func (m memFormatStore) Put(pubKey []byte, pf PeerFormats) error {
+	m[string(pubKey)] = pf
+	return nil
+}
+
+func TestFormatTrackerStore(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := NewKeyPair(nil)
+	r.NoError(err)
+	ggRef := &refs.FeedRef{ID: kp.Id.ID, Algo: refs.RefAlgoFeedGabby}
+
+	store := make(memFormatStore)
+	ft, err := LoadFormatTracker(store)
+	r.NoError(err)
+	r.Len(ft.Candidates(kp.Id), len(IdentityFormats()))
+
+	r.NoError(ft.Set(ggRef, PeerFormats{Publishes: []string{refs.RefAlgoFeedGabby}}))
+	r.Len(store, 1)
+
+	// a restart remembers the negotiated format
+	ft, err = LoadFormatTracker(store)
+	r.NoError(err)
+	cands := ft.Candidates(kp.Id)
+	r.Len(cands, 1)
+	r.True(cands[0].Equal(ggRef))
+}
+
+// stubFormat only has an algo and a storage type
+type stubFormat struct {
+	FeedFormat
+	algo string
+	st   byte
+}
+
+func (sf stubFormat) Algo() string      { return sf.algo }
+func (sf stubFormat) StorageType() byte { return sf.st }
+
+func TestRegisterFeedFormat(t *testing.T) {
+	r := require.New(t)
+
+	r.Error(RegisterFeedFormat(stubFormat{algo: refs.RefAlgoFeedSSB1, st: 0x42}), "builtin with another storage type")
+	r.Error(RegisterFeedFormat(stubFormat{algo: "stub-v1", st: 2}), "reserved storage type")
+	r.Error(RegisterFeedFormat(stubFormat{algo: "stub-v1", st: storedAddrBendyButt}), "reserved storage type")
+
+	r.NoError(RegisterFeedFormat(stubFormat{algo: "stub-v1", st: 0x42}))
+	r.Error(RegisterFeedFormat(stubFormat{algo: "stub-v1", st: 0x43}), "registered twice")
+	r.Error(RegisterFeedFormat(stubFormat{algo: "stub-v2", st: 0x42}), "storage type taken")
+
+	r.Contains(FeedFormats(), "stub-v1")
+	r.Contains(IdentityFormats(), "stub-v1")
+
+	// the accessors return copies
+	FeedFormats()[0] = "changed"
+	r.Equal(refs.RefAlgoFeedSSB1, FeedFormats()[0])
+
+	// builtin formats keep their place in the lists and their addresses
+	r.NoError(RegisterFeedFormat(stubFormat{algo: refs.RefAlgoFeedGabby, st: 2}))
+	r.Len(IdentityFormats(), 3)
+	ff, has := GetFeedFormatByStorageType(2)
+	r.True(has)
+	r.Equal(refs.RefAlgoFeedGabby, ff.Algo())
+
+	gg := &refs.FeedRef{ID: make([]byte, 32), Algo: refs.RefAlgoFeedGabby}
+	r.Equal(gg.StoredAddr(), StoredAddr(gg))
+}
+
+func TestStoredAddr(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := NewKeyPair(nil)
+	r.NoError(err)
+
+	for _, algo := range []string{refs.RefAlgoFeedSSB1, refs.RefAlgoFeedGabby, RefAlgoFeedBendyButt} {
+		ref := &refs.FeedRef{ID: kp.Id.ID, Algo: algo}
+		addr := StoredAddr(ref)
+		r.Len(addr, 33, algo)
+
+		back, err := FeedRefFromStoredAddr(addr)
+		r.NoError(err, algo)
+		r.True(back.Equal(ref), "%s came back as %s", algo, back.Ref())
+	}
+
+	// metafeeds don't share the address of the same key in another format
+	mf := &refs.FeedRef{ID: kp.Id.ID, Algo: RefAlgoFeedBendyButt}
+	r.NotEqual(kp.Id.StoredAddr(), StoredAddr(mf))
+
+	_, err = FeedRefFromStoredAddr("nope")
+	r.Error(err)
+}
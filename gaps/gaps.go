@@ -0,0 +1,136 @@
+// SPDX-License-Identifier: MIT
+
+// Package gaps keeps track of the feeds that are only stored partially, because of a replication policy.
+// Sequence checks (message.ValidateNext and sbot.FSCK) need to know about them
+// since the stored messages of these feeds don't start at sequence one or don't form a chain at all.
+package gaps
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	refs "go.mindeco.de/ssb-refs"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Marker describes what is missing of a feed
+type Marker struct {
+	Feed *refs.FeedRef `json:"feed"`
+
+	// From is the sequence of the first stored message, the ones before it are missing.
+	// The stored messages from there on form a chain.
+	From int64 `json:"from,omitempty"`
+
+	// Sparse feeds only have the messages of some types, without a chain between them.
+	Sparse bool     `json:"sparse,omitempty"`
+	Types  []string `json:"types,omitempty"`
+}
+
+// Expected returns the sequence the n-th (0-based) stored message of the feed should have.
+// It is only meaningful for feeds which are not sparse.
+func (m Marker) Expected(n int64) int64 {
+	return m.From + n
+}
+
+// Store persists the markers in the repo
+type Store struct {
+	mu sync.Mutex
+	kv *kv.DB
+}
+
+// Open opens the store of repo r
+func Open(r repo.Interface) (*Store, error) {
+	db, err := repo.OpenMKV(r.GetPath("gaps"))
+	if err != nil {
+		return nil, fmt.Errorf("gaps: failed to open key-value database (%w)", err)
+	}
+	return &Store{kv: db}, nil
+}
+
+// Close closes the underlying key-value database
+func (s *Store) Close() error { return s.kv.Close() }
+
+// Mark stores the marker m, replacing an existing one of the same feed
+func (s *Store) Mark(m Marker) error {
+	if m.Feed == nil {
+		return fmt.Errorf("gaps: marker without feed")
+	}
+	if !m.Sparse && m.From < 1 {
+		return fmt.Errorf("gaps: invalid first sequence %d", m.From)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("gaps: failed to encode marker (%w)", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.kv.Set([]byte(m.Feed.StoredAddr()), data); err != nil {
+		return fmt.Errorf("gaps: failed to store marker (%w)", err)
+	}
+	return nil
+}
+
+// Get returns the marker of feed, or nil if it is stored completely
+func (s *Store) Get(feed *refs.FeedRef) (*Marker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.kv.Get(nil, []byte(feed.StoredAddr()))
+	if err != nil {
+		return nil, fmt.Errorf("gaps: failed to load marker (%w)", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var m Marker
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("gaps: invalid marker (%w)", err)
+	}
+	return &m, nil
+}
+
+// Clear removes the marker of feed, like when it was deleted
+func (s *Store) Clear(feed *refs.FeedRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.kv.Delete([]byte(feed.StoredAddr())); err != nil {
+		return fmt.Errorf("gaps: failed to delete marker (%w)", err)
+	}
+	return nil
+}
+
+// All returns all the markers, keyed by the feed reference
+func (s *Store) All() (map[string]Marker, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	all := make(map[string]Marker)
+	iter, err := s.kv.SeekFirst()
+	if err == io.EOF {
+		return all, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("gaps: failed to iterate markers (%w)", err)
+	}
+
+	for {
+		_, data, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("gaps: failed to get next marker (%w)", err)
+		}
+
+		var m Marker
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("gaps: invalid marker (%w)", err)
+		}
+		all[m.Feed.Ref()] = m
+	}
+	return all, nil
+}
@@ -0,0 +1,63 @@
+// SPDX-License-Identifier: MIT
+
+package gaps
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestStore(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	st, err := Open(tRepo)
+	r.NoError(err)
+
+	tail := &refs.FeedRef{ID: bytes.Repeat([]byte("t"), 32), Algo: refs.RefAlgoFeedSSB1}
+	sparse := &refs.FeedRef{ID: bytes.Repeat([]byte("s"), 32), Algo: refs.RefAlgoFeedSSB1}
+	full := &refs.FeedRef{ID: bytes.Repeat([]byte("f"), 32), Algo: refs.RefAlgoFeedSSB1}
+
+	a.Error(st.Mark(Marker{Feed: tail}), "tail without first sequence")
+	r.NoError(st.Mark(Marker{Feed: tail, From: 42}))
+	r.NoError(st.Mark(Marker{Feed: sparse, Sparse: true, Types: []string{"about", "contact"}}))
+
+	// survives a restart
+	r.NoError(st.Close())
+	st, err = Open(tRepo)
+	r.NoError(err)
+	defer st.Close()
+
+	m, err := st.Get(tail)
+	r.NoError(err)
+	r.NotNil(m)
+	a.Equal(int64(42), m.From)
+	a.Equal(int64(44), m.Expected(2))
+	a.False(m.Sparse)
+
+	m, err = st.Get(full)
+	r.NoError(err)
+	a.Nil(m)
+
+	all, err := st.All()
+	r.NoError(err)
+	r.Len(all, 2)
+	a.True(all[sparse.Ref()].Sparse)
+	a.Equal([]string{"about", "contact"}, all[sparse.Ref()].Types)
+
+	r.NoError(st.Clear(tail))
+	m, err = st.Get(tail)
+	r.NoError(err)
+	a.Nil(m)
+}
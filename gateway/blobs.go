@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MIT
+
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+const blobsPathPrefix = "/blobs/get/"
+
+// inlineTypes are the sniffed content types that are shown in the browser, they can't run scripts.
+// Everything else is served as a download, since the blob could be html that runs on the origin of the RPC bridge.
+var inlineTypes = map[string]bool{
+	"image/png":                 true,
+	"image/jpeg":                true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"image/bmp":                 true,
+	"audio/mpeg":                true,
+	"audio/ogg":                 true,
+	"audio/wave":                true,
+	"video/mp4":                 true,
+	"video/webm":                true,
+	"application/ogg":           true,
+	"text/plain; charset=utf-8": true,
+}
+
+// serveBlob serves the blob with Range requests and caching headers.
+// Blobs are content addressed and public, so neither a token nor an allowed origin is required.
+// Their content comes from anyone on the network, so only the inert types of inlineTypes are shown inline.
+func (g *Gateway) serveBlob(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if g.opts.BlobStore == nil {
+		http.Error(w, "no blob store", http.StatusNotFound)
+		return
+	}
+
+	// the path is already unescaped, which keeps the + and / of the base64 encoding
+	ref, err := refs.ParseBlobRef(strings.TrimPrefix(req.URL.Path, blobsPathPrefix))
+	if err != nil {
+		http.Error(w, "bad blob", http.StatusBadRequest)
+		return
+	}
+
+	br, err := g.opts.BlobStore.Get(ref)
+	if err != nil {
+		if g.opts.WantManager != nil {
+			g.opts.WantManager.Want(ref)
+		}
+		http.Error(w, "no such blob", http.StatusNotFound)
+		return
+	}
+	if c, ok := br.(io.Closer); ok {
+		defer c.Close()
+	}
+
+	rs, ok := br.(io.ReadSeeker)
+	if !ok {
+		data, err := ioutil.ReadAll(br)
+		if err != nil {
+			level.Error(g.log).Log("http-blob", err.Error())
+			http.Error(w, "failed to read blob", http.StatusInternalServerError)
+			return
+		}
+		rs = bytes.NewReader(data)
+	}
+
+	// sniff the type like ServeContent would, but decide here what it may be
+	var head [512]byte
+	n, err := io.ReadFull(rs, head[:])
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		level.Error(g.log).Log("http-blob", err.Error())
+		http.Error(w, "failed to read blob", http.StatusInternalServerError)
+		return
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		level.Error(g.log).Log("http-blob", err.Error())
+		http.Error(w, "failed to read blob", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Access-Control-Allow-Origin", "*")
+	h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	h.Set("ETag", `"`+ref.Ref()+`"`)
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Content-Security-Policy", "sandbox")
+	if ct := http.DetectContentType(head[:n]); inlineTypes[ct] {
+		h.Set("Content-Type", ct)
+	} else {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Disposition", "attachment")
+	}
+
+	// ServeContent handles Range and If-None-Match
+	http.ServeContent(w, req, "", time.Time{}, rs)
+}
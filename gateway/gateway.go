@@ -0,0 +1,185 @@
+// SPDX-License-Identifier: MIT
+
+// Package gateway lets web applications talk to the bot over plain HTTP and websockets.
+//
+// It serves blobs at /blobs/get/&ref (like ssb-ws) and bridges JSON-RPC calls to the muxrpc handlers of the bot,
+// at /rpc for single calls over HTTP and at /rpc/ws for a websocket session.
+// RPC callers authenticate with a token, which is mapped to a feed.
+// The calls are then handled as if that feed had connected over secret-handshake,
+// so the same PluginManager permissions apply: the bot's own feed gets the master plugins, others the public ones (if they are allowed to connect at all).
+package gateway
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/origins"
+)
+
+// Options configure the gateway
+type Options struct {
+	Logger log.Logger
+
+	BlobStore   ssb.BlobStore
+	WantManager ssb.WantManager
+
+	// MakeHandler decides which muxrpc handler a connection gets, based on its (secret-handshake) remote address.
+	MakeHandler func(net.Conn) (muxrpc.Handler, error)
+
+	// Manifest is the muxrpc manifest of the bot, it tells the gateway which calls are async and which are sources.
+	Manifest json.RawMessage
+
+	// Tokens maps the accepted bearer tokens to the feed the calls are made as
+	Tokens map[string]*refs.FeedRef
+
+	// AllowedOrigins are the origins browsers may call the RPC bridge from.
+	// Requests without an Origin header (i.e. not from a browser) are always allowed,
+	// "*" allows all origins and an empty list only allows the origin of the gateway itself.
+	AllowedOrigins []string
+}
+
+// Gateway is an http.Handler that serves blobs and the JSON-RPC bridge
+type Gateway struct {
+	opts Options
+	log  log.Logger
+
+	callTypes map[string]string
+
+	mux *http.ServeMux
+
+	// websocket sessions are hijacked from the http server and need to be closed separately,
+	// the POST requests share one session per token, which stays open until Shutdown.
+	sessionsMu  sync.Mutex
+	closed      bool
+	sessions    map[*session]struct{}
+	rpcSessions map[string]*session
+}
+
+// New parses the manifest and returns the gateway
+func New(opts Options) (*Gateway, error) {
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+	if opts.MakeHandler == nil {
+		return nil, errors.Errorf("gateway: MakeHandler is required")
+	}
+
+	g := &Gateway{
+		opts:      opts,
+		log:       opts.Logger,
+		callTypes: make(map[string]string),
+		sessions:  make(map[*session]struct{}),
+
+		rpcSessions: make(map[string]*session),
+	}
+
+	if len(opts.Manifest) > 0 {
+		var manifest map[string]interface{}
+		if err := json.Unmarshal(opts.Manifest, &manifest); err != nil {
+			return nil, errors.Wrap(err, "gateway: invalid manifest")
+		}
+		flattenManifest(g.callTypes, "", manifest)
+	}
+
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc(blobsPathPrefix, g.serveBlob)
+	g.mux.HandleFunc("/rpc", g.serveRPC)
+	g.mux.HandleFunc("/rpc/ws", g.serveWebsocket)
+	return g, nil
+}
+
+func flattenManifest(out map[string]string, prefix string, manifest map[string]interface{}) {
+	for name, v := range manifest {
+		switch tv := v.(type) {
+		case string:
+			out[prefix+name] = tv
+		case map[string]interface{}:
+			flattenManifest(out, prefix+name+".", tv)
+		}
+	}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	g.mux.ServeHTTP(w, req)
+}
+
+// Shutdown closes all websocket and RPC sessions.
+// Use it together with http.Server.RegisterOnShutdown, which doesn't know about hijacked connections.
+func (g *Gateway) Shutdown() {
+	g.sessionsMu.Lock()
+	defer g.sessionsMu.Unlock()
+	g.closed = true
+	for s := range g.sessions {
+		s.close()
+	}
+}
+
+func (g *Gateway) addSession(s *session) bool {
+	g.sessionsMu.Lock()
+	defer g.sessionsMu.Unlock()
+	if g.closed {
+		return false
+	}
+	g.sessions[s] = struct{}{}
+	return true
+}
+
+func (g *Gateway) removeSession(s *session) {
+	g.sessionsMu.Lock()
+	defer g.sessionsMu.Unlock()
+	delete(g.sessions, s)
+}
+
+// authenticate returns the feed the request is made as and the token it was authenticated with.
+// The token is read from the Authorization header or, because browsers can't set headers on websockets, the token query parameter.
+func (g *Gateway) authenticate(req *http.Request) (*refs.FeedRef, string, bool) {
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = req.URL.Query().Get("token")
+	}
+	if token == "" {
+		return nil, "", false
+	}
+	for t, feed := range g.opts.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return feed, t, true
+		}
+	}
+	return nil, "", false
+}
+
+// CheckOrigin implements the origin allowlist, it has the signature of websocket.Upgrader.CheckOrigin
+func (g *Gateway) CheckOrigin(req *http.Request) bool {
+	return origins.Allowed(g.opts.AllowedOrigins, req)
+}
+
+// allowCORS sets the headers for allowed browser origins and answers preflight requests.
+// It returns false if the request was handled or refused.
+func (g *Gateway) allowCORS(w http.ResponseWriter, req *http.Request) bool {
+	if !g.CheckOrigin(req) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return false
+	}
+	if origin := req.Header.Get("Origin"); origin != "" {
+		h := w.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		h.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, OPTIONS")
+		h.Add("Vary", "Origin")
+	}
+	if req.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+	return true
+}
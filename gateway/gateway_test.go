@@ -0,0 +1,334 @@
+// SPDX-License-Identifier: MIT
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/blobstore"
+)
+
+const testManifest = `{
+	"whoami": "async",
+	"count": "source",
+	"sinky": "sink",
+	"blobs": { "has": "async" }
+}`
+
+// testHandler answers whoami with the feed of the connection and counts to three
+type testHandler struct {
+	remote *refs.FeedRef
+}
+
+func (testHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (h testHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	switch req.Method.String() {
+	case "whoami":
+		req.Return(ctx, map[string]string{"id": h.remote.Ref()})
+	case "count":
+		for i := 1; i <= 3; i++ {
+			req.Stream.Pour(ctx, i)
+		}
+		req.Stream.Close()
+	default:
+		req.Stream.CloseWithError(fmt.Errorf("unhandled: %s", req.Method))
+	}
+}
+
+func makeTestGateway(t *testing.T, opts Options) (*Gateway, *refs.FeedRef, *refs.FeedRef) {
+	r := require.New(t)
+
+	allowed, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	stranger, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	opts.MakeHandler = func(conn net.Conn) (muxrpc.Handler, error) {
+		remote, err := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+		if err != nil {
+			return nil, err
+		}
+		if !remote.Equal(allowed.Id) {
+			return nil, errors.Errorf("not allowed: %s", remote.Ref())
+		}
+		return testHandler{remote: remote}, nil
+	}
+	opts.Manifest = json.RawMessage(testManifest)
+	opts.Tokens = map[string]*refs.FeedRef{
+		"allowed-token": allowed.Id,
+		"stranger":      stranger.Id,
+	}
+
+	g, err := New(opts)
+	r.NoError(err)
+	return g, allowed.Id, stranger.Id
+}
+
+func TestBlobs(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tPath)
+
+	bs, err := blobstore.New(tPath)
+	r.NoError(err)
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+	ref, err := bs.Put(bytes.NewReader(png))
+	r.NoError(err)
+
+	g, _, _ := makeTestGateway(t, Options{BlobStore: bs})
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + blobsPathPrefix + ref.Ref())
+	r.NoError(err)
+	body, err := ioutil.ReadAll(resp.Body)
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal("image/png", resp.Header.Get("Content-Type"))
+	a.Equal("*", resp.Header.Get("Access-Control-Allow-Origin"))
+	a.Equal(png, body)
+
+	// a range
+	req, err := http.NewRequest(http.MethodGet, srv.URL+blobsPathPrefix+ref.Ref(), nil)
+	r.NoError(err)
+	req.Header.Set("Range", "bytes=1-3")
+	resp, err = http.DefaultClient.Do(req)
+	r.NoError(err)
+	body, err = ioutil.ReadAll(resp.Body)
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusPartialContent, resp.StatusCode)
+	a.Equal("PNG", string(body))
+
+	a.Equal("nosniff", resp.Header.Get("X-Content-Type-Options"))
+	a.Equal("sandbox", resp.Header.Get("Content-Security-Policy"))
+
+	// html from the network is only a download, whatever the caller asks for
+	html, err := bs.Put(strings.NewReader(`<html><script>fetch("/rpc")</script></html>`))
+	r.NoError(err)
+	resp, err = http.Get(srv.URL + blobsPathPrefix + html.Ref() + "?contentType=text/html")
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal("application/octet-stream", resp.Header.Get("Content-Type"))
+	a.Equal("attachment", resp.Header.Get("Content-Disposition"))
+	a.Equal("sandbox", resp.Header.Get("Content-Security-Policy"))
+
+	// unknown and invalid blobs
+	missing := strings.Replace(ref.Ref(), ref.Ref()[1:5], "AAAA", 1)
+	resp, err = http.Get(srv.URL + blobsPathPrefix + missing)
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusNotFound, resp.StatusCode)
+
+	resp, err = http.Get(srv.URL + blobsPathPrefix + "nope")
+	r.NoError(err)
+	resp.Body.Close()
+	a.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func postRPC(t *testing.T, url, token, origin, body string) (*http.Response, map[string]interface{}) {
+	r := require.New(t)
+
+	req, err := http.NewRequest(http.MethodPost, url+"/rpc", strings.NewReader(body))
+	r.NoError(err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	r.NoError(err)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	var reply map[string]interface{}
+	r.NoError(json.NewDecoder(resp.Body).Decode(&reply))
+	return resp, reply
+}
+
+func TestRPC(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	g, allowed, _ := makeTestGateway(t, Options{AllowedOrigins: []string{"http://localhost:3000"}})
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	defer g.Shutdown()
+
+	resp, reply := postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	r.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal(float64(1), reply["id"])
+	a.Equal(map[string]interface{}{"id": allowed.Ref()}, reply["result"])
+
+	_, reply = postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":"c","method":"count"}`)
+	a.Equal([]interface{}{float64(1), float64(2), float64(3)}, reply["result"])
+
+	// calls the handler doesn't know about fail
+	_, reply = postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":2,"method":"blobs.has","params":["&foo"]}`)
+	r.NotNil(reply["error"])
+	a.Equal(float64(codeCallFailed), reply["error"].(map[string]interface{})["code"])
+
+	// not in the manifest or not supported
+	for _, m := range []string{"nope", "sinky"} {
+		_, reply = postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":3,"method":"`+m+`"}`)
+		r.NotNil(reply["error"], m)
+		a.Equal(float64(codeMethodNotFound), reply["error"].(map[string]interface{})["code"], m)
+	}
+
+	// authentication and permissions
+	resp, _ = postRPC(t, srv.URL, "", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = postRPC(t, srv.URL, "wrong-token", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode)
+	resp, _ = postRPC(t, srv.URL, "stranger", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+
+	// origins
+	resp, _ = postRPC(t, srv.URL, "allowed-token", "http://localhost:3000", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	a.Equal(http.StatusOK, resp.StatusCode)
+	a.Equal("http://localhost:3000", resp.Header.Get("Access-Control-Allow-Origin"))
+	resp, _ = postRPC(t, srv.URL, "allowed-token", "http://evil.example", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+}
+
+// connectCounter is a testHandler that counts the calls to HandleConnect
+type connectCounter struct {
+	testHandler
+	connects *int32
+}
+
+func (h connectCounter) HandleConnect(context.Context, muxrpc.Endpoint) {
+	atomic.AddInt32(h.connects, 1)
+}
+
+func TestRPCNoConnect(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	g, _, _ := makeTestGateway(t, Options{})
+	var connects int32
+	mkHandler := g.opts.MakeHandler
+	g.opts.MakeHandler = func(conn net.Conn) (muxrpc.Handler, error) {
+		h, err := mkHandler(conn)
+		if err != nil {
+			return nil, err
+		}
+		return connectCounter{testHandler: h.(testHandler), connects: &connects}, nil
+	}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+	defer g.Shutdown()
+
+	// the call is answered after the handler was set up, so a connect would have happened by now
+	resp, reply := postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	r.Equal(http.StatusOK, resp.StatusCode)
+	a.NotNil(reply["result"])
+	a.EqualValues(0, atomic.LoadInt32(&connects), "gateway sessions aren't peers")
+}
+
+func TestRPCSessions(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	g, _, _ := makeTestGateway(t, Options{})
+	var made int32
+	mkHandler := g.opts.MakeHandler
+	g.opts.MakeHandler = func(conn net.Conn) (muxrpc.Handler, error) {
+		atomic.AddInt32(&made, 1)
+		return mkHandler(conn)
+	}
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	for i := 0; i < 5; i++ {
+		resp, reply := postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+		r.Equal(http.StatusOK, resp.StatusCode)
+		a.NotNil(reply["result"])
+	}
+	a.EqualValues(1, atomic.LoadInt32(&made), "one session per token")
+
+	// refused sessions aren't kept
+	for i := 0; i < 2; i++ {
+		resp, _ := postRPC(t, srv.URL, "stranger", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+		a.Equal(http.StatusForbidden, resp.StatusCode)
+	}
+	a.EqualValues(3, atomic.LoadInt32(&made))
+
+	g.Shutdown()
+	resp, _ := postRPC(t, srv.URL, "allowed-token", "", `{"jsonrpc":"2.0","id":1,"method":"whoami"}`)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+}
+
+func TestWebsocket(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	g, allowed, _ := makeTestGateway(t, Options{})
+	srv := httptest.NewServer(g)
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/rpc/ws"
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	r.Error(err)
+	a.Equal(http.StatusUnauthorized, resp.StatusCode)
+
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL+"?token=stranger", nil)
+	r.Error(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?token=allowed-token", nil)
+	r.NoError(err)
+
+	r.NoError(conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "count"}))
+
+	var values []interface{}
+	for {
+		var msg map[string]interface{}
+		r.NoError(conn.ReadJSON(&msg))
+		if msg["method"] == "stream" {
+			params := msg["params"].(map[string]interface{})
+			a.Equal(float64(1), params["id"])
+			values = append(values, params["value"])
+			continue
+		}
+		a.Equal(float64(1), msg["id"])
+		a.Equal(true, msg["result"])
+		break
+	}
+	a.Equal([]interface{}{float64(1), float64(2), float64(3)}, values)
+
+	r.NoError(conn.WriteJSON(map[string]interface{}{"jsonrpc": "2.0", "id": 2, "method": "whoami"}))
+	var msg map[string]interface{}
+	r.NoError(conn.ReadJSON(&msg))
+	a.Equal(map[string]interface{}{"id": allowed.Ref()}, msg["result"])
+
+	// shutting down closes the session
+	g.Shutdown()
+	_, _, err = conn.ReadMessage()
+	a.Error(err)
+}
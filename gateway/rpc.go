@@ -0,0 +1,364 @@
+// SPDX-License-Identifier: MIT
+
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/internal/netwraputil"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeCallFailed     = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcNotification carries the values of a source call over the websocket, ahead of the response with the same id
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  streamValue `json:"params"`
+}
+
+type streamValue struct {
+	ID    json.RawMessage `json:"id"`
+	Value interface{}     `json:"value"`
+}
+
+func errorResponse(id json.RawMessage, code int, err error) rpcResponse {
+	return rpcResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &rpcError{Code: code, Message: err.Error()},
+	}
+}
+
+// session is a muxrpc connection to the handlers of the bot, as if feed had connected over secret-handshake
+type session struct {
+	edp muxrpc.Endpoint
+
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closed  bool
+	closers []io.Closer
+}
+
+func (g *Gateway) newSession(feed *refs.FeedRef) (*session, error) {
+	clientConn, serverConn := net.Pipe()
+
+	spoofed, err := netwraputil.SpoofRemoteAddress(feed.PubKey())(serverConn)
+	if err != nil {
+		clientConn.Close()
+		serverConn.Close()
+		return nil, errors.Wrap(err, "gateway: failed to wrap connection")
+	}
+
+	h, err := g.opts.MakeHandler(spoofed)
+	if err != nil {
+		clientConn.Close()
+		serverConn.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &session{
+		cancel:  cancel,
+		closers: []io.Closer{clientConn, serverConn},
+	}
+
+	srvEdp := muxrpc.HandleWithRemote(muxrpc.NewPacker(spoofed), callsOnly{h}, spoofed.RemoteAddr())
+	s.edp = muxrpc.Handle(muxrpc.NewPacker(clientConn), noopHandler{})
+
+	for _, edp := range []muxrpc.Endpoint{srvEdp, s.edp} {
+		srv, ok := edp.(muxrpc.Server)
+		if !ok {
+			s.close()
+			return nil, errors.Errorf("gateway: failed to cast handler to muxrpc server (has type: %T)", edp)
+		}
+		go func() {
+			err := srv.Serve(ctx)
+			if err != nil && ctx.Err() == nil {
+				level.Debug(g.log).Log("event", "gateway session exited", "err", err)
+			}
+			s.close()
+		}()
+	}
+	return s, nil
+}
+
+// rpcSession returns the session of token for POST requests, it is made on first use and again once it ended.
+// Failures aren't kept, the next request tries again.
+func (g *Gateway) rpcSession(token string, feed *refs.FeedRef) (*session, error) {
+	g.sessionsMu.Lock()
+	defer g.sessionsMu.Unlock()
+	if g.closed {
+		return nil, errors.Errorf("gateway: shutting down")
+	}
+	if s, has := g.rpcSessions[token]; has {
+		if !s.isClosed() {
+			return s, nil
+		}
+		delete(g.sessions, s)
+	}
+
+	s, err := g.newSession(feed)
+	if err != nil {
+		delete(g.rpcSessions, token)
+		return nil, err
+	}
+	g.rpcSessions[token] = s
+	g.sessions[s] = struct{}{}
+	return s, nil
+}
+
+// addCloser closes c together with the session, or right away if it already is closed
+func (s *session) addCloser(c io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		c.Close()
+		return
+	}
+	s.closers = append(s.closers, c)
+}
+
+func (s *session) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.cancel()
+	for _, c := range s.closers {
+		c.Close()
+	}
+}
+
+// call makes the muxrpc call described by req.
+// The values of source calls are passed to emit, the result of a source call is true once it ended.
+func (g *Gateway) call(ctx context.Context, s *session, req rpcRequest, emit func(interface{}) error) (interface{}, int, error) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return nil, codeInvalidRequest, errors.Errorf("invalid JSON-RPC 2.0 request")
+	}
+	tipe, ok := g.callTypes[req.Method]
+	if !ok {
+		return nil, codeMethodNotFound, errors.Errorf("no such method: %s", req.Method)
+	}
+
+	method := muxrpc.Method(strings.Split(req.Method, "."))
+	args := make([]interface{}, len(req.Params))
+	for i, p := range req.Params {
+		args[i] = p
+	}
+
+	switch tipe {
+	case "async", "sync":
+		v, err := s.edp.Async(ctx, json.RawMessage{}, method, args...)
+		if err != nil {
+			return nil, codeCallFailed, err
+		}
+		if v == nil {
+			// a result is required by JSON-RPC
+			v = json.RawMessage("null")
+		}
+		return v, 0, nil
+
+	case "source":
+		src, err := s.edp.Source(ctx, json.RawMessage{}, method, args...)
+		if err != nil {
+			return nil, codeCallFailed, err
+		}
+		for {
+			v, err := src.Next(ctx)
+			if luigi.IsEOS(err) {
+				return true, 0, nil
+			} else if err != nil {
+				return nil, codeCallFailed, err
+			}
+			if err := emit(v); err != nil {
+				return nil, codeCallFailed, err
+			}
+		}
+	}
+	return nil, codeMethodNotFound, errors.Errorf("%s calls are not supported by the gateway: %s", tipe, req.Method)
+}
+
+// serveRPC makes a single call per POST request, over the session of the token. The values of a source are returned as an array.
+func (g *Gateway) serveRPC(w http.ResponseWriter, req *http.Request) {
+	if !g.allowCORS(w, req) {
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	feed, token, ok := g.authenticate(req)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	var rpcReq rpcRequest
+	if err := json.NewDecoder(req.Body).Decode(&rpcReq); err != nil {
+		json.NewEncoder(w).Encode(errorResponse(nil, codeParseError, err))
+		return
+	}
+
+	s, err := g.rpcSession(token, feed)
+	if err != nil {
+		level.Warn(g.log).Log("event", "gateway rpc refused", "feed", feed.Ref(), "err", err)
+		http.Error(w, "not allowed", http.StatusForbidden)
+		return
+	}
+
+	var values []interface{}
+	v, code, err := g.call(req.Context(), s, rpcReq, func(v interface{}) error {
+		values = append(values, v)
+		return nil
+	})
+	if err != nil {
+		json.NewEncoder(w).Encode(errorResponse(rpcReq.ID, code, err))
+		return
+	}
+	if g.callTypes[rpcReq.Method] == "source" {
+		v = values
+		if values == nil {
+			v = []interface{}{}
+		}
+	}
+	json.NewEncoder(w).Encode(rpcResponse{JSONRPC: "2.0", ID: rpcReq.ID, Result: v})
+}
+
+// serveWebsocket keeps one muxrpc session for the lifetime of the websocket and makes the calls concurrently.
+// The values of a source are sent as stream notifications, followed by the response with true as the result.
+func (g *Gateway) serveWebsocket(w http.ResponseWriter, req *http.Request) {
+	feed, _, ok := g.authenticate(req)
+	if !ok {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024 * 4,
+		WriteBufferSize: 1024 * 4,
+		CheckOrigin:     g.CheckOrigin,
+	}
+
+	s, err := g.newSession(feed)
+	if err != nil {
+		level.Warn(g.log).Log("event", "gateway websocket refused", "feed", feed.Ref(), "err", err)
+		http.Error(w, "not allowed", http.StatusForbidden)
+		return
+	}
+	defer s.close()
+
+	wsConn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		level.Debug(g.log).Log("event", "gateway websocket upgrade failed", "err", err)
+		return
+	}
+	s.addCloser(wsConn)
+
+	if !g.addSession(s) {
+		return
+	}
+	defer g.removeSession(s)
+
+	var writeMu sync.Mutex
+	write := func(v interface{}) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return wsConn.WriteJSON(v)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for {
+		var rpcReq rpcRequest
+		if err := wsConn.ReadJSON(&rpcReq); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				write(errorResponse(nil, codeParseError, err))
+				continue
+			}
+			return
+		}
+
+		go func(rpcReq rpcRequest) {
+			v, code, err := g.call(ctx, s, rpcReq, func(v interface{}) error {
+				return write(rpcNotification{
+					JSONRPC: "2.0",
+					Method:  "stream",
+					Params:  streamValue{ID: rpcReq.ID, Value: v},
+				})
+			})
+			if err != nil {
+				write(errorResponse(rpcReq.ID, code, err))
+				return
+			}
+			write(rpcResponse{JSONRPC: "2.0", ID: rpcReq.ID, Result: v})
+		}(rpcReq)
+	}
+}
+
+// callsOnly skips the HandleConnect of the bot's handler.
+// It would treat the session like a peer and start gossip with the client side, which doesn't answer any calls.
+type callsOnly struct {
+	muxrpc.Handler
+}
+
+func (callsOnly) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+type noopHandler struct{}
+
+func (noopHandler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
+
+func (noopHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	req.Stream.CloseWithError(fmt.Errorf("gateway: unsupported call"))
+}
@@ -52,16 +52,22 @@ type builder struct {
 
 	log kitlog.Logger
 
+	// unboxers are used to decrypt private contact messages of local identities
+	unboxers []*ssb.KeyPair
+
 	cacheLock   sync.Mutex
 	cachedGraph *Graph
 }
 
-// NewBuilder creates a Builder that is backed by a badger database
-func NewBuilder(log kitlog.Logger, db *badger.DB) *builder {
+// NewBuilder creates a Builder that is backed by a badger database.
+// The optional keypairs are used to honour private blocks, which are contact messages encrypted to self.
+func NewBuilder(log kitlog.Logger, db *badger.DB, unboxers ...*ssb.KeyPair) *builder {
 	b := &builder{
 		kv:  db,
 		idx: libbadger.NewIndex(db, 0),
 		log: log,
+
+		unboxers: unboxers,
 	}
 	return b
 }
@@ -84,17 +90,29 @@ func (b *builder) indexUpdateFunc(ctx context.Context, seq margaret.Seq, val int
 		return err
 	}
 
+	content := abs.ContentBytes()
+
+	clearContent, isPrivate := b.unboxContact(abs)
+	if isPrivate {
+		content = clearContent
+	}
+
 	var c refs.Contact
-	err := c.UnmarshalJSON(abs.ContentBytes())
+	err := c.UnmarshalJSON(content)
 	if err != nil {
 		// just ignore invalid messages, nothing to do with them (unless you are debugging something)
 		//level.Warn(b.log).Log("msg", "skipped contact message", "reason", err)
 		return nil
 	}
 
-	addr := abs.Author().StoredAddr()
-	addr += c.Contact.StoredAddr()
+	addr := ssb.StoredAddr(abs.Author())
+	addr += ssb.StoredAddr(c.Contact)
 	switch {
+	case isPrivate && c.Blocking:
+		err = idx.Set(ctx, addr, 2)
+	case isPrivate && c.Following:
+		// private follows are not a thing, only blocks are honoured from encrypted messages
+		return nil
 	case c.Following:
 		err = idx.Set(ctx, addr, 1)
 	case c.Blocking:
@@ -130,7 +148,7 @@ func (b *builder) DeleteAuthor(who *refs.FeedRef) error {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
-		prefix := []byte(who.StoredAddr())
+		prefix := []byte(ssb.StoredAddr(who))
 		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
 			it := iter.Item()
 
@@ -181,20 +199,12 @@ func (b *builder) Build() (*Graph, error) {
 				continue
 			}
 
-			var to, from refs.StorageRef
-			if err := from.Unmarshal(rawFrom); err != nil {
-				return errors.Wrapf(err, "builder: couldnt idx key value (from)")
-			}
-			if err := to.Unmarshal(rawTo); err != nil {
-				return errors.Wrap(err, "builder: couldnt idx key value (to)")
-			}
-
 			bfrom := librarian.Addr(rawFrom)
 			nFrom, has := dg.lookup[bfrom]
 			if !has {
-				fromRef, err := from.FeedRef()
+				fromRef, err := ssb.FeedRefFromStoredAddr(bfrom)
 				if err != nil {
-					return err
+					return errors.Wrapf(err, "builder: couldnt idx key value (from)")
 				}
 
 				nFrom = &contactNode{dg.NewNode(), fromRef.Copy(), ""}
@@ -205,9 +215,9 @@ func (b *builder) Build() (*Graph, error) {
 			bto := librarian.Addr(rawTo)
 			nTo, has := dg.lookup[bto]
 			if !has {
-				toRef, err := to.FeedRef()
+				toRef, err := ssb.FeedRefFromStoredAddr(bto)
 				if err != nil {
-					return err
+					return errors.Wrap(err, "builder: couldnt idx key value (to)")
 				}
 				nTo = &contactNode{dg.NewNode(), toRef.Copy(), ""}
 				dg.AddNode(nTo)
@@ -260,7 +270,7 @@ type Lookup struct {
 }
 
 func (l Lookup) Dist(to *refs.FeedRef) ([]graph.Node, float64) {
-	bto := to.StoredAddr()
+	bto := ssb.StoredAddr(to)
 	nTo, has := l.lookup[bto]
 	if !has {
 		return nil, math.Inf(-1)
@@ -277,7 +287,7 @@ func (b *builder) Follows(forRef *refs.FeedRef) (*ssb.StrFeedSet, error) {
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
-		prefix := []byte(forRef.StoredAddr())
+		prefix := []byte(ssb.StoredAddr(forRef))
 		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
 			it := iter.Item()
 			k := it.Key()
@@ -286,12 +296,11 @@ func (b *builder) Follows(forRef *refs.FeedRef) (*ssb.StrFeedSet, error) {
 				if len(v) >= 1 && v[0] == '1' {
 					// extract 2nd feed ref out of db key
 					// TODO: use compact StoredAddr
-					var sr refs.StorageRef
-					err := sr.Unmarshal(k[33:])
+					followed, err := ssb.FeedRefFromStoredAddr(librarian.Addr(k[33:]))
 					if err != nil {
 						return errors.Wrapf(err, "follows(%s): invalid ref entry in db for feed", forRef.Ref())
 					}
-					if err := fs.AddStored(&sr); err != nil {
+					if err := fs.AddRef(followed); err != nil {
 						return errors.Wrapf(err, "follows(%s): couldn't add parsed ref feed", forRef.Ref())
 					}
 				}
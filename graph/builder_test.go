@@ -29,6 +29,10 @@ import (
 )
 
 func makeBadger(t *testing.T) testStore {
+	return makeBadgerWithUnboxers(t)
+}
+
+func makeBadgerWithUnboxers(t *testing.T, unboxers ...*ssb.KeyPair) testStore {
 	r := require.New(t)
 	info := testutils.NewRelativeTimeLogger(nil)
 
@@ -50,7 +54,7 @@ func makeBadger(t *testing.T) testStore {
 
 	var tc testStore
 	_, sinkIdx, serve, err := repo.OpenBadgerIndex(tRepo, "contacts", func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
-		builder = NewBuilder(info, db)
+		builder = NewBuilder(info, db, unboxers...)
 		return builder.OpenIndex()
 	})
 	r.NoError(err)
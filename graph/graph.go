@@ -32,11 +32,11 @@ func NewGraph() *Graph {
 func (g *Graph) getEdge(from, to *refs.FeedRef) (graph.WeightedEdge, bool) {
 	g.Mutex.Lock()
 	defer g.Mutex.Unlock()
-	nFrom, has := g.lookup[from.StoredAddr()]
+	nFrom, has := g.lookup[ssb.StoredAddr(from)]
 	if !has {
 		return nil, false
 	}
-	nTo, has := g.lookup[to.StoredAddr()]
+	nTo, has := g.lookup[ssb.StoredAddr(to)]
 	if !has {
 		return nil, false
 	}
@@ -67,7 +67,7 @@ func (g *Graph) BlockedList(from *refs.FeedRef) *ssb.StrFeedSet {
 	g.Mutex.Lock()
 	defer g.Mutex.Unlock()
 	blocked := ssb.NewFeedSet(0)
-	nFrom, has := g.lookup[from.StoredAddr()]
+	nFrom, has := g.lookup[ssb.StoredAddr(from)]
 	if !has {
 		return blocked
 	}
@@ -88,7 +88,7 @@ func (g *Graph) BlockedList(from *refs.FeedRef) *ssb.StrFeedSet {
 func (g *Graph) MakeDijkstra(from *refs.FeedRef) (*Lookup, error) {
 	g.Mutex.Lock()
 	defer g.Mutex.Unlock()
-	nFrom, has := g.lookup[from.StoredAddr()]
+	nFrom, has := g.lookup[ssb.StoredAddr(from)]
 	if !has {
 		return nil, ErrNoSuchFrom{Who: from}
 	}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private"
+)
+
+// unboxContact tries to decrypt the content of msg with one of the configured keypairs.
+// Only messages authored by one of those keypairs are considered, since we only want to honour our own private blocks.
+func (b *builder) unboxContact(msg refs.Message) ([]byte, bool) {
+	var kp *ssb.KeyPair
+	for _, candidate := range b.unboxers {
+		if candidate.Id.Equal(msg.Author()) {
+			kp = candidate
+			break
+		}
+	}
+	if kp == nil {
+		return nil, false
+	}
+
+	var boxedContent []byte
+	switch msg.Author().Algo {
+	case refs.RefAlgoFeedSSB1:
+		input := msg.ContentBytes()
+		if len(input) < 2 || !(input[0] == '"' && input[len(input)-1] == '"') {
+			return nil, false // not a json string
+		}
+		b64data := bytes.TrimSuffix(input[1:], []byte(".box\""))
+		boxedData := make([]byte, len(b64data))
+		n, err := base64.StdEncoding.Decode(boxedData, b64data)
+		if err != nil {
+			return nil, false
+		}
+		boxedContent = boxedData[:n]
+
+	case refs.RefAlgoFeedGabby:
+		input := msg.ContentBytes()
+		if !bytes.HasPrefix(input, []byte("box1:")) {
+			return nil, false
+		}
+		boxedContent = bytes.TrimPrefix(input, []byte("box1:"))
+
+	default:
+		return nil, false
+	}
+
+	clearContent, err := private.Unbox(kp, boxedContent)
+	if err != nil {
+		return nil, false
+	}
+	return clearContent, true
+}
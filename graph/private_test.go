@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package graph
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/private"
+)
+
+func (p publisher) privateBlock(ref *refs.FeedRef, rcpts ...*refs.FeedRef) {
+	content, err := json.Marshal(map[string]interface{}{
+		"type":     "contact",
+		"contact":  ref.Ref(),
+		"blocking": true,
+	})
+	p.r.NoError(err)
+
+	boxed, err := private.Box(content, rcpts...)
+	p.r.NoError(err)
+
+	newSeq, err := p.publish.Append(boxed)
+	p.r.NoError(err)
+	p.r.NotNil(newSeq)
+}
+
+func TestPrivateBlocks(t *testing.T) {
+	r := require.New(t)
+
+	myKey, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	tc := makeBadgerWithUnboxers(t, myKey)
+	defer tc.close()
+
+	myself := newPublisherWithKP(t, tc.root, tc.userLogs, myKey)
+	alice := tc.newPublisher(t)
+	bob := tc.newPublisher(t)
+	claire := tc.newPublisher(t)
+
+	// alice blocks claire privately but we can't (and shouldn't) read that
+	alice.privateBlock(claire.key.Id, alice.key.Id, myKey.Id)
+
+	myself.follow(alice.key.Id)
+	myself.privateBlock(bob.key.Id, myKey.Id)
+
+	// the messages are indexed in order, so once our block is there alice's was processed, too
+	var g *Graph
+	for i := 0; i < 50; i++ {
+		g, err = tc.gbuilder.Build()
+		r.NoError(err)
+		if g.Blocks(myKey.Id, bob.key.Id) {
+			break
+		}
+		time.Sleep(time.Second / 50)
+	}
+
+	r.True(g.Follows(myKey.Id, alice.key.Id))
+	r.True(g.Blocks(myKey.Id, bob.key.Id), "private block not honoured")
+	r.False(g.Blocks(alice.key.Id, claire.key.Id), "private block of somebody else honoured")
+
+	blocked := g.BlockedList(myKey.Id)
+	r.Equal(1, blocked.Count())
+	r.True(blocked.Has(bob.key.Id))
+}
@@ -8,16 +8,19 @@ import (
 	"github.com/pkg/errors"
 
 	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/graph"
 	"go.cryptoscope.co/ssb/repo"
 )
 
 const FolderNameContacts = "contacts"
 
-func OpenContacts(log kitlog.Logger, r repo.Interface) (graph.Builder, librarian.SeqSetterIndex, librarian.SinkIndex, error) {
+// OpenContacts opens the badger backed contact graph index.
+// The passed keypairs are used to decrypt private blocks (contact messages that are encrypted to self).
+func OpenContacts(log kitlog.Logger, r repo.Interface, unboxers ...*ssb.KeyPair) (graph.Builder, librarian.SeqSetterIndex, librarian.SinkIndex, error) {
 	var builder graph.IndexingBuilder
 	f := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
-		builder = graph.NewBuilder(kitlog.With(log, "module", "graph"), db)
+		builder = graph.NewBuilder(kitlog.With(log, "module", "graph"), db, unboxers...)
 		return builder.OpenIndex()
 	}
 
@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: MIT
+
+package indexes
+
+import (
+	"github.com/dgraph-io/badger"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/ssb/metafeed"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+const FolderNameMetaFeeds = "metafeeds"
+
+// OpenMetaFeeds opens the badger backed index of metafeed/add, metafeed/tombstone and metafeed/announce messages.
+func OpenMetaFeeds(log kitlog.Logger, r repo.Interface) (metafeed.Index, librarian.SeqSetterIndex, librarian.SinkIndex, error) {
+	var mfIdx metafeed.IndexingIndex
+	f := func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
+		mfIdx = metafeed.NewIndex(log, db)
+		return mfIdx.OpenIndex()
+	}
+
+	_, idx, updateSink, err := repo.OpenBadgerIndex(r, FolderNameMetaFeeds, f)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "error getting metafeeds index")
+	}
+
+	return mfIdx, idx, updateSink, nil
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: MIT
+
+// Package origins checks the Origin header of browser requests, for the websocket listeners and the http gateway.
+package origins
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Allowed checks the Origin header of req against the allowlist.
+// Requests without an origin are not from a browser and always allowed.
+// An empty allowlist only allows the host of the request itself and "*" allows every origin.
+func Allowed(allowed []string, req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, req.Host)
+	}
+	for _, a := range allowed {
+		if a == "*" || strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
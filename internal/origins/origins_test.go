@@ -0,0 +1,27 @@
+// SPDX-License-Identifier: MIT
+
+package origins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowed(t *testing.T) {
+	a := assert.New(t)
+
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:8989/rpc", nil)
+	a.True(Allowed(nil, req), "no origin")
+
+	req.Header.Set("Origin", "http://localhost:8989")
+	a.True(Allowed(nil, req), "same host")
+	a.False(Allowed([]string{"http://other:1234"}, req))
+	a.True(Allowed([]string{"*"}, req))
+
+	req.Header.Set("Origin", "http://other:1234")
+	a.False(Allowed(nil, req))
+	a.True(Allowed([]string{"http://other:1234/"}, req))
+}
@@ -15,15 +15,17 @@ import (
 // Redeem takes an invite token and a long term key.
 // It uses the information in the token to build a guest-client connection
 // and place an 'invite.use' rpc call with it's longTerm key.
-// If the peer responds with a message it returns nil
-func Redeem(ctx context.Context, tok Token, longTerm *refs.FeedRef) error {
+// If the peer responds with a message it returns nil.
+// opts are passed to the guest-client, like client.WithSHSAppKey for other networks.
+func Redeem(ctx context.Context, tok Token, longTerm *refs.FeedRef, opts ...client.Option) error {
 	inviteKeyPair, err := ssb.NewKeyPair(bytes.NewReader(tok.Seed[:]))
 	if err != nil {
 		return errors.Wrap(err, "invite: couldn't make keypair from seed")
 	}
 
 	// now use the invite
-	inviteClient, err := client.NewTCP(inviteKeyPair, tok.Address, client.WithContext(ctx))
+	opts = append([]client.Option{client.WithContext(ctx)}, opts...)
+	inviteClient, err := client.NewTCP(inviteKeyPair, tok.Address, opts...)
 	if err != nil {
 		return errors.Wrap(err, "invite: failed to establish guest-client connection")
 	}
@@ -11,6 +11,8 @@ import (
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/secretstream"
 	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/network/netaddr"
 )
 
 var ErrInvalidToken = errors.New("invite: invalid token")
@@ -22,8 +24,16 @@ type Token struct {
 	Seed [32]byte
 }
 
+// transportAddr returns the onion address of a token if it has one, its tcp address otherwise
+func transportAddr(addr net.Addr) net.Addr {
+	if onion := netwrap.GetAddr(addr, netaddr.OnionNetwork); onion != nil {
+		return onion
+	}
+	return netwrap.GetAddr(addr, "tcp")
+}
+
 func (c Token) String() string {
-	addr := netwrap.GetAddr(c.Address, "tcp")
+	addr := transportAddr(c.Address)
 	if addr == nil {
 		return "invalid:no tcp address"
 	}
@@ -43,13 +53,18 @@ func (c Token) String() string {
 }
 
 func NewPubMessageFromToken(tok Token) (*refs.OldPubMessage, error) {
-	addr := netwrap.GetAddr(tok.Address, "tcp")
-	if addr == nil {
+	var host string
+	var port int
+	switch addr := transportAddr(tok.Address).(type) {
+	case nil:
 		return nil, errors.New("invalid invite token - no tcp address")
-	}
-
-	tcpAddr, ok := addr.(*net.TCPAddr)
-	if !ok {
+	case *net.TCPAddr:
+		host, port = addr.IP.String(), addr.Port
+	case netaddr.OnionAddr:
+		host, port = addr.Host, addr.Port
+	case netaddr.HostAddr:
+		host, port = addr.Host, addr.Port
+	default:
 		return nil, fmt.Errorf("invalid invite token - wrong address type: %T", addr)
 	}
 
@@ -57,8 +72,8 @@ func NewPubMessageFromToken(tok Token) (*refs.OldPubMessage, error) {
 		Type: "pub",
 		Address: refs.OldAddress{
 			Key:  tok.Peer,
-			Host: tcpAddr.IP.String(),
-			Port: tcpAddr.Port,
+			Host: host,
+			Port: port,
 		},
 	}, nil
 }
@@ -122,20 +137,26 @@ func ParseLegacyToken(input string) (Token, error) {
 	}
 	copy(c.Seed[:], seed)
 
-	tcpAddr := net.TCPAddr{}
+	port, err := strconv.Atoi(split[1])
+	if err != nil {
+		return Token{}, err
+	}
+
+	// onion services can only be resolved by the proxy that dials them
+	if netaddr.IsOnionHost(split[0]) {
+		c.Address = netwrap.WrapAddr(netaddr.OnionAddr{Host: split[0], Port: port}, secretstream.Addr{ref.ID[:]})
+		return c, nil
+	}
+
+	tcpAddr := net.TCPAddr{Port: port}
 	tcpAddr.IP = net.ParseIP(split[0])
 	if tcpAddr.IP == nil {
 		resolvedAddr, err := net.ResolveIPAddr("ip", split[0])
 		if err != nil {
-			// could be tor or other kind of overlay?
 			return Token{}, err
 		}
 		tcpAddr.IP = resolvedAddr.IP
 	}
-	tcpAddr.Port, err = strconv.Atoi(split[1])
-	if err != nil {
-		return Token{}, err
-	}
 
 	c.Address = netwrap.WrapAddr(&tcpAddr, secretstream.Addr{ref.ID[:]})
 
@@ -7,10 +7,13 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/secretstream"
 
 	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/network/netaddr"
 )
 
 func TestParseParseLegacyToken(t *testing.T) {
@@ -57,6 +60,16 @@ func TestParseParseLegacyToken(t *testing.T) {
 			Peer: testRef,
 			Seed: [32]byte{},
 		}},
+
+		// onion services aren't resolved
+		{"abcdefghijklmnop.onion:8008:@YjAwcGIwMHBiMDBwYjAwcGIwMHBiMDBwYjAwcGIwMHA=.ed25519~AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", nil, &Token{
+			Address: netwrap.WrapAddr(netaddr.OnionAddr{
+				Host: "abcdefghijklmnop.onion",
+				Port: 8008,
+			}, secretstream.Addr{testRef.ID}),
+			Peer: testRef,
+			Seed: [32]byte{},
+		}},
 	}
 	for i, tc := range tcases {
 		tok, err := ParseLegacyToken(tc.input)
@@ -71,3 +84,25 @@ func TestParseParseLegacyToken(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenWithHostName(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	testRef := refs.FeedRef{
+		ID:   bytes.Repeat([]byte("b00p"), 8),
+		Algo: refs.RefAlgoFeedSSB1,
+	}
+	tok := Token{
+		Address: netwrap.WrapAddr(netaddr.HostAddr{
+			Host: "pub.example.com",
+			Port: 8008,
+		}, secretstream.Addr{testRef.ID}),
+		Peer: testRef,
+	}
+	a.Equal("pub.example.com:8008:"+testRef.Ref()+"~AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", tok.String())
+
+	pub, err := NewPubMessageFromToken(tok)
+	r.NoError(err)
+	a.Equal("pub.example.com", pub.Address.Host)
+	a.Equal(8008, pub.Address.Port)
+}
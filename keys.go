@@ -24,19 +24,25 @@ type KeyPair struct {
 
 // the format of the .ssb/secret file as defined by the js implementations
 type ssbSecret struct {
-	Curve   string        `json:"curve"`
-	ID      *refs.FeedRef `json:"id"`
-	Private string        `json:"private"`
-	Public  string        `json:"public"`
+	Curve   string `json:"curve"`
+	ID      string `json:"id"` // parsed with ParseFeedRef, to support the registered formats
+	Private string `json:"private,omitempty"`
+	Public  string `json:"public"`
+
+	// replaces private if the secret is encrypted with a passphrase, see SaveEncryptedKeyPair
+	Encrypted *encryptedSecret `json:"encrypted,omitempty"`
 }
 
-// IsValidFeedFormat checks if the passed FeedRef is for one of the two supported formats,
-// legacy/crapp or GabbyGrove.
+// IsValidFeedFormat checks if the passed FeedRef is for one of the supported formats,
+// legacy/crapp, GabbyGrove or one that was registered with RegisterFeedFormat.
 func IsValidFeedFormat(r *refs.FeedRef) error {
-	if r.Algo != refs.RefAlgoFeedSSB1 && r.Algo != refs.RefAlgoFeedGabby {
-		return errors.Errorf("ssb: unsupported feed format:%s", r.Algo)
+	if r.Algo == refs.RefAlgoFeedSSB1 || r.Algo == refs.RefAlgoFeedGabby {
+		return nil
+	}
+	if _, registered := getRegisteredFeedFormat(r.Algo); registered {
+		return nil
 	}
-	return nil
+	return errors.Errorf("ssb: unsupported feed format:%s", r.Algo)
 }
 
 // NewKeyPair generates a fresh KeyPair using the passed io.Reader as a seed.
@@ -85,7 +91,7 @@ func SaveKeyPair(kp *KeyPair, path string) error {
 func EncodeKeyPairAsJSON(kp *KeyPair, w io.Writer) error {
 	var sec = ssbSecret{
 		Curve:   "ed25519",
-		ID:      kp.Id,
+		ID:      kp.Id.Ref(),
 		Private: base64.StdEncoding.EncodeToString(kp.Pair.Secret[:]) + ".ed25519",
 		Public:  base64.StdEncoding.EncodeToString(kp.Pair.Public[:]) + ".ed25519",
 	}
@@ -95,43 +101,86 @@ func EncodeKeyPairAsJSON(kp *KeyPair, w io.Writer) error {
 
 // LoadKeyPair opens fname, ignores any line starting with # and passes it ParseKeyPair
 func LoadKeyPair(fname string) (*KeyPair, error) {
+	f, err := openKeyFile(fname, true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseKeyPair(nocomment.NewReader(f))
+}
+
+// LoadKeyPairForImport is like LoadKeyPair, or LoadEncryptedKeyPair if passphrase isn't nil, for secrets of other implementations.
+// Instead of SecretPerms it only checks that group and others can't access the file, the javascript implementation uses 0400 for example.
+func LoadKeyPairForImport(fname string, passphrase []byte) (*KeyPair, error) {
+	f, err := openKeyFile(fname, false)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if passphrase == nil {
+		return ParseKeyPair(nocomment.NewReader(f))
+	}
+	return ParseEncryptedKeyPair(nocomment.NewReader(f), passphrase)
+}
+
+// openKeyFile opens fname after checking that only the owner can read it.
+// With exact the permissions need to be SecretPerms, otherwise any permissions without group and others are fine.
+func openKeyFile(fname string, exact bool) (*os.File, error) {
 	f, err := os.Open(fname)
 	if err != nil {
 		return nil, errors.Wrapf(err, "ssb.LoadKeyPair: could not open key file %s", fname)
 	}
-	defer f.Close()
 
 	info, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, errors.Wrapf(err, "ssb.LoadKeyPair: could not stat key file %s", fname)
 	}
-	if perms := info.Mode().Perm(); perms != SecretPerms {
+	perms := info.Mode().Perm()
+	if ownerOnly := perms == SecretPerms || (!exact && perms&0077 == 0); !ownerOnly {
+		f.Close()
 		return nil, fmt.Errorf("ssb.LoadKeyPair: expected key file permissions %s, but got %s", SecretPerms, perms)
 	}
-
-	return ParseKeyPair(nocomment.NewReader(f))
+	return f, nil
 }
 
 // ParseKeyPair json decodes an object from the reader.
 // It expects std base64 encoded data under the `private` and `public` fields.
+// Encrypted secrets return ErrKeyPairEncrypted, they need ParseEncryptedKeyPair.
 func ParseKeyPair(r io.Reader) (*KeyPair, error) {
 	var s ssbSecret
 	if err := json.NewDecoder(r).Decode(&s); err != nil {
 		return nil, errors.Wrapf(err, "ssb.Parse: JSON decoding failed")
 	}
 
-	if err := IsValidFeedFormat(s.ID); err != nil {
-		return nil, err
+	if s.Encrypted != nil {
+		return nil, ErrKeyPairEncrypted{ID: s.ID}
 	}
 
-	public, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Public, ".ed25519"))
+	private, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Private, ".ed25519"))
 	if err != nil {
-		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of public part failed")
+		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of private part failed")
 	}
 
-	private, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Private, ".ed25519"))
+	return s.keyPair(private)
+}
+
+// keyPair checks the id and public key of s and makes a KeyPair with the decoded private part
+func (s ssbSecret) keyPair(private []byte) (*KeyPair, error) {
+	id, err := ParseFeedRef(s.ID)
 	if err != nil {
-		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of private part failed")
+		return nil, errors.Wrapf(err, "ssb.Parse: invalid id")
+	}
+
+	if err := IsValidFeedFormat(id); err != nil {
+		return nil, err
+	}
+
+	public, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Public, ".ed25519"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of public part failed")
 	}
 
 	pair, err := secrethandshake.NewKeyPair(public, private)
@@ -140,7 +189,7 @@ func ParseKeyPair(r io.Reader) (*KeyPair, error) {
 	}
 
 	ssbkp := KeyPair{
-		Id:   s.ID,
+		Id:   id,
 		Pair: *pair,
 	}
 	return &ssbkp, errors.Wrap(err, "ssb.Parse: broken keypair?")
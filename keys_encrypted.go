@@ -0,0 +1,194 @@
+// SPDX-License-Identifier: MIT
+
+package ssb
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/keks/nocomment"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrKeyPairEncrypted is returned by ParseKeyPair and LoadKeyPair if the secret needs a passphrase.
+// ID is the public feed reference, which is stored in the clear.
+type ErrKeyPairEncrypted struct {
+	ID string
+}
+
+func (e ErrKeyPairEncrypted) Error() string {
+	return fmt.Sprintf("ssb: secret of %s is encrypted, it needs a passphrase", e.ID)
+}
+
+// ErrWrongPassphrase is returned if an encrypted secret can't be opened with the passed passphrase
+var ErrWrongPassphrase = errors.New("ssb: wrong passphrase")
+
+// the private part of an encrypted secret file, sealed with a key derived by scrypt
+type encryptedSecret struct {
+	KDF   string `json:"kdf"` // only scrypt for now
+	N     int    `json:"n"`
+	R     int    `json:"r"`
+	P     int    `json:"p"`
+	Salt  string `json:"salt"`
+	Nonce string `json:"nonce"`
+	Box   string `json:"box"` // the private key, in a secretbox
+}
+
+// the scrypt parameters recommended for interactive logins
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// the most expensive scrypt parameters we accept from a secret file, N and R need 128*N*R bytes of memory (1GiB here)
+const (
+	maxScryptN = 1 << 20
+	maxScryptR = 8
+	maxScryptP = 16
+)
+
+func (es encryptedSecret) key(passphrase, salt []byte) (*[32]byte, error) {
+	if es.KDF != "scrypt" {
+		return nil, errors.Errorf("ssb: unsupported key derivation: %q", es.KDF)
+	}
+	if es.N > maxScryptN || es.R > maxScryptR || es.P > maxScryptP {
+		return nil, errors.Errorf("ssb: scrypt parameters too expensive (N:%d r:%d p:%d)", es.N, es.R, es.P)
+	}
+	k, err := scrypt.Key(passphrase, salt, es.N, es.R, es.P, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb: failed to derive key from passphrase")
+	}
+	var key [32]byte
+	copy(key[:], k)
+	return &key, nil
+}
+
+// EncodeKeyPairAsEncryptedJSON is like EncodeKeyPairAsJSON but the private part is encrypted with passphrase.
+// The feed reference and public key stay readable.
+func EncodeKeyPairAsEncryptedJSON(kp *KeyPair, w io.Writer, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("ssb.EncodeKeyPairAsEncryptedJSON: empty passphrase")
+	}
+
+	var salt [32]byte
+	if _, err := io.ReadFull(rand.Reader, salt[:]); err != nil {
+		return errors.Wrap(err, "ssb.EncodeKeyPairAsEncryptedJSON: failed to read salt")
+	}
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return errors.Wrap(err, "ssb.EncodeKeyPairAsEncryptedJSON: failed to read nonce")
+	}
+
+	enc := encryptedSecret{
+		KDF:   "scrypt",
+		N:     scryptN,
+		R:     scryptR,
+		P:     scryptP,
+		Salt:  base64.StdEncoding.EncodeToString(salt[:]),
+		Nonce: base64.StdEncoding.EncodeToString(nonce[:]),
+	}
+	key, err := enc.key(passphrase, salt[:])
+	if err != nil {
+		return err
+	}
+	enc.Box = base64.StdEncoding.EncodeToString(secretbox.Seal(nil, kp.Pair.Secret[:], &nonce, key))
+
+	var sec = ssbSecret{
+		Curve:     "ed25519",
+		ID:        kp.Id.Ref(),
+		Public:    base64.StdEncoding.EncodeToString(kp.Pair.Public[:]) + ".ed25519",
+		Encrypted: &enc,
+	}
+	err = json.NewEncoder(w).Encode(sec)
+	return errors.Wrap(err, "ssb.EncodeKeyPairAsEncryptedJSON: encoding failed")
+}
+
+// SaveEncryptedKeyPair is like SaveKeyPair but encrypts the secret with passphrase, see EncodeKeyPairAsEncryptedJSON.
+// It errors if path already exists.
+func SaveEncryptedKeyPair(kp *KeyPair, path string, passphrase []byte) error {
+	if err := IsValidFeedFormat(kp.Id); err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("ssb.SaveEncryptedKeyPair: key already exists:%q", path)
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil && !os.IsExist(err) {
+		return errors.Wrap(err, "failed to create folder for keypair")
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, SecretPerms)
+	if err != nil {
+		return errors.Wrap(err, "ssb.SaveEncryptedKeyPair: failed to create file")
+	}
+
+	if err := EncodeKeyPairAsEncryptedJSON(kp, f, passphrase); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+
+	return errors.Wrap(f.Close(), "ssb.SaveEncryptedKeyPair: failed to close file")
+}
+
+// LoadEncryptedKeyPair is like LoadKeyPair but also opens secrets that are encrypted with passphrase
+func LoadEncryptedKeyPair(fname string, passphrase []byte) (*KeyPair, error) {
+	f, err := openKeyFile(fname, true)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseEncryptedKeyPair(nocomment.NewReader(f), passphrase)
+}
+
+// ParseEncryptedKeyPair is like ParseKeyPair but also decrypts secrets that are encrypted with passphrase.
+// It returns ErrWrongPassphrase if the secret can't be opened with it.
+func ParseEncryptedKeyPair(r io.Reader, passphrase []byte) (*KeyPair, error) {
+	var s ssbSecret
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, errors.Wrapf(err, "ssb.Parse: JSON decoding failed")
+	}
+
+	if s.Encrypted == nil { // plain secrets are fine, too
+		private, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(s.Private, ".ed25519"))
+		if err != nil {
+			return nil, errors.Wrapf(err, "ssb.Parse: base64 decode of private part failed")
+		}
+		return s.keyPair(private)
+	}
+
+	enc := s.Encrypted
+	salt, err := base64.StdEncoding.DecodeString(enc.Salt)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb.Parse: base64 decode of salt failed")
+	}
+	nonceBytes, err := base64.StdEncoding.DecodeString(enc.Nonce)
+	if err != nil || len(nonceBytes) != 24 {
+		return nil, errors.Errorf("ssb.Parse: invalid nonce")
+	}
+	var nonce [24]byte
+	copy(nonce[:], nonceBytes)
+	box, err := base64.StdEncoding.DecodeString(enc.Box)
+	if err != nil {
+		return nil, errors.Wrap(err, "ssb.Parse: base64 decode of box failed")
+	}
+
+	key, err := enc.key(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := secretbox.Open(nil, box, &nonce, key)
+	if !ok {
+		return nil, ErrWrongPassphrase
+	}
+	return s.keyPair(private)
+}
@@ -1,10 +1,12 @@
 package ssb
 
 import (
+	"bytes"
 	"os"
 	"path"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -62,3 +64,76 @@ func TestLoadKeyPair(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadKeyPairForImport(t *testing.T) {
+	r := require.New(t)
+
+	fname := path.Join(os.TempDir(), "secret-import")
+	os.Remove(fname)
+
+	keys, err := NewKeyPair(nil)
+	r.NoError(err)
+	r.NoError(SaveKeyPair(keys, fname))
+	defer os.Remove(fname)
+
+	// what the javascript implementation writes
+	r.NoError(os.Chmod(fname, 0400))
+	loaded, err := LoadKeyPairForImport(fname, nil)
+	r.NoError(err)
+	r.Equal(keys.Id.Ref(), loaded.Id.Ref())
+
+	r.NoError(os.Chmod(fname, 0640))
+	_, err = LoadKeyPairForImport(fname, nil)
+	r.Error(err, "group can read it")
+}
+
+func TestEncryptedKeyPair(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	fname := path.Join(os.TempDir(), "secret-encrypted")
+	os.Remove(fname)
+
+	keys, err := NewKeyPair(nil)
+	r.NoError(err)
+
+	r.Error(SaveEncryptedKeyPair(keys, fname, nil), "empty passphrase")
+	_, err = os.Stat(fname)
+	r.True(os.IsNotExist(err), "left a file behind")
+
+	r.NoError(SaveEncryptedKeyPair(keys, fname, []byte("correct horse")))
+	defer os.Remove(fname)
+
+	stat, err := os.Stat(fname)
+	r.NoError(err)
+	a.Equal(SecretPerms, stat.Mode(), "file permissions")
+
+	// the plain loader tells us which feed it is
+	_, err = LoadKeyPair(fname)
+	r.Error(err)
+	enc, ok := errors.Cause(err).(ErrKeyPairEncrypted)
+	r.True(ok, "wrong error: %v", err)
+	a.Equal(keys.Id.Ref(), enc.ID)
+
+	_, err = LoadEncryptedKeyPair(fname, []byte("battery staple"))
+	a.Equal(ErrWrongPassphrase, errors.Cause(err))
+
+	loaded, err := LoadEncryptedKeyPair(fname, []byte("correct horse"))
+	r.NoError(err)
+	a.Equal(keys.Id.Ref(), loaded.Id.Ref())
+	a.Equal(keys.Pair.Secret[:], loaded.Pair.Secret[:])
+
+	// plain secrets don't need a passphrase
+	var plain bytes.Buffer
+	r.NoError(EncodeKeyPairAsJSON(keys, &plain))
+	loaded, err = ParseEncryptedKeyPair(&plain, nil)
+	r.NoError(err)
+	a.Equal(keys.Pair.Secret[:], loaded.Pair.Secret[:])
+
+	// the cost of the key derivation is capped
+	var sealed bytes.Buffer
+	r.NoError(EncodeKeyPairAsEncryptedJSON(keys, &sealed, []byte("correct horse")))
+	expensive := bytes.Replace(sealed.Bytes(), []byte(`"n":32768`), []byte(`"n":1073741824`), 1)
+	r.NotEqual(sealed.Bytes(), expensive)
+	_, err = ParseEncryptedKeyPair(bytes.NewReader(expensive), []byte("correct horse"))
+	r.Error(err)
+}
@@ -0,0 +1,161 @@
+// SPDX-License-Identifier: MIT
+
+package message
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// messages per batch and worker
+const batchPerWorker = 16
+
+// BatchVerifySink is a verify sink that checks the signatures of the messages concurrently.
+// It collects the incoming messages into batches, which are verified by a number of workers
+// while the previous batch is checked against the chain (see ValidateNext) and stored in order.
+//
+// Since the messages are stored batch by batch, the problems with the last ones are only reported by Close.
+// Err returns the first problem, also after the sink was closed.
+type BatchVerifySink struct {
+	drain   *streamDrain
+	workers int
+	size    int
+
+	pending  []interface{}
+	inflight *verifyBatch
+
+	// the context of the last Pour, used for storing the last batches on Close
+	ctx context.Context
+
+	err    error
+	closed bool
+}
+
+// NewBatchVerifySink is like NewVerifySink but verifies the messages with workers goroutines.
+// If workers is zero or less, one per CPU is used.
+func NewBatchVerifySink(who *refs.FeedRef, start margaret.Seq, abs refs.Message, snk luigi.Sink, hmacKey *[32]byte, workers int) *BatchVerifySink {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &BatchVerifySink{
+		drain:   NewVerifySink(who, start, abs, snk, hmacKey).(*streamDrain),
+		workers: workers,
+		size:    workers * batchPerWorker,
+		ctx:     context.Background(),
+	}
+}
+
+// Pour adds v to the current batch. Once it's full, its verification is started and the previous batch is stored.
+func (bs *BatchVerifySink) Pour(ctx context.Context, v interface{}) error {
+	if bs.err != nil {
+		return bs.err
+	}
+	if bs.closed {
+		return errors.New("batchVerify: sink closed")
+	}
+	bs.ctx = ctx
+
+	bs.pending = append(bs.pending, v)
+	if len(bs.pending) < bs.size {
+		return nil
+	}
+
+	next := bs.startVerify(bs.pending)
+	bs.pending = nil
+
+	if bs.inflight != nil {
+		bs.err = bs.store(ctx, bs.inflight)
+	}
+	bs.inflight = next
+	return bs.err
+}
+
+// Close verifies and stores the remaining messages and closes the underlying sink
+func (bs *BatchVerifySink) Close() error {
+	if bs.closed {
+		return bs.err
+	}
+	bs.closed = true
+
+	if bs.err == nil && bs.inflight != nil {
+		bs.err = bs.store(bs.ctx, bs.inflight)
+	}
+	if bs.err == nil && len(bs.pending) > 0 {
+		bs.err = bs.store(bs.ctx, bs.startVerify(bs.pending))
+	}
+	bs.inflight, bs.pending = nil, nil
+
+	if err := bs.drain.Close(); err != nil && bs.err == nil {
+		bs.err = err
+	}
+	return bs.err
+}
+
+// Err returns the first problem with a message or the underlying sink
+func (bs *BatchVerifySink) Err() error { return bs.err }
+
+type verifyBatch struct {
+	vals []interface{}
+	msgs []refs.Message
+	errs []error
+
+	done chan struct{}
+}
+
+func (bs *BatchVerifySink) startVerify(vals []interface{}) *verifyBatch {
+	b := &verifyBatch{
+		vals: vals,
+		msgs: make([]refs.Message, len(vals)),
+		errs: make([]error, len(vals)),
+		done: make(chan struct{}),
+	}
+
+	var (
+		wg   sync.WaitGroup
+		next int64 = -1
+	)
+	wg.Add(bs.workers)
+	for w := 0; w < bs.workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(vals) {
+					return
+				}
+				b.msgs[i], b.errs[i] = bs.drain.verify.Verify(vals[i])
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(b.done)
+	}()
+	return b
+}
+
+// store waits for the verification of b and appends its messages in order
+func (bs *BatchVerifySink) store(ctx context.Context, b *verifyBatch) error {
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for i, msg := range b.msgs {
+		if err := b.errs[i]; err != nil {
+			return errors.Wrapf(err, "batchVerify(%s:%d) verify failed", bs.drain.who.ShortRef(), bs.drain.latestSeq.Seq())
+		}
+		if err := bs.drain.append(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
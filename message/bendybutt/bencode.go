@@ -0,0 +1,158 @@
+// SPDX-License-Identifier: MIT
+
+package bendybutt
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// this is a minimal bencode implementation which only knows about the types bendy butt needs:
+// integers (int64), byte strings ([]byte), lists ([]interface{}) and dictionaries (map[string]interface{}).
+
+func bencodeMarshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := bencodeWrite(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func bencodeWrite(buf *bytes.Buffer, v interface{}) error {
+	switch tv := v.(type) {
+	case int64:
+		fmt.Fprintf(buf, "i%de", tv)
+	case int:
+		fmt.Fprintf(buf, "i%de", tv)
+	case uint64:
+		fmt.Fprintf(buf, "i%de", tv)
+
+	case []byte:
+		fmt.Fprintf(buf, "%d:", len(tv))
+		buf.Write(tv)
+
+	case []interface{}:
+		buf.WriteByte('l')
+		for i, el := range tv {
+			if err := bencodeWrite(buf, el); err != nil {
+				return fmt.Errorf("bencode: list element %d: %w", i, err)
+			}
+		}
+		buf.WriteByte('e')
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(tv))
+		for k := range tv {
+			keys = append(keys, k)
+		}
+		// keys need to be sorted as raw strings
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, k := range keys {
+			fmt.Fprintf(buf, "%d:%s", len(k), k)
+			if err := bencodeWrite(buf, tv[k]); err != nil {
+				return fmt.Errorf("bencode: dict value %q: %w", k, err)
+			}
+		}
+		buf.WriteByte('e')
+
+	default:
+		return fmt.Errorf("bencode: unsupported type %T", v)
+	}
+	return nil
+}
+
+func bencodeUnmarshal(data []byte) (interface{}, error) {
+	v, rest, err := bencodeRead(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("bencode: %d trailing bytes", len(rest))
+	}
+	return v, nil
+}
+
+func bencodeRead(data []byte) (interface{}, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("bencode: unexpected end of data")
+	}
+
+	switch c := data[0]; {
+	case c == 'i':
+		end := bytes.IndexByte(data, 'e')
+		if end < 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated integer")
+		}
+		i, err := strconv.ParseInt(string(data[1:end]), 10, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bencode: invalid integer: %w", err)
+		}
+		return i, data[end+1:], nil
+
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data, ':')
+		if colon < 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated string length")
+		}
+		n, err := strconv.ParseUint(string(data[:colon]), 10, 32)
+		if err != nil {
+			return nil, nil, fmt.Errorf("bencode: invalid string length: %w", err)
+		}
+		data = data[colon+1:]
+		if uint64(len(data)) < n {
+			return nil, nil, fmt.Errorf("bencode: string longer than data")
+		}
+		return data[:n], data[n:], nil
+
+	case c == 'l':
+		var lst = []interface{}{}
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			var (
+				el  interface{}
+				err error
+			)
+			el, rest, err = bencodeRead(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			lst = append(lst, el)
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated list")
+		}
+		return lst, rest[1:], nil
+
+	case c == 'd':
+		var dict = make(map[string]interface{})
+		rest := data[1:]
+		for len(rest) > 0 && rest[0] != 'e' {
+			k, after, err := bencodeRead(rest)
+			if err != nil {
+				return nil, nil, err
+			}
+			key, ok := k.([]byte)
+			if !ok {
+				return nil, nil, fmt.Errorf("bencode: dict key is not a string but %T", k)
+			}
+
+			var v interface{}
+			v, rest, err = bencodeRead(after)
+			if err != nil {
+				return nil, nil, err
+			}
+			dict[string(key)] = v
+		}
+		if len(rest) == 0 {
+			return nil, nil, fmt.Errorf("bencode: unterminated dict")
+		}
+		return dict, rest[1:], nil
+
+	default:
+		return nil, nil, fmt.Errorf("bencode: unexpected byte %q", c)
+	}
+}
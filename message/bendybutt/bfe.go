@@ -0,0 +1,217 @@
+// SPDX-License-Identifier: MIT
+
+package bendybutt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// binary field encoding (BFE) type and format bytes
+// see https://github.com/ssb-ngi-pointer/ssb-bfe-spec
+const (
+	bfeTypeFeed      byte = 0x00
+	bfeTypeMessage   byte = 0x01
+	bfeTypeSignature byte = 0x04
+	bfeTypeBox       byte = 0x05
+	bfeTypeGeneric   byte = 0x06
+
+	bfeFeedClassic    byte = 0x00
+	bfeFeedGabby      byte = 0x01
+	bfeFeedBendyButt  byte = 0x03
+	bfeMessageClassic byte = 0x00
+	bfeMessageGabby   byte = 0x01
+	bfeMessageBendy   byte = 0x04
+
+	bfeGenericString byte = 0x00
+	bfeGenericBool   byte = 0x01
+	bfeGenericNil    byte = 0x02
+	bfeGenericBytes  byte = 0x03
+)
+
+var bfeNil = []byte{bfeTypeGeneric, bfeGenericNil}
+
+var feedFormats = map[string]byte{
+	refs.RefAlgoFeedSSB1:     bfeFeedClassic,
+	refs.RefAlgoFeedGabby:    bfeFeedGabby,
+	ssb.RefAlgoFeedBendyButt: bfeFeedBendyButt,
+}
+
+var messageFormats = map[string]byte{
+	refs.RefAlgoMessageSSB1:     bfeMessageClassic,
+	refs.RefAlgoMessageGabby:    bfeMessageGabby,
+	ssb.RefAlgoMessageBendyButt: bfeMessageBendy,
+}
+
+// EncodeFeedRef returns the BFE encoding of the feed reference
+func EncodeFeedRef(r *refs.FeedRef) ([]byte, error) {
+	format, ok := feedFormats[r.Algo]
+	if !ok {
+		return nil, fmt.Errorf("bendybutt/bfe: unsupported feed format: %s", r.Algo)
+	}
+	return append([]byte{bfeTypeFeed, format}, r.ID...), nil
+}
+
+// EncodeMessageRef returns the BFE encoding of the message reference, nil is encoded as the nil value.
+func EncodeMessageRef(r *refs.MessageRef) ([]byte, error) {
+	if r == nil {
+		return bfeNil, nil
+	}
+	format, ok := messageFormats[r.Algo]
+	if !ok {
+		return nil, fmt.Errorf("bendybutt/bfe: unsupported message format: %s", r.Algo)
+	}
+	return append([]byte{bfeTypeMessage, format}, r.Hash...), nil
+}
+
+// DecodeFeedRef parses a BFE encoded feed reference
+func DecodeFeedRef(data []byte) (*refs.FeedRef, error) {
+	if len(data) != 34 || data[0] != bfeTypeFeed {
+		return nil, fmt.Errorf("bendybutt/bfe: not a feed reference")
+	}
+	for algo, format := range feedFormats {
+		if data[1] == format {
+			return &refs.FeedRef{
+				ID:   append([]byte(nil), data[2:]...),
+				Algo: algo,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("bendybutt/bfe: unknown feed format: %x", data[1])
+}
+
+// DecodeMessageRef parses a BFE encoded message reference, returns nil for the nil value.
+func DecodeMessageRef(data []byte) (*refs.MessageRef, error) {
+	if bytes.Equal(data, bfeNil) {
+		return nil, nil
+	}
+	if len(data) != 34 || data[0] != bfeTypeMessage {
+		return nil, fmt.Errorf("bendybutt/bfe: not a message reference")
+	}
+	for algo, format := range messageFormats {
+		if data[1] == format {
+			return &refs.MessageRef{
+				Hash: append([]byte(nil), data[2:]...),
+				Algo: algo,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("bendybutt/bfe: unknown message format: %x", data[1])
+}
+
+// encodeValue turns the values of content into their bencode and BFE representation
+func encodeValue(v interface{}) (interface{}, error) {
+	switch tv := v.(type) {
+	case nil:
+		return bfeNil, nil
+	case string:
+		return append([]byte{bfeTypeGeneric, bfeGenericString}, tv...), nil
+	case bool:
+		b := byte(0)
+		if tv {
+			b = 1
+		}
+		return []byte{bfeTypeGeneric, bfeGenericBool, b}, nil
+	case []byte:
+		return append([]byte{bfeTypeGeneric, bfeGenericBytes}, tv...), nil
+	case int:
+		return int64(tv), nil
+	case int64:
+		return tv, nil
+	case *refs.FeedRef:
+		return EncodeFeedRef(tv)
+	case *refs.MessageRef:
+		return EncodeMessageRef(tv)
+	case []interface{}:
+		lst := make([]interface{}, len(tv))
+		for i, el := range tv {
+			var err error
+			lst[i], err = encodeValue(el)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return lst, nil
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(tv))
+		for k, el := range tv {
+			var err error
+			dict[k], err = encodeValue(el)
+			if err != nil {
+				return nil, fmt.Errorf("bendybutt: field %q: %w", k, err)
+			}
+		}
+		return dict, nil
+	default:
+		return nil, fmt.Errorf("bendybutt: unsupported content value %T", v)
+	}
+}
+
+// decodeValue is the inverse of encodeValue and returns values which can be encoded as JSON
+func decodeValue(v interface{}) (interface{}, error) {
+	switch tv := v.(type) {
+	case int64:
+		return tv, nil
+
+	case []byte:
+		if len(tv) < 2 {
+			return nil, fmt.Errorf("bendybutt/bfe: value too short")
+		}
+		switch tv[0] {
+		case bfeTypeFeed:
+			ref, err := DecodeFeedRef(tv)
+			if err != nil {
+				return nil, err
+			}
+			return ref.Ref(), nil
+		case bfeTypeMessage:
+			ref, err := DecodeMessageRef(tv)
+			if err != nil {
+				return nil, err
+			}
+			return ref.Ref(), nil
+		case bfeTypeGeneric:
+			switch tv[1] {
+			case bfeGenericString:
+				return string(tv[2:]), nil
+			case bfeGenericBool:
+				return len(tv) == 3 && tv[2] == 1, nil
+			case bfeGenericNil:
+				return nil, nil
+			case bfeGenericBytes:
+				return base64.StdEncoding.EncodeToString(tv[2:]), nil
+			}
+		}
+		return nil, fmt.Errorf("bendybutt/bfe: unhandled type %x/%x", tv[0], tv[1])
+
+	case []interface{}:
+		lst := make([]interface{}, len(tv))
+		for i, el := range tv {
+			var err error
+			lst[i], err = decodeValue(el)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return lst, nil
+
+	case map[string]interface{}:
+		dict := make(map[string]interface{}, len(tv))
+		for k, el := range tv {
+			var err error
+			dict[k], err = decodeValue(el)
+			if err != nil {
+				return nil, fmt.Errorf("bendybutt: field %q: %w", k, err)
+			}
+		}
+		return dict, nil
+
+	default:
+		return nil, fmt.Errorf("bendybutt: unsupported value %T", v)
+	}
+}
@@ -0,0 +1,134 @@
+// SPDX-License-Identifier: MIT
+
+package bendybutt
+
+import (
+	"fmt"
+	"time"
+
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/auth"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// SignedContent is the content of a metafeed message together with the signature of the subfeed it talks about.
+// It's signed separately so that the key of the subfeed doesn't need to be known to the publisher of the metafeed.
+type SignedContent struct {
+	encoded   map[string]interface{}
+	signature []byte
+}
+
+// SignContent encodes the content and signs it with the key of the subfeed.
+// Values can be strings, bools, nil, integers, byte slices, feed and message references and lists and maps of those.
+func SignContent(content map[string]interface{}, subfeed ed25519.PrivateKey) (*SignedContent, error) {
+	encoded, err := encodeValue(content)
+	if err != nil {
+		return nil, err
+	}
+	dict := encoded.(map[string]interface{})
+
+	raw, err := bencodeMarshal(dict)
+	if err != nil {
+		return nil, err
+	}
+	signedContent := append(append([]byte(nil), contentSignPrefix...), raw...)
+
+	return &SignedContent{
+		encoded:   dict,
+		signature: ed25519.Sign(subfeed, signedContent),
+	}, nil
+}
+
+// Encoder creates new messages on a metafeed
+type Encoder struct {
+	author *refs.FeedRef
+	secret ed25519.PrivateKey
+
+	hmacSecret   *[32]byte
+	setTimestamp bool
+}
+
+// NewEncoder returns an Encoder for the metafeed of the passed key
+func NewEncoder(secret ed25519.PrivateKey) *Encoder {
+	return &Encoder{
+		author: &refs.FeedRef{
+			ID:   []byte(secret.Public().(ed25519.PublicKey)),
+			Algo: ssb.RefAlgoFeedBendyButt,
+		},
+		secret: secret,
+	}
+}
+
+// WithHMAC signs the messages for a different network
+func (e *Encoder) WithHMAC(key []byte) error {
+	var hmacSec [32]byte
+	if n := copy(hmacSec[:], key); n != 32 {
+		return fmt.Errorf("bendybutt: hmac key of wrong length: %d", n)
+	}
+	e.hmacSecret = &hmacSec
+	return nil
+}
+
+// WithNowTimestamps sets the timestamp of the messages to the current time, otherwise it's zero
+func (e *Encoder) WithNowTimestamps(yes bool) {
+	e.setTimestamp = yes
+}
+
+// Encode creates and signs the next message of the metafeed
+func (e *Encoder) Encode(seq int64, prev *refs.MessageRef, content *SignedContent) (*Message, error) {
+	if content == nil {
+		return nil, fmt.Errorf("bendybutt: no content")
+	}
+
+	authorBFE, err := EncodeFeedRef(e.author)
+	if err != nil {
+		return nil, err
+	}
+
+	prevBFE, err := EncodeMessageRef(prev)
+	if err != nil {
+		return nil, err
+	}
+
+	var ts int64
+	if e.setTimestamp {
+		ts = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	payload := []interface{}{
+		authorBFE,
+		seq,
+		prevBFE,
+		ts,
+		[]interface{}{
+			content.encoded,
+			append([]byte{bfeTypeSignature, 0x00}, content.signature...),
+		},
+	}
+
+	toSign, err := bencodeMarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if e.hmacSecret != nil {
+		mac := auth.Sum(toSign, e.hmacSecret)
+		toSign = mac[:]
+	}
+	sig := ed25519.Sign(e.secret, toSign)
+
+	raw, err := bencodeMarshal([]interface{}{
+		payload,
+		append([]byte{bfeTypeSignature, 0x00}, sig...),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dm, err := decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("bendybutt: failed to decode new message: %w", err)
+	}
+	return dm.msg, nil
+}
@@ -0,0 +1,251 @@
+// SPDX-License-Identifier: MIT
+
+// Package bendybutt implements the bendy butt feed format, which is used by metafeeds.
+//
+// Messages are bencoded lists of a payload and a signature.
+// The payload holds author, sequence, previous, timestamp and a content section,
+// which is the content dictionary and a signature over it by the subfeed the content talks about.
+// See https://github.com/ssb-ngi-pointer/bendy-butt-spec for the details.
+package bendybutt
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cryptix/go/encodedTime"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/auth"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Message is a verified bendy butt message
+type Message struct {
+	raw []byte
+
+	key      *refs.MessageRef
+	author   *refs.FeedRef
+	previous *refs.MessageRef
+	sequence int64
+	claimed  time.Time
+
+	content          map[string]interface{}
+	contentSignature []byte
+	signature        []byte
+	received         time.Time
+}
+
+var _ refs.Message = (*Message)(nil)
+
+func (msg Message) Key() *refs.MessageRef      { return msg.key }
+func (msg Message) Author() *refs.FeedRef      { return msg.author }
+func (msg Message) Previous() *refs.MessageRef { return msg.previous }
+func (msg Message) Seq() int64                 { return msg.sequence }
+func (msg Message) Claimed() time.Time         { return msg.claimed }
+func (msg Message) Received() time.Time        { return msg.received }
+
+// SetReceived sets the time the message was stored locally (used by the multimsg wrapper)
+func (msg *Message) SetReceived(t time.Time) { msg.received = t }
+
+// Raw returns the bencoded message, as it is sent over the network
+func (msg Message) Raw() []byte { return msg.raw }
+
+// Content returns the decoded content dictionary, with references and strings in their JSON form
+func (msg Message) Content() map[string]interface{} { return msg.content }
+
+// Signature is the signature of the author over the payload
+func (msg Message) Signature() []byte { return msg.signature }
+
+// ContentSignature is the signature of the subfeed over the content
+func (msg Message) ContentSignature() []byte { return msg.contentSignature }
+
+// ContentBytes returns the content as JSON, so that the indexes can treat it like any other message
+func (msg Message) ContentBytes() []byte {
+	b, err := json.Marshal(msg.content)
+	if err != nil {
+		log.Println("warning: bendybutt content encoding failed:", err)
+		return nil
+	}
+	return b
+}
+
+func (msg Message) ValueContent() *refs.Value {
+	var val refs.Value
+	val.Previous = msg.previous
+	val.Author = *msg.author
+	val.Sequence = margaret.BaseSeq(msg.sequence)
+	val.Timestamp = encodedTime.Millisecs(msg.claimed)
+	val.Hash = ssb.RefAlgoMessageBendyButt
+	val.Content = msg.ContentBytes()
+	val.Signature = base64.StdEncoding.EncodeToString(msg.signature) + ".sig.ed25519"
+	return &val
+}
+
+func (msg Message) ValueContentJSON() json.RawMessage {
+	b, err := json.Marshal(msg.ValueContent())
+	if err != nil {
+		log.Println("warning: bendybutt value encoding failed:", err)
+		return nil
+	}
+	return b
+}
+
+// MarshalBinary returns the raw bencoded message
+func (msg Message) MarshalBinary() ([]byte, error) {
+	return msg.raw, nil
+}
+
+// UnmarshalBinary decodes the message without checking the signatures.
+// Use Verify for data that comes from the network.
+func (msg *Message) UnmarshalBinary(data []byte) error {
+	decoded, err := decode(data)
+	if err != nil {
+		return err
+	}
+	*msg = *decoded.msg
+	return nil
+}
+
+type decodedMessage struct {
+	msg *Message
+
+	payload    []byte
+	contentRaw []byte // bencoded content dict, for the content signature
+}
+
+func decode(data []byte) (*decodedMessage, error) {
+	v, err := bencodeUnmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("bendybutt: invalid message encoding: %w", err)
+	}
+	outer, ok := v.([]interface{})
+	if !ok || len(outer) != 2 {
+		return nil, fmt.Errorf("bendybutt: message is not a list of payload and signature")
+	}
+	payload, ok := outer[0].([]interface{})
+	if !ok || len(payload) != 5 {
+		return nil, fmt.Errorf("bendybutt: payload is not a list of five elements")
+	}
+
+	sigBFE, ok := outer[1].([]byte)
+	if !ok || len(sigBFE) != 2+ed25519.SignatureSize || sigBFE[0] != bfeTypeSignature {
+		return nil, fmt.Errorf("bendybutt: invalid signature field")
+	}
+
+	var dm decodedMessage
+	dm.payload, err = bencodeMarshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	msg.raw = data
+	msg.signature = sigBFE[2:]
+
+	authorBFE, ok := payload[0].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("bendybutt: author is not a byte string")
+	}
+	msg.author, err = DecodeFeedRef(authorBFE)
+	if err != nil {
+		return nil, fmt.Errorf("bendybutt: invalid author: %w", err)
+	}
+	if msg.author.Algo != ssb.RefAlgoFeedBendyButt {
+		return nil, fmt.Errorf("bendybutt: author is not a metafeed: %s", msg.author.Algo)
+	}
+
+	msg.sequence, ok = payload[1].(int64)
+	if !ok {
+		return nil, fmt.Errorf("bendybutt: sequence is not an integer")
+	}
+
+	prevBFE, ok := payload[2].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("bendybutt: previous is not a byte string")
+	}
+	msg.previous, err = DecodeMessageRef(prevBFE)
+	if err != nil {
+		return nil, fmt.Errorf("bendybutt: invalid previous: %w", err)
+	}
+
+	ts, ok := payload[3].(int64)
+	if !ok {
+		return nil, fmt.Errorf("bendybutt: timestamp is not an integer")
+	}
+	msg.claimed = time.Unix(0, ts*int64(time.Millisecond))
+
+	contentSection, ok := payload[4].([]interface{})
+	if !ok || len(contentSection) != 2 {
+		// TODO: support encrypted content sections
+		return nil, fmt.Errorf("bendybutt: content section is not a list of content and signature")
+	}
+	contentDict, ok := contentSection[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("bendybutt: content is not a dictionary")
+	}
+	dm.contentRaw, err = bencodeMarshal(contentDict)
+	if err != nil {
+		return nil, err
+	}
+	decodedContent, err := decodeValue(contentDict)
+	if err != nil {
+		return nil, err
+	}
+	msg.content = decodedContent.(map[string]interface{})
+
+	contentSig, ok := contentSection[1].([]byte)
+	if !ok || len(contentSig) != 2+ed25519.SignatureSize || contentSig[0] != bfeTypeSignature {
+		return nil, fmt.Errorf("bendybutt: invalid content signature field")
+	}
+	msg.contentSignature = contentSig[2:]
+
+	h := sha256.Sum256(data)
+	msg.key = &refs.MessageRef{
+		Hash: h[:],
+		Algo: ssb.RefAlgoMessageBendyButt,
+	}
+
+	dm.msg = &msg
+	return &dm, nil
+}
+
+// contentSignPrefix is prepended to the bencoded content before it's signed by the subfeed
+var contentSignPrefix = []byte("bendybutt")
+
+// Verify decodes data and checks the signature of the author and the signature of the subfeed over the content.
+// hmacKey is optional and used to sign on a different network.
+func Verify(data []byte, hmacKey *[32]byte) (*Message, error) {
+	dm, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	toVerify := dm.payload
+	if hmacKey != nil {
+		mac := auth.Sum(toVerify, hmacKey)
+		toVerify = mac[:]
+	}
+	if !ed25519.Verify(dm.msg.author.ID, toVerify, dm.msg.signature) {
+		return nil, fmt.Errorf("bendybutt: invalid signature of %s:%d", dm.msg.author.Ref(), dm.msg.sequence)
+	}
+
+	// the subfeed has to sign the content to prove that it agrees to be part of the metafeed
+	if subfeedStr, ok := dm.msg.content["subfeed"].(string); ok {
+		subfeed, err := ssb.ParseFeedRef(subfeedStr)
+		if err != nil {
+			return nil, fmt.Errorf("bendybutt: invalid subfeed in content: %w", err)
+		}
+		signedContent := append(append([]byte(nil), contentSignPrefix...), dm.contentRaw...)
+		if !ed25519.Verify(subfeed.ID, signedContent, dm.msg.contentSignature) {
+			return nil, fmt.Errorf("bendybutt: invalid content signature of %s:%d", dm.msg.author.Ref(), dm.msg.sequence)
+		}
+	}
+
+	return dm.msg, nil
+}
@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+
+package bendybutt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+)
+
+func TestBencode(t *testing.T) {
+	r := require.New(t)
+
+	v := []interface{}{
+		int64(-23),
+		[]byte("hello"),
+		map[string]interface{}{
+			"b": []byte{},
+			"a": []interface{}{int64(1), int64(2)},
+		},
+	}
+
+	enc, err := bencodeMarshal(v)
+	r.NoError(err)
+	r.Equal("li-23e5:hellod1:ali1ei2ee1:b0:ee", string(enc))
+
+	dec, err := bencodeUnmarshal(enc)
+	r.NoError(err)
+	r.Equal(v, dec)
+
+	_, err = bencodeUnmarshal(append(enc, 'x'))
+	r.Error(err, "trailing data")
+
+	_, err = bencodeUnmarshal(enc[:len(enc)-1])
+	r.Error(err, "unterminated list")
+}
+
+func TestMessageRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	_, mfKey, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("meta"), 8)))
+	r.NoError(err)
+	subPub, subKey, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("subf"), 8)))
+	r.NoError(err)
+
+	subfeed := &refs.FeedRef{ID: subPub, Algo: refs.RefAlgoFeedSSB1}
+
+	enc := NewEncoder(mfKey)
+
+	var prev *refs.MessageRef
+	var msgs [][]byte
+	for i := int64(1); i <= 3; i++ {
+		content, err := SignContent(map[string]interface{}{
+			"type":        "metafeed/add",
+			"feedpurpose": "test",
+			"subfeed":     subfeed,
+			"metafeed":    enc.author,
+			"nonce":       []byte{1, 2, 3},
+			"tombstoned":  false,
+			"count":       i,
+		}, subKey)
+		r.NoError(err)
+
+		msg, err := enc.Encode(i, prev, content)
+		r.NoError(err)
+		r.Equal(i, msg.Seq())
+		r.True(msg.Author().Equal(enc.author))
+		if prev == nil {
+			r.Nil(msg.Previous())
+		} else {
+			r.True(msg.Previous().Equal(*prev))
+		}
+
+		verified, err := Verify(msg.Raw(), nil)
+		r.NoError(err)
+		r.Equal(msg.Key().Ref(), verified.Key().Ref())
+		r.Len(verified.Signature(), ed25519.SignatureSize)
+		r.Equal(base64.StdEncoding.EncodeToString(verified.Signature())+".sig.ed25519", verified.ValueContent().Signature)
+
+		var c map[string]interface{}
+		r.NoError(json.Unmarshal(verified.ContentBytes(), &c))
+		r.Equal("metafeed/add", c["type"])
+		r.Equal(subfeed.Ref(), c["subfeed"])
+		r.Equal("AQID", c["nonce"])
+		r.Equal(false, c["tombstoned"])
+		r.EqualValues(i, c["count"])
+
+		prev = msg.Key()
+		msgs = append(msgs, msg.Raw())
+	}
+
+	// wrong hmac key
+	var hmacKey [32]byte
+	_, err = Verify(msgs[0], &hmacKey)
+	r.Error(err)
+
+	// tampered content
+	tampered := bytes.Replace(msgs[1], []byte("test"), []byte("tost"), 1)
+	r.NotEqual(msgs[1], tampered)
+	_, err = Verify(tampered, nil)
+	r.Error(err)
+
+	// content signed by the wrong key
+	content, err := SignContent(map[string]interface{}{
+		"type":    "metafeed/add",
+		"subfeed": subfeed,
+	}, mfKey)
+	r.NoError(err)
+	msg, err := enc.Encode(4, prev, content)
+	r.NoError(err)
+	_, err = Verify(msg.Raw(), nil)
+	r.Error(err)
+}
+
+func TestMessageHMAC(t *testing.T) {
+	r := require.New(t)
+
+	_, mfKey, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("meta"), 8)))
+	r.NoError(err)
+
+	hmacKey := bytes.Repeat([]byte("h"), 32)
+	enc := NewEncoder(mfKey)
+	r.NoError(enc.WithHMAC(hmacKey))
+	r.Error(enc.WithHMAC([]byte("short")))
+
+	content, err := SignContent(map[string]interface{}{"type": "test"}, mfKey)
+	r.NoError(err)
+	msg, err := enc.Encode(1, nil, content)
+	r.NoError(err)
+
+	_, err = Verify(msg.Raw(), nil)
+	r.Error(err)
+
+	var hk [32]byte
+	copy(hk[:], hmacKey)
+	_, err = Verify(msg.Raw(), &hk)
+	r.NoError(err)
+
+	var decoded Message
+	r.NoError(decoded.UnmarshalBinary(msg.Raw()))
+	r.Equal(msg.Key().Ref(), decoded.Key().Ref())
+}
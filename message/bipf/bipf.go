@@ -0,0 +1,434 @@
+// SPDX-License-Identifier: MIT
+
+// Package bipf implements the binary in-place format, a compact encoding of JSON-like values.
+//
+// Every value starts with a varint tag, which holds the length of the value shifted by three and the type in the lower bits.
+// Arrays and objects are the concatenation of their encoded elements, so fields can be found without decoding the whole value.
+// See https://github.com/ssbc/bipf for the details.
+package bipf
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// the types of bipf values
+const (
+	TypeString   byte = 0
+	TypeBuffer   byte = 1
+	TypeInt      byte = 2 // 32bit little endian
+	TypeDouble   byte = 3 // 64bit little endian
+	TypeArray    byte = 4
+	TypeObject   byte = 5
+	TypeBoolNull byte = 6 // no data is null, one byte is a boolean
+)
+
+// Raw is a value that is already bipf encoded, Encode copies it as is
+type Raw []byte
+
+// Encode returns the bipf encoding of v.
+// Supported are nil, bool, string, []byte, integers, float64, json.Number, json.RawMessage, Raw and slices and maps of those.
+// The keys of maps are sorted, to make the encoding deterministic.
+func Encode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encode(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeTag(buf *bytes.Buffer, t byte, length int) {
+	var tag [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tag[:], uint64(length)<<3|uint64(t))
+	buf.Write(tag[:n])
+}
+
+func encode(buf *bytes.Buffer, v interface{}) error {
+	switch tv := v.(type) {
+	case nil:
+		writeTag(buf, TypeBoolNull, 0)
+
+	case bool:
+		writeTag(buf, TypeBoolNull, 1)
+		if tv {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+
+	case string:
+		writeTag(buf, TypeString, len(tv))
+		buf.WriteString(tv)
+
+	case []byte:
+		writeTag(buf, TypeBuffer, len(tv))
+		buf.Write(tv)
+
+	case int:
+		return encodeNumber(buf, float64(tv))
+	case int32:
+		return encodeNumber(buf, float64(tv))
+	case int64:
+		return encodeNumber(buf, float64(tv))
+	case uint64:
+		return encodeNumber(buf, float64(tv))
+	case float64:
+		return encodeNumber(buf, tv)
+
+	case json.Number:
+		f, err := tv.Float64()
+		if err != nil {
+			return fmt.Errorf("bipf: invalid number %q: %w", tv, err)
+		}
+		return encodeNumber(buf, f)
+
+	case Raw:
+		buf.Write(tv)
+
+	case json.RawMessage:
+		enc, err := FromJSON(tv)
+		if err != nil {
+			return err
+		}
+		buf.Write(enc)
+
+	case []interface{}:
+		var elems bytes.Buffer
+		for i, el := range tv {
+			if err := encode(&elems, el); err != nil {
+				return fmt.Errorf("bipf: array element %d: %w", i, err)
+			}
+		}
+		writeTag(buf, TypeArray, elems.Len())
+		buf.Write(elems.Bytes())
+
+	case map[string]interface{}:
+		keys := make([]string, 0, len(tv))
+		for k := range tv {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		var fields bytes.Buffer
+		for _, k := range keys {
+			writeTag(&fields, TypeString, len(k))
+			fields.WriteString(k)
+			if err := encode(&fields, tv[k]); err != nil {
+				return fmt.Errorf("bipf: object field %q: %w", k, err)
+			}
+		}
+		writeTag(buf, TypeObject, fields.Len())
+		buf.Write(fields.Bytes())
+
+	default:
+		// go through JSON for structs and other types
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("bipf: unsupported type %T: %w", v, err)
+		}
+		enc, err := FromJSON(data)
+		if err != nil {
+			return err
+		}
+		buf.Write(enc)
+	}
+	return nil
+}
+
+func encodeNumber(buf *bytes.Buffer, f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("bipf: can't encode %v", f)
+	}
+	if f == math.Trunc(f) && f >= math.MinInt32 && f <= math.MaxInt32 {
+		writeTag(buf, TypeInt, 4)
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], uint32(int32(f)))
+		buf.Write(b[:])
+		return nil
+	}
+	writeTag(buf, TypeDouble, 8)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], math.Float64bits(f))
+	buf.Write(b[:])
+	return nil
+}
+
+// FromJSON converts JSON to bipf and keeps the order of the object fields
+func FromJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var buf bytes.Buffer
+	if err := fromJSON(&buf, dec); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("bipf: trailing data after JSON value")
+	}
+	return buf.Bytes(), nil
+}
+
+func fromJSON(buf *bytes.Buffer, dec *json.Decoder) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("bipf: invalid JSON: %w", err)
+	}
+
+	switch tv := tok.(type) {
+	case json.Delim:
+		var elems bytes.Buffer
+		switch tv {
+		case '[':
+			for dec.More() {
+				if err := fromJSON(&elems, dec); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil { // ]
+				return fmt.Errorf("bipf: invalid JSON: %w", err)
+			}
+			writeTag(buf, TypeArray, elems.Len())
+
+		case '{':
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return fmt.Errorf("bipf: invalid JSON: %w", err)
+				}
+				key, ok := keyTok.(string)
+				if !ok {
+					return fmt.Errorf("bipf: object key is not a string: %v", keyTok)
+				}
+				writeTag(&elems, TypeString, len(key))
+				elems.WriteString(key)
+				if err := fromJSON(&elems, dec); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil { // }
+				return fmt.Errorf("bipf: invalid JSON: %w", err)
+			}
+			writeTag(buf, TypeObject, elems.Len())
+
+		default:
+			return fmt.Errorf("bipf: unexpected delimiter %v", tv)
+		}
+		buf.Write(elems.Bytes())
+		return nil
+
+	default:
+		return encode(buf, tok)
+	}
+}
+
+// ReadTag returns the type and length of the value at the start of data and the number of bytes the tag used
+func ReadTag(data []byte) (byte, int, int, error) {
+	tag, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, 0, 0, fmt.Errorf("bipf: invalid tag")
+	}
+	length := tag >> 3
+	if length > uint64(len(data)-n) {
+		return 0, 0, 0, fmt.Errorf("bipf: value longer than data (%d > %d)", length, len(data)-n)
+	}
+	return byte(tag & 7), int(length), n, nil
+}
+
+// Decode returns the value at the start of data.
+// Objects are map[string]interface{}, arrays []interface{}, integers int64 and doubles float64.
+func Decode(data []byte) (interface{}, error) {
+	t, length, n, err := ReadTag(data)
+	if err != nil {
+		return nil, err
+	}
+	body := data[n : n+length]
+
+	switch t {
+	case TypeString:
+		return string(body), nil
+
+	case TypeBuffer:
+		return append([]byte(nil), body...), nil
+
+	case TypeInt:
+		if length != 4 {
+			return nil, fmt.Errorf("bipf: invalid int length %d", length)
+		}
+		return int64(int32(binary.LittleEndian.Uint32(body))), nil
+
+	case TypeDouble:
+		if length != 8 {
+			return nil, fmt.Errorf("bipf: invalid double length %d", length)
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(body)), nil
+
+	case TypeBoolNull:
+		switch length {
+		case 0:
+			return nil, nil
+		case 1:
+			return body[0] == 1, nil
+		}
+		return nil, fmt.Errorf("bipf: invalid bool length %d", length)
+
+	case TypeArray:
+		var arr = []interface{}{}
+		err := iterate(body, func(el []byte) error {
+			v, err := Decode(el)
+			if err != nil {
+				return err
+			}
+			arr = append(arr, v)
+			return nil
+		})
+		return arr, err
+
+	case TypeObject:
+		var obj = make(map[string]interface{})
+		err := iterateObject(body, func(key string, val []byte) error {
+			v, err := Decode(val)
+			if err != nil {
+				return err
+			}
+			obj[key] = v
+			return nil
+		})
+		return obj, err
+	}
+	return nil, fmt.Errorf("bipf: unknown type %d", t)
+}
+
+// iterate calls fn with each of the encoded values in body
+func iterate(body []byte, fn func([]byte) error) error {
+	for len(body) > 0 {
+		_, length, n, err := ReadTag(body)
+		if err != nil {
+			return err
+		}
+		if err := fn(body[:n+length]); err != nil {
+			return err
+		}
+		body = body[n+length:]
+	}
+	return nil
+}
+
+func iterateObject(body []byte, fn func(string, []byte) error) error {
+	var key *string
+	return iterate(body, func(el []byte) error {
+		if key == nil {
+			t, length, n, err := ReadTag(el)
+			if err != nil {
+				return err
+			}
+			if t != TypeString {
+				return fmt.Errorf("bipf: object key is not a string but %d", t)
+			}
+			k := string(el[n : n+length])
+			key = &k
+			return nil
+		}
+		err := fn(*key, el)
+		key = nil
+		return err
+	})
+}
+
+// ToJSON converts the bipf value at the start of data to JSON, in the order of the encoded fields.
+// Buffers are encoded as base64 strings.
+func ToJSON(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toJSON(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func toJSON(buf *bytes.Buffer, data []byte) error {
+	t, length, n, err := ReadTag(data)
+	if err != nil {
+		return err
+	}
+	body := data[n : n+length]
+
+	switch t {
+	case TypeArray:
+		buf.WriteByte('[')
+		first := true
+		err := iterate(body, func(el []byte) error {
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			return toJSON(buf, el)
+		})
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(']')
+		return nil
+
+	case TypeObject:
+		buf.WriteByte('{')
+		first := true
+		err := iterateObject(body, func(key string, val []byte) error {
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			if err := writeJSON(buf, key); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			return toJSON(buf, val)
+		})
+		if err != nil {
+			return err
+		}
+		buf.WriteByte('}')
+		return nil
+
+	case TypeBuffer:
+		return writeJSON(buf, base64.StdEncoding.EncodeToString(body))
+
+	case TypeDouble:
+		v, err := Decode(data)
+		if err != nil {
+			return err
+		}
+		f := v.(float64)
+		// like JSON.stringify, only use the exponent for really large numbers
+		format := byte('g')
+		if f == math.Trunc(f) && math.Abs(f) < 1e21 {
+			format = 'f'
+		}
+		buf.WriteString(strconv.FormatFloat(f, format, -1, 64))
+		return nil
+
+	default:
+		v, err := Decode(data)
+		if err != nil {
+			return err
+		}
+		return writeJSON(buf, v)
+	}
+}
+
+// writeJSON doesn't escape HTML characters, like JSON.stringify
+func writeJSON(buf *bytes.Buffer, v interface{}) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+	return nil
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: MIT
+
+package bipf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	var cases = []string{
+		`null`,
+		`true`,
+		`false`,
+		`0`,
+		`-23`,
+		`1.5`,
+		`1604000000000`,
+		`"hello, world"`,
+		`"<html> & friends"`,
+		`[]`,
+		`{}`,
+		`[1,"two",null,[true]]`,
+		`{"type":"post","text":"hi","mentions":[{"link":"@x","name":"x"}],"root":null}`,
+		`{"zzz":1,"aaa":2}`, // order is kept
+	}
+
+	for i, c := range cases {
+		enc, err := FromJSON([]byte(c))
+		r.NoError(err, "case %d", i)
+
+		back, err := ToJSON(enc)
+		r.NoError(err, "case %d", i)
+		r.Equal(c, string(back), "case %d", i)
+	}
+
+	_, err := FromJSON([]byte(`{"a":1} {}`))
+	r.Error(err, "trailing data")
+}
+
+func TestEncodeDecode(t *testing.T) {
+	r := require.New(t)
+
+	v := map[string]interface{}{
+		"int":    int64(42),
+		"double": 0.25,
+		"big":    float64(1 << 40),
+		"str":    "text",
+		"buf":    []byte{1, 2, 3},
+		"arr":    []interface{}{int64(-1), nil, true},
+		"obj":    map[string]interface{}{"nested": "yes"},
+	}
+
+	enc, err := Encode(v)
+	r.NoError(err)
+
+	dec, err := Decode(enc)
+	r.NoError(err)
+	r.Equal(v, dec)
+
+	// the tag of an object holds its length
+	typ, length, n, err := ReadTag(enc)
+	r.NoError(err)
+	r.Equal(TypeObject, typ)
+	r.Equal(len(enc), length+n)
+
+	_, err = Decode(enc[:len(enc)-1])
+	r.Error(err, "truncated")
+}
+
+func TestSeek(t *testing.T) {
+	r := require.New(t)
+
+	enc, err := FromJSON([]byte(`{"key":"%x","value":{"sequence":3,"content":{"type":"post","root":"%r","nothing":null}}}`))
+	r.NoError(err)
+
+	typ, err := SeekString(enc, "value", "content", "type")
+	r.NoError(err)
+	r.Equal("post", typ)
+
+	root, err := SeekString(enc, "value", "content", "root")
+	r.NoError(err)
+	r.Equal("%r", root)
+
+	seq, err := Seek(enc, "value", "sequence")
+	r.NoError(err)
+	v, err := Decode(seq)
+	r.NoError(err)
+	r.Equal(int64(3), v)
+
+	nothing, err := Seek(enc, "value", "content", "nothing")
+	r.NoError(err)
+	r.True(IsNull(nothing))
+	r.False(IsNull(seq))
+
+	content, err := Seek(enc, "value", "content")
+	r.NoError(err)
+	js, err := ToJSON(content)
+	r.NoError(err)
+	r.Equal(`{"type":"post","root":"%r","nothing":null}`, string(js))
+
+	_, err = Seek(enc, "value", "content", "missing")
+	r.Equal(ErrNotFound, err)
+
+	_, err = Seek(enc, "key", "deeper")
+	r.Equal(ErrNotFound, err)
+
+	_, err = SeekString(enc, "value", "sequence")
+	r.Error(err, "not a string")
+
+	whole, err := Seek(enc)
+	r.NoError(err)
+	r.Equal(enc, whole)
+}
@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+package bipf
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNotFound is returned by Seek if one of the fields on the path doesn't exist
+var ErrNotFound = errors.New("bipf: field not found")
+
+// Seek returns the encoded value at path, where every element of path is the key of a field in an object.
+// Only the tags of the skipped values are read, nothing is decoded or copied.
+func Seek(data []byte, path ...string) ([]byte, error) {
+	for _, key := range path {
+		t, length, n, err := ReadTag(data)
+		if err != nil {
+			return nil, err
+		}
+		if t != TypeObject {
+			return nil, ErrNotFound
+		}
+		data, err = seekKey(data[n:n+length], key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, _, _, err := ReadTag(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// seekKey returns the value of the field key in the body of an object
+func seekKey(body []byte, key string) ([]byte, error) {
+	for len(body) > 0 {
+		kt, klen, kn, err := ReadTag(body)
+		if err != nil {
+			return nil, err
+		}
+		if kt != TypeString {
+			return nil, fmt.Errorf("bipf: object key is not a string but %d", kt)
+		}
+		found := string(body[kn:kn+klen]) == key
+		body = body[kn+klen:]
+
+		_, vlen, vn, err := ReadTag(body)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return body[:vn+vlen], nil
+		}
+		body = body[vn+vlen:]
+	}
+	return nil, ErrNotFound
+}
+
+// SeekString returns the string at path
+func SeekString(data []byte, path ...string) (string, error) {
+	v, err := Seek(data, path...)
+	if err != nil {
+		return "", err
+	}
+	t, length, n, err := ReadTag(v)
+	if err != nil {
+		return "", err
+	}
+	if t != TypeString {
+		return "", fmt.Errorf("bipf: value is not a string but %d", t)
+	}
+	return string(v[n : n+length]), nil
+}
+
+// IsNull returns true if the value at the start of data is null
+func IsNull(data []byte) bool {
+	t, length, _, err := ReadTag(data)
+	return err == nil && t == TypeBoolNull && length == 0
+}
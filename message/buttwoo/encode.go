@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MIT
+
+package buttwoo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/auth"
+
+	"go.cryptoscope.co/ssb/message/bipf"
+)
+
+// Encoder creates new messages on a feed
+type Encoder struct {
+	author *refs.FeedRef
+	secret ed25519.PrivateKey
+
+	hmacSecret   *[32]byte
+	setTimestamp bool
+}
+
+// NewEncoder returns an Encoder for the feed of the passed key
+func NewEncoder(secret ed25519.PrivateKey) *Encoder {
+	return &Encoder{
+		author: &refs.FeedRef{
+			ID:   []byte(secret.Public().(ed25519.PublicKey)),
+			Algo: RefAlgo,
+		},
+		secret: secret,
+	}
+}
+
+// WithHMAC signs the messages for a different network
+func (e *Encoder) WithHMAC(key []byte) error {
+	var hmacSec [32]byte
+	if n := copy(hmacSec[:], key); n != 32 {
+		return fmt.Errorf("buttwoo: hmac key of wrong length: %d", n)
+	}
+	e.hmacSecret = &hmacSec
+	return nil
+}
+
+// WithNowTimestamps sets the timestamp of the messages to the current time, otherwise it's zero
+func (e *Encoder) WithNowTimestamps(yes bool) {
+	e.setTimestamp = yes
+}
+
+// Create implements ssb.FeedCreator
+func (e *Encoder) Create(content interface{}, prev *refs.MessageRef, seq int64) (refs.Message, error) {
+	msg, err := e.Encode(seq, prev, content)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Encode creates and signs the next message of the feed.
+// Encrypted content ([]byte, with an optional box1: prefix) is stored as a base64 string with a .box suffix, like on legacy feeds.
+func (e *Encoder) Encode(seq int64, prev *refs.MessageRef, val interface{}) (*Message, error) {
+	if bindata, ok := val.([]byte); ok {
+		bindata = bytes.TrimPrefix(bindata, []byte("box1:"))
+		val = base64.StdEncoding.EncodeToString(bindata) + ".box"
+	}
+	content, err := bipf.Encode(val)
+	if err != nil {
+		return nil, fmt.Errorf("buttwoo: failed to encode content: %w", err)
+	}
+	contentHash := sha256.Sum256(content)
+
+	var prevField interface{}
+	if prev != nil {
+		if prev.Algo != RefAlgo {
+			return nil, fmt.Errorf("buttwoo: previous has the wrong format: %s", prev.Algo)
+		}
+		prevField = prev.Hash
+	}
+
+	var ts int64
+	if e.setTimestamp {
+		ts = time.Now().UnixNano() / int64(time.Millisecond)
+	}
+
+	value, err := bipf.Encode([]interface{}{
+		[]byte(e.author.ID),
+		nil, // parent
+		seq,
+		ts,
+		prevField,
+		[]byte{TagNone},
+		int64(len(content)),
+		contentHash[:],
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	toSign := value
+	if e.hmacSecret != nil {
+		mac := auth.Sum(toSign, e.hmacSecret)
+		toSign = mac[:]
+	}
+	sig := ed25519.Sign(e.secret, toSign)
+
+	raw, err := bipf.Encode([]interface{}{value, sig, content})
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := decode(raw)
+	if err != nil {
+		return nil, fmt.Errorf("buttwoo: failed to decode new message: %w", err)
+	}
+	return msg, nil
+}
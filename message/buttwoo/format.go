@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: MIT
+
+package buttwoo
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// StorageType tags buttwoo messages in the receive log and multilogs
+const StorageType byte = 0x10
+
+func init() {
+	if err := ssb.RegisterFeedFormat(Format{}); err != nil {
+		panic(err)
+	}
+}
+
+// Format implements ssb.FeedFormat for buttwoo
+type Format struct{}
+
+var (
+	_ ssb.FeedFormat      = Format{}
+	_ ssb.SignatureFormat = Format{}
+)
+
+func (Format) Algo() string      { return RefAlgo }
+func (Format) StorageType() byte { return StorageType }
+func (Format) Binary() bool      { return true }
+
+func (Format) Encode(msg refs.Message) ([]byte, error) {
+	bm, ok := msg.(*Message)
+	if !ok {
+		return nil, fmt.Errorf("buttwoo: expected %T - got %T", bm, msg)
+	}
+	return bm.raw, nil
+}
+
+func (Format) Decode(data []byte) (refs.Message, error) {
+	msg, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (Format) Verify(data []byte, hmacKey *[32]byte) (refs.Message, error) {
+	msg, err := Verify(data, hmacKey)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (Format) Signature(msg refs.Message) (string, error) {
+	bm, ok := msg.(*Message)
+	if !ok {
+		return "", fmt.Errorf("buttwoo: expected %T - got %T", bm, msg)
+	}
+	return base64.StdEncoding.EncodeToString(bm.signature) + ".sig.ed25519", nil
+}
+
+func (Format) NewCreator(kp *ssb.KeyPair) (ssb.FeedCreator, error) {
+	if kp.Id.Algo != RefAlgo {
+		return nil, fmt.Errorf("buttwoo: wrong feed format for creator: %s", kp.Id.Algo)
+	}
+	return NewEncoder(kp.Pair.Secret), nil
+}
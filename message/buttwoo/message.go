@@ -0,0 +1,272 @@
+// SPDX-License-Identifier: MIT
+
+// Package buttwoo implements a compact binary feed format, modeled after buttwoo.
+//
+// Messages are bipf arrays of the value, the signature and the content.
+// The value holds author, parent, sequence, timestamp, previous, a tag and the length and hash of the content,
+// so that the content can be dropped without breaking the chain.
+//
+// The original buttwoo uses blake3 for the message keys, this version uses sha256 instead.
+// Because of that it uses its own suffix and is not compatible with other buttwoo implementations.
+//
+// The format is registered with ssb.RegisterFeedFormat when the package is imported.
+package buttwoo
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/cryptix/go/encodedTime"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/nacl/auth"
+
+	"go.cryptoscope.co/ssb/message/bipf"
+)
+
+// RefAlgo is the suffix of feed and message references of this format
+const RefAlgo = "buttwoo-sha256"
+
+// the tags of a message
+const (
+	TagNone  byte = 0 // a regular message
+	TagEnd   byte = 1 // the last message of the feed
+	TagOther byte = 2 // reserved
+)
+
+const valueFields = 8
+
+// Message is a buttwoo message
+type Message struct {
+	raw   []byte // the transfer encoding
+	value []byte
+
+	key      *refs.MessageRef
+	author   *refs.FeedRef
+	previous *refs.MessageRef
+	sequence int64
+	claimed  time.Time
+	tag      byte
+
+	signature     []byte
+	content       []byte // bipf
+	contentLength int64
+	contentHash   []byte
+
+	received time.Time
+}
+
+var _ refs.Message = (*Message)(nil)
+
+func (msg Message) Key() *refs.MessageRef      { return msg.key }
+func (msg Message) Author() *refs.FeedRef      { return msg.author }
+func (msg Message) Previous() *refs.MessageRef { return msg.previous }
+func (msg Message) Seq() int64                 { return msg.sequence }
+func (msg Message) Claimed() time.Time         { return msg.claimed }
+func (msg Message) Received() time.Time        { return msg.received }
+
+// SetReceived sets the time the message was stored locally
+func (msg *Message) SetReceived(t time.Time) { msg.received = t }
+
+// Tag returns the tag of the message
+func (msg Message) Tag() byte { return msg.tag }
+
+// Raw returns the transfer encoding of the message
+func (msg Message) Raw() []byte { return msg.raw }
+
+// ContentBytes returns the content as JSON, so that the indexes can treat it like any other message
+func (msg Message) ContentBytes() []byte {
+	if len(msg.content) == 0 {
+		return nil
+	}
+	b, err := bipf.ToJSON(msg.content)
+	if err != nil {
+		log.Println("warning: buttwoo content encoding failed:", err)
+		return nil
+	}
+	return b
+}
+
+func (msg Message) ValueContent() *refs.Value {
+	var val refs.Value
+	val.Previous = msg.previous
+	val.Author = *msg.author
+	val.Sequence = margaret.BaseSeq(msg.sequence)
+	val.Timestamp = encodedTime.Millisecs(msg.claimed)
+	val.Hash = RefAlgo
+	val.Content = msg.ContentBytes()
+	val.Signature = base64.StdEncoding.EncodeToString(msg.signature) + ".sig.ed25519"
+	return &val
+}
+
+func (msg Message) ValueContentJSON() json.RawMessage {
+	b, err := json.Marshal(msg.ValueContent())
+	if err != nil {
+		log.Println("warning: buttwoo value encoding failed:", err)
+		return nil
+	}
+	return b
+}
+
+// MarshalBinary returns the transfer encoding
+func (msg Message) MarshalBinary() ([]byte, error) {
+	return msg.raw, nil
+}
+
+// UnmarshalBinary decodes the message without checking the signature.
+// Use Verify for data that comes from the network.
+func (msg *Message) UnmarshalBinary(data []byte) error {
+	decoded, err := decode(data)
+	if err != nil {
+		return err
+	}
+	*msg = *decoded
+	return nil
+}
+
+func decode(data []byte) (*Message, error) {
+	v, err := bipf.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("buttwoo: invalid message encoding: %w", err)
+	}
+	if _, length, n, _ := bipf.ReadTag(data); n+length != len(data) {
+		return nil, fmt.Errorf("buttwoo: trailing data after message")
+	}
+
+	transfer, ok := v.([]interface{})
+	if !ok || len(transfer) != 3 {
+		return nil, fmt.Errorf("buttwoo: message is not a list of value, signature and content")
+	}
+
+	var msg Message
+	msg.raw = data
+
+	msg.value, ok = transfer[0].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("buttwoo: value is not a buffer")
+	}
+	msg.signature, ok = transfer[1].([]byte)
+	if !ok || len(msg.signature) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("buttwoo: invalid signature")
+	}
+	switch tc := transfer[2].(type) {
+	case nil: // dropped content
+	case []byte:
+		msg.content = tc
+	default:
+		return nil, fmt.Errorf("buttwoo: content is not a buffer but %T", tc)
+	}
+
+	if err := msg.decodeValue(); err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	h.Write(msg.value)
+	h.Write(msg.signature)
+	msg.key = &refs.MessageRef{
+		Hash: h.Sum(nil),
+		Algo: RefAlgo,
+	}
+	return &msg, nil
+}
+
+func (msg *Message) decodeValue() error {
+	v, err := bipf.Decode(msg.value)
+	if err != nil {
+		return fmt.Errorf("buttwoo: invalid value encoding: %w", err)
+	}
+	fields, ok := v.([]interface{})
+	if !ok || len(fields) != valueFields {
+		return fmt.Errorf("buttwoo: value is not a list of %d fields", valueFields)
+	}
+
+	author, ok := fields[0].([]byte)
+	if !ok || len(author) != ed25519.PublicKeySize {
+		return fmt.Errorf("buttwoo: invalid author")
+	}
+	msg.author = &refs.FeedRef{ID: author, Algo: RefAlgo}
+
+	if fields[1] != nil {
+		return fmt.Errorf("buttwoo: parent feeds are not supported yet")
+	}
+
+	msg.sequence, ok = fields[2].(int64)
+	if !ok || msg.sequence < 1 {
+		return fmt.Errorf("buttwoo: invalid sequence")
+	}
+
+	switch ts := fields[3].(type) {
+	case int64:
+		msg.claimed = time.Unix(0, ts*int64(time.Millisecond))
+	case float64:
+		msg.claimed = time.Unix(0, int64(ts)*int64(time.Millisecond))
+	default:
+		return fmt.Errorf("buttwoo: timestamp is not a number but %T", ts)
+	}
+
+	switch prev := fields[4].(type) {
+	case nil:
+		if msg.sequence != 1 {
+			return fmt.Errorf("buttwoo: no previous on sequence %d", msg.sequence)
+		}
+	case []byte:
+		if len(prev) != sha256.Size {
+			return fmt.Errorf("buttwoo: invalid previous length: %d", len(prev))
+		}
+		msg.previous = &refs.MessageRef{Hash: prev, Algo: RefAlgo}
+	default:
+		return fmt.Errorf("buttwoo: previous is not a buffer but %T", prev)
+	}
+
+	tag, ok := fields[5].([]byte)
+	if !ok || len(tag) != 1 || tag[0] > TagOther {
+		return fmt.Errorf("buttwoo: invalid tag")
+	}
+	msg.tag = tag[0]
+
+	msg.contentLength, ok = fields[6].(int64)
+	if !ok || msg.contentLength < 0 {
+		return fmt.Errorf("buttwoo: invalid content length")
+	}
+
+	msg.contentHash, ok = fields[7].([]byte)
+	if !ok || len(msg.contentHash) != sha256.Size {
+		return fmt.Errorf("buttwoo: invalid content hash")
+	}
+	return nil
+}
+
+// Verify decodes the message and checks its signature and, unless it was dropped, its content.
+// hmacKey is optional.
+func Verify(data []byte, hmacKey *[32]byte) (*Message, error) {
+	msg, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	toVerify := msg.value
+	if hmacKey != nil {
+		mac := auth.Sum(toVerify, hmacKey)
+		toVerify = mac[:]
+	}
+	if !ed25519.Verify(ed25519.PublicKey(msg.author.ID), toVerify, msg.signature) {
+		return nil, fmt.Errorf("buttwoo: invalid signature")
+	}
+
+	if msg.content != nil {
+		if int64(len(msg.content)) != msg.contentLength {
+			return nil, fmt.Errorf("buttwoo: content length mismatch (%d != %d)", len(msg.content), msg.contentLength)
+		}
+		sum := sha256.Sum256(msg.content)
+		if string(sum[:]) != string(msg.contentHash) {
+			return nil, fmt.Errorf("buttwoo: content hash mismatch")
+		}
+	}
+	return msg, nil
+}
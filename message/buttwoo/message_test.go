@@ -0,0 +1,138 @@
+// SPDX-License-Identifier: MIT
+
+package buttwoo
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/ed25519"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestMessageRoundtrip(t *testing.T) {
+	r := require.New(t)
+
+	_, key, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("woo!"), 8)))
+	r.NoError(err)
+
+	enc := NewEncoder(key)
+	enc.WithNowTimestamps(true)
+
+	var prev *refs.MessageRef
+	var msgs [][]byte
+	for i := int64(1); i <= 3; i++ {
+		msg, err := enc.Encode(i, prev, map[string]interface{}{
+			"type": "test",
+			"i":    i,
+			"text": "hello, world",
+		})
+		r.NoError(err)
+		r.Equal(i, msg.Seq())
+		r.True(msg.Author().Equal(enc.author))
+		r.Equal(RefAlgo, msg.Key().Algo)
+		if prev == nil {
+			r.Nil(msg.Previous())
+		} else {
+			r.True(msg.Previous().Equal(*prev))
+		}
+
+		verified, err := Verify(msg.Raw(), nil)
+		r.NoError(err)
+		r.Equal(msg.Key().Ref(), verified.Key().Ref())
+		r.Equal(msg.Claimed().Unix(), verified.Claimed().Unix())
+
+		var c map[string]interface{}
+		r.NoError(json.Unmarshal(verified.ContentBytes(), &c))
+		r.Equal("test", c["type"])
+		r.Equal("hello, world", c["text"])
+		r.EqualValues(i, c["i"])
+
+		prev = msg.Key()
+		msgs = append(msgs, msg.Raw())
+	}
+
+	// wrong hmac key
+	var hmacKey [32]byte
+	_, err = Verify(msgs[0], &hmacKey)
+	r.Error(err)
+
+	// tampered content
+	tampered := bytes.Replace(msgs[1], []byte("world"), []byte("w0rld"), 1)
+	r.NotEqual(msgs[1], tampered)
+	_, err = Verify(tampered, nil)
+	r.Error(err)
+
+	// encrypted content becomes a string
+	boxed, err := enc.Encode(4, prev, append([]byte("box1:"), 1, 2, 3))
+	r.NoError(err)
+	r.Equal(`"AQID.box"`, string(boxed.ContentBytes()))
+
+	// previous of another format
+	_, err = enc.Encode(5, &refs.MessageRef{Hash: make([]byte, 32), Algo: refs.RefAlgoMessageSSB1}, "nope")
+	r.Error(err)
+}
+
+func TestMessageHMAC(t *testing.T) {
+	r := require.New(t)
+
+	_, key, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("woo!"), 8)))
+	r.NoError(err)
+
+	hmacKey := bytes.Repeat([]byte("h"), 32)
+	enc := NewEncoder(key)
+	r.NoError(enc.WithHMAC(hmacKey))
+	r.Error(enc.WithHMAC([]byte("short")))
+
+	msg, err := enc.Encode(1, nil, map[string]interface{}{"type": "test"})
+	r.NoError(err)
+
+	_, err = Verify(msg.Raw(), nil)
+	r.Error(err)
+
+	var hk [32]byte
+	copy(hk[:], hmacKey)
+	_, err = Verify(msg.Raw(), &hk)
+	r.NoError(err)
+}
+
+func TestRegistered(t *testing.T) {
+	r := require.New(t)
+
+	ff, ok := ssb.GetFeedFormat(RefAlgo)
+	r.True(ok)
+	byType, ok := ssb.GetFeedFormatByStorageType(StorageType)
+	r.True(ok)
+	r.Equal(ff, byType)
+
+	_, key, err := ed25519.GenerateKey(bytes.NewReader(bytes.Repeat([]byte("woo!"), 8)))
+	r.NoError(err)
+	kp := &ssb.KeyPair{Id: &refs.FeedRef{ID: []byte(key.Public().(ed25519.PublicKey)), Algo: RefAlgo}}
+	kp.Pair.Secret = key
+	kp.Pair.Public = key.Public().(ed25519.PublicKey)
+
+	c, err := ff.NewCreator(kp)
+	r.NoError(err)
+	msg, err := c.Create(map[string]interface{}{"type": "test"}, nil, 1)
+	r.NoError(err)
+
+	data, err := ff.Encode(msg)
+	r.NoError(err)
+	decoded, err := ff.Decode(data)
+	r.NoError(err)
+	r.Equal(msg.Key().Ref(), decoded.Key().Ref())
+
+	ref, err := ssb.ParseFeedRef(kp.Id.Ref())
+	r.NoError(err)
+	r.True(ref.Equal(kp.Id))
+
+	back, err := ssb.FeedRefFromStoredAddr(ssb.StoredAddr(kp.Id))
+	r.NoError(err)
+	r.True(back.Equal(kp.Id))
+
+	r.Error(ssb.RegisterFeedFormat(Format{}), "registered twice")
+}
@@ -6,15 +6,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"time"
+	"fmt"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
-	gabbygrove "go.mindeco.de/ssb-gabbygrove"
 	refs "go.mindeco.de/ssb-refs"
 
-	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb"
+	// registers the builtin feed formats
+	_ "go.cryptoscope.co/ssb/message/multimsg"
 )
 
 // NewVerifySink returns a sink that does message verification and appends corret messages to the passed log.
@@ -30,73 +31,59 @@ func NewVerifySink(who *refs.FeedRef, start margaret.Seq, abs refs.Message, snk
 		latestMsg: abs,
 		storage:   snk,
 	}
-	switch who.Algo {
-	case refs.RefAlgoFeedSSB1:
-		sd.verify = legacyVerify{hmacKey: hmacKey}
-	case refs.RefAlgoFeedGabby:
-		sd.verify = gabbyVerify{hmacKey: hmacKey}
+	if ff, ok := ssb.GetFeedFormat(who.Algo); ok {
+		sd.verify = formatVerify{format: ff, hmacKey: hmacKey}
+	} else {
+		sd.verify = unsupportedVerify(who.Algo)
 	}
 	return sd
 }
 
-type verifier interface {
-	Verify(v interface{}) (refs.Message, error)
+// NewPartialVerifySink is like NewVerifySink but for feeds that are only replicated partially.
+// The first message does not need to have sequence one, it anchors the chain if abs is nil.
+// If sparse is true, the messages don't need to form a chain either, see ValidateSparse.
+func NewPartialVerifySink(who *refs.FeedRef, start margaret.Seq, abs refs.Message, snk luigi.Sink, hmacKey *[32]byte, sparse bool) luigi.Sink {
+	sd := NewVerifySink(who, start, abs, snk, hmacKey).(*streamDrain)
+	sd.partial = true
+	sd.sparse = sparse
+	return sd
 }
 
-type legacyVerify struct {
-	hmacKey *[32]byte
+type verifier interface {
+	Verify(v interface{}) (refs.Message, error)
 }
 
-func (lv legacyVerify) Verify(v interface{}) (refs.Message, error) {
-	rmsg, ok := v.(json.RawMessage)
-	if !ok {
-		return nil, errors.Errorf("legacyVerify: expected %T - got %T", rmsg, v)
-	}
-	ref, dmsg, err := legacy.Verify(rmsg, lv.hmacKey)
-	if err != nil {
-		return nil, err
-	}
+// unsupportedVerify rejects everything of a feed format that isn't registered
+type unsupportedVerify string
 
-	return &legacy.StoredMessage{
-		Author_:    &dmsg.Author,
-		Previous_:  dmsg.Previous,
-		Key_:       ref,
-		Sequence_:  dmsg.Sequence,
-		Timestamp_: time.Now(),
-		Raw_:       rmsg,
-	}, nil
+func (algo unsupportedVerify) Verify(_ interface{}) (refs.Message, error) {
+	return nil, errors.Errorf("verify: unsupported feed format: %s", string(algo))
 }
 
-type gabbyVerify struct {
+// formatVerify verifies the messages of a format added with ssb.RegisterFeedFormat
+type formatVerify struct {
+	format  ssb.FeedFormat
 	hmacKey *[32]byte
 }
 
-func (gv gabbyVerify) Verify(v interface{}) (msg refs.Message, err error) {
-	trBytes, ok := v.([]uint8)
-	if !ok {
-		err = errors.Errorf("gabbyVerify: expected %T - got %T", trBytes, v)
-		return
-	}
-	var tr gabbygrove.Transfer
-	if uErr := tr.UnmarshalCBOR(trBytes); uErr != nil {
-		err = errors.Wrapf(uErr, "gabbyVerify: transfer unmarshal failed")
-		return
-	}
-
-	defer func() {
-		if r := recover(); r != nil {
-			if panicErr, ok := r.(error); ok {
-				err = errors.Wrap(panicErr, "gabbyVerify: recovered from panic")
-			} else {
-				panic(r)
-			}
+func (rv formatVerify) Verify(v interface{}) (refs.Message, error) {
+	var data []byte
+	switch tv := v.(type) {
+	case []uint8:
+		data = tv
+	case json.RawMessage:
+		if rv.format.Binary() {
+			return nil, errors.Errorf("%sVerify: expected binary data - got JSON", rv.format.Algo())
 		}
-	}()
-	if !tr.Verify(gv.hmacKey) {
-		return nil, errors.Errorf("gabbyVerify: transfer verify failed")
+		data = tv
+	default:
+		return nil, errors.Errorf("%sVerify: expected %T - got %T", rv.format.Algo(), data, v)
 	}
-	msg = &tr
-	return
+	msg, err := rv.format.Verify(data, rv.hmacKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%sVerify: message verify failed", rv.format.Algo())
+	}
+	return msg, nil
 }
 
 type streamDrain struct {
@@ -109,6 +96,9 @@ type streamDrain struct {
 	latestSeq margaret.BaseSeq
 	latestMsg refs.Message
 
+	// partial feeds can start anywhere, sparse ones don't form a chain
+	partial, sparse bool
+
 	storage luigi.Sink
 }
 
@@ -117,8 +107,22 @@ func (ld *streamDrain) Pour(ctx context.Context, v interface{}) error {
 	if err != nil {
 		return errors.Wrapf(err, "muxDrain(%s:%d) verify failed", ld.who.ShortRef(), ld.latestSeq.Seq())
 	}
+	return ld.append(ctx, next)
+}
 
-	err = ValidateNext(ld.latestMsg, next)
+// append checks that next fits onto the feed and stores it
+func (ld *streamDrain) append(ctx context.Context, next refs.Message) error {
+	var err error
+	switch {
+	case ld.sparse:
+		err = ValidateSparse(ld.who, ld.latestMsg, next)
+	case ld.partial && ld.latestMsg == nil:
+		if !ld.who.Equal(next.Author()) {
+			err = errors.Errorf("muxDrain(%s): wrong author: %s", ld.who.ShortRef(), next.Author().ShortRef())
+		}
+	default:
+		err = ValidateNext(ld.latestMsg, next)
+	}
 	if err != nil {
 		return err
 	}
@@ -146,7 +150,15 @@ func ValidateNext(current, next refs.Message) error {
 			return errors.Errorf("ValidateNext(%s:%d): wrong author: %s", author.ShortRef(), current.Seq(), next.Author().ShortRef())
 		}
 
+		// same sequence but a different message is the proof of a fork
+		if current.Seq() == next.Seq() && !current.Key().Equal(*next.Key()) {
+			return ErrFork{Current: current, Other: next}
+		}
+
 		if bytes.Compare(current.Key().Hash, next.Previous().Hash) != 0 {
+			if current.Seq()+1 == next.Seq() {
+				return ErrFork{Current: current, Other: next}
+			}
 			return errors.Errorf("ValidateNext(%s:%d): previous compare failed expected:%s incoming:%s",
 				author.Ref(),
 				current.Seq(),
@@ -167,3 +179,46 @@ func ValidateNext(current, next refs.Message) error {
 
 	return nil
 }
+
+// ErrFork is returned by ValidateNext if next doesn't continue the feed of current.
+// Either Other has the same sequence as Current, which proves the fork,
+// or Other points to a different message with the sequence of Current, which still needs to be fetched as proof.
+type ErrFork struct {
+	Current, Other refs.Message
+}
+
+func (e ErrFork) Error() string {
+	author := e.Current.Author()
+	if e.Proven() {
+		return fmt.Sprintf("ValidateNext(%s:%d): feed forked, got %s instead of %s",
+			author.ShortRef(),
+			e.Current.Seq(),
+			e.Other.Key().Ref(),
+			e.Current.Key().Ref(),
+		)
+	}
+	return fmt.Sprintf("ValidateNext(%s:%d): previous compare failed expected:%s incoming:%s",
+		author.Ref(),
+		e.Current.Seq(),
+		e.Current.Key().Ref(),
+		e.Other.Previous().Ref(),
+	)
+}
+
+// Proven returns true if both messages have the same sequence
+func (e ErrFork) Proven() bool { return e.Current.Seq() == e.Other.Seq() }
+
+// ValidateSparse is the check for feeds of which only some messages are stored, like only the ones of certain types.
+// Since the previous hashes can't be checked, it only makes sure the messages are from author who and that the sequence is increasing.
+func ValidateSparse(who *refs.FeedRef, current, next refs.Message) error {
+	if !who.Equal(next.Author()) {
+		return errors.Errorf("ValidateSparse(%s): wrong author: %s", who.ShortRef(), next.Author().ShortRef())
+	}
+	if current != nil && next.Seq() <= current.Seq() {
+		return errors.Errorf("ValidateSparse(%s:%d): sequence %d is not increasing", who.ShortRef(), current.Seq(), next.Seq())
+	}
+	if next.Seq() < 1 {
+		return errors.Errorf("ValidateSparse(%s): invalid sequence %d", who.ShortRef(), next.Seq())
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// SPDX-License-Identifier: MIT
+
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+// signChain signs n messages of author, starting with sequence from after prev
+func signChain(t *testing.T, author *ssb.KeyPair, prev *refs.MessageRef, from, n int, tag string) ([]json.RawMessage, []*refs.MessageRef) {
+	var (
+		msgs []json.RawMessage
+		keys []*refs.MessageRef
+	)
+	for i := from; i < from+n; i++ {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    author.Id.Ref(),
+			Sequence:  margaret.BaseSeq(i),
+			Timestamp: int64(i),
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "i": i, "tag": tag},
+		}
+		ref, signed, err := lm.Sign(author.Pair.Secret[:], nil)
+		require.NoError(t, err)
+		msgs = append(msgs, signed)
+		keys = append(keys, ref)
+		prev = ref
+	}
+	return msgs, keys
+}
+
+func TestPartialVerifySink(t *testing.T) {
+	r := require.New(t)
+
+	author, err := ssb.NewKeyPair(rand.New(rand.NewSource(23)))
+	r.NoError(err)
+	other, err := ssb.NewKeyPair(rand.New(rand.NewSource(42)))
+	r.NoError(err)
+
+	// a chain of six signed messages
+	chain, _ := signChain(t, author, nil, 1, 6, "a")
+	_, foreign, err := legacy.LegacyMessage{
+		Author:    other.Id.Ref(),
+		Sequence:  5,
+		Timestamp: 1,
+		Hash:      "sha256",
+		Content:   map[string]interface{}{"type": "test"},
+	}.Sign(other.Pair.Secret[:], nil)
+	r.NoError(err)
+
+	pourAll := func(snk luigi.Sink, msgs ...json.RawMessage) error {
+		for _, m := range msgs {
+			if err := snk.Pour(context.TODO(), m); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	type testCase struct {
+		sparse bool
+		msgs   []json.RawMessage
+		okay   bool
+	}
+	cases := []testCase{
+		// the complete feed is fine for both
+		{false, chain, true},
+		{true, chain, true},
+
+		// the tail has to form a chain
+		{false, chain[3:], true},
+		{false, []json.RawMessage{chain[2], chain[4]}, false},
+		{false, []json.RawMessage{chain[3], chain[2]}, false},
+
+		// sparse only needs increasing sequences
+		{true, []json.RawMessage{chain[1], chain[4], chain[5]}, true},
+		{true, []json.RawMessage{chain[4], chain[1]}, false},
+		{true, []json.RawMessage{chain[4], chain[4]}, false},
+	}
+
+	for i, tc := range cases {
+		t.Run(fmt.Sprint(i), func(t *testing.T) {
+			a := assert.New(t)
+			var stored []int64
+			store := luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+				if err != nil {
+					return err
+				}
+				stored = append(stored, v.(refs.Message).Seq())
+				return nil
+			})
+
+			snk := NewPartialVerifySink(author.Id, margaret.BaseSeq(0), nil, store, nil, tc.sparse)
+			err := pourAll(snk, tc.msgs...)
+			if tc.okay {
+				a.NoError(err)
+				a.Len(stored, len(tc.msgs))
+			} else {
+				a.Error(err)
+			}
+		})
+	}
+
+	// the wrong author is refused in all cases
+	for _, sparse := range []bool{false, true} {
+		snk := NewPartialVerifySink(author.Id, margaret.BaseSeq(0), nil, luigi.FuncSink(func(context.Context, interface{}, error) error { return nil }), nil, sparse)
+		err := pourAll(snk, json.RawMessage(foreign))
+		assert.Error(t, err, "sparse:%v", sparse)
+	}
+
+	// the normal sink still wants the chain to start at one
+	snk := NewVerifySink(author.Id, margaret.BaseSeq(0), nil, luigi.FuncSink(func(context.Context, interface{}, error) error { return nil }), nil)
+	err = pourAll(snk, chain[3:]...)
+	assert.Error(t, err)
+}
+
+func TestValidateNextFork(t *testing.T) {
+	r := require.New(t)
+
+	author, err := ssb.NewKeyPair(rand.New(rand.NewSource(23)))
+	r.NoError(err)
+
+	// both versions share the first two messages
+	chainA, keysA := signChain(t, author, nil, 1, 4, "a")
+	chainB, _ := signChain(t, author, keysA[1], 3, 2, "b")
+
+	var stored []refs.Message
+	store := luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		stored = append(stored, v.(refs.Message))
+		return nil
+	})
+	snk := NewVerifySink(author.Id, margaret.BaseSeq(0), nil, store, nil)
+	for _, m := range chainA[:3] {
+		r.NoError(snk.Pour(context.TODO(), m))
+	}
+	r.Len(stored, 3)
+
+	ff, ok := ssb.GetFeedFormat(refs.RefAlgoFeedSSB1)
+	r.True(ok)
+	verify := formatVerify{format: ff}
+
+	// the next message of the other version points to a different previous
+	other4, err := verify.Verify(chainB[1])
+	r.NoError(err)
+	err = ValidateNext(stored[2], other4)
+	forkErr, ok := err.(ErrFork)
+	r.True(ok, "wrong error: %v", err)
+	r.False(forkErr.Proven())
+	r.Equal(int64(3), forkErr.Current.Seq())
+
+	// the same sequence with a different key is the proof
+	other3, err := verify.Verify(chainB[0])
+	r.NoError(err)
+	err = ValidateNext(stored[2], other3)
+	forkErr, ok = err.(ErrFork)
+	r.True(ok, "wrong error: %v", err)
+	r.True(forkErr.Proven())
+
+	// the stored version still continues fine
+	next, err := verify.Verify(chainA[3])
+	r.NoError(err)
+	r.NoError(ValidateNext(stored[2], next))
+}
+
+func TestBatchVerifySink(t *testing.T) {
+	r := require.New(t)
+
+	author, err := ssb.NewKeyPair(rand.New(rand.NewSource(23)))
+	r.NoError(err)
+
+	chain, keys := signChain(t, author, nil, 1, 100, "a")
+	forked, _ := signChain(t, author, keys[59], 61, 1, "b")
+
+	collect := func(stored *[]int64) luigi.Sink {
+		return luigi.FuncSink(func(_ context.Context, v interface{}, err error) error {
+			if err != nil {
+				if luigi.IsEOS(err) {
+					return nil
+				}
+				return err
+			}
+			*stored = append(*stored, v.(refs.Message).Seq())
+			return nil
+		})
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		t.Run(fmt.Sprint(workers), func(t *testing.T) {
+			r := require.New(t)
+
+			// the complete chain arrives in order
+			var stored []int64
+			snk := NewBatchVerifySink(author.Id, margaret.BaseSeq(0), nil, collect(&stored), nil, workers)
+			for _, m := range chain {
+				r.NoError(snk.Pour(context.TODO(), m))
+			}
+			r.NoError(snk.Close())
+			r.Len(stored, len(chain))
+			for i, seq := range stored {
+				r.Equal(int64(i+1), seq)
+			}
+
+			// the next message after a diverging one stops the chain
+			broken := append(append([]json.RawMessage{}, chain[:60]...), forked[0])
+			broken = append(broken, chain[61:]...)
+			stored = nil
+			snk = NewBatchVerifySink(author.Id, margaret.BaseSeq(0), nil, collect(&stored), nil, workers)
+			for _, m := range broken {
+				if err := snk.Pour(context.TODO(), m); err != nil {
+					break
+				}
+			}
+			err := snk.Close()
+			r.Error(err)
+			_, isFork := err.(ErrFork)
+			r.True(isFork, "wrong error: %v", err)
+			r.Equal(err, snk.Err())
+			r.Len(stored, 61)
+
+			// invalid signatures are reported
+			invalid := append(append([]json.RawMessage{}, chain[:10]...), json.RawMessage(`{"nope":true}`))
+			stored = nil
+			snk = NewBatchVerifySink(author.Id, margaret.BaseSeq(0), nil, collect(&stored), nil, workers)
+			for _, m := range invalid {
+				r.NoError(snk.Pour(context.TODO(), m))
+			}
+			r.Error(snk.Close())
+			r.Len(stored, 10)
+		})
+	}
+}
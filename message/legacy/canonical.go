@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+
+package legacy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// MaxMessageLength is the longest a signed message can be.
+// It's counted in UTF-16 code units of the JSON.stringify(msg, null, 2) encoding, which is what EncodePreserveOrder produces.
+const MaxMessageLength = 8192
+
+// EncodedLength returns the length of enc in UTF-16 code units, like String.length in JS
+func EncodedLength(enc []byte) int {
+	n := 0
+	for _, r := range string(enc) {
+		if r >= 0x10000 { // surrogate pair
+			n += 2
+		} else {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckLength returns ssb.ErrMessageTooLong if the encoded message enc is longer than MaxMessageLength
+func CheckLength(enc []byte) error {
+	if n := EncodedLength(enc); n > MaxMessageLength {
+		return ssb.ErrMessageTooLong{Length: n, Max: MaxMessageLength}
+	}
+	return nil
+}
+
+// the orders of the fields the JS implementation accepts, old messages have the sequence before the author
+var canonicalFieldOrders = []string{
+	"previous,author,sequence,timestamp,hash,content,signature",
+	"previous,sequence,author,timestamp,hash,content,signature",
+}
+
+// CheckCanonical returns ssb.ErrNonCanonical if the received message raw isn't encoded the way the JS implementation would encode it.
+// enc has to be EncodePreserveOrder(raw). Both may only differ in insignificant whitespace (raw can also be compact JSON),
+// the message needs exactly the fields of a signed message in the right order and no object can have the same key twice.
+func CheckCanonical(raw, enc []byte) error {
+	keys, err := uniqueKeys(raw)
+	if err != nil {
+		return ssb.ErrNonCanonical{Reason: err.Error()}
+	}
+
+	fields := strings.Join(keys, ",")
+	ordered := false
+	for _, o := range canonicalFieldOrders {
+		if fields == o {
+			ordered = true
+			break
+		}
+	}
+	if !ordered {
+		return ssb.ErrNonCanonical{Reason: fmt.Sprintf("unexpected fields or order: %s", fields)}
+	}
+
+	var compactRaw, compactEnc bytes.Buffer
+	if err := json.Compact(&compactRaw, raw); err != nil {
+		return ssb.ErrNonCanonical{Reason: err.Error()}
+	}
+	if err := json.Compact(&compactEnc, enc); err != nil {
+		return ssb.ErrNonCanonical{Reason: err.Error()}
+	}
+	a, b := compactRaw.Bytes(), compactEnc.Bytes()
+	if !bytes.Equal(a, b) {
+		i := 0
+		for i < len(a) && i < len(b) && a[i] == b[i] {
+			i++
+		}
+		return ssb.ErrNonCanonical{Reason: fmt.Sprintf("encoding differs at offset %d of the compacted message", i)}
+	}
+
+	var msg struct {
+		Hash string `json:"hash"`
+	}
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return ssb.ErrNonCanonical{Reason: err.Error()}
+	}
+	if msg.Hash != "sha256" {
+		return ssb.ErrNonCanonical{Reason: fmt.Sprintf("unsupported hash: %q", msg.Hash)}
+	}
+	return nil
+}
+
+// uniqueKeys returns the keys of the outer object in raw, in the order they appear.
+// It fails if any object in raw has a key more than once, JSON.parse would silently drop all but the last.
+func uniqueKeys(raw []byte) ([]string, error) {
+	type level struct {
+		object    bool
+		expectKey bool
+		keys      map[string]struct{}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var (
+		stack []*level
+		outer []string
+	)
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var cur *level
+		if n := len(stack); n > 0 {
+			cur = stack[n-1]
+		}
+
+		if d, ok := t.(json.Delim); ok {
+			switch d {
+			case '{', '[':
+				if cur != nil && cur.object {
+					// the value of the current pair, the next token there is a key again
+					cur.expectKey = true
+				}
+				stack = append(stack, &level{
+					object:    d == '{',
+					expectKey: true,
+					keys:      make(map[string]struct{}),
+				})
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("expected an object, got %v", t)
+		}
+		if !cur.object {
+			continue
+		}
+		if !cur.expectKey {
+			cur.expectKey = true
+			continue
+		}
+
+		key, ok := t.(string)
+		if !ok {
+			return nil, fmt.Errorf("unexpected key %v", t)
+		}
+		if _, dupe := cur.keys[key]; dupe {
+			return nil, fmt.Errorf("duplicate key %q", key)
+		}
+		cur.keys[key] = struct{}{}
+		if len(stack) == 1 {
+			outer = append(outer, key)
+		}
+		cur.expectKey = false
+	}
+	return outer, nil
+}
@@ -0,0 +1,130 @@
+// SPDX-License-Identifier: MIT
+
+package legacy
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestVerifyCanonical(t *testing.T) {
+	a, r := assert.New(t), require.New(t)
+
+	// everything from the JS implementation passes
+	n := len(testMessages)
+	if testing.Short() {
+		n = min(50, n)
+	}
+	for i := 1; i < n; i++ {
+		hash, _, err := VerifyCanonical(testMessages[i].Input, nil)
+		r.NoError(err, "verify failed %d", i)
+		a.Equal(testMessages[i].Hash, hash.Ref(), "hash mismatch %d", i)
+
+		// also in the compact form it is send over the wire
+		var compact bytes.Buffer
+		r.NoError(json.Compact(&compact, testMessages[i].Input))
+		_, _, err = VerifyCanonical(compact.Bytes(), nil)
+		r.NoError(err, "verify compact failed %d", i)
+	}
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("canonical"), 8)))
+	r.NoError(err)
+
+	sign := func(content interface{}) []byte {
+		var msg LegacyMessage
+		msg.Hash = "sha256"
+		msg.Author = kp.Id.Ref()
+		msg.Sequence = 1
+		msg.Content = content
+		_, signed, err := msg.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+		return signed
+	}
+
+	requireNonCanonical := func(msg []byte, what string) {
+		_, _, err := VerifyCanonical(msg, nil)
+		r.Error(err, what)
+		_, is := errors.Cause(err).(ssb.ErrNonCanonical)
+		a.True(is, "%s: wrong error %v", what, err)
+		a.True(ssb.IsMessageUnusable(err), what)
+	}
+
+	valid := sign(map[string]interface{}{"type": "test", "text": "hello\n\"world\" ✨"})
+	_, _, err = VerifyCanonical(valid, nil)
+	r.NoError(err)
+
+	// the signature still checks out but the escaping isn't how JSON.stringify would do it
+	escaped := bytes.Replace(valid, []byte(`"hello`), []byte(`"\u0068ello`), 1)
+	_, _, err = Verify(escaped, nil)
+	r.NoError(err, "plain verify should accept it")
+	requireNonCanonical(escaped, "escaped")
+
+	// JSON.parse would only keep the last text
+	dupe := sign(json.RawMessage(`{"type":"test","text":"a","text":"b"}`))
+	requireNonCanonical(dupe, "duplicate key")
+
+	// fields in the wrong order
+	reordered := sign(map[string]interface{}{"type": "test"})
+	var fields map[string]json.RawMessage
+	r.NoError(json.Unmarshal(reordered, &fields))
+	var wrongOrder bytes.Buffer
+	wrongOrder.WriteString("{")
+	for i, k := range []string{"author", "previous", "sequence", "timestamp", "hash", "content", "signature"} {
+		if i > 0 {
+			wrongOrder.WriteString(",")
+		}
+		wrongOrder.WriteString(`"` + k + `":`)
+		wrongOrder.Write(fields[k])
+	}
+	wrongOrder.WriteString("}")
+	err = CheckCanonical(wrongOrder.Bytes(), wrongOrder.Bytes())
+	r.Error(err)
+	a.True(ssb.IsMessageUnusable(err))
+}
+
+func TestMessageLength(t *testing.T) {
+	a, r := assert.New(t), require.New(t)
+
+	a.Equal(3, EncodedLength([]byte("abc")))
+	a.Equal(1, EncodedLength([]byte("ä")), "one code unit, two bytes")
+	a.Equal(2, EncodedLength([]byte("🦀")), "surrogate pair")
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("length"), 8)))
+	r.NoError(err)
+
+	sign := func(text string) []byte {
+		var msg LegacyMessage
+		msg.Hash = "sha256"
+		msg.Author = kp.Id.Ref()
+		msg.Sequence = 1
+		msg.Content = map[string]interface{}{"type": "test", "text": text}
+		_, signed, err := msg.Sign(kp.Pair.Secret[:], nil)
+		r.NoError(err)
+		return signed
+	}
+
+	overhead := EncodedLength(sign(""))
+
+	fits := sign(strings.Repeat("a", MaxMessageLength-overhead))
+	a.Equal(MaxMessageLength, EncodedLength(fits))
+	_, _, err = VerifyCanonical(fits, nil)
+	r.NoError(err)
+
+	// crabs are two code units but four bytes
+	tooLong := sign(strings.Repeat("🦀", (MaxMessageLength-overhead)/2+1))
+	_, _, err = VerifyCanonical(tooLong, nil)
+	r.Error(err)
+	tl, is := errors.Cause(err).(ssb.ErrMessageTooLong)
+	r.True(is, "wrong error %v", err)
+	a.Equal(MaxMessageLength, tl.Max)
+	a.True(tl.Length > MaxMessageLength)
+	a.True(ssb.IsMessageUnusable(err))
+}
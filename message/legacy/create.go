@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: MIT
+
+package legacy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// Creator signs the new messages of one legacy feed
+type Creator struct {
+	key          ssb.KeyPair
+	hmac         *[32]byte
+	setTimestamp bool
+}
+
+var _ ssb.FeedCreator = (*Creator)(nil)
+
+// NewCreator returns a Creator for the feed of kp
+func NewCreator(kp *ssb.KeyPair) *Creator {
+	return &Creator{key: *kp}
+}
+
+// WithHMAC signs the messages for a different network
+func (c *Creator) WithHMAC(key []byte) error {
+	var hmacSec [32]byte
+	if n := copy(hmacSec[:], key); n != 32 {
+		return fmt.Errorf("hmac key of wrong length:%d", n)
+	}
+	c.hmac = &hmacSec
+	return nil
+}
+
+// WithNowTimestamps sets the claimed timestamp of the messages to the current time
+func (c *Creator) WithNowTimestamps(yes bool) {
+	c.setTimestamp = yes
+}
+
+// Create signs content as message seq of the feed. Binary content is boxed, see private.
+func (c *Creator) Create(val interface{}, prev *refs.MessageRef, seq int64) (refs.Message, error) {
+	// prepare persisted message
+	var stored StoredMessage
+	stored.Timestamp_ = time.Now() // "rx"
+	stored.Author_ = c.key.Id
+
+	// set metadata
+	var newMsg LegacyMessage
+	newMsg.Hash = "sha256"
+	newMsg.Author = c.key.Id.Ref()
+	newMsg.Previous = prev
+	newMsg.Sequence = margaret.BaseSeq(seq)
+
+	if bindata, ok := val.([]byte); ok {
+		bindata = bytes.TrimPrefix(bindata, []byte("box1:"))
+		newMsg.Content = base64.StdEncoding.EncodeToString(bindata) + ".box"
+	} else {
+		newMsg.Content = val
+	}
+
+	if c.setTimestamp {
+		newMsg.Timestamp = time.Now().UnixNano() / 1000000
+	}
+
+	mr, signedMessage, err := newMsg.Sign(c.key.Pair.Secret[:], c.hmac)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckLength(signedMessage); err != nil {
+		return nil, errors.Wrapf(err, "publish: content too big for message %d", seq)
+	}
+
+	stored.Previous_ = newMsg.Previous
+	stored.Sequence_ = newMsg.Sequence
+	stored.Key_ = mr
+	stored.Raw_ = signedMessage
+	return &stored, nil
+}
@@ -32,26 +32,42 @@ func ExtractSignature(b []byte) ([]byte, Signature, error) {
 // At last it uses internalV8Binary to create a the SHA256 hash for the message key.
 // If you find a buggy message, use `node ./encode_test.js $feedID` to generate a new testdata.zip
 func Verify(raw []byte, hmacSecret *[32]byte) (*refs.MessageRef, *DeserializedMessage, error) {
-	enc, err := EncodePreserveOrder(raw)
+	ref, dmsg, _, err := verify(raw, hmacSecret)
+	return ref, dmsg, err
+}
+
+// VerifyCanonical is like Verify but also rejects messages that the JS implementation doesn't accept,
+// those that are longer than MaxMessageLength (ssb.ErrMessageTooLong) or not canonically encoded (ssb.ErrNonCanonical, see CheckCanonical).
+func VerifyCanonical(raw []byte, hmacSecret *[32]byte) (*refs.MessageRef, *DeserializedMessage, error) {
+	ref, dmsg, enc, err := verify(raw, hmacSecret)
 	if err != nil {
-		if len(raw) > 15 {
-			raw = raw[:15]
-		}
-		return nil, nil, errors.Wrapf(err, "ssb Verify: could not encode message: %q...", raw)
+		return nil, nil, err
+	}
+	if err := CheckLength(enc); err != nil {
+		return nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d)", dmsg.Author.Ref(), dmsg.Sequence)
 	}
+	if err := CheckCanonical(raw, enc); err != nil {
+		return nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d)", dmsg.Author.Ref(), dmsg.Sequence)
+	}
+	return ref, dmsg, nil
+}
 
-	// destroys it for the network layer but makes it easier to access its values
-	var dmsg DeserializedMessage
-	if err := json.Unmarshal(raw, &dmsg); err != nil {
-		if len(raw) > 15 {
-			raw = raw[:15]
-		}
-		return nil, nil, errors.Wrapf(err, "ssb Verify: could not json.Unmarshal message: %q...", raw)
+// Decode is like Verify without checking the signature, for messages that were verified before they were stored
+func Decode(raw []byte) (*refs.MessageRef, *DeserializedMessage, error) {
+	ref, dmsg, _, err := decode(raw)
+	return ref, dmsg, err
+}
+
+// verify also returns the encoding of raw that was used to check the signature
+func verify(raw []byte, hmacSecret *[32]byte) (*refs.MessageRef, *DeserializedMessage, []byte, error) {
+	mr, dmsg, enc, err := decode(raw)
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	woSig, sig, err := ExtractSignature(enc)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d): could not extract signature", dmsg.Author.Ref(), dmsg.Sequence)
+		return nil, nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d): could not extract signature", dmsg.Author.Ref(), dmsg.Sequence)
 	}
 
 	if hmacSecret != nil {
@@ -60,13 +76,34 @@ func Verify(raw []byte, hmacSecret *[32]byte) (*refs.MessageRef, *DeserializedMe
 	}
 
 	if err := sig.Verify(woSig, &dmsg.Author); err != nil {
-		return nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d): could not verify message", dmsg.Author.Ref(), dmsg.Sequence)
+		return nil, nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d): could not verify message", dmsg.Author.Ref(), dmsg.Sequence)
+	}
+	return mr, dmsg, enc, nil
+}
+
+// decode pretty prints raw, deserializes it and computes its key
+func decode(raw []byte) (*refs.MessageRef, *DeserializedMessage, []byte, error) {
+	enc, err := EncodePreserveOrder(raw)
+	if err != nil {
+		if len(raw) > 15 {
+			raw = raw[:15]
+		}
+		return nil, nil, nil, errors.Wrapf(err, "ssb Verify: could not encode message: %q...", raw)
+	}
+
+	// destroys it for the network layer but makes it easier to access its values
+	var dmsg DeserializedMessage
+	if err := json.Unmarshal(raw, &dmsg); err != nil {
+		if len(raw) > 15 {
+			raw = raw[:15]
+		}
+		return nil, nil, nil, errors.Wrapf(err, "ssb Verify: could not json.Unmarshal message: %q...", raw)
 	}
 
 	// hash the message - it's sadly the internal string rep of v8 that get's hashed, not the json string
 	v8warp, err := InternalV8Binary(enc)
 	if err != nil {
-		return nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d): could hash convert message", dmsg.Author.Ref(), dmsg.Sequence)
+		return nil, nil, nil, errors.Wrapf(err, "ssb Verify(%s:%d): could hash convert message", dmsg.Author.Ref(), dmsg.Sequence)
 	}
 	h := sha256.New()
 	io.Copy(h, bytes.NewReader(v8warp))
@@ -75,5 +112,5 @@ func Verify(raw []byte, hmacSecret *[32]byte) (*refs.MessageRef, *DeserializedMe
 		Hash: h.Sum(nil),
 		Algo: refs.RefAlgoMessageSSB1,
 	}
-	return &mr, &dmsg, nil
+	return &mr, &dmsg, enc, nil
 }
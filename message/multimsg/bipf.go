@@ -0,0 +1,233 @@
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/bipf"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+// storedLegacyBIPF tags legacy messages that were stored with MarshalBIPF.
+// In memory they are still of type Legacy.
+const storedLegacyBIPF byte = 0x04
+
+// how the signed JSON of a legacy message can be restored from its bipf value
+const (
+	bipfRawCompact = iota // as JSON.stringify without indentation
+	bipfRawPretty         // the v8 like encoding used for signing
+)
+
+// MarshalBIPF is like MarshalBinary but stores legacy messages as bipf,
+// so that indexes can read their fields with SeekContent instead of parsing the JSON again.
+// The other formats are already binary and are stored like MarshalBinary does.
+func (mm MultiMessage) MarshalBIPF() ([]byte, error) {
+	if mm.tipe != Legacy {
+		return mm.MarshalBinary()
+	}
+	sm, ok := mm.AsLegacy()
+	if !ok {
+		return nil, errors.Errorf("multiMessage: not a legacy message: %T", mm.Message)
+	}
+
+	rec := map[string]interface{}{
+		"sequence": sm.Sequence_.Seq(),
+		"received": sm.Timestamp_.UnixNano() / int64(time.Millisecond),
+	}
+	if sm.Key_ != nil {
+		rec["key"] = sm.Key_.Ref()
+	}
+	if sm.Author_ != nil {
+		rec["author"] = sm.Author_.Ref()
+	}
+	if sm.Previous_ != nil {
+		rec["previous"] = sm.Previous_.Ref()
+	}
+
+	// only keep the bipf form if the signed JSON can be restored from it exactly,
+	// otherwise (odd float encodings or unicode escapes for instance) keep the raw bytes
+	if value, format, ok := legacyValueBIPF(sm.Raw_); ok {
+		rec["value"] = bipf.Raw(value)
+		rec["json"] = format
+	} else {
+		rec["raw"] = sm.Raw_
+	}
+
+	enc, err := bipf.Encode(rec)
+	if err != nil {
+		return nil, errors.Wrap(err, "multiMessage: bipf encoding failed")
+	}
+	return append([]byte{storedLegacyBIPF}, enc...), nil
+}
+
+func legacyValueBIPF(raw []byte) ([]byte, int, bool) {
+	value, err := bipf.FromJSON(raw)
+	if err != nil {
+		return nil, 0, false
+	}
+	for _, format := range []int{bipfRawCompact, bipfRawPretty} {
+		restored, err := restoreLegacyJSON(value, format)
+		if err == nil && bytes.Equal(restored, raw) {
+			return value, format, true
+		}
+	}
+	return nil, 0, false
+}
+
+func restoreLegacyJSON(value []byte, format int) ([]byte, error) {
+	compact, err := bipf.ToJSON(value)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case bipfRawCompact:
+		return compact, nil
+	case bipfRawPretty:
+		return legacy.EncodePreserveOrder(compact)
+	}
+	return nil, errors.Errorf("multiMessage: unknown json format %d", format)
+}
+
+func (mm *MultiMessage) unmarshalBIPF(data []byte) error {
+	var sm legacy.StoredMessage
+
+	if key, err := bipf.SeekString(data, "key"); err == nil {
+		if sm.Key_, err = refs.ParseMessageRef(key); err != nil {
+			return errors.Wrap(err, "multiMessage: invalid key")
+		}
+	}
+	if author, err := bipf.SeekString(data, "author"); err == nil {
+		if sm.Author_, err = ssb.ParseFeedRef(author); err != nil {
+			return errors.Wrap(err, "multiMessage: invalid author")
+		}
+	}
+	if prev, err := bipf.SeekString(data, "previous"); err == nil {
+		if sm.Previous_, err = refs.ParseMessageRef(prev); err != nil {
+			return errors.Wrap(err, "multiMessage: invalid previous")
+		}
+	}
+
+	seq, err := seekInt(data, "sequence")
+	if err != nil {
+		return errors.Wrap(err, "multiMessage: invalid sequence")
+	}
+	sm.Sequence_ = margaret.BaseSeq(seq)
+
+	received, err := seekInt(data, "received")
+	if err != nil {
+		return errors.Wrap(err, "multiMessage: invalid received time")
+	}
+	sm.Timestamp_ = time.Unix(0, received*int64(time.Millisecond))
+
+	if value, err := bipf.Seek(data, "value"); err == nil {
+		format, err := seekInt(data, "json")
+		if err != nil {
+			return errors.Wrap(err, "multiMessage: invalid json format")
+		}
+		mm.value = append([]byte(nil), value...)
+		sm.Raw_, err = restoreLegacyJSON(mm.value, int(format))
+		if err != nil {
+			return errors.Wrap(err, "multiMessage: failed to restore message JSON")
+		}
+	} else {
+		raw, err := bipf.Seek(data, "raw")
+		if err != nil {
+			return errors.Wrap(err, "multiMessage: neither value nor raw message")
+		}
+		v, err := bipf.Decode(raw)
+		if err != nil {
+			return err
+		}
+		rawBytes, ok := v.([]byte)
+		if !ok {
+			return errors.Errorf("multiMessage: raw message is not a buffer but %T", v)
+		}
+		sm.Raw_ = rawBytes
+	}
+
+	mm.tipe = Legacy
+	mm.received = sm.Timestamp_
+	mm.Message = &sm
+	mm.key = sm.Key_
+	return nil
+}
+
+func seekInt(data []byte, key string) (int64, error) {
+	v, err := bipf.Seek(data, key)
+	if err != nil {
+		return 0, err
+	}
+	dec, err := bipf.Decode(v)
+	if err != nil {
+		return 0, err
+	}
+	switch tv := dec.(type) {
+	case int64:
+		return tv, nil
+	case float64:
+		return int64(tv), nil
+	}
+	return 0, errors.Errorf("multiMessage: %s is not a number but %T", key, dec)
+}
+
+// SeekContent returns the bipf encoded field at path inside the content of msg.
+// Legacy messages that were stored as bipf are read in place, for all the others the JSON content is decoded along the path and only the field is converted.
+// It returns bipf.ErrNotFound if the content doesn't have the field, for instance because it's encrypted.
+func SeekContent(msg refs.Message, path ...string) ([]byte, error) {
+	if mm, ok := msg.(*MultiMessage); ok && mm.value != nil {
+		return bipf.Seek(mm.value, append([]string{"content"}, path...)...)
+	}
+	v, err := seekJSON(msg.ContentBytes(), path)
+	if err != nil {
+		return nil, err
+	}
+	return bipf.FromJSON(v)
+}
+
+// SeekContentString is like SeekContent for string fields, like content.type
+func SeekContentString(msg refs.Message, path ...string) (string, error) {
+	if mm, ok := msg.(*MultiMessage); ok && mm.value != nil {
+		return bipf.SeekString(mm.value, append([]string{"content"}, path...)...)
+	}
+	v, err := seekJSON(msg.ContentBytes(), path)
+	if err != nil {
+		return "", err
+	}
+	var str string
+	if err := json.Unmarshal(v, &str); err != nil {
+		return "", errors.Wrap(err, "multiMessage: content field is not a string")
+	}
+	return str, nil
+}
+
+// seekJSON returns the JSON value at path in content, only the objects on the way are decoded (without their values).
+func seekJSON(content []byte, path []string) (json.RawMessage, error) {
+	if len(content) == 0 {
+		return nil, bipf.ErrNotFound
+	}
+	v := json.RawMessage(content)
+	for _, key := range path {
+		// encrypted content is a string, which doesn't have fields either
+		if b := bytes.TrimLeft(v, " \t\r\n"); len(b) == 0 || b[0] != '{' {
+			return nil, bipf.ErrNotFound
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(v, &obj); err != nil {
+			return nil, errors.Wrap(err, "multiMessage: invalid content")
+		}
+		field, has := obj[key]
+		if !has {
+			return nil, bipf.ErrNotFound
+		}
+		v = field
+	}
+	return v, nil
+}
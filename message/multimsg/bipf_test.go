@@ -0,0 +1,274 @@
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/offset2"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/bipf"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+func makeLegacyMessages(t testing.TB, n int) []*legacy.StoredMessage {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("bipf"), 8)))
+	r.NoError(err)
+
+	var (
+		prev *refs.MessageRef
+		msgs []*legacy.StoredMessage
+	)
+	for i := 1; i <= n; i++ {
+		var lm legacy.LegacyMessage
+		lm.Hash = "sha256"
+		lm.Author = kp.Id.Ref()
+		lm.Previous = prev
+		lm.Sequence = margaret.BaseSeq(i)
+		lm.Timestamp = 1604000000000 + int64(i)
+		lm.Content = map[string]interface{}{
+			"type": "post",
+			"text": fmt.Sprintf("hello, world! #%d", i),
+			"root": "%Ugs4PX3eYgyzd3ttPQDgsT3+fKtmaoTEgZYvHvsMSjA=.sha256",
+			"mentions": []interface{}{
+				map[string]interface{}{"link": kp.Id.Ref(), "name": "me"},
+			},
+		}
+
+		key, raw, err := lm.Sign(kp.Pair.Secret, nil)
+		r.NoError(err)
+
+		msgs = append(msgs, &legacy.StoredMessage{
+			Author_:    kp.Id,
+			Previous_:  prev,
+			Key_:       key,
+			Sequence_:  lm.Sequence,
+			Timestamp_: time.Unix(1605000000, int64(i)*int64(time.Millisecond)),
+			Raw_:       raw,
+		})
+		prev = key
+	}
+	return msgs
+}
+
+func TestMultiMsgBIPF(t *testing.T) {
+	r := require.New(t)
+
+	msgs := makeLegacyMessages(t, 3)
+
+	// compact JSON, as it comes from JS peers
+	var compact bytes.Buffer
+	r.NoError(json.Compact(&compact, msgs[1].Raw_))
+	msgs[1].Raw_ = compact.Bytes()
+
+	// a number that can't be restored exactly is kept as is
+	msgs[2].Raw_ = bytes.Replace(msgs[2].Raw_, []byte(`"timestamp": 1604000000003`), []byte(`"timestamp": 1604000000003.0`), 1)
+
+	for i, sm := range msgs {
+		mm := NewMultiMessageFromLegacy(sm)
+
+		b, err := mm.MarshalBIPF()
+		r.NoError(err, "msg %d", i)
+		r.Equal(storedLegacyBIPF, b[0])
+
+		var mm2 MultiMessage
+		r.NoError(mm2.UnmarshalBinary(b), "msg %d", i)
+		r.Equal(Legacy, mm2.tipe)
+		r.Equal(i != 2, mm2.value != nil, "msg %d", i)
+
+		got, ok := mm2.AsLegacy()
+		r.True(ok)
+		r.Equal(string(sm.Raw_), string(got.Raw_), "msg %d", i)
+		r.True(sm.Key_.Equal(*got.Key_))
+		r.True(sm.Author_.Equal(got.Author_))
+		if sm.Previous_ == nil {
+			r.Nil(got.Previous_)
+		} else {
+			r.True(sm.Previous_.Equal(*got.Previous_))
+		}
+		r.Equal(sm.Sequence_, got.Sequence_)
+		r.True(sm.Timestamp_.Equal(mm2.Received()))
+
+		typ, err := SeekContentString(&mm2, "type")
+		r.NoError(err)
+		r.Equal("post", typ)
+
+		// the same without the bipf value
+		typ, err = SeekContentString(mm, "type")
+		r.NoError(err)
+		r.Equal("post", typ)
+
+		_, err = SeekContent(&mm2, "nope")
+		r.Equal(bipf.ErrNotFound, err)
+		_, err = SeekContent(mm, "nope")
+		r.Equal(bipf.ErrNotFound, err)
+		_, err = SeekContent(mm, "type", "nope")
+		r.Equal(bipf.ErrNotFound, err)
+
+		mentions, err := SeekContent(&mm2, "mentions")
+		r.NoError(err)
+		mentionsJSON, err := SeekContent(mm, "mentions")
+		r.NoError(err)
+		r.Equal(mentions, mentionsJSON)
+	}
+}
+
+func TestBIPFCodecLog(t *testing.T) {
+	r := require.New(t)
+
+	tPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tPath)
+
+	msgs := makeLegacyMessages(t, 4)
+
+	// start with the old codec
+	log, err := offset2.Open(tPath, MargaretCodec{})
+	r.NoError(err)
+	wl := NewWrappedLog(log)
+	for _, sm := range msgs[:2] {
+		_, err = wl.Append(sm)
+		r.NoError(err)
+	}
+	r.NoError(log.Close())
+
+	// continue with bipf
+	log, err = offset2.Open(tPath, BIPFCodec{})
+	r.NoError(err)
+	wl = NewWrappedLog(log)
+	for _, sm := range msgs[2:] {
+		_, err = wl.Append(sm)
+		r.NoError(err)
+	}
+
+	for i, sm := range msgs {
+		v, err := wl.Get(margaret.BaseSeq(i))
+		r.NoError(err)
+		mm, ok := v.(*MultiMessage)
+		r.True(ok, "wrong type: %T", v)
+		r.Equal(i >= 2, mm.value != nil, "msg %d", i)
+		r.Equal(sm.Key_.Ref(), mm.Key().Ref())
+		r.Equal(string(sm.Raw_), string(mm.ValueContentJSON()))
+	}
+	r.NoError(log.Close())
+}
+
+func BenchmarkCodec(b *testing.B) {
+	msgs := makeLegacyMessages(b, 100)
+
+	codecs := []struct {
+		name string
+		c    margaret.Codec
+	}{
+		{"margaret", MargaretCodec{}},
+		{"bipf", BIPFCodec{}},
+	}
+
+	for _, tc := range codecs {
+		encoded := make([][]byte, len(msgs))
+		for i, sm := range msgs {
+			var err error
+			encoded[i], err = tc.c.Marshal(*NewMultiMessageFromLegacy(sm))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.Run(tc.name+"/marshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := tc.c.Marshal(*NewMultiMessageFromLegacy(msgs[i%len(msgs)])); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		b.Run(tc.name+"/unmarshal", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := tc.c.Unmarshal(encoded[i%len(encoded)]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		// what the bytype index does for every message
+		b.Run(tc.name+"/contentType", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				v, err := tc.c.Unmarshal(encoded[i%len(encoded)])
+				if err != nil {
+					b.Fatal(err)
+				}
+				typ, err := SeekContentString(v.(refs.Message), "type")
+				if err != nil || typ != "post" {
+					b.Fatal("wrong type", typ, err)
+				}
+			}
+		})
+
+		// what the bytype and tangles indexes extract together
+		b.Run(tc.name+"/indexes", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				v, err := tc.c.Unmarshal(encoded[i%len(encoded)])
+				if err != nil {
+					b.Fatal(err)
+				}
+				msg := v.(refs.Message)
+				typ, err := SeekContentString(msg, "type")
+				if err != nil || typ != "post" {
+					b.Fatal("wrong type", typ, err)
+				}
+				root, err := SeekContentString(msg, "root")
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := refs.ParseMessageRef(root); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+
+	// the previous approach of the indexes, for comparison
+	b.Run("json/contentType", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var typed struct {
+				Content struct {
+					Type string
+				}
+			}
+			if err := json.Unmarshal(msgs[i%len(msgs)].Raw_, &typed); err != nil || typed.Content.Type != "post" {
+				b.Fatal("wrong type", typed.Content.Type, err)
+			}
+		}
+	})
+
+	b.Run("json/indexes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var typed struct {
+				Content struct {
+					Type string
+					Root *refs.MessageRef
+				}
+			}
+			if err := json.Unmarshal(msgs[i%len(msgs)].Raw_, &typed); err != nil || typed.Content.Type != "post" || typed.Content.Root == nil {
+				b.Fatal("wrong content", typed.Content.Type, err)
+			}
+		}
+	})
+}
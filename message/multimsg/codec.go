@@ -11,6 +11,8 @@ import (
 	"go.cryptoscope.co/margaret"
 )
 
+// MargaretCodec stores messages with MultiMessage.MarshalBinary.
+// It reads messages that were stored by BIPFCodec as well.
 type MargaretCodec struct{}
 
 func (c MargaretCodec) NewEncoder(w io.Writer) margaret.Encoder { return encoder{w: w} }
@@ -30,14 +32,25 @@ func (c MargaretCodec) Unmarshal(data []byte) (interface{}, error) {
 	return &mm, err
 }
 
-type encoder struct{ w io.Writer }
+type encoder struct {
+	w    io.Writer
+	bipf bool
+}
 
 func (enc encoder) Encode(v interface{}) error {
 	mm, ok := v.(MultiMessage)
 	if !ok {
 		return errors.Errorf("mmCodec: wrong type: %T", v)
 	}
-	bin, err := mm.MarshalBinary()
+	var (
+		bin []byte
+		err error
+	)
+	if enc.bipf {
+		bin, err = mm.MarshalBIPF()
+	} else {
+		bin, err = mm.MarshalBinary()
+	}
 	if err != nil {
 		return err
 	}
@@ -58,3 +71,22 @@ func (dec decoder) Decode() (interface{}, error) {
 	}
 	return &mm, nil
 }
+
+// BIPFCodec stores legacy messages with MultiMessage.MarshalBIPF and all others like MargaretCodec.
+// Logs can be read with both codecs, so switching the codec of an existing log only changes how new messages are stored.
+type BIPFCodec struct{}
+
+func (c BIPFCodec) NewEncoder(w io.Writer) margaret.Encoder { return encoder{w: w, bipf: true} }
+func (c BIPFCodec) NewDecoder(r io.Reader) margaret.Decoder { return decoder{r: r} }
+
+func (c BIPFCodec) Marshal(v interface{}) ([]byte, error) {
+	mm, ok := v.(MultiMessage)
+	if !ok {
+		return nil, errors.Errorf("mmCodec: wrong type: %T", v)
+	}
+	return mm.MarshalBIPF()
+}
+
+func (c BIPFCodec) Unmarshal(data []byte) (interface{}, error) {
+	return MargaretCodec{}.Unmarshal(data)
+}
@@ -0,0 +1,326 @@
+// SPDX-License-Identifier: MIT
+
+package multimsg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ugorji/go/codec"
+	gabbygrove "go.mindeco.de/ssb-gabbygrove"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/bendybutt"
+	"go.cryptoscope.co/ssb/message/legacy"
+)
+
+// the builtin formats are registered here since their storage predates the registry,
+// everything else (verifying, publishing, createHistoryStream) goes through ssb.FeedFormat like for the others
+func init() {
+	for _, ff := range []ssb.FeedFormat{legacyFormat{}, gabbyFormat{}, bendyButtFormat{}} {
+		if err := ssb.RegisterFeedFormat(ff); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// storageCodec is implemented by the builtin formats, which keep the storage encoding they had before the registry.
+// The registered formats are stored in their transfer encoding.
+type storageCodec interface {
+	marshalStored(msg refs.Message, received time.Time) ([]byte, error)
+	unmarshalStored(data []byte) (refs.Message, time.Time, error)
+}
+
+func cborEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	var mh codec.CborHandle
+	mh.StructToArray = true
+	err := codec.NewEncoder(&buf, &mh).Encode(v)
+	return buf.Bytes(), err
+}
+
+func cborDecode(data []byte, v interface{}) error {
+	var mh codec.CborHandle
+	mh.StructToArray = true
+	return codec.NewDecoderBytes(data, &mh).Decode(v)
+}
+
+type legacyFormat struct{}
+
+var (
+	_ ssb.FeedFormat      = legacyFormat{}
+	_ ssb.SignatureFormat = legacyFormat{}
+	_ storageCodec        = legacyFormat{}
+)
+
+func (legacyFormat) Algo() string      { return refs.RefAlgoFeedSSB1 }
+func (legacyFormat) StorageType() byte { return byte(Legacy) }
+func (legacyFormat) Binary() bool      { return false }
+
+func (legacyFormat) Encode(msg refs.Message) ([]byte, error) {
+	sm, ok := msg.(*legacy.StoredMessage)
+	if !ok {
+		return nil, errors.Errorf("legacy: expected %T - got %T", sm, msg)
+	}
+	return sm.Raw_, nil
+}
+
+func (legacyFormat) Decode(data []byte) (refs.Message, error) {
+	ref, dmsg, err := legacy.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return storedLegacy(ref, dmsg, data), nil
+}
+
+// Verify rejects what other implementations wouldn't accept, instead of storing and passing it on
+func (legacyFormat) Verify(data []byte, hmacKey *[32]byte) (refs.Message, error) {
+	ref, dmsg, err := legacy.VerifyCanonical(data, hmacKey)
+	if err != nil {
+		return nil, err
+	}
+	return storedLegacy(ref, dmsg, data), nil
+}
+
+func storedLegacy(ref *refs.MessageRef, dmsg *legacy.DeserializedMessage, raw []byte) *legacy.StoredMessage {
+	return &legacy.StoredMessage{
+		Author_:    &dmsg.Author,
+		Previous_:  dmsg.Previous,
+		Key_:       ref,
+		Sequence_:  dmsg.Sequence,
+		Timestamp_: time.Now(),
+		Raw_:       raw,
+	}
+}
+
+// Signature is the signature field of the message JSON
+func (legacyFormat) Signature(msg refs.Message) (string, error) {
+	sm, ok := msg.(*legacy.StoredMessage)
+	if !ok {
+		return "", errors.Errorf("legacy: expected %T - got %T", sm, msg)
+	}
+	sig := sm.ValueContent().Signature
+	if sig == "" {
+		return "", errors.Errorf("legacy: message %s has no signature", sm.Key().Ref())
+	}
+	return sig, nil
+}
+
+func (legacyFormat) NewCreator(kp *ssb.KeyPair) (ssb.FeedCreator, error) {
+	return legacy.NewCreator(kp), nil
+}
+
+// legacy messages keep the received time in the stored message itself
+func (legacyFormat) marshalStored(msg refs.Message, received time.Time) ([]byte, error) {
+	sm, ok := msg.(*legacy.StoredMessage)
+	if !ok {
+		return nil, errors.Errorf("multiMessage: not a legacy message: %T", msg)
+	}
+	stored := *sm
+	if !received.IsZero() {
+		stored.Timestamp_ = received
+	}
+	return cborEncode(stored)
+}
+
+func (legacyFormat) unmarshalStored(data []byte) (refs.Message, time.Time, error) {
+	var msg legacy.StoredMessage
+	if err := cborDecode(data, &msg); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "multiMessage: legacy decoding failed")
+	}
+	return &msg, msg.Timestamp_, nil
+}
+
+type gabbyFormat struct{}
+
+var (
+	_ ssb.FeedFormat      = gabbyFormat{}
+	_ ssb.SignatureFormat = gabbyFormat{}
+	_ storageCodec        = gabbyFormat{}
+)
+
+func (gabbyFormat) Algo() string      { return refs.RefAlgoFeedGabby }
+func (gabbyFormat) StorageType() byte { return byte(Gabby) }
+func (gabbyFormat) Binary() bool      { return true }
+
+func (gabbyFormat) Encode(msg refs.Message) ([]byte, error) {
+	tr, ok := msg.(*gabbygrove.Transfer)
+	if !ok {
+		return nil, errors.Errorf("gabby: expected %T - got %T", tr, msg)
+	}
+	return tr.MarshalCBOR()
+}
+
+func (gabbyFormat) Decode(data []byte) (refs.Message, error) {
+	var tr gabbygrove.Transfer
+	if err := tr.UnmarshalCBOR(data); err != nil {
+		return nil, errors.Wrap(err, "gabby: transfer unmarshal failed")
+	}
+	return &tr, nil
+}
+
+func (gf gabbyFormat) Verify(data []byte, hmacKey *[32]byte) (msg refs.Message, err error) {
+	decoded, err := gf.Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	tr := decoded.(*gabbygrove.Transfer)
+
+	defer func() {
+		if r := recover(); r != nil {
+			if panicErr, ok := r.(error); ok {
+				err = errors.Wrap(panicErr, "gabby: recovered from panic")
+			} else {
+				panic(r)
+			}
+		}
+	}()
+	if !tr.Verify(hmacKey) {
+		return nil, errors.Errorf("gabby: transfer verify failed")
+	}
+	return tr, nil
+}
+
+func (gabbyFormat) Signature(msg refs.Message) (string, error) {
+	tr, ok := msg.(*gabbygrove.Transfer)
+	if !ok {
+		return "", errors.Errorf("gabby: expected %T - got %T", tr, msg)
+	}
+	return base64.StdEncoding.EncodeToString(tr.Signature) + ".sig.ed25519", nil
+}
+
+func (gabbyFormat) NewCreator(kp *ssb.KeyPair) (ssb.FeedCreator, error) {
+	return gabbyCreator{enc: gabbygrove.NewEncoder(kp.Pair.Secret)}, nil
+}
+
+func (gabbyFormat) marshalStored(msg refs.Message, received time.Time) ([]byte, error) {
+	tr, ok := msg.(*gabbygrove.Transfer)
+	if !ok {
+		return nil, errors.Errorf("multiMessage: wrong type of message: %T", msg)
+	}
+	return cborEncode(ggWithMetadata{Transfer: *tr, ReceivedTime: received})
+}
+
+func (gabbyFormat) unmarshalStored(data []byte) (refs.Message, time.Time, error) {
+	var meta ggWithMetadata
+	if err := cborDecode(data, &meta); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "multiMessage: gabby decoding failed")
+	}
+	return &meta.Transfer, meta.ReceivedTime, nil
+}
+
+type gabbyCreator struct {
+	enc *gabbygrove.Encoder
+}
+
+func (gc gabbyCreator) Create(val interface{}, prev *refs.MessageRef, seq int64) (refs.Message, error) {
+	var br *gabbygrove.BinaryRef
+	if prev != nil {
+		var err error
+		br, err = gabbygrove.NewBinaryRef(prev)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tr, _, err := gc.enc.Encode(uint64(seq), br, val)
+	if err != nil {
+		return nil, errors.Wrap(err, "gabby: failed to encode content")
+	}
+	return tr, nil
+}
+
+func (gc gabbyCreator) WithHMAC(key []byte) error {
+	gc.enc.WithHMAC(key)
+	return nil
+}
+
+func (gc gabbyCreator) WithNowTimestamps(yes bool) { gc.enc.WithNowTimestamps(yes) }
+
+type bendyButtFormat struct{}
+
+var (
+	_ ssb.FeedFormat      = bendyButtFormat{}
+	_ ssb.SignatureFormat = bendyButtFormat{}
+	_ storageCodec        = bendyButtFormat{}
+)
+
+func (bendyButtFormat) Algo() string      { return ssb.RefAlgoFeedBendyButt }
+func (bendyButtFormat) StorageType() byte { return byte(BendyButt) }
+func (bendyButtFormat) Binary() bool      { return true }
+
+func (bendyButtFormat) Encode(msg refs.Message) ([]byte, error) {
+	bb, ok := msg.(*bendybutt.Message)
+	if !ok {
+		return nil, errors.Errorf("bendybutt: expected %T - got %T", bb, msg)
+	}
+	return bb.Raw(), nil
+}
+
+func (bendyButtFormat) Decode(data []byte) (refs.Message, error) {
+	var msg bendybutt.Message
+	if err := msg.UnmarshalBinary(data); err != nil {
+		return nil, errors.Wrap(err, "bendybutt: message decoding failed")
+	}
+	return &msg, nil
+}
+
+func (bendyButtFormat) Verify(data []byte, hmacKey *[32]byte) (refs.Message, error) {
+	msg, err := bendybutt.Verify(data, hmacKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "bendybutt: message verify failed")
+	}
+	return msg, nil
+}
+
+func (bendyButtFormat) Signature(msg refs.Message) (string, error) {
+	bb, ok := msg.(*bendybutt.Message)
+	if !ok {
+		return "", errors.Errorf("bendybutt: expected %T - got %T", bb, msg)
+	}
+	return base64.StdEncoding.EncodeToString(bb.Signature()) + ".sig.ed25519", nil
+}
+
+func (bendyButtFormat) NewCreator(kp *ssb.KeyPair) (ssb.FeedCreator, error) {
+	return bendyButtCreator{bendybutt.NewEncoder(kp.Pair.Secret)}, nil
+}
+
+func (bendyButtFormat) marshalStored(msg refs.Message, received time.Time) ([]byte, error) {
+	bb, ok := msg.(*bendybutt.Message)
+	if !ok {
+		return nil, errors.Errorf("multiMessage: wrong type of message: %T", msg)
+	}
+	return cborEncode(bbWithMetadata{Raw: bb.Raw(), ReceivedTime: received})
+}
+
+func (bendyButtFormat) unmarshalStored(data []byte) (refs.Message, time.Time, error) {
+	var meta bbWithMetadata
+	if err := cborDecode(data, &meta); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "multiMessage: bendybutt decoding failed")
+	}
+	var msg bendybutt.Message
+	if err := msg.UnmarshalBinary(meta.Raw); err != nil {
+		return nil, time.Time{}, errors.Wrap(err, "multiMessage: bendybutt message decoding failed")
+	}
+	msg.SetReceived(meta.ReceivedTime)
+	return &msg, meta.ReceivedTime, nil
+}
+
+// bendyButtCreator only takes content that was signed by the subfeed, see bendybutt.SignContent
+type bendyButtCreator struct {
+	*bendybutt.Encoder
+}
+
+func (bc bendyButtCreator) Create(val interface{}, prev *refs.MessageRef, seq int64) (refs.Message, error) {
+	content, ok := val.(*bendybutt.SignedContent)
+	if !ok {
+		return nil, errors.Errorf("bendybutt: content needs to be signed by the subfeed first (got %T)", val)
+	}
+	msg, err := bc.Encode(seq, prev, content)
+	if err != nil {
+		return nil, errors.Wrap(err, "bendybutt: failed to encode content")
+	}
+	return msg, nil
+}
@@ -3,14 +3,14 @@
 package multimsg
 
 import (
-	"bytes"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/ugorji/go/codec"
 	gabbygrove "go.mindeco.de/ssb-gabbygrove"
 	refs "go.mindeco.de/ssb-refs"
 
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/bendybutt"
 	"go.cryptoscope.co/ssb/message/legacy"
 )
 
@@ -20,10 +20,11 @@ const (
 	Unknown MessageType = iota
 	Legacy
 	Gabby
+	BendyButt
 )
 
 // MultiMessage attempts to support multiple message formats in the same storage layer
-// currently supports Proto and legacy
+// currently supports legacy, gabbygrove and bendy butt
 type MultiMessage struct {
 	refs.Message
 	tipe MessageType
@@ -31,6 +32,9 @@ type MultiMessage struct {
 
 	// metadata
 	received time.Time
+
+	// the bipf encoded legacy message, if it was stored with MarshalBIPF
+	value []byte
 }
 
 type ggWithMetadata struct {
@@ -38,66 +42,84 @@ type ggWithMetadata struct {
 	ReceivedTime time.Time
 }
 
+type bbWithMetadata struct {
+	Raw          []byte
+	ReceivedTime time.Time
+}
+
+// registered formats are stored in their transfer encoding
+type registeredWithMetadata struct {
+	Data         []byte
+	ReceivedTime time.Time
+}
+
 func (mm MultiMessage) MarshalBinary() ([]byte, error) {
-	var buf bytes.Buffer
-	var mh codec.CborHandle
-	mh.StructToArray = true
-	enc := codec.NewEncoder(&buf, &mh)
-	buf.WriteByte(byte(mm.tipe))
-	var err error
-	switch mm.tipe {
-	case Legacy:
-		legacy, ok := mm.AsLegacy()
-		if !ok {
-			return nil, errors.Errorf("multiMessage: not a legacy message: %T", mm.Message)
-		}
-		err = enc.Encode(legacy)
-	case Gabby:
-		var meta ggWithMetadata
-		meta.ReceivedTime = mm.Received()
-		gabby, ok := mm.AsGabby()
-		if !ok {
-			return nil, errors.Errorf("multiMessage: wrong type of message: %T", mm.Message)
-		}
-		meta.Transfer = *gabby
-		err = enc.Encode(meta)
-	default:
+	ff, ok := ssb.GetFeedFormatByStorageType(byte(mm.tipe))
+	if !ok {
 		return nil, errors.Errorf("multiMessage: unsupported message type: %x", mm.tipe)
 	}
-	return buf.Bytes(), errors.Wrapf(err, "multiMessage(%v): data encoding failed", mm.tipe)
+
+	var (
+		data []byte
+		err  error
+	)
+	if sc, ok := ff.(storageCodec); ok {
+		data, err = sc.marshalStored(mm.Message, mm.Received())
+	} else {
+		var encoded []byte
+		encoded, err = ff.Encode(mm.Message)
+		if err != nil {
+			return nil, errors.Wrapf(err, "multiMessage(%s): encoding failed", ff.Algo())
+		}
+		data, err = cborEncode(registeredWithMetadata{
+			Data:         encoded,
+			ReceivedTime: mm.Received(),
+		})
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "multiMessage(%v): data encoding failed", mm.tipe)
+	}
+	return append([]byte{byte(mm.tipe)}, data...), nil
 }
 
 func (mm *MultiMessage) UnmarshalBinary(data []byte) error {
 	if len(data) < 1 {
 		return errors.Errorf("multiMessage: data to short")
 	}
-	var mh codec.CborHandle
-	mh.StructToArray = true
-	dec := codec.NewDecoderBytes(data[1:], &mh)
+
+	if data[0] == storedLegacyBIPF {
+		return mm.unmarshalBIPF(data[1:])
+	}
 
 	mm.tipe = MessageType(data[0])
-	switch mm.tipe {
-	case Legacy:
-		var msg legacy.StoredMessage
-		err := dec.Decode(&msg)
-		if err != nil {
-			return errors.Wrap(err, "multiMessage: legacy decoding failed")
-		}
-		mm.received = msg.Timestamp_
-		mm.Message = &msg
-		mm.key = msg.Key_
-	case Gabby:
-		var msg ggWithMetadata
-		err := dec.Decode(&msg)
+	ff, ok := ssb.GetFeedFormatByStorageType(data[0])
+	if !ok {
+		return errors.Errorf("multiMessage: unsupported message type: %x", mm.tipe)
+	}
+
+	if sc, ok := ff.(storageCodec); ok {
+		msg, received, err := sc.unmarshalStored(data[1:])
 		if err != nil {
-			return errors.Wrap(err, "multiMessage: gabby decoding failed")
+			return err
 		}
-		mm.received = msg.ReceivedTime
-		mm.Message = &msg.Transfer
+		mm.received = received
+		mm.Message = msg
 		mm.key = msg.Key()
-	default:
-		return errors.Errorf("multiMessage: unsupported message type: %x", mm.tipe)
+		return nil
 	}
+
+	var meta registeredWithMetadata
+	err := cborDecode(data[1:], &meta)
+	if err != nil {
+		return errors.Wrapf(err, "multiMessage: %s decoding failed", ff.Algo())
+	}
+	msg, err := ff.Decode(meta.Data)
+	if err != nil {
+		return errors.Wrapf(err, "multiMessage: %s message decoding failed", ff.Algo())
+	}
+	mm.received = meta.ReceivedTime
+	mm.Message = msg
+	mm.key = msg.Key()
 	return nil
 }
 
@@ -105,6 +127,9 @@ func (mm MultiMessage) Received() time.Time {
 	return mm.received
 }
 
+// Unwrap returns the message of the feed format, see ssb.MessageSignature
+func (mm MultiMessage) Unwrap() refs.Message { return mm.Message }
+
 func (mm MultiMessage) AsLegacy() (*legacy.StoredMessage, bool) {
 	if mm.tipe != Legacy {
 		return nil, false
@@ -127,10 +152,27 @@ func (mm MultiMessage) AsGabby() (*gabbygrove.Transfer, bool) {
 	return gabby, true
 }
 
+func (mm MultiMessage) AsBendyButt() (*bendybutt.Message, bool) {
+	if mm.tipe != BendyButt {
+		return nil, false
+	}
+	bb, ok := mm.Message.(*bendybutt.Message)
+	if !ok {
+		return nil, false
+	}
+	return bb, true
+}
+
+// AsRegistered returns the format of messages that use one of the registered feed formats
+func (mm MultiMessage) AsRegistered() (ssb.FeedFormat, bool) {
+	return ssb.GetFeedFormatByStorageType(byte(mm.tipe))
+}
+
 func NewMultiMessageFromLegacy(msg *legacy.StoredMessage) *MultiMessage {
 	var mm MultiMessage
 	mm.tipe = Legacy
 	mm.key = msg.Key_
 	mm.Message = msg
+	mm.received = msg.Timestamp_
 	return &mm
 }
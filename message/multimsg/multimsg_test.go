@@ -4,6 +4,7 @@ package multimsg
 
 import (
 	"bytes"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -12,6 +13,7 @@ import (
 	refs "go.mindeco.de/ssb-refs"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/buttwoo"
 	"go.cryptoscope.co/ssb/message/legacy"
 )
 
@@ -106,3 +108,62 @@ func TestMultiMsgGabby(t *testing.T) {
 	r.NoError(err)
 	r.Equal(uint64(123), evt2.Sequence)
 }
+
+func TestMultiMsgRegistered(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("woo!"), 8)))
+	r.NoError(err)
+
+	msg, err := buttwoo.NewEncoder(kp.Pair.Secret).Encode(1, nil, map[string]interface{}{"type": "test"})
+	r.NoError(err)
+
+	var mm MultiMessage
+	mm.tipe = MessageType(buttwoo.StorageType)
+	mm.Message = msg
+
+	b, err := mm.MarshalBinary()
+	r.NoError(err)
+	r.Equal(buttwoo.StorageType, b[0])
+
+	var mm2 MultiMessage
+	err = mm2.UnmarshalBinary(b)
+	r.NoError(err)
+	ff, ok := mm2.AsRegistered()
+	r.True(ok)
+	r.Equal(buttwoo.RefAlgo, ff.Algo())
+	r.Equal(msg.Key().Ref(), mm2.Key().Ref())
+	r.Equal(msg.Raw(), mm2.Message.(*buttwoo.Message).Raw())
+}
+
+func TestMessageSignature(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(bytes.Repeat([]byte("sigs"), 8)))
+	r.NoError(err)
+
+	msg, err := buttwoo.NewEncoder(kp.Pair.Secret).Encode(1, nil, map[string]interface{}{"type": "test"})
+	r.NoError(err)
+
+	sig, err := ssb.MessageSignature(msg)
+	r.NoError(err)
+	r.Equal(msg.ValueContent().Signature, sig)
+	r.True(strings.HasSuffix(sig, ".sig.ed25519"))
+
+	// stored messages are unwrapped
+	var mm MultiMessage
+	mm.tipe = MessageType(buttwoo.StorageType)
+	mm.Message = msg
+	wrapped, err := ssb.MessageSignature(&mm)
+	r.NoError(err)
+	r.Equal(sig, wrapped)
+
+	// gabby keeps it in the transfer
+	tr := &gabbygrove.Transfer{Signature: []byte("sig")}
+	s, err := gabbyFormat{}.Signature(tr)
+	r.NoError(err)
+	r.Equal("c2ln.sig.ed25519", s)
+
+	_, err = legacyFormat{}.Signature(tr)
+	r.Error(err, "wrong type of message")
+}
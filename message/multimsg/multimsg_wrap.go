@@ -8,9 +8,9 @@ import (
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/margaret"
-	gabbygrove "go.mindeco.de/ssb-gabbygrove"
 	refs "go.mindeco.de/ssb-refs"
 
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message/legacy"
 )
 
@@ -61,18 +61,13 @@ func (wl WrappedLog) Append(val interface{}) (margaret.Seq, error) {
 
 	mm.key = abs.Key()
 
-	switch tv := val.(type) {
-	case *legacy.StoredMessage:
-		mm.tipe = Legacy
-		mm.Message = tv
-		tv.Timestamp_ = wl.receivedNow()
-	case *gabbygrove.Transfer:
-		mm.tipe = Gabby
-		mm.Message = tv
-		mm.received = wl.receivedNow()
-	default:
-		return margaret.SeqEmpty, errors.Errorf("wrappedLog: unsupported message type: %T", val)
+	ff, ok := ssb.GetFeedFormat(abs.Author().Algo)
+	if !ok {
+		return margaret.SeqEmpty, errors.Errorf("wrappedLog: unsupported feed format: %s", abs.Author().Algo)
 	}
+	mm.tipe = MessageType(ff.StorageType())
+	mm.Message = abs
+	mm.received = wl.receivedNow()
 
 	return wl.AlterableLog.Append(mm)
 }
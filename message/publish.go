@@ -3,29 +3,82 @@
 package message
 
 import (
-	"bytes"
-	"encoding/base64"
+	"context"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
-	gabbygrove "go.mindeco.de/ssb-gabbygrove"
 	refs "go.mindeco.de/ssb-refs"
 
 	"go.cryptoscope.co/ssb"
-	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/bendybutt"
 )
 
 type publishLog struct {
-	sync.Mutex
+	// shared by the publish logs of the same feed, see WithFeedLocks
+	mu     *sync.Mutex
+	author *refs.FeedRef
+
 	margaret.Log
 	rootLog margaret.Log
+	sublogs multilog.MultiLog
+
+	create ssb.FeedCreator
+
+	// skipValidation disables the ssb.ValidateContent check, see CheckContent
+	skipValidation bool
+}
 
-	create creater
+// FeedLocks holds a mutex per feed, so that publish logs which are opened for the same feed (like by sbot.PublishAs)
+// don't create messages with the same sequence. The owner of the logs keeps one and passes it with WithFeedLocks.
+// Other writers, like replication and archive imports, take the lock of a feed with Lock before they look at its stored state.
+type FeedLocks struct {
+	mu    sync.Mutex
+	feeds map[string]*sync.Mutex
+}
+
+// NewFeedLocks returns an empty set of feed locks
+func NewFeedLocks() *FeedLocks {
+	return &FeedLocks{feeds: make(map[string]*sync.Mutex)}
+}
+
+func (fl *FeedLocks) lockFor(feed *refs.FeedRef) *sync.Mutex {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	addr := string(ssb.StoredAddr(feed))
+	mu, has := fl.feeds[addr]
+	if !has {
+		mu = new(sync.Mutex)
+		fl.feeds[addr] = mu
+	}
+	return mu
+}
+
+// Lock locks feed and returns the function to unlock it again
+func (fl *FeedLocks) Lock(feed *refs.FeedRef) (unlock func()) {
+	mu := fl.lockFor(feed)
+	mu.Lock()
+	return mu.Unlock
+}
+
+var _ ssb.BatchPublisher = (*publishLog)(nil)
+
+// ErrWrongPrevious is returned by PublishAfter if the newest message of the feed isn't the expected one
+type ErrWrongPrevious struct {
+	Expected, Current *refs.MessageRef
+}
+
+func (e ErrWrongPrevious) Error() string {
+	ref := func(r *refs.MessageRef) string {
+		if r == nil {
+			return "none"
+		}
+		return r.Ref()
+	}
+	return fmt.Sprintf("publish: feed moved on, expected previous %s but it is %s", ref(e.Expected), ref(e.Current))
 }
 
 func (p *publishLog) Publish(content interface{}) (*refs.MessageRef, error) {
@@ -72,55 +125,199 @@ TODO: do the same for Query()? but how?
 => just overwrite publish on the authorLog for now
 */
 func (pl *publishLog) Append(val interface{}) (margaret.Seq, error) {
-	pl.Lock()
-	defer pl.Unlock()
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
 
-	// current state of the local sig-chain
-	var (
-		nextPrevious *refs.MessageRef // = invalid
-		nextSequence = margaret.SeqEmpty
-	)
+	seqs, _, err := pl.appendLocked(false, nil, []interface{}{val})
+	if err != nil {
+		return nil, err
+	}
+	return seqs[0], nil
+}
+
+// PublishBatch publishes contents as consecutive messages and returns their keys.
+// All of them are validated, created and signed before the first one is appended,
+// so that invalid content doesn't leave a part of the batch on the feed.
+// If the receive log fails while appending, the messages before the failed one are taken back again before the feed is unlocked,
+// so either all of them are published or none.
+func (pl *publishLog) PublishBatch(contents ...interface{}) ([]*refs.MessageRef, error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	_, keys, err := pl.appendLocked(false, nil, contents)
+	return keys, err
+}
+
+// PublishAfter is like PublishBatch but only publishes if previous is the newest message of the feed (or nil for an empty feed).
+// Otherwise it returns ErrWrongPrevious. Callers can use it to build on what they read from the feed, without racing other publishers.
+func (pl *publishLog) PublishAfter(previous *refs.MessageRef, contents ...interface{}) ([]*refs.MessageRef, error) {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+
+	_, keys, err := pl.appendLocked(true, previous, contents)
+	return keys, err
+}
 
-	currSeq, err := pl.Seq().Value()
+// appendLocked creates the messages for vals and appends them to the root log. pl.mu needs to be held.
+// If check is set, the newest message of the feed has to be expected.
+func (pl *publishLog) appendLocked(check bool, expected *refs.MessageRef, vals []interface{}) ([]margaret.Seq, []*refs.MessageRef, error) {
+	if len(vals) == 0 {
+		return nil, nil, errors.New("publishLog: nothing to publish")
+	}
+
+	nextPrevious, nextSequence, err := pl.head()
 	if err != nil {
-		return nil, errors.Wrap(err, "publishLog: failed to establish current seq")
+		return nil, nil, err
 	}
-	seq, ok := currSeq.(margaret.Seq)
-	if !ok {
-		return nil, errors.Errorf("publishLog: invalid sequence from publish sublog %v: %T", currSeq, currSeq)
+
+	if check {
+		same := expected == nil && nextPrevious == nil
+		if expected != nil && nextPrevious != nil {
+			same = expected.Equal(*nextPrevious)
+		}
+		if !same {
+			return nil, nil, ErrWrongPrevious{Expected: expected, Current: nextPrevious}
+		}
 	}
 
-	currRootSeq, err := pl.Get(seq)
-	if err != nil && !luigi.IsEOS(err) {
-		return nil, errors.Wrap(err, "publishLog: failed to retreive current msg")
+	if !pl.skipValidation {
+		for i, val := range vals {
+			if _, signed := val.(*bendybutt.SignedContent); signed {
+				continue
+			}
+			if err := ssb.ValidateContent(val); err != nil {
+				return nil, nil, errors.Wrapf(err, "publish: refusing content (%d of %d)", i+1, len(vals))
+			}
+		}
 	}
-	if luigi.IsEOS(err) { // new feed
-		nextPrevious = nil
-		nextSequence = 1
-	} else {
-		currMM, err := pl.rootLog.Get(currRootSeq.(margaret.Seq))
+
+	// create all of them first, so that nothing is stored if one fails
+	msgs := make([]refs.Message, len(vals))
+	for i, val := range vals {
+		nextMsg, err := pl.create.Create(val, nextPrevious, nextSequence.Seq())
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to create next msg (%d of %d)", i+1, len(vals))
+		}
+		msgs[i] = nextMsg
+		nextPrevious = nextMsg.Key()
+		nextSequence = margaret.BaseSeq(nextSequence.Seq() + 1)
+	}
+
+	seqs := make([]margaret.Seq, len(msgs))
+	keys := make([]*refs.MessageRef, len(msgs))
+	for i, msg := range msgs {
+		rlSeq, err := pl.rootLog.Append(msg)
 		if err != nil {
-			return nil, errors.Wrap(err, "publishLog: failed to establish current seq")
+			err = errors.Wrapf(err, "failed to append new msg (%d of %d)", i+1, len(msgs))
+			if undoErr := pl.undoLocked(seqs[:i]); undoErr != nil {
+				return nil, nil, errors.Wrapf(err, "and failed to take back the ones before: %s", undoErr)
+			}
+			return nil, nil, err
+		}
+		seqs[i] = rlSeq
+		keys[i] = msg.Key()
+	}
+	return seqs, keys, nil
+}
+
+// undoLocked takes back the messages of a batch that couldn't be stored completely. pl.mu needs to be held.
+// They are nulled in the root log, which the indexes skip, and the author's sublog is rebuilt without them in case they were indexed already.
+func (pl *publishLog) undoLocked(appended []margaret.Seq) error {
+	if len(appended) == 0 {
+		return nil
+	}
+	alt, ok := pl.rootLog.(margaret.Alterer)
+	if !ok {
+		return errors.Errorf("publishLog: can't null messages in %T", pl.rootLog)
+	}
+	undone := make(map[int64]struct{}, len(appended))
+	for _, seq := range appended {
+		if err := alt.Null(seq); err != nil {
+			return errors.Wrapf(err, "publishLog: failed to null message %d", seq.Seq())
+		}
+		undone[seq.Seq()] = struct{}{}
+	}
+
+	src, err := pl.Log.Query()
+	if err != nil {
+		return errors.Wrap(err, "publishLog: failed to query author sublog")
+	}
+	var (
+		keep    []margaret.Seq
+		indexed bool
+	)
+	for {
+		v, err := src.Next(context.TODO())
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "publishLog: failed to read author sublog")
 		}
-		mm, ok := currMM.(refs.Message)
+		seq, ok := v.(margaret.Seq)
 		if !ok {
-			return nil, errors.Errorf("publishLog: invalid value at sequence %v: %T", currSeq, currMM)
+			return errors.Errorf("publishLog: invalid value in author sublog: %T", v)
 		}
-		nextPrevious = mm.Key()
-		nextSequence = margaret.BaseSeq(mm.Seq() + 1)
+		if _, has := undone[seq.Seq()]; has {
+			indexed = true
+			continue
+		}
+		keep = append(keep, seq)
+	}
+	if !indexed {
+		return nil
 	}
 
-	nextMsg, err := pl.create.Create(val, nextPrevious, nextSequence)
+	addr := ssb.StoredAddr(pl.author)
+	if err := pl.sublogs.Delete(addr); err != nil {
+		return errors.Wrap(err, "publishLog: failed to drop author sublog")
+	}
+	authorLog, err := pl.sublogs.Get(addr)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create next msg")
+		return errors.Wrap(err, "publishLog: failed to reopen author sublog")
+	}
+	for _, seq := range keep {
+		if _, err := authorLog.Append(seq); err != nil {
+			return errors.Wrap(err, "publishLog: failed to rebuild author sublog")
+		}
+	}
+	pl.Log = authorLog
+	return nil
+}
+
+// head returns the key and sequence the next message of the feed needs to have
+// The author's sublog is looked up again, since a failed batch of another publish log of the feed might have rebuilt it.
+func (pl *publishLog) head() (*refs.MessageRef, margaret.Seq, error) {
+	authorLog, err := pl.sublogs.Get(ssb.StoredAddr(pl.author))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "publishLog: failed to open author sublog")
 	}
 
-	rlSeq, err := pl.rootLog.Append(nextMsg)
+	currSeq, err := authorLog.Seq().Value()
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to append new msg")
+		return nil, nil, errors.Wrap(err, "publishLog: failed to establish current seq")
+	}
+	seq, ok := currSeq.(margaret.Seq)
+	if !ok {
+		return nil, nil, errors.Errorf("publishLog: invalid sequence from publish sublog %v: %T", currSeq, currSeq)
 	}
 
-	return rlSeq, nil
+	currRootSeq, err := authorLog.Get(seq)
+	if err != nil && !luigi.IsEOS(err) {
+		return nil, nil, errors.Wrap(err, "publishLog: failed to retreive current msg")
+	}
+	if luigi.IsEOS(err) { // new feed
+		return nil, margaret.BaseSeq(1), nil
+	}
+
+	currMM, err := pl.rootLog.Get(currRootSeq.(margaret.Seq))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "publishLog: failed to establish current seq")
+	}
+	mm, ok := currMM.(refs.Message)
+	if !ok {
+		return nil, nil, errors.Errorf("publishLog: invalid value at sequence %v: %T", currSeq, currMM)
+	}
+	return mm.Key(), margaret.BaseSeq(mm.Seq() + 1), nil
 }
 
 // OpenPublishLog needs the base datastore (root or receive log - offset2)
@@ -135,28 +332,27 @@ func OpenPublishLog(rootLog margaret.Log, sublogs multilog.MultiLog, kp *ssb.Key
 		return nil, errors.Errorf("no sublog for publish")
 	}
 
-	authorLog, err := sublogs.Get(kp.Id.StoredAddr())
+	authorLog, err := sublogs.Get(ssb.StoredAddr(kp.Id))
 	if err != nil {
 		return nil, errors.Wrap(err, "publish: failed to open sublog for author")
 	}
 
 	pl := &publishLog{
+		mu:      new(sync.Mutex),
+		author:  kp.Id,
 		Log:     authorLog,
 		rootLog: rootLog,
+		sublogs: sublogs,
 	}
 
-	switch kp.Id.Algo {
-	case refs.RefAlgoFeedSSB1:
-		pl.create = &legacyCreate{
-			key: *kp,
-		}
-	case refs.RefAlgoFeedGabby:
-		pl.create = &gabbyCreate{
-			enc: gabbygrove.NewEncoder(kp.Pair.Secret),
-		}
-	default:
+	ff, ok := ssb.GetFeedFormat(kp.Id.Algo)
+	if !ok {
 		return nil, errors.Errorf("publish: unsupported feed algorithm: %s", kp.Id.Algo)
 	}
+	pl.create, err = ff.NewCreator(kp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "publish: failed to create %s creator", kp.Id.Algo)
+	}
 
 	for i, o := range opts {
 		if err := o(pl); err != nil {
@@ -171,101 +367,33 @@ type PublishOption func(*publishLog) error
 
 func SetHMACKey(hmackey []byte) PublishOption {
 	return func(pl *publishLog) error {
-		var hmacSec [32]byte
-		if n := copy(hmacSec[:], hmackey); n != 32 {
+		if n := len(hmackey); n != 32 {
 			return fmt.Errorf("hmac key of wrong length:%d", n)
 		}
-		switch cv := pl.create.(type) {
-		case *legacyCreate:
-			cv.hmac = &hmacSec
-		case *gabbyCreate:
-			cv.enc.WithHMAC(hmackey)
-		default:
-			return fmt.Errorf("hmac: unknown creater: %T", cv)
-		}
-		return nil
+		return pl.create.WithHMAC(hmackey)
 	}
 }
 
 func UseNowTimestamps(yes bool) PublishOption {
 	return func(pl *publishLog) error {
-		switch cv := pl.create.(type) {
-		case *legacyCreate:
-			cv.setTimestamp = yes
-
-		case *gabbyCreate:
-			cv.enc.WithNowTimestamps(yes)
-
-		default:
-			return fmt.Errorf("setTimestamp: unknown creater: %T", cv)
-		}
+		pl.create.WithNowTimestamps(yes)
 		return nil
 	}
 }
 
-type creater interface {
-	Create(val interface{}, prev *refs.MessageRef, seq margaret.Seq) (refs.Message, error)
-}
-
-type legacyCreate struct {
-	key          ssb.KeyPair
-	hmac         *[32]byte
-	setTimestamp bool
-}
-
-func (lc legacyCreate) Create(val interface{}, prev *refs.MessageRef, seq margaret.Seq) (refs.Message, error) {
-	// prepare persisted message
-	var stored legacy.StoredMessage
-	stored.Timestamp_ = time.Now() // "rx"
-	stored.Author_ = lc.key.Id
-
-	// set metadata
-	var newMsg legacy.LegacyMessage
-	newMsg.Hash = "sha256"
-	newMsg.Author = lc.key.Id.Ref()
-	newMsg.Previous = prev
-	newMsg.Sequence = margaret.BaseSeq(seq.Seq())
-
-	if bindata, ok := val.([]byte); ok {
-		bindata = bytes.TrimPrefix(bindata, []byte("box1:"))
-		newMsg.Content = base64.StdEncoding.EncodeToString(bindata) + ".box"
-	} else {
-		newMsg.Content = val
-	}
-
-	if lc.setTimestamp {
-		newMsg.Timestamp = time.Now().UnixNano() / 1000000
-	}
-
-	mr, signedMessage, err := newMsg.Sign(lc.key.Pair.Secret[:], lc.hmac)
-	if err != nil {
-		return nil, err
+// WithFeedLocks makes the publish log use the lock of its feed in fl,
+// so that it can be used next to the other publish logs of the same feed that were opened with fl.
+func WithFeedLocks(fl *FeedLocks) PublishOption {
+	return func(pl *publishLog) error {
+		pl.mu = fl.lockFor(pl.author)
+		return nil
 	}
-
-	stored.Previous_ = newMsg.Previous
-	stored.Sequence_ = newMsg.Sequence
-	stored.Key_ = mr
-	stored.Raw_ = signedMessage
-	return &stored, nil
-}
-
-type gabbyCreate struct {
-	enc *gabbygrove.Encoder
 }
 
-func (pc gabbyCreate) Create(val interface{}, prev *refs.MessageRef, seq margaret.Seq) (refs.Message, error) {
-	var br *gabbygrove.BinaryRef
-	if prev != nil {
-		var err error
-		br, err = gabbygrove.NewBinaryRef(prev)
-		if err != nil {
-			return nil, err
-		}
-	}
-	nextSeq := uint64(seq.Seq())
-	tr, _, err := pc.enc.Encode(nextSeq, br, val)
-	if err != nil {
-		return nil, errors.Wrap(err, "gabby: failed to encode content")
+// CheckContent controls if content is checked with ssb.ValidateContent before it is published, which is the default.
+func CheckContent(yes bool) PublishOption {
+	return func(pl *publishLog) error {
+		pl.skipValidation = !yes
+		return nil
 	}
-	return tr, nil
 }
@@ -7,14 +7,21 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/asynctesting"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/repo"
 	refs "go.mindeco.de/ssb-refs"
@@ -104,3 +111,298 @@ func TestSignMessages(t *testing.T) {
 	cancel()
 	r.NoError(<-errc, "serveLog failed")
 }
+
+func TestPublishBatch(t *testing.T) {
+	tctx := context.TODO()
+	r, a := require.New(t), assert.New(t)
+
+	rpath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(rpath)
+
+	testRepo := repo.New(rpath)
+	rl, err := repo.OpenLog(testRepo)
+	r.NoError(err, "failed to open root log")
+
+	userFeeds, userFeedsSnk, err := multilogs.OpenUserFeeds(testRepo)
+	r.NoError(err, "failed to get user feeds multilog")
+
+	killServe, cancel := context.WithCancel(tctx)
+	defer cancel()
+	errc := asynctesting.ServeLog(killServe, t.Name(), rl, userFeedsSnk, true)
+
+	testAuthor, err := ssb.NewKeyPair(rand.New(rand.NewSource(42)))
+	r.NoError(err)
+
+	authorLog, err := userFeeds.Get(testAuthor.Id.StoredAddr())
+	r.NoError(err)
+
+	locks := NewFeedLocks()
+	pub, err := OpenPublishLog(rl, userFeeds, testAuthor, WithFeedLocks(locks))
+	r.NoError(err)
+	bp := pub.(ssb.BatchPublisher)
+
+	storedSeqs := func() []int64 {
+		var seqs []int64
+		latest, err := authorLog.Seq().Value()
+		r.NoError(err)
+		for i := int64(0); i <= latest.(margaret.Seq).Seq(); i++ {
+			rootSeq, err := authorLog.Get(margaret.BaseSeq(i))
+			r.NoError(err)
+			v, err := rl.Get(rootSeq.(margaret.Seq))
+			r.NoError(err)
+			seqs = append(seqs, v.(refs.Message).Seq())
+		}
+		return seqs
+	}
+
+	// on an empty feed, previous has to be nil
+	_, err = bp.PublishAfter(&refs.MessageRef{Hash: make([]byte, 32), Algo: refs.RefAlgoMessageSSB1}, "nope")
+	a.IsType(ErrWrongPrevious{}, err)
+
+	keys, err := bp.PublishAfter(nil, "one", "two")
+	r.NoError(err)
+	r.Len(keys, 2)
+
+	keys, err = bp.PublishBatch("three", "four", "five")
+	r.NoError(err)
+	r.Len(keys, 3)
+	head := keys[2]
+	a.Equal([]int64{1, 2, 3, 4, 5}, storedSeqs())
+
+	// nothing is stored if one of them can't be created
+	_, err = bp.PublishBatch("six", make(chan int))
+	a.Error(err)
+	a.Equal([]int64{1, 2, 3, 4, 5}, storedSeqs())
+
+	// another publisher moves the feed on
+	other, err := OpenPublishLog(rl, userFeeds, testAuthor, WithFeedLocks(locks))
+	r.NoError(err)
+	_, err = other.Publish("six")
+	r.NoError(err)
+
+	_, err = bp.PublishAfter(head, "seven")
+	r.Error(err)
+	wrongPrev, ok := err.(ErrWrongPrevious)
+	r.True(ok, "wrong error: %v", err)
+	a.True(wrongPrev.Expected.Equal(*head))
+	a.False(wrongPrev.Current.Equal(*head))
+
+	_, err = bp.PublishAfter(wrongPrev.Current, "seven")
+	r.NoError(err)
+
+	// publish logs of the same feed don't race each other
+	var wg sync.WaitGroup
+	for _, p := range []ssb.Publisher{pub, other} {
+		wg.Add(1)
+		go func(p ssb.Publisher) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				_, err := p.Publish(i)
+				a.NoError(err)
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	seqs := storedSeqs()
+	r.Len(seqs, 27)
+	for i, seq := range seqs {
+		a.Equal(int64(i+1), seq)
+	}
+
+	cancel()
+	r.NoError(<-errc, "serveLog failed")
+}
+
+func TestPublishChecksContent(t *testing.T) {
+	tctx := context.TODO()
+	r, a := require.New(t), assert.New(t)
+
+	rpath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(rpath)
+
+	testRepo := repo.New(rpath)
+	rl, err := repo.OpenLog(testRepo)
+	r.NoError(err, "failed to open root log")
+
+	userFeeds, userFeedsSnk, err := multilogs.OpenUserFeeds(testRepo)
+	r.NoError(err, "failed to get user feeds multilog")
+
+	killServe, cancel := context.WithCancel(tctx)
+	defer cancel()
+	errc := asynctesting.ServeLog(killServe, t.Name(), rl, userFeedsSnk, true)
+
+	testAuthor, err := ssb.NewKeyPair(rand.New(rand.NewSource(42)))
+	r.NoError(err)
+
+	authorLog, err := userFeeds.Get(testAuthor.Id.StoredAddr())
+	r.NoError(err)
+
+	pub, err := OpenPublishLog(rl, userFeeds, testAuthor)
+	r.NoError(err)
+	bp := pub.(ssb.BatchPublisher)
+
+	_, err = pub.Publish(map[string]interface{}{
+		"type":    "contact",
+		"contact": "not a feed",
+	})
+	r.Error(err)
+	invalid, ok := errors.Cause(err).(ssb.ErrInvalidContent)
+	r.True(ok, "wrong error: %v", err)
+	a.Equal("contact", invalid.Type)
+
+	// one invalid message keeps the whole batch from being published
+	_, err = bp.PublishBatch(
+		map[string]interface{}{"type": "post", "text": "fine"},
+		map[string]interface{}{"type": "about", "name": "no about"},
+	)
+	r.Error(err)
+
+	// valid but too long
+	_, err = pub.Publish(map[string]interface{}{
+		"type": "post",
+		"text": strings.Repeat("a", legacy.MaxMessageLength),
+	})
+	r.Error(err)
+	_, ok = errors.Cause(err).(ssb.ErrMessageTooLong)
+	r.True(ok, "wrong error: %v", err)
+
+	seq, err := authorLog.Seq().Value()
+	r.NoError(err)
+	a.EqualValues(-1, seq.(margaret.Seq).Seq(), "something was published")
+
+	// unknown types and encrypted content pass
+	_, err = bp.PublishBatch(
+		map[string]interface{}{"type": "post", "text": "fine"},
+		map[string]interface{}{"type": "whatever", "text": 23},
+		"c2VjcmV0.box",
+	)
+	r.NoError(err)
+
+	// the check can be turned off
+	unchecked, err := OpenPublishLog(rl, userFeeds, testAuthor, CheckContent(false))
+	r.NoError(err)
+	_, err = unchecked.Publish(map[string]interface{}{"type": "post"})
+	r.NoError(err)
+
+	cancel()
+	r.NoError(<-errc, "serveLog failed")
+}
+
+// failingLog fails the append after the first ok ones
+type failingLog struct {
+	multimsg.AlterableLog
+
+	ok int
+}
+
+func (fl *failingLog) Append(v interface{}) (margaret.Seq, error) {
+	if fl.ok == 0 {
+		return nil, errors.New("injected append failure")
+	}
+	fl.ok--
+	return fl.AlterableLog.Append(v)
+}
+
+func TestPublishBatchAtomic(t *testing.T) {
+	tctx := context.TODO()
+	r, a := require.New(t), assert.New(t)
+
+	rpath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(rpath)
+
+	testRepo := repo.New(rpath)
+	rl, err := repo.OpenLog(testRepo)
+	r.NoError(err, "failed to open root log")
+
+	userFeeds, userFeedsSnk, err := multilogs.OpenUserFeeds(testRepo)
+	r.NoError(err, "failed to get user feeds multilog")
+
+	killServe, cancel := context.WithCancel(tctx)
+	defer cancel()
+	errc := asynctesting.ServeLog(killServe, t.Name(), rl, userFeedsSnk, true)
+
+	testAuthor, err := ssb.NewKeyPair(rand.New(rand.NewSource(42)))
+	r.NoError(err)
+
+	// the sublog is looked up every time, since a failed batch rebuilds it
+	storedSeqs := func() []int64 {
+		authorLog, err := userFeeds.Get(testAuthor.Id.StoredAddr())
+		r.NoError(err)
+		src, err := authorLog.Query()
+		r.NoError(err)
+		var seqs []int64
+		for {
+			v, err := src.Next(tctx)
+			if luigi.IsEOS(err) {
+				break
+			}
+			r.NoError(err)
+			msg, err := rl.Get(v.(margaret.Seq))
+			r.NoError(err)
+			seqs = append(seqs, msg.(refs.Message).Seq())
+		}
+		return seqs
+	}
+
+	locks := NewFeedLocks()
+	pub, err := OpenPublishLog(rl, userFeeds, testAuthor, WithFeedLocks(locks))
+	r.NoError(err)
+	bp := pub.(ssb.BatchPublisher)
+
+	_, err = bp.PublishBatch("one", "two")
+	r.NoError(err)
+	a.Equal([]int64{1, 2}, storedSeqs())
+
+	// the receive log fails on the third message of the batch
+	fl := &failingLog{AlterableLog: rl, ok: 2}
+	failing, err := OpenPublishLog(fl, userFeeds, testAuthor, WithFeedLocks(locks))
+	r.NoError(err)
+	_, err = failing.(ssb.BatchPublisher).PublishBatch("three", "four", "five")
+	r.Error(err)
+	a.Equal(0, fl.ok, "expected two appends before the failure")
+	a.Equal([]int64{1, 2}, storedSeqs(), "part of the batch was left")
+
+	// the feed goes on where it was before the batch
+	keys, err := bp.PublishBatch("three", "four")
+	r.NoError(err)
+	r.Len(keys, 2)
+	a.Equal([]int64{1, 2, 3, 4}, storedSeqs())
+
+	cancel()
+	r.NoError(<-errc, "serveLog failed")
+}
+
+func TestFeedLocks(t *testing.T) {
+	r := require.New(t)
+
+	locks := NewFeedLocks()
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	other, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	unlock := locks.Lock(kp.Id)
+
+	// other feeds aren't held up, the same key as a metafeed is another feed
+	locks.Lock(other.Id)()
+	locks.Lock(&refs.FeedRef{ID: kp.Id.ID, Algo: ssb.RefAlgoFeedBendyButt})()
+
+	locked := make(chan struct{})
+	go func() {
+		locks.Lock(kp.Id)()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("feed was locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		t.Fatal("feed stayed locked")
+	}
+}
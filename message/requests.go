@@ -7,6 +7,8 @@ import (
 
 	"github.com/pkg/errors"
 	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
 )
 
 type WhoamiReply struct {
@@ -47,7 +49,7 @@ func NewCreateHistArgsFromMap(argMap map[string]interface{}) (*CreateHistArgs, e
 			switch k {
 			case "id":
 				var err error
-				qry.ID, err = refs.ParseFeedRef(val)
+				qry.ID, err = ssb.ParseFeedRef(val)
 				if err != nil {
 					return nil, errors.Wrapf(err, "ssb/message: not a feed ref")
 				}
@@ -0,0 +1,96 @@
+// SPDX-License-Identifier: MIT
+
+package message
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// loadBenchFeed reads the signed messages of the single feed in the legacy testdata
+func loadBenchFeed(b *testing.B) (*refs.FeedRef, []json.RawMessage) {
+	zr, err := zip.OpenReader("legacy/testdata.zip")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer zr.Close()
+
+	var inputs []*zip.File
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".input") {
+			inputs = append(inputs, f)
+		}
+	}
+	sort.Slice(inputs, func(i, j int) bool { return inputs[i].Name < inputs[j].Name })
+
+	var msgs []json.RawMessage
+	for _, f := range inputs {
+		rc, err := f.Open()
+		if err != nil {
+			b.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+		msgs = append(msgs, data)
+	}
+
+	var first struct {
+		Author string
+	}
+	if err := json.Unmarshal(msgs[0], &first); err != nil {
+		b.Fatal(err)
+	}
+	author, err := refs.ParseFeedRef(first.Author)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return author, msgs
+}
+
+var discard = luigi.FuncSink(func(context.Context, interface{}, error) error { return nil })
+
+func benchmarkSink(b *testing.B, msgs []json.RawMessage, mk func() luigi.Sink) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		snk := mk()
+		for _, m := range msgs {
+			if err := snk.Pour(context.TODO(), m); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := snk.Close(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVerifySink(b *testing.B) {
+	author, msgs := loadBenchFeed(b)
+	benchmarkSink(b, msgs, func() luigi.Sink {
+		return NewVerifySink(author, margaret.BaseSeq(0), nil, discard, nil)
+	})
+}
+
+func BenchmarkBatchVerifySink(b *testing.B) {
+	author, msgs := loadBenchFeed(b)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprint(workers), func(b *testing.B) {
+			benchmarkSink(b, msgs, func() luigi.Sink {
+				return NewBatchVerifySink(author, margaret.BaseSeq(0), nil, discard, nil, workers)
+			})
+		})
+	}
+}
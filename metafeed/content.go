@@ -0,0 +1,120 @@
+// SPDX-License-Identifier: MIT
+
+package metafeed
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message/bendybutt"
+)
+
+// the content types of metafeed messages
+const (
+	TypeAdd       = "metafeed/add"
+	TypeTombstone = "metafeed/tombstone"
+
+	// TypeAnnounce is published on the main feed to tell others about its metafeed
+	TypeAnnounce = "metafeed/announce"
+)
+
+// NewAddContent returns the signed content that adds subfeed to the metafeed.
+// The nonce is the one that was used to derive the subfeed from the seed.
+func NewAddContent(metafeed *refs.FeedRef, subfeed *ssb.KeyPair, purpose string, nonce []byte) (*bendybutt.SignedContent, error) {
+	return bendybutt.SignContent(map[string]interface{}{
+		"type":        TypeAdd,
+		"feedpurpose": purpose,
+		"subfeed":     subfeed.Id,
+		"metafeed":    metafeed,
+		"nonce":       nonce,
+	}, subfeed.Pair.Secret)
+}
+
+// NewTombstoneContent returns the signed content that ends subfeed
+func NewTombstoneContent(metafeed *refs.FeedRef, subfeed *ssb.KeyPair, reason string) (*bendybutt.SignedContent, error) {
+	return bendybutt.SignContent(map[string]interface{}{
+		"type":     TypeTombstone,
+		"subfeed":  subfeed.Id,
+		"metafeed": metafeed,
+		"reason":   reason,
+	}, subfeed.Pair.Secret)
+}
+
+// Announcement is published on the main feed of an identity so that others can find its metafeed
+type Announcement struct {
+	Type     string        `json:"type"`
+	MetaFeed *refs.FeedRef `json:"metafeed"`
+}
+
+// NewAnnouncement returns the content for a metafeed/announce message
+func NewAnnouncement(metafeed *refs.FeedRef) Announcement {
+	return Announcement{
+		Type:     TypeAnnounce,
+		MetaFeed: metafeed,
+	}
+}
+
+// Content is a decoded metafeed/add, metafeed/tombstone or metafeed/announce message
+type Content struct {
+	Type string
+
+	MetaFeed *refs.FeedRef
+	SubFeed  *refs.FeedRef // not set for announcements
+
+	FeedPurpose string
+	Nonce       []byte
+	Reason      string
+}
+
+// ParseContent decodes the JSON content of a message
+func ParseContent(content []byte) (*Content, error) {
+	var raw struct {
+		Type        string `json:"type"`
+		MetaFeed    string `json:"metafeed"`
+		SubFeed     string `json:"subfeed"`
+		FeedPurpose string `json:"feedpurpose"`
+		Nonce       string `json:"nonce"`
+		Reason      string `json:"reason"`
+	}
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, errors.Wrap(err, "metafeed: invalid content")
+	}
+
+	var c Content
+	c.Type = raw.Type
+	c.FeedPurpose = raw.FeedPurpose
+	c.Reason = raw.Reason
+
+	switch c.Type {
+	case TypeAdd, TypeTombstone:
+		sub, err := ssb.ParseFeedRef(raw.SubFeed)
+		if err != nil {
+			return nil, errors.Wrap(err, "metafeed: invalid subfeed")
+		}
+		c.SubFeed = sub
+	case TypeAnnounce:
+	default:
+		return nil, errors.Errorf("metafeed: not a metafeed message: %q", c.Type)
+	}
+
+	mf, err := ssb.ParseFeedRef(raw.MetaFeed)
+	if err != nil {
+		return nil, errors.Wrap(err, "metafeed: invalid metafeed")
+	}
+	if mf.Algo != ssb.RefAlgoFeedBendyButt {
+		return nil, errors.Errorf("metafeed: not a metafeed reference: %s", mf.Ref())
+	}
+	c.MetaFeed = mf
+
+	if raw.Nonce != "" {
+		c.Nonce, err = base64.StdEncoding.DecodeString(raw.Nonce)
+		if err != nil {
+			return nil, errors.Wrap(err, "metafeed: invalid nonce")
+		}
+	}
+	return &c, nil
+}
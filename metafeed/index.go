@@ -0,0 +1,243 @@
+// SPDX-License-Identifier: MIT
+
+package metafeed
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/dgraph-io/badger"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	libbadger "go.cryptoscope.co/librarian/badger"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// SubFeed is an entry of a metafeed
+type SubFeed struct {
+	Feed       *refs.FeedRef
+	Purpose    string
+	Nonce      []byte
+	Tombstoned bool
+}
+
+// Index answers which subfeeds a metafeed has and which metafeed a main feed announced
+type Index interface {
+	// SubFeeds returns all the feeds that were added to the metafeed, including the tombstoned ones
+	SubFeeds(metafeed *refs.FeedRef) ([]SubFeed, error)
+
+	// Announced returns the metafeed the main feed announced, if any
+	Announced(main *refs.FeedRef) (*refs.FeedRef, bool, error)
+}
+
+type IndexingIndex interface {
+	Index
+
+	OpenIndex() (librarian.SeqSetterIndex, librarian.SinkIndex)
+}
+
+// stored under metafeed+subfeed
+type storedSubFeed struct {
+	Purpose    string `json:"purpose"`
+	Nonce      []byte `json:"nonce,omitempty"`
+	Tombstoned bool   `json:"tombstoned,omitempty"`
+}
+
+// announcements are stored under this prefix and the main feed, the value is the reference of the metafeed
+var announcePrefix = []byte("announce:")
+
+type index struct {
+	kv *badger.DB
+
+	idx     librarian.SeqSetterIndex
+	idxSink librarian.SinkIndex
+
+	log kitlog.Logger
+}
+
+// NewIndex creates an Index that is backed by a badger database
+func NewIndex(log kitlog.Logger, db *badger.DB) IndexingIndex {
+	return &index{
+		kv:  db,
+		idx: libbadger.NewIndex(db, storedSubFeed{}),
+		log: log,
+	}
+}
+
+func (i *index) OpenIndex() (librarian.SeqSetterIndex, librarian.SinkIndex) {
+	if i.idxSink == nil {
+		i.idxSink = librarian.NewSinkIndex(i.indexUpdateFunc, i.idx)
+	}
+	return i.idx, i.idxSink
+}
+
+func (i *index) indexUpdateFunc(ctx context.Context, seq margaret.Seq, val interface{}, idx librarian.SetterIndex) error {
+	if nulled, ok := val.(error); ok {
+		if margaret.IsErrNulled(nulled) {
+			return nil
+		}
+		return nulled
+	}
+
+	msg, ok := val.(refs.Message)
+	if !ok {
+		return errors.Errorf("metafeed/idx: invalid msg value %T", val)
+	}
+
+	c, err := ParseContent(msg.ContentBytes())
+	if err != nil {
+		// not a metafeed message
+		return nil
+	}
+
+	author := msg.Author()
+	switch c.Type {
+	case TypeAnnounce:
+		if author.Algo == ssb.RefAlgoFeedBendyButt {
+			return nil
+		}
+		addr := librarian.Addr(announcePrefix) + ssb.StoredAddr(author)
+		err = idx.Set(ctx, addr, c.MetaFeed.Ref())
+
+	case TypeAdd, TypeTombstone:
+		// only the metafeed itself can change its entries.
+		// the signature of the subfeed was checked by the verification of the message
+		if author.Algo != ssb.RefAlgoFeedBendyButt || !c.MetaFeed.Equal(author) {
+			level.Warn(i.log).Log("msg", "ignoring metafeed message for other feed", "author", author.Ref(), "seq", msg.Seq())
+			return nil
+		}
+		addr := ssb.StoredAddr(author) + ssb.StoredAddr(c.SubFeed)
+
+		if c.Type == TypeAdd {
+			err = idx.Set(ctx, addr, storedSubFeed{
+				Purpose: c.FeedPurpose,
+				Nonce:   c.Nonce,
+			})
+			break
+		}
+
+		var existing storedSubFeed
+		has, getErr := i.get(addr, &existing)
+		if getErr != nil {
+			return getErr
+		}
+		if !has {
+			level.Warn(i.log).Log("msg", "tombstone for unknown subfeed", "author", author.Ref(), "subfeed", c.SubFeed.Ref())
+		}
+		existing.Tombstoned = true
+		err = idx.Set(ctx, addr, existing)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "metafeed/idx: failed to update index for %s:%d", author.Ref(), msg.Seq())
+	}
+	return nil
+}
+
+// get decodes the value stored under addr into v
+func (i *index) get(addr librarian.Addr, v interface{}) (bool, error) {
+	var has bool
+	err := i.kv.View(func(txn *badger.Txn) error {
+		it, err := txn.Get([]byte(addr))
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		has = true
+		return it.Value(func(data []byte) error {
+			return json.Unmarshal(data, v)
+		})
+	})
+	if err != nil {
+		return false, errors.Wrap(err, "metafeed/idx: failed to get entry")
+	}
+	return has, nil
+}
+
+func (i *index) SubFeeds(metafeed *refs.FeedRef) ([]SubFeed, error) {
+	var subfeeds []SubFeed
+	err := i.kv.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		prefix := []byte(ssb.StoredAddr(metafeed))
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			it := iter.Item()
+			k := it.Key()
+
+			sub, err := ssb.FeedRefFromStoredAddr(librarian.Addr(k[len(prefix):]))
+			if err != nil {
+				return errors.Wrapf(err, "metafeed/idx: invalid subfeed entry for %s", metafeed.Ref())
+			}
+
+			var sf storedSubFeed
+			err = it.Value(func(v []byte) error {
+				return json.Unmarshal(v, &sf)
+			})
+			if err != nil {
+				return errors.Wrapf(err, "metafeed/idx: invalid value for %s", sub.Ref())
+			}
+
+			subfeeds = append(subfeeds, SubFeed{
+				Feed:       sub,
+				Purpose:    sf.Purpose,
+				Nonce:      sf.Nonce,
+				Tombstoned: sf.Tombstoned,
+			})
+		}
+		return nil
+	})
+	return subfeeds, err
+}
+
+func (i *index) Announced(main *refs.FeedRef) (*refs.FeedRef, bool, error) {
+	addr := librarian.Addr(announcePrefix) + ssb.StoredAddr(main)
+	var mfRef string
+	has, err := i.get(addr, &mfRef)
+	if err != nil || !has {
+		return nil, false, err
+	}
+	mf, err := ssb.ParseFeedRef(mfRef)
+	if err != nil {
+		return nil, false, errors.Wrap(err, "metafeed/idx: invalid announcement")
+	}
+	return mf, true, nil
+}
+
+// Follow returns all the feeds of the metafeed tree below root which are not tombstoned, including nested metafeeds.
+func Follow(idx Index, root *refs.FeedRef) ([]*refs.FeedRef, error) {
+	var (
+		feeds   []*refs.FeedRef
+		visited = make(map[string]struct{})
+		queue   = []*refs.FeedRef{root}
+	)
+	for len(queue) > 0 {
+		mf := queue[0]
+		queue = queue[1:]
+
+		if _, seen := visited[string(ssb.StoredAddr(mf))]; seen {
+			continue
+		}
+		visited[string(ssb.StoredAddr(mf))] = struct{}{}
+
+		subfeeds, err := idx.SubFeeds(mf)
+		if err != nil {
+			return nil, err
+		}
+		for _, sf := range subfeeds {
+			if sf.Tombstoned {
+				continue
+			}
+			feeds = append(feeds, sf.Feed)
+			if sf.Feed.Algo == ssb.RefAlgoFeedBendyButt {
+				queue = append(queue, sf.Feed)
+			}
+		}
+	}
+	return feeds, nil
+}
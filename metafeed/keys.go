@@ -0,0 +1,118 @@
+// SPDX-License-Identifier: MIT
+
+// Package metafeed implements metafeeds, which let one identity publish multiple feeds for different purposes.
+//
+// The root metafeed uses the bendy butt format and announces its subfeeds with metafeed/add messages,
+// which are signed by the metafeed and the subfeed. Subfeeds can be dropped again with metafeed/tombstone.
+// All the keys are derived from a single seed, so that only it needs to be backed up.
+package metafeed
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/crypto/hkdf"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+const (
+	seedSize  = 64
+	nonceSize = 32
+
+	infoPrefix = "ssb-meta-feed-seed-v1:"
+)
+
+// GenerateSeed returns a new random seed to derive metafeed keys from
+func GenerateSeed() ([]byte, error) {
+	var seed = make([]byte, seedSize)
+	if _, err := io.ReadFull(rand.Reader, seed); err != nil {
+		return nil, errors.Wrap(err, "metafeed: failed to read random seed")
+	}
+	return seed, nil
+}
+
+// LoadOrCreateSeed returns the seed stored in the secrets folder of the repo and creates a new one if there is none
+func LoadOrCreateSeed(r repo.Interface) ([]byte, error) {
+	seedPath := r.GetPath("secrets", "metafeed-seed")
+
+	data, err := ioutil.ReadFile(seedPath)
+	if err == nil {
+		seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, errors.Wrap(err, "metafeed: invalid seed file")
+		}
+		return seed, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "metafeed: failed to read seed file")
+	}
+
+	seed, err := GenerateSeed()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(r.GetPath("secrets"), 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "metafeed: failed to create secrets folder")
+	}
+	err = ioutil.WriteFile(seedPath, []byte(base64.StdEncoding.EncodeToString(seed)), ssb.SecretPerms)
+	if err != nil {
+		return nil, errors.Wrap(err, "metafeed: failed to save seed")
+	}
+	return seed, nil
+}
+
+// DeriveRoot returns the keypair of the root metafeed of the seed
+func DeriveRoot(seed []byte) (*ssb.KeyPair, error) {
+	return derive(seed, infoPrefix+"metafeed", ssb.RefAlgoFeedBendyButt)
+}
+
+// DeriveSubFeed returns the keypair of the subfeed with the passed nonce.
+// algo is the feed format the subfeed should use.
+func DeriveSubFeed(seed, nonce []byte, algo string) (*ssb.KeyPair, error) {
+	if len(nonce) != nonceSize {
+		return nil, errors.Errorf("metafeed: invalid nonce length: %d", len(nonce))
+	}
+	return derive(seed, infoPrefix+base64.StdEncoding.EncodeToString(nonce), algo)
+}
+
+// NewNonce returns random bytes to derive a new subfeed with
+func NewNonce() ([]byte, error) {
+	var nonce = make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "metafeed: failed to read random nonce")
+	}
+	return nonce, nil
+}
+
+func derive(seed []byte, info, algo string) (*ssb.KeyPair, error) {
+	if len(seed) == 0 {
+		return nil, errors.Errorf("metafeed: empty seed")
+	}
+	var derived = make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, seed, []byte("ssb"), []byte(info)), derived)
+	if err != nil {
+		return nil, errors.Wrap(err, "metafeed: key derivation failed")
+	}
+
+	kp, err := ssb.NewKeyPair(bytes.NewReader(derived))
+	if err != nil {
+		return nil, err
+	}
+	kp.Id = &refs.FeedRef{
+		ID:   kp.Id.ID,
+		Algo: algo,
+	}
+	return kp, nil
+}
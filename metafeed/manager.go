@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MIT
+
+package metafeed
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// Manager publishes the root metafeed of a seed and derives, adds and removes its subfeeds
+type Manager struct {
+	mu sync.Mutex
+
+	seed []byte
+	root *ssb.KeyPair
+
+	rootLog  margaret.Log
+	userLogs multilog.MultiLog
+	idx      Index
+	synced   func()
+	pubopts  []message.PublishOption
+
+	publish ssb.Publisher
+
+	// the keypairs and publish logs of the subfeeds, by reference
+	keys       map[string]*ssb.KeyPair
+	publishers map[string]ssb.Publisher
+}
+
+// NewManager derives the root metafeed from seed and opens a publisher for it.
+// synced needs to block until idx has processed the receive log,
+// it is used to look up the subfeeds that were added before the manager was opened.
+func NewManager(seed []byte, rootLog margaret.Log, userLogs multilog.MultiLog, idx Index, synced func(), opts ...message.PublishOption) (*Manager, error) {
+	root, err := DeriveRoot(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	publish, err := message.OpenPublishLog(rootLog, userLogs, root, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "metafeed: failed to open publish log for root")
+	}
+
+	return &Manager{
+		seed: seed,
+		root: root,
+
+		rootLog:  rootLog,
+		userLogs: userLogs,
+		idx:      idx,
+		synced:   synced,
+		pubopts:  opts,
+
+		publish: publish,
+
+		keys:       make(map[string]*ssb.KeyPair),
+		publishers: make(map[string]ssb.Publisher),
+	}, nil
+}
+
+// Root returns the reference of the root metafeed
+func (m *Manager) Root() *refs.FeedRef {
+	return m.root.Id
+}
+
+// SubFeeds lists the subfeeds of the root metafeed
+func (m *Manager) SubFeeds() ([]SubFeed, error) {
+	return m.idx.SubFeeds(m.root.Id)
+}
+
+// CreateSubFeed derives a new feed with the passed format and adds it to the root metafeed for purpose
+func (m *Manager) CreateSubFeed(purpose, algo string) (*ssb.KeyPair, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nonce, err := NewNonce()
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := DeriveSubFeed(m.seed, nonce, algo)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := NewAddContent(m.root.Id, sub, purpose, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := m.publish.Publish(content); err != nil {
+		return nil, errors.Wrap(err, "metafeed: failed to publish add message")
+	}
+	m.keys[sub.Id.Ref()] = sub
+	return sub, nil
+}
+
+// Tombstone marks the subfeed as ended on the root metafeed
+func (m *Manager) Tombstone(subfeed *refs.FeedRef, reason string) error {
+	sub, err := m.KeyPair(subfeed)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	content, err := NewTombstoneContent(m.root.Id, sub, reason)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.publish.Publish(content)
+	return errors.Wrap(err, "metafeed: failed to publish tombstone message")
+}
+
+// KeyPair returns the keypair of the subfeed.
+// Subfeeds that were created before the manager was opened are derived again, using the nonce from the add message.
+func (m *Manager) KeyPair(subfeed *refs.FeedRef) (*ssb.KeyPair, error) {
+	m.mu.Lock()
+	kp, has := m.keys[subfeed.Ref()]
+	m.mu.Unlock()
+	if has {
+		return kp, nil
+	}
+
+	// the add message might not be indexed yet
+	if m.synced != nil {
+		m.synced()
+	}
+	subfeeds, err := m.SubFeeds()
+	if err != nil {
+		return nil, err
+	}
+	for _, sf := range subfeeds {
+		if !sf.Feed.Equal(subfeed) {
+			continue
+		}
+		kp, err := DeriveSubFeed(m.seed, sf.Nonce, subfeed.Algo)
+		if err != nil {
+			return nil, err
+		}
+		m.mu.Lock()
+		m.keys[subfeed.Ref()] = kp
+		m.mu.Unlock()
+		return kp, nil
+	}
+	return nil, errors.Errorf("metafeed: %s is not a subfeed of %s", subfeed.Ref(), m.root.Id.Ref())
+}
+
+// PublishAs publishes content on one of the subfeeds
+func (m *Manager) PublishAs(subfeed *refs.FeedRef, content interface{}) (*refs.MessageRef, error) {
+	sub, err := m.KeyPair(subfeed)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	pl, has := m.publishers[sub.Id.Ref()]
+	if !has {
+		pl, err = message.OpenPublishLog(m.rootLog, m.userLogs, sub, m.pubopts...)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, errors.Wrap(err, "metafeed: failed to open publish log for subfeed")
+		}
+		m.publishers[sub.Id.Ref()] = pl
+	}
+	m.mu.Unlock()
+	return pl.Publish(content)
+}
+
+// Announce publishes a metafeed/announce message for the root metafeed on the main feed,
+// so that peers which replicate it also find the subfeeds.
+func (m *Manager) Announce(main ssb.Publisher) (*refs.MessageRef, error) {
+	return main.Publish(NewAnnouncement(m.root.Id))
+}
@@ -0,0 +1,159 @@
+// SPDX-License-Identifier: MIT
+
+package metafeed
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/message/bendybutt"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestDerive(t *testing.T) {
+	r := require.New(t)
+
+	seed := bytes.Repeat([]byte("seed"), 16)
+
+	root, err := DeriveRoot(seed)
+	r.NoError(err)
+	r.Equal(ssb.RefAlgoFeedBendyButt, root.Id.Algo)
+
+	again, err := DeriveRoot(seed)
+	r.NoError(err)
+	r.True(root.Id.Equal(again.Id), "not deterministic")
+
+	nonce := bytes.Repeat([]byte{1}, 32)
+	sub, err := DeriveSubFeed(seed, nonce, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	r.Equal(refs.RefAlgoFeedGabby, sub.Id.Algo)
+	r.NotEqual(root.Id.ID, sub.Id.ID)
+
+	otherNonce := bytes.Repeat([]byte{2}, 32)
+	other, err := DeriveSubFeed(seed, otherNonce, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	r.NotEqual(sub.Id.ID, other.Id.ID)
+
+	_, err = DeriveSubFeed(seed, []byte("short"), refs.RefAlgoFeedSSB1)
+	r.Error(err)
+
+	mfRef, err := ssb.ParseFeedRef(root.Id.Ref())
+	r.NoError(err)
+	r.True(mfRef.Equal(root.Id))
+}
+
+func TestIndex(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	tRepoPath, err := ioutil.TempDir("", "metafeedIdx")
+	r.NoError(err)
+	defer os.RemoveAll(tRepoPath)
+
+	var idx *index
+	_, setter, _, err := repo.OpenBadgerIndex(repo.New(tRepoPath), "metafeeds", func(db *badger.DB) (librarian.SeqSetterIndex, librarian.SinkIndex) {
+		idx = NewIndex(testutils.NewRelativeTimeLogger(nil), db).(*index)
+		return idx.OpenIndex()
+	})
+	r.NoError(err)
+	defer setter.Close()
+
+	seed := bytes.Repeat([]byte("seed"), 16)
+	root, err := DeriveRoot(seed)
+	r.NoError(err)
+	enc := bendybutt.NewEncoder(root.Pair.Secret)
+
+	var (
+		seq  int64
+		prev *refs.MessageRef
+	)
+	publish := func(content *bendybutt.SignedContent) {
+		seq++
+		msg, err := enc.Encode(seq, prev, content)
+		r.NoError(err)
+		prev = msg.Key()
+		err = idx.indexUpdateFunc(ctx, margaret.BaseSeq(seq-1), msg, idx.idx)
+		r.NoError(err)
+	}
+
+	var subfeeds []*ssb.KeyPair
+	for i, purpose := range []string{"main", "games", "nested"} {
+		nonce := bytes.Repeat([]byte{byte(i)}, 32)
+		algo := refs.RefAlgoFeedSSB1
+		if purpose == "nested" {
+			algo = ssb.RefAlgoFeedBendyButt
+		}
+		sub, err := DeriveSubFeed(seed, nonce, algo)
+		r.NoError(err)
+		subfeeds = append(subfeeds, sub)
+
+		content, err := NewAddContent(root.Id, sub, purpose, nonce)
+		r.NoError(err)
+		publish(content)
+	}
+
+	// add a feed to the nested metafeed
+	nestedEnc := bendybutt.NewEncoder(subfeeds[2].Pair.Secret)
+	nonce := bytes.Repeat([]byte{9}, 32)
+	deep, err := DeriveSubFeed(seed, nonce, refs.RefAlgoFeedGabby)
+	r.NoError(err)
+	content, err := NewAddContent(subfeeds[2].Id, deep, "deep", nonce)
+	r.NoError(err)
+	nestedMsg, err := nestedEnc.Encode(1, nil, content)
+	r.NoError(err)
+	r.NoError(idx.indexUpdateFunc(ctx, margaret.BaseSeq(100), nestedMsg, idx.idx))
+
+	listed, err := idx.SubFeeds(root.Id)
+	r.NoError(err)
+	r.Len(listed, 3)
+	for _, sf := range listed {
+		r.False(sf.Tombstoned)
+		r.Len(sf.Nonce, 32)
+	}
+
+	followed, err := Follow(idx, root.Id)
+	r.NoError(err)
+	r.Len(followed, 4)
+
+	// tombstone the games feed
+	content, err = NewTombstoneContent(root.Id, subfeeds[1], "bored")
+	r.NoError(err)
+	publish(content)
+
+	listed, err = idx.SubFeeds(root.Id)
+	r.NoError(err)
+	r.Len(listed, 3)
+	for _, sf := range listed {
+		r.Equal(sf.Feed.Equal(subfeeds[1].Id), sf.Tombstoned, "wrong state for %s", sf.Purpose)
+	}
+
+	followed, err = Follow(idx, root.Id)
+	r.NoError(err)
+	r.Len(followed, 3)
+	for _, f := range followed {
+		r.False(f.Equal(subfeeds[1].Id))
+	}
+
+	// another metafeed can't add to ours
+	intruder := bendybutt.NewEncoder(deep.Pair.Secret)
+	content, err = NewAddContent(root.Id, subfeeds[1], "hijack", nonce)
+	r.NoError(err)
+	msg, err := intruder.Encode(1, nil, content)
+	r.NoError(err)
+	r.NoError(idx.indexUpdateFunc(ctx, margaret.BaseSeq(101), msg, idx.idx))
+
+	listed, err = idx.SubFeeds(root.Id)
+	r.NoError(err)
+	r.Len(listed, 3)
+}
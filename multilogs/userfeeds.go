@@ -9,6 +9,7 @@ import (
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/repo"
 	refs "go.mindeco.de/ssb-refs"
 )
@@ -41,7 +42,7 @@ func UserFeedsUpdate(ctx context.Context, seq margaret.Seq, value interface{}, m
 		panic("passed nil mlog")
 	}
 
-	authorLog, err := mlog.Get(author.StoredAddr())
+	authorLog, err := mlog.Get(ssb.StoredAddr(author))
 	if err != nil {
 		return errors.Wrap(err, "error opening sublog")
 	}
@@ -34,6 +34,9 @@ type Network interface {
 	// websock hack
 	HandleHTTP(handler http.Handler)
 
+	// StopAccepting closes the listeners but keeps the established connections, for draining before Close
+	StopAccepting()
+
 	io.Closer
 }
 
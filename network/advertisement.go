@@ -0,0 +1,148 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// advertisement is the payload of a LAN discovery packet.
+// It holds one or more multiserver addresses of the same peer, separated by semicolons, like
+// net:192.168.1.2:8008~shs:<key>;net:[fe80::2]:8008~shs:<key>;ws://192.168.1.2:8989~shs:<key>
+type advertisement struct {
+	ref *refs.FeedRef
+
+	net []*net.TCPAddr
+	ws  []*net.TCPAddr
+}
+
+func (adv advertisement) String() string {
+	key := base64.StdEncoding.EncodeToString(adv.ref.PubKey())
+
+	var parts []string
+	for _, a := range adv.net {
+		parts = append(parts, "net:"+joinHostPort(a)+"~shs:"+key)
+	}
+	for _, a := range adv.ws {
+		parts = append(parts, "ws://"+joinHostPort(a)+"~shs:"+key)
+	}
+	return strings.Join(parts, ";")
+}
+
+// the zone is only meaningful on the sending host
+func joinHostPort(a *net.TCPAddr) string {
+	return net.JoinHostPort(a.IP.String(), strconv.Itoa(a.Port))
+}
+
+// parseAdvertisement skips transports it doesn't know but needs all addresses to be for the same key.
+func parseAdvertisement(data []byte) (*advertisement, error) {
+	var adv advertisement
+	for _, part := range strings.Split(strings.TrimSpace(string(data)), ";") {
+		tildeIdx := strings.Index(part, "~")
+		if tildeIdx < 0 {
+			return nil, errors.Errorf("advertisement: no protocol in %q", part)
+		}
+		transport, protocol := part[:tildeIdx], part[tildeIdx+1:]
+
+		if !strings.HasPrefix(protocol, "shs:") {
+			continue
+		}
+		ref, err := refs.ParseFeedRef("@" + strings.TrimPrefix(protocol, "shs:") + ".ed25519")
+		if err != nil {
+			return nil, errors.Wrapf(err, "advertisement: invalid key in %q", part)
+		}
+		if adv.ref == nil {
+			adv.ref = ref
+		} else if !adv.ref.Equal(ref) {
+			return nil, errors.Errorf("advertisement: addresses for different keys")
+		}
+
+		switch {
+		case strings.HasPrefix(transport, "net:"):
+			addr, err := parseHostPort(strings.TrimPrefix(transport, "net:"))
+			if err != nil {
+				return nil, errors.Wrapf(err, "advertisement: invalid net address in %q", part)
+			}
+			adv.net = append(adv.net, addr)
+
+		case strings.HasPrefix(transport, "ws://"):
+			addr, err := parseHostPort(strings.TrimSuffix(strings.TrimPrefix(transport, "ws://"), "/"))
+			if err != nil {
+				return nil, errors.Wrapf(err, "advertisement: invalid ws address in %q", part)
+			}
+			adv.ws = append(adv.ws, addr)
+		}
+	}
+	if adv.ref == nil {
+		return nil, errors.Errorf("advertisement: no shs address")
+	}
+	return &adv, nil
+}
+
+// parseHostPort takes the bracketed form of IPv6 addresses as well as the plain one JS peers send
+func parseHostPort(s string) (*net.TCPAddr, error) {
+	var host, port string
+	if strings.HasPrefix(s, "[") {
+		var err error
+		host, port, err = net.SplitHostPort(s)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		i := strings.LastIndex(s, ":")
+		if i < 0 {
+			return nil, errors.Errorf("missing port in %q", s)
+		}
+		host, port = s[:i], s[i+1:]
+	}
+
+	var addr net.TCPAddr
+	if i := strings.Index(host, "%"); i >= 0 {
+		host, addr.Zone = host[:i], host[i+1:]
+	}
+	addr.IP = net.ParseIP(host)
+	if addr.IP == nil {
+		return nil, errors.Errorf("not an IP address: %q", host)
+	}
+	var err error
+	addr.Port, err = strconv.Atoi(port)
+	if err != nil || addr.Port <= 0 || addr.Port > 65535 {
+		return nil, errors.Errorf("invalid port: %q", port)
+	}
+	return &addr, nil
+}
+
+// pick returns the net address to dial for an advertisement that was received from src.
+// Only the sender itself is dialed: with the port of the address that has its IP or,
+// if it didn't list that one (like the link-local address an IPv6 packet was sent from), the first one of the same family.
+// Link-local IPv6 addresses get the zone of the interface the packet came in on.
+func (adv advertisement) pick(src *net.UDPAddr) (*net.TCPAddr, bool) {
+	var picked *net.TCPAddr
+	for _, a := range adv.net {
+		if a.IP.Equal(src.IP) {
+			picked = a
+			break
+		}
+		if picked == nil && isIPv4(a.IP) == isIPv4(src.IP) {
+			picked = a
+		}
+	}
+	if picked == nil {
+		return nil, false
+	}
+
+	dial := &net.TCPAddr{
+		IP:   src.IP,
+		Port: picked.Port,
+	}
+	if dial.IP.IsLinkLocalUnicast() && !isIPv4(dial.IP) {
+		dial.Zone = src.Zone
+	}
+	return dial, true
+}
@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "LtQ3tOuLoeQFi5s/ic7U6wDBxWS3t2yxauc4/AwqfWc="
+
+func TestParseAdvertisement(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	kp := makeTestPubKey(t)
+
+	type tcase struct {
+		msg string
+		net []string
+		ws  []string
+	}
+	cases := []tcase{
+		{
+			msg: "net:192.168.1.2:8008~shs:" + testKey,
+			net: []string{"192.168.1.2:8008"},
+		},
+		{
+			// bracketed and plain IPv6, like JS peers send it
+			msg: "net:[fe80::2]:8008~shs:" + testKey + ";net:fd00::2:8009~shs:" + testKey,
+			net: []string{"[fe80::2]:8008", "[fd00::2]:8009"},
+		},
+		{
+			msg: "net:192.168.1.2:8008~shs:" + testKey + ";ws://192.168.1.2:8989~shs:" + testKey,
+			net: []string{"192.168.1.2:8008"},
+			ws:  []string{"192.168.1.2:8989"},
+		},
+		{
+			// unknown transports and protocols are skipped
+			msg: "onion:abc.onion:8008~shs:" + testKey + ";net:10.0.0.1:8008~noauth;net:10.0.0.2:8008~shs:" + testKey,
+			net: []string{"10.0.0.2:8008"},
+		},
+	}
+
+	for i, tc := range cases {
+		adv, err := parseAdvertisement([]byte(tc.msg))
+		r.NoError(err, "case %d", i)
+		a.True(adv.ref.Equal(kp.Id), "case %d", i)
+
+		var gotNet, gotWS []string
+		for _, n := range adv.net {
+			gotNet = append(gotNet, n.String())
+		}
+		for _, w := range adv.ws {
+			gotWS = append(gotWS, w.String())
+		}
+		a.Equal(tc.net, gotNet, "case %d", i)
+		a.Equal(tc.ws, gotWS, "case %d", i)
+
+		// formatting and parsing again gives the same addresses
+		again, err := parseAdvertisement([]byte(adv.String()))
+		r.NoError(err, "case %d", i)
+		a.Equal(adv.String(), again.String(), "case %d", i)
+	}
+
+	other := makeRandPubkey(t)
+	invalid := []string{
+		"",
+		"net:192.168.1.2:8008",
+		"net:192.168.1.2~shs:" + testKey,
+		"net:nope:8008~shs:" + testKey,
+		"net:192.168.1.2:99999~shs:" + testKey,
+		"net:192.168.1.2:8008~shs:" + testKey + ";net:192.168.1.3:8008~shs:" + base64.StdEncoding.EncodeToString(other.Id.PubKey()),
+		"net:192.168.1.2:8008~shs:garbage",
+	}
+	for _, msg := range invalid {
+		_, err := parseAdvertisement([]byte(msg))
+		a.Error(err, "%q", msg)
+	}
+}
+
+func TestAdvertisementPick(t *testing.T) {
+	a := assert.New(t)
+
+	adv, err := parseAdvertisement([]byte("net:192.168.1.2:8008~shs:" + testKey + ";net:[fd00::2]:8009~shs:" + testKey + ";net:[fe80::2]:8010~shs:" + testKey))
+	require.NoError(t, err)
+
+	type tcase struct {
+		src  *net.UDPAddr
+		want string
+	}
+	cases := []tcase{
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 8008}, "192.168.1.2:8008"},
+		{&net.UDPAddr{IP: net.ParseIP("fd00::2"), Port: 8008}, "[fd00::2]:8009"},
+		{&net.UDPAddr{IP: net.ParseIP("fe80::2"), Port: 8008, Zone: "eth0"}, "[fe80::2%eth0]:8010"},
+		// a source that isn't listed is dialed with the port of its family, never the claimed address
+		{&net.UDPAddr{IP: net.ParseIP("fe80::3"), Port: 8008, Zone: "wlan0"}, "[fe80::3%wlan0]:8009"},
+		{&net.UDPAddr{IP: net.ParseIP("10.0.0.5"), Port: 8008}, "10.0.0.5:8008"},
+	}
+	for _, tc := range cases {
+		got, ok := adv.pick(tc.src)
+		if a.True(ok, "%s", tc.src) {
+			a.Equal(tc.want, got.String(), "%s", tc.src)
+		}
+	}
+
+	v4only, err := parseAdvertisement([]byte("net:192.168.1.2:8008~shs:" + testKey))
+	require.NoError(t, err)
+	_, ok := v4only.pick(&net.UDPAddr{IP: net.ParseIP("fe80::2"), Zone: "eth0"})
+	a.False(ok)
+}
+
+func TestPlanAdvertisements(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	kp := makeTestPubKey(t)
+
+	mustNet := func(cidr string) *net.IPNet {
+		ip, n, err := net.ParseCIDR(cidr)
+		r.NoError(err)
+		n.IP = ip
+		return n
+	}
+
+	ifcs := []lanInterface{
+		{name: "eth0", addrs: []*net.IPNet{mustNet("192.168.1.2/24"), mustNet("fd00::2/64"), mustNet("fe80::2/64")}},
+		{name: "wlan0", addrs: []*net.IPNet{mustNet("10.1.2.3/8"), mustNet("10.9.9.9/32")}},
+		{name: "v6only", addrs: []*net.IPNet{mustNet("fe80::7/64")}},
+	}
+
+	plan := planAdvertisements(ifcs, kp, 8008, 8989)
+
+	type sent struct{ src, dst string }
+	var got []sent
+	for _, p := range plan {
+		got = append(got, sent{p.src.String(), p.dst.String()})
+	}
+	a.Equal([]sent{
+		{"192.168.1.2:8008", "192.168.1.255:8008"},
+		{"[fe80::2%eth0]:8008", "[ff02::1%eth0]:8008"},
+		{"10.1.2.3:8008", "10.255.255.255:8008"},
+		{"10.9.9.9:8008", "255.255.255.255:8008"},
+		{"[fe80::7%v6only]:8008", "[ff02::1%v6only]:8008"},
+	}, got)
+
+	// everything sent on an interface lists all of its addresses
+	a.Equal("net:192.168.1.2:8008~shs:"+testKey+
+		";net:[fd00::2]:8008~shs:"+testKey+
+		";net:[fe80::2]:8008~shs:"+testKey+
+		";ws://192.168.1.2:8989~shs:"+testKey+
+		";ws://[fd00::2]:8989~shs:"+testKey+
+		";ws://[fe80::2]:8989~shs:"+testKey, plan[0].msg)
+	a.Equal(plan[0].msg, plan[1].msg)
+
+	// without a websocket listener
+	plan = planAdvertisements(ifcs[2:], kp, 8008, 0)
+	r.Len(plan, 1)
+	a.Equal("net:[fe80::7]:8008~shs:"+testKey, plan[0].msg)
+}
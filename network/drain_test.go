@@ -0,0 +1,47 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestStopAcceptingWebsocket(t *testing.T) {
+	r := require.New(t)
+
+	kp, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	nw, err := New(Options{
+		Logger:  log.NewNopLogger(),
+		KeyPair: kp,
+		AppKey:  make([]byte, 32),
+		MakeHandler: func(net.Conn) (muxrpc.Handler, error) {
+			return nil, errors.New("not in this test")
+		},
+		WebsocketAddr: "localhost:0",
+	})
+	r.NoError(err)
+	addr := nw.(*node).httpLis.Addr().String()
+
+	resp, err := http.Get("http://" + addr + "/other")
+	r.NoError(err)
+	resp.Body.Close()
+
+	nw.StopAccepting()
+
+	_, err = net.Dial("tcp", addr)
+	r.Error(err, "websocket listener still open")
+
+	// the listener is closed once
+	r.NoError(nw.Close())
+}
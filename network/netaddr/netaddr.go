@@ -0,0 +1,38 @@
+// SPDX-License-Identifier: MIT
+
+// Package netaddr has the address types of the network package that others need without importing all of it, like invite tokens.
+package netaddr
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// HostAddr is a tcp address that keeps its host name instead of resolving it,
+// for addresses that are given to others, like the one of a pub in invites and pub messages.
+type HostAddr struct {
+	Host string
+	Port int
+}
+
+func (a HostAddr) Network() string { return "tcp" }
+func (a HostAddr) String() string  { return net.JoinHostPort(a.Host, strconv.Itoa(a.Port)) }
+
+// OnionNetwork is the network of OnionAddr, use it as the key for network.Options.Dialers
+const OnionNetwork = "onion"
+
+// OnionAddr is the address of a Tor onion service.
+// It can't be resolved locally and needs to be dialed through a SOCKS5 proxy, see network.SOCKS5Dialer.
+type OnionAddr struct {
+	Host string
+	Port int
+}
+
+func (a OnionAddr) Network() string { return OnionNetwork }
+func (a OnionAddr) String() string  { return net.JoinHostPort(a.Host, strconv.Itoa(a.Port)) }
+
+// IsOnionHost checks if host is the name of an onion service
+func IsOnionHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), ".onion")
+}
@@ -3,46 +3,41 @@
 package network
 
 import (
-	"encoding/base64"
 	"fmt"
 	"log"
 	"net"
 	"os"
-	"strconv"
 	"time"
 
 	"github.com/libp2p/go-reuseport"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/ssb"
-	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
 type Advertiser struct {
 	keyPair *ssb.KeyPair
 
-	local  *net.UDPAddr // Local listening address, may not be needed (auto-detect?).
-	remote *net.UDPAddr // Address being broadcasted to, this should be deduced form 'local'.
+	local *net.UDPAddr // Local listening address, may not be needed (auto-detect?).
+	ws    int          // the port of the websocket listener, if there is one
+
+	// returns the interfaces to advertise on, replaced in tests
+	interfaces func(local *net.UDPAddr) ([]lanInterface, error)
 
 	waitTime time.Duration
 	ticker   *time.Ticker
 }
 
-func newPublicKeyString(keyPair *ssb.KeyPair) string {
-	publicKey := keyPair.Pair.Public[:]
-	return base64.StdEncoding.EncodeToString(publicKey)
-}
-
 func newAdvertisement(local *net.UDPAddr, keyPair *ssb.KeyPair) (string, error) {
 	if local == nil {
 		return "", errors.Errorf("ssb: passed nil local address")
 	}
 
-	withoutZone := *local
-	withoutZone.Zone = ""
-
-	// crunchy way of making a https://github.com/ssbc/multiserver/
-	msg := fmt.Sprintf("net:%s~shs:%s", &withoutZone, newPublicKeyString(keyPair))
-	_, err := multiserver.ParseNetAddress([]byte(msg))
+	adv := advertisement{
+		ref: keyPair.Id,
+		net: []*net.TCPAddr{{IP: local.IP, Port: local.Port}},
+	}
+	msg := adv.String()
+	_, err := parseAdvertisement([]byte(msg))
 	return msg, err
 }
 
@@ -64,85 +59,159 @@ func NewAdvertiser(local net.Addr, keyPair *ssb.KeyPair) (*Advertiser, error) {
 	}
 	log.Printf("adverstiser using local address %s", udpAddr)
 
-	remote, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", net.IPv4bcast, DefaultPort))
-	if err != nil {
-		return nil, errors.Wrap(err, "ssb/NewAdvertiser: failed to resolve v4 broadcast addr")
-	}
-
 	return &Advertiser{
-		local:    udpAddr,
-		remote:   remote,
-		waitTime: time.Second * 45,
-		keyPair:  keyPair,
+		local:      udpAddr,
+		interfaces: lanInterfaces,
+		waitTime:   time.Second * 45,
+		keyPair:    keyPair,
 	}, nil
 }
 
-func (b *Advertiser) advertise() error {
-	localAddresses, err := findSiteLocalNetworkAddresses(b.local)
+// AdvertiseWebsocket adds ws:// addresses with the port of addr to the advertisements
+func (b *Advertiser) AdvertiseWebsocket(addr net.Addr) error {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return errors.Errorf("node Advertise: invalid websocket address type: %T", addr)
+	}
+	b.ws = tcpAddr.Port
+	return nil
+}
+
+// lanInterface is a network interface with the site-local addresses that are advertised on it
+type lanInterface struct {
+	name  string
+	addrs []*net.IPNet
+}
+
+// lanInterfaces returns the interfaces that are up and have site-local addresses.
+// If local isn't the unspecified address, it only returns the interface with that address.
+func lanInterfaces(local *net.UDPAddr) ([]lanInterface, error) {
+	netIfs, err := net.Interfaces()
 	if err != nil {
-		return errors.Wrap(err, "ssb: failed to make new advertisment")
+		return nil, err
 	}
 
-	for _, localAddress := range localAddresses {
-		// log.Print("DBG23: using", localAddress)
-		var localUDP = new(net.UDPAddr)
-		// carry port from address or use default one
-		switch v := localAddress.(type) {
-		case *net.IPAddr:
-			localUDP.IP = v.IP
-			if !isIPv4(v.IP) {
-				localUDP.Zone = v.Zone
-			}
-			localUDP.Port = DefaultPort
-		case *net.IPNet:
-			localUDP.IP = v.IP
-			localUDP.Port = DefaultPort
-		case *net.TCPAddr:
-			localUDP.IP = v.IP
-			localUDP.Port = v.Port
-		case *net.UDPAddr:
-			localUDP.IP = v.IP
-			localUDP.Port = v.Port
-		default:
-			return errors.Errorf("cannot get Port for network type %s", localAddress.Network())
+	var ret []lanInterface
+	for _, netIf := range netIfs {
+		if netIf.Flags&net.FlagUp == 0 || netIf.Flags&net.FlagLoopback != 0 {
+			continue
 		}
 
-		broadcastAddress, err := localBroadcastAddress(localAddress)
+		addrs, err := netIf.Addrs()
 		if err != nil {
-			return errors.Wrap(err, "ssb: failed to find site local address broadcast address")
+			return nil, err
 		}
-		dstStr := net.JoinHostPort(broadcastAddress, strconv.Itoa(DefaultPort))
-		remoteUDP, err := net.ResolveUDPAddr("udp", dstStr)
-		if err != nil {
-			return errors.Wrapf(err, "ssb: failed to resolve broadcast dest addr for advertiser: %s", dstStr)
+
+		lanIf := lanInterface{name: netIf.Name}
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+			if local.IP != nil && !local.IP.IsUnspecified() && !local.IP.Equal(ipNet.IP) {
+				continue
+			}
+			if siteLocal, _ := isNetworkAddressSiteLocal(ipNet); siteLocal {
+				lanIf.addrs = append(lanIf.addrs, ipNet)
+			}
 		}
+		if len(lanIf.addrs) > 0 {
+			ret = append(ret, lanIf)
+		}
+	}
+	return ret, nil
+}
 
-		msg, err := newAdvertisement(
-			localUDP,
-			b.keyPair,
-		)
-		if err != nil {
-			return err
+// outgoingAdvertisement is a packet to send from src to the broadcast or multicast address dst
+type outgoingAdvertisement struct {
+	src, dst *net.UDPAddr
+	msg      string
+}
+
+// planAdvertisements makes one advertisement per interface, with all of its addresses.
+// It is sent to the broadcast address of each IPv4 subnet and once to the link-local all-nodes multicast group for IPv6.
+func planAdvertisements(ifcs []lanInterface, keyPair *ssb.KeyPair, port, wsPort int) []outgoingAdvertisement {
+	var out []outgoingAdvertisement
+	for _, ifc := range ifcs {
+		adv := advertisement{ref: keyPair.Id}
+		for _, a := range ifc.addrs {
+			adv.net = append(adv.net, &net.TCPAddr{IP: a.IP, Port: port})
 		}
-		broadcastConn, err := reuseport.Dial("udp", localUDP.String(), remoteUDP.String())
-		if err != nil {
-			// err = errors.Wrap(err, "adv dial failed")
-			// log.Println("debug,cont:", err)
-			continue
+		if wsPort > 0 {
+			for _, a := range ifc.addrs {
+				adv.ws = append(adv.ws, &net.TCPAddr{IP: a.IP, Port: wsPort})
+			}
 		}
-		_, err = fmt.Fprint(broadcastConn, msg)
+		msg := adv.String()
+
+		var v6src *net.UDPAddr
+		for _, a := range ifc.addrs {
+			if isIPv4(a.IP) {
+				out = append(out, outgoingAdvertisement{
+					src: &net.UDPAddr{IP: a.IP, Port: port},
+					dst: &net.UDPAddr{IP: ipv4Broadcast(a), Port: DefaultPort},
+					msg: msg,
+				})
+				continue
+			}
+			// prefer the link-local address, that is what receivers see as the source anyway
+			if v6src == nil || (a.IP.IsLinkLocalUnicast() && !v6src.IP.IsLinkLocalUnicast()) {
+				v6src = &net.UDPAddr{IP: a.IP, Port: port, Zone: ifc.name}
+			}
+		}
+		if v6src != nil {
+			out = append(out, outgoingAdvertisement{
+				src: v6src,
+				dst: &net.UDPAddr{IP: net.IPv6linklocalallnodes, Port: DefaultPort, Zone: ifc.name},
+				msg: msg,
+			})
+		}
+	}
+	return out
+}
+
+// ipv4Broadcast returns the directed broadcast address of the subnet,
+// or the limited broadcast address for subnets that don't have one
+func ipv4Broadcast(n *net.IPNet) net.IP {
+	ip := n.IP.To4()
+	ones, bits := n.Mask.Size()
+	if ip == nil || bits == 0 || bits-ones < 2 {
+		return net.IPv4bcast
+	}
+	mask := n.Mask
+	if len(mask) == net.IPv6len {
+		mask = mask[12:]
+	}
+	bcast := make(net.IP, net.IPv4len)
+	for i := range ip {
+		bcast[i] = ip[i] | ^mask[i]
+	}
+	return bcast
+}
+
+func (b *Advertiser) advertise() error {
+	ifcs, err := b.interfaces(b.local)
+	if err != nil {
+		return errors.Wrap(err, "ssb: failed to find interfaces to advertise on")
+	}
+
+	port := b.local.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	for _, adv := range planAdvertisements(ifcs, b.keyPair, port, b.ws) {
+		broadcastConn, err := reuseport.Dial("udp", adv.src.String(), adv.dst.String())
 		if err != nil {
-			// err = errors.Wrapf(err, "adv send of msg failed")
 			// log.Println("debug,cont:", err)
 			continue
 		}
+		_, err = fmt.Fprint(broadcastConn, adv.msg)
 		_ = broadcastConn.Close()
 		if err != nil {
-			// err = errors.Wrapf(err, "close of con failed")
 			// log.Println("debug,cont:", err)
 			continue
 		}
-		// log.Println("adv pkt sent:", msg)
 	}
 	return nil
 }
@@ -150,7 +219,6 @@ func (b *Advertiser) advertise() error {
 func (b *Advertiser) Start() {
 	b.ticker = time.NewTicker(b.waitTime)
 	// TODO: notice interface changes
-	// net.IPv6linklocalallnodes
 
 	go func() {
 		for range b.ticker.C {
@@ -14,14 +14,12 @@ import (
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/secretstream"
 	"go.cryptoscope.co/ssb"
-	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
 type Discoverer struct {
 	local *ssb.KeyPair // to ignore our own
 
-	rx4 net.PacketConn
-	rx6 net.PacketConn
+	rx []net.PacketConn
 
 	brLock    sync.Mutex
 	brodcasts map[int]chan net.Addr
@@ -35,29 +33,39 @@ func NewDiscoverer(local *ssb.KeyPair) (*Discoverer, error) {
 	return d, d.start()
 }
 
+// start listens for IPv4 broadcasts and joins the IPv6 link-local all-nodes group on every multicast interface.
+// Hosts without one of the two families only need the other.
 func (d *Discoverer) start() error {
-
-	var err error
-	d.rx4, err = makePktConn("udp4")
-	if err != nil {
-		return err
+	rx4, err4 := makePktConn("udp4")
+	if err4 == nil {
+		d.rx = append(d.rx, rx4)
 	}
 
-	d.rx6, err = makePktConn("udp6")
-	if err != nil {
-		return err
+	rx6, err6 := makeMulticastConns()
+	if err6 != nil || len(rx6) == 0 {
+		// fall back to the unspecified address, which also gets multicasts without joining them explicitly
+		var rx net.PacketConn
+		rx, err6 = makePktConn("udp6")
+		if err6 == nil {
+			rx6 = []net.PacketConn{rx}
+		}
 	}
+	d.rx = append(d.rx, rx6...)
 
-	go d.work(d.rx4)
-	go d.work(d.rx6)
+	if len(d.rx) == 0 {
+		return errors.Errorf("ssb: discovery failed to listen (v4: %v, v6: %v)", err4, err6)
+	}
 
+	for _, rx := range d.rx {
+		go d.work(rx)
+	}
 	return nil
 }
 
 func makePktConn(n string) (net.PacketConn, error) {
 	lis, err := reuseport.ListenPacket(n, fmt.Sprintf(":%d", DefaultPort))
 	if err != nil {
-		return nil, errors.Wrap(err, "ssb: adv start failed to listen on v4 broadcast")
+		return nil, errors.Wrapf(err, "ssb: adv start failed to listen on %s broadcast", n)
 	}
 	switch v := lis.(type) {
 	case *net.UDPConn:
@@ -67,11 +75,36 @@ func makePktConn(n string) (net.PacketConn, error) {
 	}
 }
 
+func makeMulticastConns() ([]net.PacketConn, error) {
+	netIfs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	group := &net.UDPAddr{IP: net.IPv6linklocalallnodes, Port: DefaultPort}
+
+	var conns []net.PacketConn
+	for i := range netIfs {
+		netIf := netIfs[i]
+		if netIf.Flags&net.FlagUp == 0 || netIf.Flags&net.FlagMulticast == 0 || netIf.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		conn, err := net.ListenMulticastUDP("udp6", &netIf, group)
+		if err != nil {
+			// no IPv6 on this interface
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
 func (d *Discoverer) work(rx net.PacketConn) {
 
 	for {
 		rx.SetReadDeadline(time.Now().Add(time.Second * 1))
-		buf := make([]byte, 128)
+		// room for a couple of addresses
+		buf := make([]byte, 1024)
 		n, addr, err := rx.ReadFrom(buf)
 		if err != nil {
 			if !os.IsTimeout(err) {
@@ -83,29 +116,30 @@ func (d *Discoverer) work(rx net.PacketConn) {
 
 		buf = buf[:n] // strip of zero bytes
 		// log.Printf("dbg adv raw: %q", string(buf))
-		na, err := multiserver.ParseNetAddress(buf)
+		adv, err := parseAdvertisement(buf)
 		if err != nil {
 			// log.Println("rx adv err", err.Error())
-			// TODO: _could_ try to get key out if just ws://[::]~shs:... and dial pkt origin
 			continue
 		}
 
-		if na.Ref.Equal(d.local.Id) {
+		if adv.ref.Equal(d.local.Id) {
 			continue
 		}
 
-		ua := addr.(*net.UDPAddr)
-
-		// skip advertisments not from source
-		if !ua.IP.Equal(na.Addr.IP) {
+		ua, ok := addr.(*net.UDPAddr)
+		if !ok {
 			continue
 		}
 
-		na.Addr.Zone = ua.Zone
+		// only dial back the sender of the packet
+		dialAddr, ok := adv.pick(ua)
+		if !ok {
+			continue
+		}
 
-		// fmt.Printf("[localadv debug] %s (claimed:%s) %s\n", addr.String(), na.Addr.String(), na.Ref.Ref())
+		// fmt.Printf("[localadv debug] %s (dialing:%s) %s\n", addr.String(), dialAddr.String(), adv.ref.Ref())
 
-		wrappedAddr := netwrap.WrapAddr(&na.Addr, secretstream.Addr{PubKey: na.Ref.PubKey()})
+		wrappedAddr := netwrap.WrapAddr(dialAddr, secretstream.Addr{PubKey: adv.ref.PubKey()})
 
 		d.brLock.Lock()
 		for _, ch := range d.brodcasts {
@@ -121,14 +155,10 @@ func (d *Discoverer) Stop() {
 		close(ch)
 		delete(d.brodcasts, i)
 	}
-	if d.rx4 != nil {
-		d.rx4.Close()
-		d.rx4 = nil
-	}
-	if d.rx6 != nil {
-		d.rx6.Close()
-		d.rx6 = nil
+	for _, rx := range d.rx {
+		rx.Close()
 	}
+	d.rx = nil
 	d.brLock.Unlock()
 	return
 }
@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -24,17 +26,26 @@ import (
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/neterr"
+	"go.cryptoscope.co/ssb/network/netaddr"
+	"go.cryptoscope.co/ssb/peerstats"
 )
 
 // DefaultPort is the default listening port for ScuttleButt.
 const DefaultPort = 8008
 
+// how long Close waits for running http requests
+const httpShutdownTimeout = 5 * time.Second
+
 type Options struct {
 	Logger log.Logger
 
 	Dialer     netwrap.Dialer
 	ListenAddr net.Addr
 
+	// Dialers overwrite Dialer for specific address types, keyed by network like "tcp" or netaddr.OnionNetwork.
+	// Onion addresses can only be dialed if one is set for them, like a SOCKS5Dialer.
+	Dialers map[string]netwrap.Dialer
+
 	AdvertsSend      bool
 	AdvertsConnectTo bool
 
@@ -56,7 +67,16 @@ type Options struct {
 	Latency         metrics.Histogram
 	EndpointWrapper func(muxrpc.Endpoint) muxrpc.Endpoint
 
+	// PeerQuotas limit the streams, message rate and bandwidth of each connection.
+	// Throttling is counted as throttled.streams, throttled.messages and throttled.bytes events.
+	PeerQuotas PeerQuotas
+
+	// PeerStats records the connections, failures, traffic and dial latency of each peer (optional)
+	PeerStats *peerstats.Store
+
 	WebsocketAddr string
+	// WebsocketOrigins are the browser origins that may connect to the websocket, see origins.Allowed
+	WebsocketOrigins []string
 }
 
 type node struct {
@@ -67,6 +87,7 @@ type node struct {
 	lisClose sync.Once
 
 	dialer        netwrap.Dialer
+	dialers       map[string]netwrap.Dialer
 	l             net.Listener
 	localDiscovRx *Discoverer
 	localDiscovTx *Advertiser
@@ -79,6 +100,9 @@ type node struct {
 
 	listening chan struct{}
 
+	// set by StopAccepting
+	draining int32
+
 	remotesLock sync.Mutex
 	remotes     map[string]muxrpc.Endpoint
 
@@ -89,6 +113,7 @@ type node struct {
 
 	// "ssb-ws"
 	httpLis     net.Listener
+	httpSrv     *http.Server
 	httpHandler http.Handler
 }
 
@@ -110,6 +135,13 @@ func New(opts Options) (ssb.Network, error) {
 	} else {
 		n.dialer = netwrap.Dial
 	}
+	n.dialers = make(map[string]netwrap.Dialer, len(opts.Dialers))
+	for network, d := range opts.Dialers {
+		n.dialers[network] = d
+	}
+	if _, has := n.dialers["tcp"]; !has {
+		n.dialers["tcp"] = n.dialer
+	}
 
 	n.secretClient, err = secretstream.NewClient(opts.KeyPair.Pair, opts.AppKey)
 	if err != nil {
@@ -168,23 +200,39 @@ func New(opts Options) (ssb.Network, error) {
 	})
 
 	if addr := opts.WebsocketAddr; addr != "" {
-		n.httpLis, err = net.Listen("tcp", addr)
+		lis, err := net.Listen("tcp", addr)
 		if err != nil {
 			return nil, err
 		}
+		n.httpLis = &onceCloseListener{Listener: lis}
+
+		n.httpSrv = &http.Server{Handler: httpHandler}
+
+		if n.localDiscovTx != nil {
+			if err := n.localDiscovTx.AdvertiseWebsocket(n.httpLis.Addr()); err != nil {
+				return nil, err
+			}
+		}
 
 		// TODO: move to serve
 		go func() {
-			err := http.Serve(n.httpLis, httpHandler)
-			level.Error(n.log).Log("conn", "ssb-ws :8998 listen exited", "err", err)
+			err := n.httpSrv.Serve(n.httpLis)
+			if err != http.ErrServerClosed && !n.isDraining() {
+				level.Error(n.log).Log("conn", "ssb-ws listen exited", "addr", addr, "err", err)
+			}
 		}()
 	}
 
 	return n, nil
 }
 
+// HandleHTTP serves h for all requests other than the websocket connections at /.
+// If h has a Shutdown() method, like the gateway, it is called when the network is closed.
 func (n *node) HandleHTTP(h http.Handler) {
 	n.httpHandler = h
+	if sh, ok := h.(interface{ Shutdown() }); ok && n.httpSrv != nil {
+		n.httpSrv.RegisterOnShutdown(sh.Shutdown)
+	}
 }
 
 func (n *node) GetConnTracker() ssb.ConnTracker {
@@ -271,6 +319,16 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		return
 	}
 
+	if n.opts.PeerQuotas.BytesPerSecond > 0 {
+		conn = n.throttleConn(conn)
+	}
+
+	var counted *countingConn
+	if n.opts.PeerStats != nil {
+		counted = &countingConn{Conn: conn}
+		conn = counted
+	}
+
 	ok, ctx := n.connTracker.OnAccept(ctx, conn)
 	if !ok {
 		err := conn.Close()
@@ -289,8 +347,15 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		n.evtCtr.With("event", "connection").Add(1)
 	}
 
+	remote, _ := ssb.GetFeedRefFromAddr(conn.RemoteAddr())
+
 	h, err := n.opts.MakeHandler(conn)
 	if err != nil {
+		if n.opts.PeerStats != nil && remote != nil {
+			if err := n.opts.PeerStats.Failed(remote, "denied", err); err != nil {
+				level.Warn(n.log).Log("event", "peerstats", "err", err)
+			}
+		}
 		if _, ok := errors.Cause(err).(*ssb.ErrOutOfReach); ok {
 			return // ignore silently
 		}
@@ -298,9 +363,28 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 		return
 	}
 
+	if n.opts.PeerStats != nil && remote != nil {
+		var addr string
+		if tcp := netwrap.GetAddr(conn.RemoteAddr(), "tcp"); tcp != nil {
+			addr = tcp.String()
+		}
+		if err := n.opts.PeerStats.Connected(remote, addr); err != nil {
+			level.Warn(n.log).Log("event", "peerstats", "err", err)
+		}
+		defer func() {
+			rx, tx := counted.counts()
+			if err := n.opts.PeerStats.Disconnected(remote, rx, tx); err != nil {
+				level.Warn(n.log).Log("event", "peerstats", "err", err)
+			}
+		}()
+	}
+
 	for _, hw := range hws {
 		h = hw(h)
 	}
+	if n.opts.PeerQuotas.limitsStreams() {
+		h = n.newConnQuota().handlerWrapper()(h)
+	}
 
 	pkr := muxrpc.NewPacker(conn)
 	filtered := level.NewFilter(n.log, level.AllowInfo())
@@ -327,6 +411,9 @@ func (n *node) handleConnection(ctx context.Context, origConn net.Conn, hws ...m
 // Serve starts the network listener and configured resources like local discovery.
 // Canceling the passed context makes the function return. Defers take care of stopping these resources.
 func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) error {
+	if n.isDraining() {
+		return ErrDraining
+	}
 	evtLog := log.With(n.log, "event", "network.Serve")
 	// TODO: make multiple listeners (localhost:8008 should not restrict or kill connections)
 	lisWrap := netwrap.NewListenerWrapper(n.secretServer.Addr(), append(n.opts.BefreCryptoWrappers, n.secretServer.ConnWrapper())...)
@@ -425,6 +512,21 @@ func (n *node) Serve(ctx context.Context, wrappers ...muxrpc.HandlerWrapper) err
 	}
 }
 
+// pickDialer returns the dialer for the transport part of addr, onion addresses are preferred over tcp
+func (n *node) pickDialer(addr net.Addr) (netwrap.Dialer, net.Addr, error) {
+	if onion := netwrap.GetAddr(addr, netaddr.OnionNetwork); onion != nil {
+		d, has := n.dialers[netaddr.OnionNetwork]
+		if !has {
+			return nil, nil, errors.Errorf("node/connect: no dialer for onion address %s (configure a SOCKS5 proxy)", onion)
+		}
+		return d, onion, nil
+	}
+	if tcp := netwrap.GetAddr(addr, "tcp"); tcp != nil {
+		return n.dialers["tcp"], tcp, nil
+	}
+	return nil, nil, errors.Errorf("node/connect: no dialable address in %s", addr)
+}
+
 func (n *node) Connect(ctx context.Context, addr net.Addr) error {
 	select {
 	case <-ctx.Done():
@@ -443,8 +545,19 @@ func (n *node) Connect(ctx context.Context, addr net.Addr) error {
 		return errors.New("node/connect: expected shs-bs address to be of type secretstream.Addr")
 	}
 
-	conn, err := n.dialer(netwrap.GetAddr(addr, "tcp"), append(n.beforeCryptoConnWrappers,
+	if n.isDraining() {
+		return ErrDraining
+	}
+
+	dial, dialAddr, err := n.pickDialer(addr)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	conn, err := dial(dialAddr, append(n.beforeCryptoConnWrappers,
 		n.secretClient.ConnWrapper(pubKey))...)
+	n.recordDial(pubKey, time.Since(start), err)
 	if err != nil {
 		if conn != nil {
 			conn.Close()
@@ -479,15 +592,63 @@ func (n *node) applyConnWrappers(conn net.Conn) (net.Conn, error) {
 	return conn, nil
 }
 
+// ErrDraining is returned by Serve and Connect once StopAccepting was called
+var ErrDraining = errors.New("ssb: network is draining")
+
+// StopAccepting closes the listeners of secret-handshake and the websocket and stops local discovery,
+// the established connections stay open. Serve and Connect return ErrDraining afterwards.
+func (n *node) StopAccepting() {
+	atomic.StoreInt32(&n.draining, 1)
+
+	if n.localDiscovTx != nil {
+		n.localDiscovTx.Stop()
+	}
+
+	if n.l != nil {
+		n.lisClose.Do(func() {
+			n.l.Close()
+		})
+	}
+
+	if n.httpSrv != nil {
+		// requests on open connections are still answered, but not kept alive
+		n.httpSrv.SetKeepAlivesEnabled(false)
+		n.httpLis.Close()
+	}
+}
+
+// onceCloseListener can be closed by StopAccepting and again by http.Server.Shutdown, which would fail otherwise
+type onceCloseListener struct {
+	net.Listener
+
+	once sync.Once
+	err  error
+}
+
+func (l *onceCloseListener) Close() error {
+	l.once.Do(func() {
+		l.err = l.Listener.Close()
+	})
+	return l.err
+}
+
+func (n *node) isDraining() bool { return atomic.LoadInt32(&n.draining) == 1 }
+
 func (n *node) Close() error {
 	if n.localDiscovTx != nil {
 		n.localDiscovTx.Stop()
 	}
 
-	if n.httpLis != nil {
-		err := n.httpLis.Close()
+	if n.httpSrv != nil {
+		// let running http requests finish, Shutdown also closes the listener
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		err := n.httpSrv.Shutdown(ctx)
+		cancel()
 		if err != nil {
-			return errors.Wrap(err, "ssb: failed to close http listener")
+			level.Warn(n.log).Log("event", "http shutdown timed out, closing", "err", err)
+			if err := n.httpSrv.Close(); err != nil {
+				return errors.Wrap(err, "ssb: failed to close http server")
+			}
 		}
 	}
 
@@ -0,0 +1,54 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+	multiserver "go.mindeco.de/ssb-multiserver"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/network/netaddr"
+)
+
+// ParseMultiserverAddress parses net: and onion: addresses with secret-handshake,
+// like net:1.2.3.4:8008~shs:<key> or onion:<name>.onion:8008~shs:<key>,
+// into a wrapped address that can be passed to Network.Connect.
+func ParseMultiserverAddress(input string) (net.Addr, error) {
+	if !strings.HasPrefix(input, "onion:") {
+		msaddr, err := multiserver.ParseNetAddress([]byte(input))
+		if err != nil {
+			return nil, err
+		}
+		return netwrap.WrapAddr(&msaddr.Addr, secretstream.Addr{PubKey: msaddr.Ref.PubKey()}), nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(input, "onion:"), "~shs:", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("multiserver: expected onion:host:port~shs:key, got %q", input)
+	}
+
+	host, portStr, err := net.SplitHostPort(parts[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "multiserver: invalid onion address")
+	}
+	if !netaddr.IsOnionHost(host) {
+		return nil, errors.Errorf("multiserver: not an onion host: %q", host)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, errors.Errorf("multiserver: invalid onion port: %q", portStr)
+	}
+
+	ref, err := refs.ParseFeedRef("@" + parts[1] + ".ed25519")
+	if err != nil {
+		return nil, errors.Wrap(err, "multiserver: invalid shs key")
+	}
+
+	return netwrap.WrapAddr(netaddr.OnionAddr{Host: host, Port: port}, secretstream.Addr{PubKey: ref.PubKey()}), nil
+}
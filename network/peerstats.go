@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"crypto/ed25519"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/secretstream/secrethandshake"
+	refs "go.mindeco.de/ssb-refs"
+)
+
+// recordDial puts the outcome of dialing pubKey into the peer stats, if they are enabled
+func (n *node) recordDial(pubKey ed25519.PublicKey, took time.Duration, err error) {
+	if n.opts.PeerStats == nil {
+		return
+	}
+	remote := &refs.FeedRef{ID: pubKey, Algo: refs.RefAlgoFeedSSB1}
+
+	if err == nil {
+		err = n.opts.PeerStats.Latency(remote, took)
+	} else {
+		reason := "dial"
+		switch errors.Cause(err).(type) {
+		case secrethandshake.ErrProtocol, secrethandshake.ErrProcessing:
+			reason = "handshake"
+		}
+		err = n.opts.PeerStats.Failed(remote, reason, err)
+	}
+	if err != nil {
+		level.Warn(n.log).Log("event", "peerstats", "err", err)
+	}
+}
+
+// countingConn counts the bytes that go over a connection, for the peer stats
+type countingConn struct {
+	net.Conn
+
+	rx, tx uint64
+}
+
+func (cc *countingConn) Read(b []byte) (int, error) {
+	n, err := cc.Conn.Read(b)
+	atomic.AddUint64(&cc.rx, uint64(n))
+	return n, err
+}
+
+func (cc *countingConn) Write(b []byte) (int, error) {
+	n, err := cc.Conn.Write(b)
+	atomic.AddUint64(&cc.tx, uint64(n))
+	return n, err
+}
+
+func (cc *countingConn) counts() (rx, tx uint64) {
+	return atomic.LoadUint64(&cc.rx), atomic.LoadUint64(&cc.tx)
+}
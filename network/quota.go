@@ -0,0 +1,333 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+)
+
+// ErrQuotaExceeded is returned to peers that open more streams than their PeerQuotas allow
+var ErrQuotaExceeded = errors.New("ssb: peer quota exceeded")
+
+// PeerQuotas limit the resources a single connection can use. Zero values mean no limit.
+// Calls of the peer never pass an EndpointWrapper, so the stream quotas also wrap the muxrpc handler of a connection.
+// The endpoint the handler gets is wrapped in turn, which meters the streams we open on the peer, like createHistoryStream.
+type PeerQuotas struct {
+	// MaxStreams is the number of source, sink and duplex calls a peer can have open at the same time
+	MaxStreams int
+
+	// MessagesPerSecond is how many stream items can pass a connection, with bursts of up to MessageBurst.
+	// It counts what the peer sends us and what we send on its calls, going faster only delays the stream.
+	MessagesPerSecond float64
+	MessageBurst      int
+
+	// BytesPerSecond is the bandwidth of a connection, in both directions, with bursts of up to BytesBurst
+	BytesPerSecond int
+	BytesBurst     int
+}
+
+func (q PeerQuotas) limitsStreams() bool { return q.MaxStreams > 0 || q.MessagesPerSecond > 0 }
+
+// tokenBucket allows rate events per second on average and burst of them at once
+type tokenBucket struct {
+	mu sync.Mutex
+
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	now func() time.Time
+}
+
+// newTokenBucket starts full, a burst of zero defaults to one second worth of tokens
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, math.Ceil(rate))
+	}
+	tb := &tokenBucket{
+		rate:   rate,
+		burst:  b,
+		tokens: b,
+		now:    time.Now,
+	}
+	tb.last = tb.now()
+	return tb
+}
+
+func (tb *tokenBucket) refill() {
+	now := tb.now()
+	tb.tokens = math.Min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	tb.last = now
+}
+
+// allow takes a token if there is one
+func (tb *tokenBucket) allow() bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+	return true
+}
+
+// take removes n tokens, going into debt if needed, and returns how long to wait until it is paid off
+func (tb *tokenBucket) take(n int) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	tb.refill()
+	tb.tokens -= float64(n)
+	if tb.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// connQuota enforces the stream quotas of one connection
+type connQuota struct {
+	maxStreams int32
+	streams    int32
+	messages   *tokenBucket
+
+	throttled func(kind string)
+}
+
+func (n *node) newConnQuota() *connQuota {
+	q := n.opts.PeerQuotas
+	cq := &connQuota{
+		maxStreams: int32(q.MaxStreams),
+		throttled:  n.throttled,
+	}
+	if q.MessagesPerSecond > 0 {
+		cq.messages = newTokenBucket(q.MessagesPerSecond, q.MessageBurst)
+	}
+	return cq
+}
+
+// wait delays a stream item until the connection is back within its message rate
+func (cq *connQuota) wait(ctx context.Context) error {
+	if cq.messages == nil {
+		return nil
+	}
+	d := cq.messages.take(1)
+	if d <= 0 {
+		return nil
+	}
+	cq.throttled("messages")
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handlerWrapper limits the calls of the peer and hands the wrapped endpoint to the handler
+func (cq *connQuota) handlerWrapper() muxrpc.HandlerWrapper {
+	return func(root muxrpc.Handler) muxrpc.Handler {
+		return &quotaHandler{root: root, cq: cq}
+	}
+}
+
+// endpointWrapper meters the items of streams opened on the peer
+func (cq *connQuota) endpointWrapper() func(muxrpc.Endpoint) muxrpc.Endpoint {
+	return func(edp muxrpc.Endpoint) muxrpc.Endpoint {
+		if cq.messages == nil {
+			return edp
+		}
+		return &quotaEndpoint{Endpoint: edp, cq: cq}
+	}
+}
+
+type quotaHandler struct {
+	root muxrpc.Handler
+	cq   *connQuota
+}
+
+func (qh *quotaHandler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {
+	qh.root.HandleConnect(ctx, qh.cq.endpointWrapper()(edp))
+}
+
+func (qh *quotaHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	cq := qh.cq
+	edp = cq.endpointWrapper()(edp)
+
+	switch req.Type {
+	case "source", "sink", "duplex":
+	default:
+		qh.root.HandleCall(ctx, req, edp)
+		return
+	}
+
+	qs := &quotaStream{Stream: req.Stream, cq: cq, done: make(chan struct{})}
+	if cq.maxStreams > 0 {
+		if atomic.AddInt32(&cq.streams, 1) > cq.maxStreams {
+			atomic.AddInt32(&cq.streams, -1)
+			cq.throttled("streams")
+			req.Stream.CloseWithError(ErrQuotaExceeded)
+			return
+		}
+		qs.release = func() { atomic.AddInt32(&cq.streams, -1) }
+		// the handler might never see the end of the stream if the connection goes away
+		go func() {
+			select {
+			case <-ctx.Done():
+				qs.end()
+			case <-qs.done:
+			}
+		}()
+	}
+	req.Stream = qs
+
+	qh.root.HandleCall(ctx, req, edp)
+}
+
+// quotaStream meters the items of a call of the peer and gives back its slot once either side ended it
+type quotaStream struct {
+	muxrpc.Stream
+	cq *connQuota
+
+	once    sync.Once
+	done    chan struct{}
+	release func()
+}
+
+func (qs *quotaStream) end() {
+	qs.once.Do(func() {
+		if qs.release != nil {
+			qs.release()
+		}
+		close(qs.done)
+	})
+}
+
+func (qs *quotaStream) Pour(ctx context.Context, v interface{}) error {
+	if err := qs.cq.wait(ctx); err != nil {
+		return err
+	}
+	err := qs.Stream.Pour(ctx, v)
+	if err != nil {
+		// the remote closed the stream
+		qs.end()
+	}
+	return err
+}
+
+func (qs *quotaStream) Next(ctx context.Context) (interface{}, error) {
+	v, err := qs.Stream.Next(ctx)
+	if err != nil {
+		// end of stream or the remote closed it
+		qs.end()
+		return nil, err
+	}
+	if err := qs.cq.wait(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func (qs *quotaStream) Close() error {
+	qs.end()
+	return qs.Stream.Close()
+}
+
+func (qs *quotaStream) CloseWithError(err error) error {
+	qs.end()
+	return qs.Stream.CloseWithError(err)
+}
+
+// quotaEndpoint delays reading from the sources it opens on the peer to stay within the message rate
+type quotaEndpoint struct {
+	muxrpc.Endpoint
+	cq *connQuota
+}
+
+func (qe *quotaEndpoint) Source(ctx context.Context, tipe interface{}, method muxrpc.Method, args ...interface{}) (luigi.Source, error) {
+	src, err := qe.Endpoint.Source(ctx, tipe, method, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &quotaSource{Source: src, cq: qe.cq}, nil
+}
+
+func (qe *quotaEndpoint) Duplex(ctx context.Context, tipe interface{}, method muxrpc.Method, args ...interface{}) (luigi.Source, luigi.Sink, error) {
+	src, snk, err := qe.Endpoint.Duplex(ctx, tipe, method, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &quotaSource{Source: src, cq: qe.cq}, snk, nil
+}
+
+type quotaSource struct {
+	luigi.Source
+	cq *connQuota
+}
+
+func (qs *quotaSource) Next(ctx context.Context) (interface{}, error) {
+	v, err := qs.Source.Next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := qs.cq.wait(ctx); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// throttledConn delays reads and writes to stay within the bandwidth of its bucket
+type throttledConn struct {
+	net.Conn
+
+	bucket    *tokenBucket
+	throttled func(kind string)
+}
+
+func (n *node) throttleConn(conn net.Conn) net.Conn {
+	q := n.opts.PeerQuotas
+	return &throttledConn{
+		Conn:      conn,
+		bucket:    newTokenBucket(float64(q.BytesPerSecond), q.BytesBurst),
+		throttled: n.throttled,
+	}
+}
+
+func (tc *throttledConn) Read(b []byte) (int, error) {
+	n, err := tc.Conn.Read(b)
+	tc.wait(n)
+	return n, err
+}
+
+func (tc *throttledConn) Write(b []byte) (int, error) {
+	tc.wait(len(b))
+	return tc.Conn.Write(b)
+}
+
+func (tc *throttledConn) wait(n int) {
+	if n == 0 {
+		return
+	}
+	if d := tc.bucket.take(n); d > 0 {
+		tc.throttled("bytes")
+		time.Sleep(d)
+	}
+}
+
+func (n *node) throttled(kind string) {
+	if n.evtCtr != nil {
+		n.evtCtr.With("event", "throttled."+kind).Add(1)
+	}
+}
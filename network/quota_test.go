@@ -0,0 +1,246 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/muxrpc"
+)
+
+func TestTokenBucket(t *testing.T) {
+	a := assert.New(t)
+
+	now := time.Unix(1000, 0)
+	tb := newTokenBucket(2, 3)
+	tb.now = func() time.Time { return now }
+	tb.last = now
+
+	a.True(tb.allow())
+	a.True(tb.allow())
+	a.True(tb.allow())
+	a.False(tb.allow(), "burst used up")
+
+	now = now.Add(500 * time.Millisecond)
+	a.True(tb.allow(), "one token after half a second")
+	a.False(tb.allow())
+
+	now = now.Add(time.Hour)
+	a.Equal(time.Duration(0), tb.take(3), "refills up to the burst")
+	a.Equal(time.Second, tb.take(2), "debt is paid off with the rate")
+
+	// the default burst is a second worth of tokens
+	a.Equal(float64(10), newTokenBucket(10, 0).burst)
+	a.Equal(float64(1), newTokenBucket(0.1, 0).burst)
+}
+
+// countingCounter counts Add calls by their event label
+type countingCounter struct {
+	mu     *sync.Mutex
+	counts map[string]float64
+	event  string
+}
+
+func (c *countingCounter) With(lvs ...string) metrics.Counter {
+	return &countingCounter{mu: c.mu, counts: c.counts, event: lvs[1]}
+}
+
+func (c *countingCounter) Add(delta float64) {
+	c.mu.Lock()
+	c.counts[c.event] += delta
+	c.mu.Unlock()
+}
+
+// holdHandler keeps source calls open until release is closed and answers async calls right away
+type holdHandler struct {
+	release chan struct{}
+}
+
+func (holdHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (hh holdHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Type != "source" {
+		req.Return(ctx, "pong")
+		return
+	}
+	go func() {
+		req.Stream.Pour(ctx, "hi")
+		<-hh.release
+		req.Stream.Close()
+	}()
+}
+
+func TestQuotaHandler(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	ctr := &countingCounter{mu: new(sync.Mutex), counts: make(map[string]float64)}
+	n := &node{
+		opts:   Options{PeerQuotas: PeerQuotas{MaxStreams: 2}},
+		evtCtr: ctr,
+	}
+	hh := holdHandler{release: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cliConn, srvConn := net.Pipe()
+	srv := muxrpc.Handle(muxrpc.NewPacker(srvConn), n.newConnQuota().handlerWrapper()(hh))
+	go srv.(muxrpc.Server).Serve(ctx)
+	cli := muxrpc.Handle(muxrpc.NewPacker(cliConn), remoteHandler{})
+	go cli.(muxrpc.Server).Serve(ctx)
+
+	openSource := func() (luigi.Source, error) {
+		src, err := cli.Source(ctx, json.RawMessage{}, muxrpc.Method{"hold"})
+		if err != nil {
+			return nil, err
+		}
+		_, err = src.Next(ctx)
+		return src, err
+	}
+
+	// two streams fit
+	src1, err := openSource()
+	r.NoError(err)
+	_, err = openSource()
+	r.NoError(err)
+
+	// the third doesn't
+	_, err = openSource()
+	r.Error(err)
+	a.Equal(float64(1), ctr.counts["throttled.streams"])
+
+	// closing them gives the slots back
+	close(hh.release)
+	_, err = src1.Next(ctx)
+	a.True(luigi.IsEOS(err), "expected end of stream, got %v", err)
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = openSource()
+	r.NoError(err, "slot should be free again")
+
+	// async calls don't take a slot
+	_, err = cli.Async(ctx, "str", muxrpc.Method{"ping"})
+	r.NoError(err)
+}
+
+// readHandler answers duplex calls with one item and reads until the remote ends them, without ever closing them itself
+type readHandler struct{}
+
+func (readHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (readHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	go func() {
+		req.Stream.Pour(ctx, "hi")
+		for {
+			if _, err := req.Stream.Next(ctx); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func TestQuotaReleaseOnRemoteClose(t *testing.T) {
+	r := require.New(t)
+
+	n := &node{opts: Options{PeerQuotas: PeerQuotas{MaxStreams: 1}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cliConn, srvConn := net.Pipe()
+	srv := muxrpc.Handle(muxrpc.NewPacker(srvConn), n.newConnQuota().handlerWrapper()(readHandler{}))
+	go srv.(muxrpc.Server).Serve(ctx)
+	cli := muxrpc.Handle(muxrpc.NewPacker(cliConn), remoteHandler{})
+	go cli.(muxrpc.Server).Serve(ctx)
+
+	openDuplex := func() (luigi.Sink, error) {
+		src, snk, err := cli.Duplex(ctx, json.RawMessage{}, muxrpc.Method{"read"})
+		if err != nil {
+			return nil, err
+		}
+		_, err = src.Next(ctx)
+		return snk, err
+	}
+
+	snk, err := openDuplex()
+	r.NoError(err)
+
+	_, err = openDuplex()
+	r.Error(err, "only one stream allowed")
+
+	// the remote ending its side gives the slot back, even though the handler never closes the stream
+	r.NoError(snk.Close())
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = openDuplex()
+	r.NoError(err, "slot should be free again")
+}
+
+// pourHandler answers source calls with count items
+type pourHandler struct {
+	count int
+}
+
+func (pourHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (ph pourHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	go func() {
+		for i := 0; i < ph.count; i++ {
+			if err := req.Stream.Pour(ctx, i); err != nil {
+				return
+			}
+		}
+		req.Stream.Close()
+	}()
+}
+
+func TestQuotaMessages(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	ctr := &countingCounter{mu: new(sync.Mutex), counts: make(map[string]float64)}
+	n := &node{
+		opts: Options{PeerQuotas: PeerQuotas{
+			MessagesPerSecond: 100,
+			MessageBurst:      2,
+		}},
+		evtCtr: ctr,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cliConn, srvConn := net.Pipe()
+	srv := muxrpc.Handle(muxrpc.NewPacker(srvConn), n.newConnQuota().handlerWrapper()(pourHandler{count: 5}))
+	go srv.(muxrpc.Server).Serve(ctx)
+	cli := muxrpc.Handle(muxrpc.NewPacker(cliConn), remoteHandler{})
+	go cli.(muxrpc.Server).Serve(ctx)
+
+	start := time.Now()
+	src, err := cli.Source(ctx, json.RawMessage{}, muxrpc.Method{"pour"})
+	r.NoError(err)
+
+	var got int
+	for {
+		_, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		got++
+	}
+	a.Equal(5, got, "items are delayed, not dropped")
+	a.True(time.Since(start) >= 25*time.Millisecond, "three items over the burst should take 30ms")
+
+	ctr.mu.Lock()
+	a.Equal(float64(3), ctr.counts["throttled.messages"])
+	ctr.mu.Unlock()
+}
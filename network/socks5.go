@@ -0,0 +1,160 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/netwrap"
+)
+
+// how long the proxy has to set up a connection, Tor can take a while for onion services
+const socks5HandshakeTimeout = time.Minute
+
+// SOCKS5Dialer returns a dialer that connects through the SOCKS5 proxy at proxyAddr, like the one of a Tor daemon (localhost:9050).
+// Host names, like the ones of onion services, are resolved by the proxy.
+func SOCKS5Dialer(proxyAddr string) netwrap.Dialer {
+	return func(addr net.Addr, wrappers ...netwrap.ConnWrapper) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return nil, errors.Wrap(err, "socks5: invalid destination")
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, errors.Wrap(err, "socks5: invalid destination port")
+		}
+
+		conn, err := net.Dial("tcp", proxyAddr)
+		if err != nil {
+			return nil, errors.Wrap(err, "socks5: failed to reach proxy")
+		}
+
+		conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+		if err := socks5Connect(conn, host, uint16(port)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn.SetDeadline(time.Time{})
+
+		// the remote is what we asked for, not the proxy
+		var wrapped net.Conn = proxiedConn{Conn: conn, remote: addr}
+		for i, w := range wrappers {
+			wrapped, err = w(wrapped)
+			if err != nil {
+				conn.Close()
+				return nil, errors.Wrapf(err, "socks5: error applying connection wrapper #%d", i)
+			}
+		}
+		return wrapped, nil
+	}
+}
+
+type proxiedConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (pc proxiedConn) RemoteAddr() net.Addr { return pc.remote }
+
+const (
+	socks5Version = 5
+
+	socks5NoAuth = 0
+
+	socks5CmdConnect = 1
+
+	socks5AtypIPv4   = 1
+	socks5AtypDomain = 3
+	socks5AtypIPv6   = 4
+)
+
+var socks5Replies = map[byte]string{
+	1: "general failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// socks5Connect does the CONNECT handshake of RFC 1928, without authentication
+func socks5Connect(rw io.ReadWriter, host string, port uint16) error {
+	if _, err := rw.Write([]byte{socks5Version, 1, socks5NoAuth}); err != nil {
+		return errors.Wrap(err, "socks5: failed to send greeting")
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(rw, choice[:]); err != nil {
+		return errors.Wrap(err, "socks5: failed to read greeting")
+	}
+	if choice[0] != socks5Version {
+		return errors.Errorf("socks5: unexpected version %d", choice[0])
+	}
+	if choice[1] != socks5NoAuth {
+		return errors.Errorf("socks5: proxy requires authentication (method %d)", choice[1])
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errors.Errorf("socks5: host name too long")
+		}
+		req = append(req, socks5AtypDomain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5AtypIPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5AtypIPv6)
+		req = append(req, ip.To16()...)
+	}
+	var portBytes [2]byte
+	binary.BigEndian.PutUint16(portBytes[:], port)
+	req = append(req, portBytes[:]...)
+
+	if _, err := rw.Write(req); err != nil {
+		return errors.Wrap(err, "socks5: failed to send connect request")
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return errors.Wrap(err, "socks5: failed to read connect reply")
+	}
+	if reply[0] != socks5Version {
+		return errors.Errorf("socks5: unexpected version %d", reply[0])
+	}
+	if reply[1] != 0 {
+		msg, ok := socks5Replies[reply[1]]
+		if !ok {
+			msg = "unknown error " + strconv.Itoa(int(reply[1]))
+		}
+		return errors.Errorf("socks5: connect to %s failed: %s", net.JoinHostPort(host, strconv.Itoa(int(port))), msg)
+	}
+
+	// skip the bound address
+	var skip int
+	switch reply[3] {
+	case socks5AtypIPv4:
+		skip = net.IPv4len
+	case socks5AtypIPv6:
+		skip = net.IPv6len
+	case socks5AtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(rw, l[:]); err != nil {
+			return errors.Wrap(err, "socks5: failed to read bound address")
+		}
+		skip = int(l[0])
+	default:
+		return errors.Errorf("socks5: unknown address type %d", reply[3])
+	}
+	if _, err := io.ReadFull(rw, make([]byte, skip+2)); err != nil {
+		return errors.Wrap(err, "socks5: failed to read bound address")
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+
+	"go.cryptoscope.co/ssb/network/netaddr"
+)
+
+// socks5StandIn is a minimal SOCKS5 proxy that only knows the hosts in its map, like tor knows onion services
+type socks5StandIn struct {
+	l     net.Listener
+	hosts map[string]string
+}
+
+func newSOCKS5StandIn(t *testing.T, hosts map[string]string) *socks5StandIn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	p := &socks5StandIn{l: l, hosts: hosts}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(c)
+		}
+	}()
+	return p
+}
+
+func (p *socks5StandIn) serve(c net.Conn) {
+	defer c.Close()
+
+	var greeting [2]byte
+	if _, err := io.ReadFull(c, greeting[:]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(c, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	c.Write([]byte{socks5Version, socks5NoAuth})
+
+	var req [4]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socks5AtypDomain:
+		var l [1]byte
+		io.ReadFull(c, l[:])
+		name := make([]byte, l[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	case socks5AtypIPv4:
+		ip := make([]byte, net.IPv4len)
+		io.ReadFull(c, ip)
+		host = net.IP(ip).String()
+	case socks5AtypIPv6:
+		ip := make([]byte, net.IPv6len)
+		io.ReadFull(c, ip)
+		host = net.IP(ip).String()
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(c, port[:]); err != nil {
+		return
+	}
+
+	target, has := p.hosts[net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))]
+	if !has {
+		c.Write([]byte{socks5Version, 4, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	upstream, err := net.Dial("tcp", target)
+	if err != nil {
+		c.Write([]byte{socks5Version, 5, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+
+	// reply with a domain as the bound address to check that the dialer skips it correctly
+	bound := "bound.example"
+	reply := append([]byte{socks5Version, 0, 0, socks5AtypDomain, byte(len(bound))}, bound...)
+	c.Write(append(reply, 0, 1))
+
+	go io.Copy(upstream, c)
+	io.Copy(c, upstream)
+}
+
+func (p *socks5StandIn) Close() error { return p.l.Close() }
+
+func TestSOCKS5Dialer(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer echo.Close()
+	go func() {
+		for {
+			c, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+
+	onion := netaddr.OnionAddr{Host: "abcdefghijklmnop.onion", Port: 8008}
+	proxy := newSOCKS5StandIn(t, map[string]string{
+		onion.String():  echo.Addr().String(),
+		"10.1.2.3:8008": echo.Addr().String(),
+	})
+	defer proxy.Close()
+
+	dial := SOCKS5Dialer(proxy.l.Addr().String())
+
+	var wrapped bool
+	conn, err := dial(onion, func(c net.Conn) (net.Conn, error) {
+		wrapped = true
+		return c, nil
+	})
+	r.NoError(err)
+	a.True(wrapped, "wrappers should be applied")
+	a.Equal(onion, conn.RemoteAddr(), "remote should be the proxied address")
+
+	_, err = conn.Write([]byte("hello"))
+	r.NoError(err)
+	got := make([]byte, 5)
+	_, err = io.ReadFull(conn, got)
+	r.NoError(err)
+	a.Equal("hello", string(got))
+	r.NoError(conn.Close())
+
+	// IP addresses are passed as such
+	conn, err = dial(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 8008})
+	r.NoError(err)
+	conn.Close()
+
+	// the proxy doesn't know it
+	_, err = dial(netaddr.OnionAddr{Host: "unknown.onion", Port: 8008})
+	r.Error(err)
+	a.Contains(err.Error(), "host unreachable")
+
+	// no proxy running
+	proxy.Close()
+	_, err = dial(onion)
+	r.Error(err)
+}
+
+func TestParseMultiserverAddress(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	addr, err := ParseMultiserverAddress("onion:abcdefghijklmnop.onion:8008~shs:" + testKey)
+	r.NoError(err)
+	a.Equal(netaddr.OnionAddr{Host: "abcdefghijklmnop.onion", Port: 8008}, netwrap.GetAddr(addr, netaddr.OnionNetwork))
+	a.Nil(netwrap.GetAddr(addr, "tcp"))
+	shs, ok := netwrap.GetAddr(addr, secretstream.NetworkString).(secretstream.Addr)
+	r.True(ok)
+	a.Equal(makeTestPubKey(t).Id.PubKey(), []byte(shs.PubKey))
+
+	addr, err = ParseMultiserverAddress("net:192.168.1.2:8008~shs:" + testKey)
+	r.NoError(err)
+	a.Equal("192.168.1.2:8008", netwrap.GetAddr(addr, "tcp").String())
+	a.Nil(netwrap.GetAddr(addr, netaddr.OnionNetwork))
+
+	for _, input := range []string{
+		"onion:abcdefghijklmnop.onion:8008",
+		"onion:example.com:8008~shs:" + testKey,
+		"onion:abcdefghijklmnop.onion~shs:" + testKey,
+		"onion:abcdefghijklmnop.onion:0~shs:" + testKey,
+		"onion:abcdefghijklmnop.onion:8008~shs:garbage",
+	} {
+		_, err := ParseMultiserverAddress(input)
+		a.Error(err, "%q", input)
+	}
+}
+
+func TestPickDialer(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	var used string
+	mkDialer := func(name string) netwrap.Dialer {
+		return func(net.Addr, ...netwrap.ConnWrapper) (net.Conn, error) {
+			used = name
+			return nil, nil
+		}
+	}
+
+	n := &node{dialers: map[string]netwrap.Dialer{"tcp": mkDialer("tcp")}}
+
+	onion, err := ParseMultiserverAddress("onion:abcdefghijklmnop.onion:8008~shs:" + testKey)
+	r.NoError(err)
+	_, _, err = n.pickDialer(onion)
+	a.Error(err, "no onion dialer configured")
+
+	n.dialers[netaddr.OnionNetwork] = mkDialer("onion")
+	d, dialAddr, err := n.pickDialer(onion)
+	r.NoError(err)
+	d(dialAddr)
+	a.Equal("onion", used)
+	a.Equal(netaddr.OnionNetwork, dialAddr.Network())
+
+	tcp, err := ParseMultiserverAddress("net:192.168.1.2:8008~shs:" + testKey)
+	r.NoError(err)
+	d, dialAddr, err = n.pickDialer(tcp)
+	r.NoError(err)
+	d(dialAddr)
+	a.Equal("tcp", used)
+	a.Equal("192.168.1.2:8008", dialAddr.String())
+}
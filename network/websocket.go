@@ -11,14 +11,20 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb/internal/origins"
 )
 
 func websockHandler(n *node) http.HandlerFunc {
 	var upgrader = websocket.Upgrader{
 		ReadBufferSize:  1024 * 4,
 		WriteBufferSize: 1024 * 4,
-		CheckOrigin: func(_ *http.Request) bool {
-			return true
+		CheckOrigin: func(req *http.Request) bool {
+			// the connection is authenticated by secret-handshake, so all origins are fine unless an allowlist is set
+			if len(n.opts.WebsocketOrigins) == 0 {
+				return true
+			}
+			return origins.Allowed(n.opts.WebsocketOrigins, req)
 		},
 		EnableCompression: false,
 	}
@@ -44,11 +50,7 @@ func websockHandler(n *node) http.HandlerFunc {
 			wsc: wsConn,
 		}
 
-		// comment out this block to get `noauth` instead of `shs`
-		// TODO:
-		// netwrap.WrapAddr(remoteAddr, secretstream.Addr{
-		// 	PubKey: n.opts.KeyPair.Id.ID,
-		// })
+		// for local clients without shs keys, see ListenNoauthWebsocket
 		cw := n.secretServer.ConnWrapper()
 		wc, err = cw(wc)
 		if err != nil {
@@ -0,0 +1,211 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/internal/netwraputil"
+	"go.cryptoscope.co/ssb/internal/origins"
+)
+
+// NoauthWebsocketOptions configure ListenNoauthWebsocket
+type NoauthWebsocketOptions struct {
+	Logger log.Logger
+
+	// ListenAddr needs to be a loopback address, like localhost:8990 or [::1]:8990
+	ListenAddr string
+
+	// As is the feed the connections are made as, like the unix socket this is the feed of the bot.
+	As *refs.FeedRef
+
+	MakeHandler func(net.Conn) (muxrpc.Handler, error)
+
+	// Wrappers are applied to the connections before they are handled
+	Wrappers []netwrap.ConnWrapper
+
+	// Origins are the browser origins that may connect, see origins.Allowed.
+	// Without them, only pages served from the listen address itself can.
+	Origins []string
+}
+
+// NoauthWebsocket serves muxrpc over websockets without secret-handshake,
+// so that local UIs can connect without handling keys.
+type NoauthWebsocket struct {
+	log  log.Logger
+	lis  net.Listener
+	srv  *http.Server
+	opts NoauthWebsocketOptions
+
+	// websocket connections are hijacked and not closed by srv.Shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// ListenNoauthWebsocket refuses to listen on anything but loopback addresses,
+// since every connection is handled as if it was made by opts.As.
+func ListenNoauthWebsocket(opts NoauthWebsocketOptions) (*NoauthWebsocket, error) {
+	if opts.Logger == nil {
+		opts.Logger = log.NewNopLogger()
+	}
+	if opts.As == nil || opts.MakeHandler == nil {
+		return nil, errors.Errorf("noauth websocket: feed and MakeHandler are required")
+	}
+	if err := checkLoopback(opts.ListenAddr); err != nil {
+		return nil, err
+	}
+
+	lis, err := net.Listen("tcp", opts.ListenAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "noauth websocket: failed to listen")
+	}
+
+	nw := &NoauthWebsocket{
+		log:  opts.Logger,
+		lis:  lis,
+		opts: opts,
+	}
+	nw.ctx, nw.cancel = context.WithCancel(context.Background())
+	nw.srv = &http.Server{Handler: http.HandlerFunc(nw.serve)}
+
+	go func() {
+		err := nw.srv.Serve(lis)
+		if err != http.ErrServerClosed {
+			level.Error(nw.log).Log("conn", "noauth websocket listen exited", "err", err)
+		}
+	}()
+	return nw, nil
+}
+
+// checkLoopback makes sure all the addresses the host resolves to are loopback ones.
+// An empty host would bind to all interfaces and is refused as well.
+func checkLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return errors.Wrap(err, "noauth websocket: invalid listen address")
+	}
+	if host == "" {
+		return errors.Errorf("noauth websocket: refusing to listen on all interfaces (%s)", addr)
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return errors.Wrap(err, "noauth websocket: failed to resolve listen address")
+	}
+	for _, ip := range ips {
+		if !ip.IsLoopback() {
+			return errors.Errorf("noauth websocket: refusing to listen on non-loopback address %s", ip)
+		}
+	}
+	return nil
+}
+
+// Addr returns the address the listener is bound to
+func (nw *NoauthWebsocket) Addr() net.Addr { return nw.lis.Addr() }
+
+// Close stops accepting new connections and closes the open ones
+func (nw *NoauthWebsocket) Close() error {
+	nw.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	return nw.srv.Shutdown(ctx)
+}
+
+// loopbackHost is true for the Host headers of requests that were meant for a loopback address.
+// Other names are refused, because they could be rebound to 127.0.0.1 by a website.
+func loopbackHost(hostport string) bool {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+func (nw *NoauthWebsocket) serve(w http.ResponseWriter, req *http.Request) {
+	if !loopbackHost(req.Host) {
+		http.Error(w, "not a loopback host", http.StatusForbidden)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  1024 * 4,
+		WriteBufferSize: 1024 * 4,
+		// the connections get full access, so other websites must not be able to open them
+		CheckOrigin: func(req *http.Request) bool {
+			return origins.Allowed(nw.opts.Origins, req)
+		},
+	}
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	if err != nil {
+		level.Warn(nw.log).Log("event", "failed to resolve remote", "err", err, "remote", req.RemoteAddr)
+		return
+	}
+	wsConn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		level.Warn(nw.log).Log("event", "websocket upgrade failed", "err", err, "remote", remoteAddr)
+		return
+	}
+
+	var wc net.Conn = &wrappedConn{
+		remote: remoteAddr,
+		local:  nw.lis.Addr(),
+		wsc:    wsConn,
+	}
+
+	wc, err = netwraputil.SpoofRemoteAddress(nw.opts.As.PubKey())(wc)
+	if err != nil {
+		level.Error(nw.log).Log("event", "failed to spoof remote", "err", err)
+		wsConn.Close()
+		return
+	}
+	for _, w := range nw.opts.Wrappers {
+		wc, err = w(wc)
+		if err != nil {
+			level.Warn(nw.log).Log("event", "failed to wrap connection", "err", err)
+			wsConn.Close()
+			return
+		}
+	}
+
+	h, err := nw.opts.MakeHandler(wc)
+	if err != nil {
+		level.Error(nw.log).Log("event", "noauth websocket make handler", "err", err)
+		wsConn.Close()
+		return
+	}
+
+	edp := muxrpc.HandleWithRemote(muxrpc.NewPacker(wc), h, wc.RemoteAddr())
+	srv := edp.(muxrpc.Server)
+
+	served := make(chan struct{})
+	go func() {
+		select {
+		case <-nw.ctx.Done():
+			wsConn.Close()
+		case <-served:
+		}
+	}()
+
+	if err := srv.Serve(nw.ctx); err != nil {
+		level.Debug(nw.log).Log("conn", "serve exited", "err", err, "peer", remoteAddr)
+	}
+	close(served)
+	edp.Terminate()
+	wsConn.Close()
+}
@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+
+package network
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestCheckLoopback(t *testing.T) {
+	a := assert.New(t)
+
+	a.NoError(checkLoopback("127.0.0.1:8990"))
+	a.NoError(checkLoopback("[::1]:8990"))
+	a.NoError(checkLoopback("localhost:8990"))
+
+	a.Error(checkLoopback(":8990"), "all interfaces")
+	a.Error(checkLoopback("0.0.0.0:8990"))
+	a.Error(checkLoopback("[::]:8990"))
+	a.Error(checkLoopback("192.168.1.2:8990"))
+	a.Error(checkLoopback("no-port"))
+
+	a.True(loopbackHost("localhost:8990"))
+	a.True(loopbackHost("127.0.0.1:8990"))
+	a.True(loopbackHost("[::1]:8990"))
+	a.False(loopbackHost("rebound.example:8990"))
+}
+
+// remoteHandler returns the feed the connection was made as
+type remoteHandler struct{}
+
+func (remoteHandler) HandleConnect(context.Context, muxrpc.Endpoint) {}
+
+func (remoteHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	remote, err := ssb.GetFeedRefFromAddr(edp.Remote())
+	if err != nil {
+		req.Stream.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, remote.Ref())
+}
+
+func TestNoauthWebsocket(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	kp := makeRandPubkey(t)
+
+	_, err := ListenNoauthWebsocket(NoauthWebsocketOptions{
+		ListenAddr:  ":0",
+		As:          kp.Id,
+		MakeHandler: func(net.Conn) (muxrpc.Handler, error) { return remoteHandler{}, nil },
+	})
+	r.Error(err, "should refuse to bind to all interfaces")
+
+	nw, err := ListenNoauthWebsocket(NoauthWebsocketOptions{
+		ListenAddr:  "127.0.0.1:0",
+		As:          kp.Id,
+		MakeHandler: func(net.Conn) (muxrpc.Handler, error) { return remoteHandler{}, nil },
+	})
+	r.NoError(err)
+	defer nw.Close()
+
+	wsURL := fmt.Sprintf("ws://%s/", nw.Addr())
+
+	// other websites can't connect
+	hdr := http.Header{}
+	hdr.Set("Origin", "http://evil.example")
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, hdr)
+	r.Error(err)
+	a.Equal(http.StatusForbidden, resp.StatusCode)
+
+	wsConn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	r.NoError(err)
+
+	conn := &wrappedConn{remote: nw.Addr(), local: nw.Addr(), wsc: wsConn}
+	edp := muxrpc.Handle(muxrpc.NewPacker(conn), remoteHandler{})
+	srv := edp.(muxrpc.Server)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Serve(ctx)
+
+	v, err := edp.Async(ctx, "str", muxrpc.Method{"whoami"})
+	r.NoError(err)
+	a.Equal(kp.Id.Ref(), v)
+
+	// closing ends the open connections
+	r.NoError(nw.Close())
+	_, err = edp.Async(ctx, "str", muxrpc.Method{"whoami"})
+	a.Error(err)
+}
@@ -0,0 +1,350 @@
+// SPDX-License-Identifier: MIT
+
+// Package peerstats keeps a persistent record of the connections to other peers,
+// so that operators and the connection scheduling can tell which peers are worth keeping.
+package peerstats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	refs "go.mindeco.de/ssb-refs"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// Peer is the record of one remote peer
+type Peer struct {
+	ID *refs.FeedRef `json:"id"`
+
+	// Addr is the address of the last connection
+	Addr string `json:"addr,omitempty"`
+
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+
+	// Connections counts the established connections, in both directions
+	Connections uint `json:"connections"`
+
+	// Failures counts failed connection attempts by reason (dial, handshake, denied)
+	Failures      map[string]uint `json:"failures,omitempty"`
+	LastFailure   string          `json:"lastFailure,omitempty"`
+	LastFailureAt time.Time       `json:"lastFailureAt,omitempty"`
+
+	BytesRx uint64 `json:"bytesRx"`
+	BytesTx uint64 `json:"bytesTx"`
+
+	// Received counts the messages we got from this peer, by feed.
+	// Only the feeds with the most messages are kept, the rest is summed up in ReceivedOther.
+	Received      map[string]uint64 `json:"received,omitempty"`
+	ReceivedOther uint64            `json:"receivedOther,omitempty"`
+
+	// Latency is the average time it took to dial the peer and finish the secret-handshake
+	Latency        time.Duration `json:"latency"`
+	LatencySamples uint          `json:"latencySamples"`
+}
+
+// TotalReceived sums up the received messages of all feeds
+func (p Peer) TotalReceived() uint64 {
+	n := p.ReceivedOther
+	for _, cnt := range p.Received {
+		n += cnt
+	}
+	return n
+}
+
+// MaxReceivedFeeds is how many feeds a Peer record counts the received messages of
+const MaxReceivedFeeds = 256
+
+// FlushInterval is how often the buffered message counts are written
+const FlushInterval = time.Minute
+
+// Store persists the Peer records in the repo
+type Store struct {
+	mu sync.Mutex
+	kv *kv.DB
+
+	// received buffers the message counts of each peer until the next flush, so that fetching doesn't rewrite the record for every feed
+	received map[string]*pendingReceived
+	maxFeeds int
+
+	done    chan struct{}
+	flusher sync.WaitGroup
+
+	now func() time.Time
+}
+
+type pendingReceived struct {
+	remote *refs.FeedRef
+	feeds  map[string]uint64
+}
+
+// Open opens the store of repo r
+func Open(r repo.Interface) (*Store, error) {
+	db, err := repo.OpenMKV(r.GetPath("peerstats"))
+	if err != nil {
+		return nil, fmt.Errorf("peerstats: failed to open key-value database (%w)", err)
+	}
+	s := &Store{
+		kv:       db,
+		received: make(map[string]*pendingReceived),
+		maxFeeds: MaxReceivedFeeds,
+		done:     make(chan struct{}),
+		now:      time.Now,
+	}
+	s.flusher.Add(1)
+	go s.flushEvery(FlushInterval)
+	return s, nil
+}
+
+func (s *Store) flushEvery(d time.Duration) {
+	defer s.flusher.Done()
+	tick := time.NewTicker(d)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			// errors are returned again by the final flush in Close
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Close writes the buffered counts and closes the underlying key-value database
+func (s *Store) Close() error {
+	close(s.done)
+	s.flusher.Wait()
+	err := s.Flush()
+	if cerr := s.kv.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Flush writes the buffered message counts
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *Store) flush() error {
+	for key, pr := range s.received {
+		k := []byte(key)
+		p, err := s.get(k)
+		if err != nil {
+			return err
+		}
+		if p == nil {
+			p = &Peer{ID: pr.remote}
+		}
+		s.addReceived(p, pr)
+		if err := s.set(k, p); err != nil {
+			return err
+		}
+		delete(s.received, key)
+	}
+	return nil
+}
+
+// addReceived adds the buffered counts to p and folds the smallest counts into ReceivedOther if there are too many feeds
+func (s *Store) addReceived(p *Peer, pr *pendingReceived) {
+	if p.Received == nil {
+		p.Received = make(map[string]uint64)
+	}
+	for feed, n := range pr.feeds {
+		p.Received[feed] += n
+	}
+
+	over := len(p.Received) - s.maxFeeds
+	if over <= 0 {
+		return
+	}
+	feeds := make([]string, 0, len(p.Received))
+	for feed := range p.Received {
+		feeds = append(feeds, feed)
+	}
+	sort.Slice(feeds, func(i, j int) bool { return p.Received[feeds[i]] < p.Received[feeds[j]] })
+	for _, feed := range feeds[:over] {
+		p.ReceivedOther += p.Received[feed]
+		delete(p.Received, feed)
+	}
+}
+
+// update loads the record of remote, passes it to fn and stores it again
+func (s *Store) update(remote *refs.FeedRef, fn func(*Peer)) error {
+	if remote == nil {
+		return fmt.Errorf("peerstats: no remote")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := remote.StoredAddr()
+	p, err := s.get([]byte(key))
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		p = &Peer{ID: remote}
+	}
+
+	// the record is written anyway, take the buffered counts along
+	if pr, has := s.received[key]; has {
+		s.addReceived(p, pr)
+		delete(s.received, key)
+	}
+
+	fn(p)
+
+	return s.set([]byte(key), p)
+}
+
+func (s *Store) set(key []byte, p *Peer) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("peerstats: failed to encode record (%w)", err)
+	}
+	if err := s.kv.Set(key, data); err != nil {
+		return fmt.Errorf("peerstats: failed to store record (%w)", err)
+	}
+	return nil
+}
+
+func (s *Store) get(key []byte) (*Peer, error) {
+	data, err := s.kv.Get(nil, key)
+	if err != nil {
+		return nil, fmt.Errorf("peerstats: failed to load record (%w)", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+	var p Peer
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("peerstats: invalid record (%w)", err)
+	}
+	return &p, nil
+}
+
+func (s *Store) seen(p *Peer) {
+	now := s.now()
+	if p.FirstSeen.IsZero() {
+		p.FirstSeen = now
+	}
+	p.LastSeen = now
+}
+
+// Connected records an established connection to remote
+func (s *Store) Connected(remote *refs.FeedRef, addr string) error {
+	return s.update(remote, func(p *Peer) {
+		s.seen(p)
+		p.Connections++
+		if addr != "" {
+			p.Addr = addr
+		}
+	})
+}
+
+// Disconnected records the end of a connection and how many bytes went over it
+func (s *Store) Disconnected(remote *refs.FeedRef, rx, tx uint64) error {
+	return s.update(remote, func(p *Peer) {
+		s.seen(p)
+		p.BytesRx += rx
+		p.BytesTx += tx
+	})
+}
+
+// Failed records a failed connection attempt. reason is the category of the failure, err the details.
+func (s *Store) Failed(remote *refs.FeedRef, reason string, err error) error {
+	return s.update(remote, func(p *Peer) {
+		if p.Failures == nil {
+			p.Failures = make(map[string]uint)
+		}
+		p.Failures[reason]++
+		p.LastFailure = reason
+		if err != nil {
+			p.LastFailure += ": " + err.Error()
+		}
+		p.LastFailureAt = s.now()
+	})
+}
+
+// Latency adds a sample to the average latency of remote
+func (s *Store) Latency(remote *refs.FeedRef, d time.Duration) error {
+	return s.update(remote, func(p *Peer) {
+		p.LatencySamples++
+		p.Latency += (d - p.Latency) / time.Duration(p.LatencySamples)
+	})
+}
+
+// Received adds n messages of feed that came from remote.
+// The counts are kept in memory until the next flush or until the record of remote is updated anyway, like on Disconnected.
+func (s *Store) Received(remote, feed *refs.FeedRef, n uint64) error {
+	if remote == nil {
+		return fmt.Errorf("peerstats: no remote")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := remote.StoredAddr()
+	pr, has := s.received[key]
+	if !has {
+		pr = &pendingReceived{remote: remote, feeds: make(map[string]uint64)}
+		s.received[key] = pr
+	}
+	pr.feeds[feed.Ref()] += n
+	return nil
+}
+
+// Get returns the record of remote, if there is one
+func (s *Store) Get(remote *refs.FeedRef) (*Peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	return s.get([]byte(remote.StoredAddr()))
+}
+
+// All returns all the records, the most recently seen first
+func (s *Store) All() ([]Peer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	iter, err := s.kv.SeekFirst()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("peerstats: failed to iterate records (%w)", err)
+	}
+
+	var lst []Peer
+	for {
+		_, data, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("peerstats: failed to get next record (%w)", err)
+		}
+
+		var p Peer
+		if err := json.Unmarshal(data, &p); err != nil {
+			return nil, fmt.Errorf("peerstats: invalid record (%w)", err)
+		}
+		lst = append(lst, p)
+	}
+
+	sort.Slice(lst, func(i, j int) bool { return lst[i].LastSeen.After(lst[j].LastSeen) })
+	return lst, nil
+}
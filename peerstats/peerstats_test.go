@@ -0,0 +1,137 @@
+// SPDX-License-Identifier: MIT
+
+package peerstats
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestStore(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	st, err := Open(tRepo)
+	r.NoError(err)
+
+	now := time.Unix(1600000000, 0)
+	st.now = func() time.Time { return now }
+
+	alice := &refs.FeedRef{ID: bytes.Repeat([]byte("a"), 32), Algo: refs.RefAlgoFeedSSB1}
+	bob := &refs.FeedRef{ID: bytes.Repeat([]byte("b"), 32), Algo: refs.RefAlgoFeedSSB1}
+	feed := &refs.FeedRef{ID: bytes.Repeat([]byte("f"), 32), Algo: refs.RefAlgoFeedGabby}
+
+	p, err := st.Get(alice)
+	r.NoError(err)
+	a.Nil(p, "nothing stored yet")
+
+	r.NoError(st.Connected(alice, "10.0.0.1:8008"))
+	r.NoError(st.Latency(alice, 100*time.Millisecond))
+	r.NoError(st.Latency(alice, 300*time.Millisecond))
+	r.NoError(st.Received(alice, alice, 10))
+	r.NoError(st.Received(alice, feed, 5))
+	r.NoError(st.Received(alice, feed, 2))
+	now = now.Add(time.Hour)
+	r.NoError(st.Disconnected(alice, 1000, 200))
+
+	r.NoError(st.Failed(bob, "dial", errors.New("connection refused")))
+	r.NoError(st.Failed(bob, "dial", nil))
+	r.NoError(st.Failed(bob, "handshake", errors.New("wrong key")))
+
+	// the records survive a restart
+	r.NoError(st.Close())
+	st, err = Open(tRepo)
+	r.NoError(err)
+	defer st.Close()
+
+	p, err = st.Get(alice)
+	r.NoError(err)
+	r.NotNil(p)
+	a.True(p.ID.Equal(alice))
+	a.Equal("10.0.0.1:8008", p.Addr)
+	a.Equal(uint(1), p.Connections)
+	a.True(p.FirstSeen.Equal(time.Unix(1600000000, 0)), "first seen: %s", p.FirstSeen)
+	a.True(p.LastSeen.Equal(now), "last seen: %s", p.LastSeen)
+	a.Equal(uint64(1000), p.BytesRx)
+	a.Equal(uint64(200), p.BytesTx)
+	a.Equal(200*time.Millisecond, p.Latency)
+	a.Equal(uint64(10), p.Received[alice.Ref()])
+	a.Equal(uint64(7), p.Received[feed.Ref()])
+	a.Equal(uint64(17), p.TotalReceived())
+	a.Len(p.Failures, 0)
+
+	p, err = st.Get(bob)
+	r.NoError(err)
+	r.NotNil(p)
+	a.Equal(uint(0), p.Connections)
+	a.True(p.LastSeen.IsZero(), "never connected")
+	a.Equal(map[string]uint{"dial": 2, "handshake": 1}, p.Failures)
+	a.Equal("handshake: wrong key", p.LastFailure)
+
+	all, err := st.All()
+	r.NoError(err)
+	r.Len(all, 2)
+	a.True(all[0].ID.Equal(alice), "most recently seen first")
+	a.True(all[1].ID.Equal(bob))
+}
+
+func TestStoreReceived(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	st, err := Open(tRepo)
+	r.NoError(err)
+	st.maxFeeds = 2
+
+	alice := &refs.FeedRef{ID: bytes.Repeat([]byte("a"), 32), Algo: refs.RefAlgoFeedSSB1}
+	feed := func(b byte) *refs.FeedRef {
+		return &refs.FeedRef{ID: bytes.Repeat([]byte{b}, 32), Algo: refs.RefAlgoFeedSSB1}
+	}
+
+	r.NoError(st.Connected(alice, ""))
+	r.NoError(st.Received(alice, feed('1'), 1))
+	r.NoError(st.Received(alice, feed('2'), 20))
+
+	// nothing is written until a flush
+	p, err := st.get([]byte(alice.StoredAddr()))
+	r.NoError(err)
+	r.NotNil(p)
+	a.Len(p.Received, 0)
+
+	// updating the record anyway takes the counts along
+	r.NoError(st.Disconnected(alice, 0, 0))
+	p, err = st.get([]byte(alice.StoredAddr()))
+	r.NoError(err)
+	a.Len(p.Received, 2)
+
+	// the feeds with the fewest messages are folded once there are too many
+	r.NoError(st.Received(alice, feed('3'), 5))
+	r.NoError(st.Received(alice, feed('2'), 1))
+	r.NoError(st.Close())
+
+	st, err = Open(tRepo)
+	r.NoError(err)
+	defer st.Close()
+
+	p, err = st.Get(alice)
+	r.NoError(err)
+	a.Equal(map[string]uint64{feed('2').Ref(): 21, feed('3').Ref(): 5}, p.Received)
+	a.Equal(uint64(1), p.ReceivedOther)
+	a.Equal(uint64(27), p.TotalReceived())
+}
@@ -6,24 +6,27 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/muxrpc"
-	"go.cryptoscope.co/netwrap"
-	"go.cryptoscope.co/secretstream"
 	"go.cryptoscope.co/ssb/internal/muxmux"
-	multiserver "go.mindeco.de/ssb-multiserver"
 	refs "go.mindeco.de/ssb-refs"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/network"
 )
 
 type handler struct {
 	node ssb.Network
 	repl ssb.Replicator
 
+	lists ssb.BlockListSubscriber
+
+	drainer ssb.Drainer
+
 	info logging.Interface
 }
 
@@ -41,6 +44,17 @@ func New(i logging.Interface, n ssb.Network, r ssb.Replicator) muxrpc.Handler {
 
 	mux.RegisterAsync(muxrpc.Method{"ctrl", "replicate"}, unmarshalActionMap(h.replicate))
 	mux.RegisterAsync(muxrpc.Method{"ctrl", "block"}, unmarshalActionMap(h.block))
+
+	if bls, ok := r.(ssb.BlockListSubscriber); ok {
+		h.lists = bls
+		mux.RegisterAsync(muxrpc.Method{"ctrl", "subscribeBlockList"}, unmarshalActionMap(h.subscribeBlockList))
+		mux.RegisterAsync(muxrpc.Method{"ctrl", "blockLists"}, muxmux.AsyncFunc(h.blockLists))
+	}
+
+	if d, ok := r.(ssb.Drainer); ok {
+		h.drainer = d
+		mux.RegisterAsync(muxrpc.Method{"ctrl", "shutdown"}, muxmux.AsyncFunc(h.shutdown))
+	}
 	return &mux
 }
 
@@ -108,6 +122,56 @@ func (h *handler) block(ctx context.Context, m actionMap) error {
 	return nil
 }
 
+// subscribeBlockList takes the same arguments as block but (un)subscribes to the blocks of the passed feeds
+func (h *handler) subscribeBlockList(ctx context.Context, m actionMap) error {
+	for ref, do := range m {
+		var err error
+		if do {
+			err = h.lists.SubscribeBlockList(ref)
+		} else {
+			err = h.lists.UnsubscribeBlockList(ref)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "ctrl.subscribeBlockList: failed to update %s", ref.Ref())
+		}
+	}
+	return nil
+}
+
+func (h *handler) blockLists(ctx context.Context, r *muxrpc.Request) (interface{}, error) {
+	lst, err := h.lists.BlockListSubscriptions()
+	if err != nil {
+		return nil, errors.Wrap(err, "ctrl.blockLists: failed to get subscriptions")
+	}
+	return lst, nil
+}
+
+// DefaultDrainTimeout is used by ctrl.shutdown if no timeout is passed
+const DefaultDrainTimeout = 30 * time.Second
+
+// shutdown drains the bot in the background, the optional argument is the timeout in seconds
+func (h *handler) shutdown(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	timeout := DefaultDrainTimeout
+	if args := req.Args(); len(args) > 0 {
+		secs, ok := args[0].(float64)
+		if !ok || secs <= 0 {
+			return nil, errors.Errorf("ctrl.shutdown: expected timeout in seconds, got %v", args[0])
+		}
+		timeout = time.Duration(secs * float64(time.Second))
+	}
+
+	level.Info(h.info).Log("event", "shutdown requested", "timeout", timeout)
+	// the reply has to go out before the connection is closed by draining
+	go func() {
+		drainCtx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := h.drainer.Drain(drainCtx); err != nil {
+			level.Warn(h.info).Log("event", "shutdown", "err", err)
+		}
+	}()
+	return fmt.Sprintf("draining (timeout %s)", timeout), nil
+}
+
 func (h *handler) disconnect(ctx context.Context, r *muxrpc.Request) (interface{}, error) {
 	h.node.GetConnTracker().CloseAll()
 	return "disconencted", nil
@@ -122,14 +186,17 @@ func (h *handler) connect(ctx context.Context, req *muxrpc.Request) (interface{}
 	if !ok {
 		return nil, errors.Errorf("ctrl.connect call: expected argument to be string, got %T", req.Args()[0])
 	}
-	msaddr, err := multiserver.ParseNetAddress([]byte(dest))
+	wrappedAddr, err := network.ParseMultiserverAddress(dest)
 	if err != nil {
 		return nil, errors.Wrapf(err, "ctrl.connect call: failed to parse input: %s", dest)
 	}
 
-	wrappedAddr := netwrap.WrapAddr(&msaddr.Addr, secretstream.Addr{PubKey: msaddr.Ref.PubKey()})
-	level.Info(h.info).Log("event", "doing gossip.connect", "remote", msaddr.Ref.ShortRef())
+	remote, err := ssb.GetFeedRefFromAddr(wrappedAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ctrl.connect call: failed to get remote key: %s", dest)
+	}
+	level.Info(h.info).Log("event", "doing gossip.connect", "remote", remote.ShortRef())
 	// TODO: add context to tracker to cancel connections
 	err = h.node.Connect(context.Background(), wrappedAddr)
-	return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", msaddr.Addr)
+	return nil, errors.Wrapf(err, "ctrl.connect call: error connecting to %q", dest)
 }
@@ -0,0 +1,50 @@
+// SPDX-License-Identifier: MIT
+
+// Package forks offers forks.list, the proofs of the feeds the bot saw forking
+package forks
+
+import (
+	"context"
+
+	"github.com/cryptix/go/logging"
+	"go.cryptoscope.co/muxrpc"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/internal/muxmux"
+)
+
+// Lister is implemented by bots that record forked feeds
+type Lister interface {
+	ForkProofs() ([]forks.Proof, error)
+}
+
+type plugin struct {
+	h muxrpc.Handler
+}
+
+func (plugin) Name() string              { return "forks" }
+func (plugin) Method() muxrpc.Method     { return muxrpc.Method{"forks"} }
+func (p plugin) Handler() muxrpc.Handler { return p.h }
+
+// New returns the plugin with forks.list
+func New(log logging.Interface, l Lister) ssb.Plugin {
+	h := muxmux.New(log)
+	h.RegisterAsync(muxrpc.Method{"forks", "list"}, listHandler{l})
+	return plugin{h: &h}
+}
+
+type listHandler struct {
+	l Lister
+}
+
+func (lh listHandler) HandleAsync(ctx context.Context, req *muxrpc.Request) (interface{}, error) {
+	proofs, err := lh.l.ForkProofs()
+	if err != nil {
+		return nil, err
+	}
+	if proofs == nil {
+		proofs = []forks.Proof{}
+	}
+	return proofs, nil
+}
@@ -204,7 +204,7 @@ func (m *FeedManager) CreateStreamHistory(
 		return errors.Errorf("bad request: missing id argument")
 	}
 	// check what we got
-	userLog, err := m.UserFeeds.Get(arg.ID.StoredAddr())
+	userLog, err := m.UserFeeds.Get(ssb.StoredAddr(arg.ID))
 	if err != nil {
 		return errors.Wrapf(err, "failed to open sublog for user")
 	}
@@ -235,19 +235,16 @@ func (m *FeedManager) CreateStreamHistory(
 		return errors.Wrapf(err, "invalid user log query")
 	}
 
-	switch arg.ID.Algo {
-	case refs.RefAlgoFeedSSB1:
+	ff, ok := ssb.GetFeedFormat(arg.ID.Algo)
+	if !ok {
+		return errors.Errorf("unsupported feed format: %s", arg.ID.Algo)
+	}
+	// metafeeds are only exchanged in their bencoded form
+	asJSON := arg.AsJSON && arg.ID.Algo != ssb.RefAlgoFeedBendyButt
+	if asJSON || !ff.Binary() {
 		sink = transform.NewKeyValueWrapper(sink, arg.Keys)
-
-	case refs.RefAlgoFeedGabby:
-		switch {
-		case arg.AsJSON:
-			sink = transform.NewKeyValueWrapper(sink, arg.Keys)
-		default:
-			sink = gabbyStreamSink(sink)
-		}
-	default:
-		return errors.Errorf("unsupported feed format.")
+	} else {
+		sink = feedFormatStreamSink(ff, sink)
 	}
 
 	sent := 0
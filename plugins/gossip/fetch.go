@@ -14,7 +14,6 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/luigi"
-	"go.cryptoscope.co/luigi/mfr"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/muxrpc/codec"
@@ -22,6 +21,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/gaps"
 	"go.cryptoscope.co/ssb/internal/neterr"
 	"go.cryptoscope.co/ssb/message"
 )
@@ -93,13 +93,33 @@ func (h *handler) makeWorker(work <-chan *refs.FeedRef, ctx context.Context, edp
 
 func isIn(list []librarian.Addr, a *refs.FeedRef) bool {
 	for _, el := range list {
-		if bytes.Equal([]byte(a.StoredAddr()), []byte(el)) {
+		if bytes.Equal([]byte(ssb.StoredAddr(a)), []byte(el)) {
 			return true
 		}
 	}
 	return false
 }
 
+// drain stops new fetches and waits for the running ones to finish, until ctx is done
+func (g *handler) drain(ctx context.Context) error {
+	g.activeLock.Lock()
+	g.draining = true
+	g.activeLock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.fetching.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // fetchFeed requests the feed fr from endpoint e into the repo of the handler
 func (g *handler) fetchFeed(
 	ctx context.Context,
@@ -112,16 +132,22 @@ func (g *handler) fetchFeed(
 		return ctx.Err()
 	default:
 	}
+	// forked feeds are not replicated any further
+	if g.forks != nil && g.forks.Has(fr) {
+		return nil
+	}
 	// check our latest
-	frAddr := fr.StoredAddr()
+	frAddr := ssb.StoredAddr(fr)
 	addr := string(frAddr)
 	g.activeLock.Lock()
 	_, ok := g.activeFetch[addr]
-	if ok {
+	if ok || g.draining {
 		//level.Debug(g.logger).Log("fetchFeed", "crawl active", "addr", fr.ShortRef())
 		g.activeLock.Unlock()
 		return nil
 	}
+	g.fetching.Add(1)
+	defer g.fetching.Done()
 	if g.sysGauge != nil {
 		g.sysGauge.With("part", "fetches").Add(1)
 	}
@@ -136,6 +162,10 @@ func (g *handler) fetchFeed(
 			g.sysGauge.With("part", "fetches").Add(-1)
 		}
 	}()
+	// imports and publishers of the same feed wait until it's fetched
+	if g.feedLocks != nil {
+		defer g.feedLocks.Lock(fr)()
+	}
 	userLog, err := g.UserFeeds.Get(frAddr)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open sublog for user")
@@ -144,6 +174,13 @@ func (g *handler) fetchFeed(
 	if err != nil {
 		return errors.Wrapf(err, "failed to observe latest")
 	}
+	var marker *gaps.Marker
+	if g.gaps != nil {
+		marker, err = g.gaps.Get(fr)
+		if err != nil {
+			return err
+		}
+	}
 	var (
 		latestSeq margaret.BaseSeq
 		latestMsg refs.Message
@@ -153,6 +190,9 @@ func (g *handler) fetchFeed(
 		// nothing stored, fetch from zero
 	case margaret.BaseSeq:
 		latestSeq = v + 1 // sublog is 0-init while ssb chains start at 1
+		if marker != nil && !marker.Sparse {
+			latestSeq = margaret.BaseSeq(marker.Expected(v.Seq()))
+		}
 		if v >= 0 {
 			rootLogValue, err := userLog.Get(v)
 			if err != nil {
@@ -170,7 +210,9 @@ func (g *handler) fetchFeed(
 			}
 
 			// make sure our house is in order
-			if hasSeq := latestMsg.Seq(); hasSeq != latestSeq.Seq() {
+			if marker != nil && marker.Sparse {
+				latestSeq = margaret.BaseSeq(latestMsg.Seq())
+			} else if hasSeq := latestMsg.Seq(); hasSeq != latestSeq.Seq() {
 				return ssb.ErrWrongSequence{Ref: fr, Stored: latestMsg, Logical: latestSeq}
 			}
 		}
@@ -197,11 +239,32 @@ func (g *handler) fetchFeed(
 			if g.sysCtr != nil {
 				g.sysCtr.With("event", "gossiprx").Add(float64(n))
 			}
+			if g.peerStats != nil {
+				if remote, err := ssb.GetFeedRefFromAddr(edp.Remote()); err == nil {
+					if err := g.peerStats.Received(remote, fr, uint64(n)); err != nil {
+						level.Warn(info).Log("msg", "failed to record received messages", "err", err)
+					}
+				}
+			}
 			level.Debug(info).Log("received", n, "took", time.Since(started))
 		}
 	}()
 
-	method := muxrpc.Method{"createHistoryStream"}
+	// feeds that are only replicated partially, sparse ones stay that way
+	if g.gaps != nil && fr.Algo == refs.RefAlgoFeedSSB1 && !fr.Equal(g.Id) {
+		var pol *Policy
+		switch {
+		case marker != nil && marker.Sparse:
+			pol = &Policy{Types: marker.Types}
+		case latestMsg == nil && g.policy != nil:
+			pol = g.policy(fr)
+		}
+		if pol != nil {
+			n, err := g.fetchPartial(toLong, fr, edp, *pol, latestMsg)
+			latestSeq += margaret.BaseSeq(n)
+			return err
+		}
+	}
 
 	store := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
 		if err != nil {
@@ -210,32 +273,54 @@ func (g *handler) fetchFeed(
 			}
 			return err
 		}
-		_, err = g.RootLog.Append(val)
-		return errors.Wrap(err, "failed to append verified message to rootLog")
+		if _, err := g.RootLog.Append(val); err != nil {
+			return errors.Wrap(err, "failed to append verified message to rootLog")
+		}
+		// only stored messages are counted as received
+		latestSeq++
+		return nil
 	})
 
 	var (
-		src luigi.Source
-		snk luigi.Sink = message.NewVerifySink(fr, latestSeq, latestMsg, store, g.hmacSec)
+		snk   luigi.Sink
+		batch *message.BatchVerifySink
 	)
-
-	switch fr.Algo {
-	case refs.RefAlgoFeedSSB1:
-		src, err = edp.Source(toLong, json.RawMessage{}, method, q)
-	case refs.RefAlgoFeedGabby:
-		src, err = edp.Source(toLong, codec.Body{}, method, q)
+	if g.verifyWorkers > 0 {
+		batch = message.NewBatchVerifySink(fr, latestSeq, latestMsg, store, g.hmacSec, g.verifyWorkers)
+		snk = batch
+	} else {
+		snk = message.NewVerifySink(fr, latestSeq, latestMsg, store, g.hmacSec)
 	}
+
+	src, err := g.historySource(toLong, edp, fr, q)
 	if err != nil {
 		return errors.Wrapf(err, "fetchFeed(%s:%d) failed to create source", fr.Ref(), latestSeq)
 	}
 
-	// count the received messages
-	snk = mfr.SinkMap(snk, func(_ context.Context, val interface{}) (interface{}, error) {
-		latestSeq++
-		return val, nil
-	})
-
 	// info.Log("starting", "fetch")
 	err = luigi.Pump(toLong, snk, src)
+	if batch != nil {
+		// the last batches are only verified and stored on close,
+		// also if the stream broke off, the messages before that are fine
+		if closeErr := batch.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if forkErr, ok := errors.Cause(err).(message.ErrFork); ok && g.forks != nil {
+		return g.recordFork(ctx, edp, forkErr)
+	}
 	return errors.Wrap(err, "gossip pump failed")
 }
+
+// historySource calls createHistoryStream on the remote with the right encoding for the format of fr
+func (g *handler) historySource(ctx context.Context, edp muxrpc.Endpoint, fr *refs.FeedRef, q message.CreateHistArgs) (luigi.Source, error) {
+	method := muxrpc.Method{"createHistoryStream"}
+	ff, ok := ssb.GetFeedFormat(fr.Algo)
+	if !ok {
+		return nil, errors.Errorf("fetchFeed(%s): unsupported feed format", fr.Ref())
+	}
+	if ff.Binary() {
+		return edp.Source(ctx, codec.Body{}, method, q)
+	}
+	return edp.Source(ctx, json.RawMessage{}, method, q)
+}
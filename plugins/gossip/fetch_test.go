@@ -0,0 +1,163 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+	mmock "go.cryptoscope.co/muxrpc/mock"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestDrain(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	h := &handler{
+		activeLock:  &sync.Mutex{},
+		activeFetch: make(map[string]struct{}),
+	}
+
+	// pretend a fetch is running
+	h.fetching.Add(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	err := h.drain(ctx)
+	cancel()
+	a.Equal(context.DeadlineExceeded, err, "the running fetch should block draining")
+
+	// new fetches are not started while draining
+	fr := &refs.FeedRef{ID: bytes.Repeat([]byte("f"), 32), Algo: refs.RefAlgoFeedSSB1}
+	err = h.fetchFeed(context.Background(), fr, nil, time.Now())
+	r.NoError(err)
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		h.fetching.Done()
+	}()
+	r.NoError(h.drain(context.Background()))
+}
+
+// brokenSource emits vals and then fails with err, like a connection that broke off
+type brokenSource struct {
+	vals []interface{}
+	err  error
+}
+
+func (bs *brokenSource) Next(context.Context) (interface{}, error) {
+	if len(bs.vals) == 0 {
+		return nil, bs.err
+	}
+	v := bs.vals[0]
+	bs.vals = bs.vals[1:]
+	return v, nil
+}
+
+type countingCounter struct{ n float64 }
+
+func (c *countingCounter) With(...string) metrics.Counter { return c }
+func (c *countingCounter) Add(delta float64)              { c.n += delta }
+
+func TestFetchCountsStored(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	rootLog, err := repo.OpenLog(tRepo)
+	r.NoError(err)
+	userFeeds, _, err := multilogs.OpenUserFeeds(tRepo)
+	r.NoError(err)
+
+	// signs the messages of a new feed, the ones in broken point to the wrong previous
+	mkFeed := func(n int, broken int) (*ssb.KeyPair, []interface{}) {
+		author, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		var (
+			prev *refs.MessageRef
+			raws []interface{}
+		)
+		for seq := 1; seq <= n; seq++ {
+			lm := legacy.LegacyMessage{
+				Previous:  prev,
+				Author:    author.Id.Ref(),
+				Sequence:  margaret.BaseSeq(seq),
+				Timestamp: int64(seq),
+				Hash:      "sha256",
+				Content:   map[string]interface{}{"type": "test", "i": seq},
+			}
+			if seq == broken {
+				lm.Previous = nil
+			}
+			ref, raw, err := lm.Sign(author.Pair.Secret[:], nil)
+			r.NoError(err)
+			prev = ref
+			raws = append(raws, json.RawMessage(raw))
+		}
+		return author, raws
+	}
+
+	stored := func() int64 {
+		v, err := rootLog.Seq().Value()
+		r.NoError(err)
+		return v.(margaret.Seq).Seq() + 1
+	}
+
+	for _, workers := range []int{0, 2} {
+		ctr := new(countingCounter)
+		h := &handler{
+			Info:          testutils.NewRelativeTimeLogger(nil),
+			RootLog:       rootLog,
+			UserFeeds:     userFeeds,
+			verifyWorkers: workers,
+			sysCtr:        ctr,
+			activeLock:    &sync.Mutex{},
+			activeFetch:   make(map[string]struct{}),
+		}
+
+		var served luigi.Source
+		edp := &mmock.FakeEndpoint{
+			SourceStub: func(context.Context, interface{}, muxrpc.Method, ...interface{}) (luigi.Source, error) {
+				return served, nil
+			},
+		}
+
+		// the stream breaks off, the messages before that are stored
+		before := stored()
+		author, raws := mkFeed(5, 0)
+		served = &brokenSource{vals: raws, err: errors.New("connection lost")}
+		err := h.fetchFeed(context.Background(), author.Id, edp, time.Now())
+		a.Error(err, "workers: %d", workers)
+		a.EqualValues(5, stored()-before, "workers: %d", workers)
+		a.EqualValues(5, ctr.n, "workers: %d", workers)
+
+		// a message that doesn't verify isn't counted, neither are the ones after it
+		before = stored()
+		author, raws = mkFeed(5, 3)
+		served = &brokenSource{vals: raws, err: luigi.EOS{}}
+		err = h.fetchFeed(context.Background(), author.Id, edp, time.Now())
+		a.Error(err, "workers: %d", workers)
+		a.EqualValues(2, stored()-before, "workers: %d", workers)
+		a.EqualValues(7, ctr.n, "workers: %d", workers)
+	}
+}
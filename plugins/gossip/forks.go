@@ -0,0 +1,105 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// recordFork stores the proof of a fork that was found while fetching.
+// If the diverging message doesn't have the same sequence as the stored one, the remote's version of that sequence is fetched first.
+func (g *handler) recordFork(ctx context.Context, edp muxrpc.Endpoint, fe message.ErrFork) error {
+	feed := fe.Current.Author()
+
+	other := fe.Other
+	if !fe.Proven() {
+		var err error
+		other, err = g.fetchOne(ctx, edp, feed, fe.Current.Seq())
+		if err != nil {
+			return errors.Wrapf(err, "fork of %s: failed to get the diverging message", feed.ShortRef())
+		}
+		if other.Key().Equal(*fe.Current.Key()) {
+			// the remote has the same message, the next one was just broken
+			return fe
+		}
+	}
+
+	proof := forks.Proof{
+		Feed:     feed,
+		Sequence: fe.Current.Seq(),
+		Messages: [2]json.RawMessage{fe.Current.ValueContentJSON(), other.ValueContentJSON()},
+		Keys:     [2]*refs.MessageRef{fe.Current.Key(), other.Key()},
+	}
+	if remote, err := ssb.GetFeedRefFromAddr(edp.Remote()); err == nil {
+		proof.From = remote
+	}
+	for i, msg := range []refs.Message{fe.Current, other} {
+		sig, err := ssb.MessageSignature(msg)
+		if err != nil {
+			// the fork is still recorded, the proof just can't be published
+			level.Debug(g.Info).Log("event", "fork proof without signatures", "fr", feed.ShortRef(), "err", err)
+			proof.Sigs = [2]string{}
+			break
+		}
+		proof.Sigs[i] = sig
+	}
+
+	isNew, err := g.forks.Add(proof)
+	if err != nil {
+		return err
+	}
+	if isNew {
+		level.Warn(g.Info).Log("event", "feed forked", "fr", feed.ShortRef(), "seq", proof.Sequence, "remote", edp.Remote().String())
+	}
+	return nil
+}
+
+// fetchOne gets and verifies the message with sequence seq of feed from the remote
+func (g *handler) fetchOne(ctx context.Context, edp muxrpc.Endpoint, feed *refs.FeedRef, seq int64) (refs.Message, error) {
+	q := message.CreateHistArgs{
+		ID:         feed,
+		Seq:        seq,
+		StreamArgs: message.StreamArgs{Limit: 1},
+	}
+	src, err := g.historySource(ctx, edp, feed, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var got refs.Message
+	keep := luigi.FuncSink(func(_ context.Context, val interface{}, err error) error {
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+		got = val.(refs.Message)
+		return nil
+	})
+
+	// sparse, since it's a single message from the middle of the feed
+	snk := message.NewPartialVerifySink(feed, margaret.BaseSeq(0), nil, keep, g.hmacSec, true)
+	if err := luigi.Pump(ctx, snk, src); err != nil {
+		return nil, err
+	}
+	if got == nil {
+		return nil, errors.Errorf("remote didn't send message %d", seq)
+	}
+	if got.Seq() != seq {
+		return nil, errors.Errorf("remote sent message %d instead of %d", got.Seq(), seq)
+	}
+	return got, nil
+}
@@ -0,0 +1,146 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+	mmock "go.cryptoscope.co/muxrpc/mock"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestRecordFork(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath, err := ioutil.TempDir("", "recordFork")
+	r.NoError(err)
+	defer os.RemoveAll(tRepoPath)
+
+	st, err := forks.Open(repo.New(tRepoPath))
+	r.NoError(err)
+	defer st.Close()
+
+	h := &handler{
+		Info:  testutils.NewRelativeTimeLogger(nil),
+		forks: st,
+	}
+
+	remote, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	// sign returns the verified message seq of author on top of prev
+	ssb1, ok := ssb.GetFeedFormat(refs.RefAlgoFeedSSB1)
+	r.True(ok)
+	sign := func(author *ssb.KeyPair, prev *refs.MessageRef, seq int, tag string) (refs.Message, json.RawMessage) {
+		lm := legacy.LegacyMessage{
+			Previous:  prev,
+			Author:    author.Id.Ref(),
+			Sequence:  margaret.BaseSeq(seq),
+			Timestamp: int64(seq),
+			Hash:      "sha256",
+			Content:   map[string]interface{}{"type": "test", "tag": tag},
+		}
+		_, raw, err := lm.Sign(author.Pair.Secret[:], nil)
+		r.NoError(err)
+		msg, err := ssb1.Verify(raw, nil)
+		r.NoError(err)
+		return msg, raw
+	}
+
+	// the remote has the other version of the requested message
+	var served json.RawMessage
+	edp := &mmock.FakeEndpoint{
+		SourceStub: func(_ context.Context, _ interface{}, method muxrpc.Method, args ...interface{}) (luigi.Source, error) {
+			a.Equal(muxrpc.Method{"createHistoryStream"}, method)
+			return (*luigi.SliceSource)(&[]interface{}{served}), nil
+		},
+		RemoteStub: func() net.Addr {
+			return netwrap.WrapAddr(&net.TCPAddr{Port: 8008}, secretstream.Addr{PubKey: remote.Id.PubKey()})
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("proven", func(t *testing.T) {
+		author, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		first, _ := sign(author, nil, 1, "")
+		stored, storedRaw := sign(author, first.Key(), 2, "a")
+		other, otherRaw := sign(author, first.Key(), 2, "b")
+
+		r.NoError(h.recordFork(ctx, edp, message.ErrFork{Current: stored, Other: other}))
+
+		p, err := st.Get(author.Id)
+		r.NoError(err)
+		r.NotNil(p, "no proof stored")
+		a.EqualValues(2, p.Sequence)
+		a.JSONEq(string(storedRaw), string(p.Messages[0]))
+		a.JSONEq(string(otherRaw), string(p.Messages[1]))
+		if a.NotNil(p.Keys[0]) && a.NotNil(p.Keys[1]) {
+			a.True(p.Keys[0].Equal(*stored.Key()))
+			a.True(p.Keys[1].Equal(*other.Key()))
+		}
+		if a.NotNil(p.From) {
+			a.True(p.From.Equal(remote.Id))
+		}
+		sigs, err := p.Signatures()
+		r.NoError(err)
+		a.NotEqual(sigs[0], sigs[1])
+		a.Equal(stored.ValueContent().Signature, sigs[0])
+		a.Equal(other.ValueContent().Signature, sigs[1])
+	})
+
+	t.Run("fetches the other version", func(t *testing.T) {
+		author, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		first, _ := sign(author, nil, 1, "")
+		stored, _ := sign(author, first.Key(), 2, "a")
+		other2, other2Raw := sign(author, first.Key(), 2, "b")
+		other3, _ := sign(author, other2.Key(), 3, "b")
+
+		// the diverging message points to a previous that isn't ours
+		served = other2Raw
+		r.NoError(h.recordFork(ctx, edp, message.ErrFork{Current: stored, Other: other3}))
+
+		p, err := st.Get(author.Id)
+		r.NoError(err)
+		r.NotNil(p, "no proof stored")
+		a.EqualValues(2, p.Sequence)
+		if a.NotNil(p.Keys[1]) {
+			a.True(p.Keys[1].Equal(*other2.Key()))
+		}
+	})
+
+	t.Run("same message", func(t *testing.T) {
+		author, err := ssb.NewKeyPair(nil)
+		r.NoError(err)
+		first, _ := sign(author, nil, 1, "")
+		stored, storedRaw := sign(author, first.Key(), 2, "a")
+		broken, _ := sign(author, first.Key(), 3, "b")
+
+		// the remote has our version, so the next message is just broken
+		served = storedRaw
+		fe := message.ErrFork{Current: stored, Other: broken}
+		a.Equal(fe, h.recordFork(ctx, edp, fe))
+
+		a.False(st.Has(author.Id), "proof for a broken message")
+	})
+}
@@ -17,7 +17,11 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/gaps"
 	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/peerstats"
+	"go.cryptoscope.co/ssb/plugins/whoami"
 	refs "go.mindeco.de/ssb-refs"
 )
 
@@ -30,11 +34,39 @@ type handler struct {
 
 	hmacSec  HMACSecret
 	hopCount int
-	promisc  bool // ask for remote feed even if it's not on owns fetch list
+
+	// verifyWorkers > 0 verifies the fetched messages concurrently, see message.NewBatchVerifySink
+	verifyWorkers int
+
+	promiscMu sync.RWMutex
+	promisc   bool // ask for remote feed even if it's not on owns fetch list
+
+	// formats keeps the negotiated feed formats of the remotes (optional)
+	formats *ssb.FormatTracker
+
+	// authorizeFormat checks the remote again once its format is negotiated (optional)
+	authorizeFormat AuthorizeFormat
+
+	// peerStats counts the messages received from each peer (optional)
+	peerStats *peerstats.Store
+
+	// policy decides which feeds are only fetched partially, the gaps are noted in the gap store (both optional)
+	policy PolicyFunc
+	gaps   *gaps.Store
+
+	// forks keeps the proofs of forked feeds, which are not fetched anymore (optional)
+	forks *forks.Store
+
+	// feedLocks is shared with the other writers of the repo, a feed is locked while it's fetched (optional)
+	feedLocks *message.FeedLocks
 
 	activeLock  *sync.Mutex
 	activeFetch map[string]struct{}
 
+	// set by Drain, no new fetches are started afterwards (guarded by activeLock)
+	draining bool
+	fetching sync.WaitGroup
+
 	sysGauge metrics.Gauge
 	sysCtr   metrics.Counter
 
@@ -43,6 +75,18 @@ type handler struct {
 	rootCtx context.Context
 }
 
+func (g *handler) isPromisc() bool {
+	g.promiscMu.RLock()
+	defer g.promiscMu.RUnlock()
+	return g.promisc
+}
+
+func (g *handler) setPromisc(yes bool) {
+	g.promiscMu.Lock()
+	g.promisc = yes
+	g.promiscMu.Unlock()
+}
+
 func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 	remote := e.Remote()
 	remoteRef, err := ssb.GetFeedRefFromAddr(remote)
@@ -57,6 +101,30 @@ func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 	info := log.With(g.Info, "remote", remoteRef.ShortRef(), "event", "gossiprx")
 	start := time.Now()
 
+	if g.formats != nil {
+		negCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		negotiated, pf, err := whoami.Negotiate(negCtx, e, remoteRef)
+		cancel()
+		if err == nil {
+			if err := g.formats.Set(negotiated, pf); err != nil {
+				level.Warn(info).Log("msg", "failed to store negotiated formats", "err", err)
+			}
+			remoteRef = negotiated
+		} else {
+			level.Debug(info).Log("msg", "format negotiation failed", "err", err)
+		}
+	}
+
+	// the connection was accepted with the formats the key might publish, now we know which one it is
+	// (peers that can't negotiate publish the classic format)
+	if g.authorizeFormat != nil {
+		if err := g.authorizeFormat(remoteRef); err != nil {
+			level.Warn(info).Log("msg", "feed of remote not allowed", "feed", remoteRef.Ref(), "err", err)
+			e.Terminate()
+			return
+		}
+	}
+
 	// re-sync _our_ feed if we don't have it yet (re-onboarding of an existing feed)
 	hasSelf, err := multilog.Has(g.UserFeeds, g.Id.StoredAddr())
 	if err != nil {
@@ -73,7 +141,7 @@ func (g *handler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {
 		info.Log("msg", "done fetching self")
 	}
 
-	if g.promisc {
+	if g.isPromisc() {
 		hasCallee, err := multilog.Has(g.UserFeeds, remoteRef.StoredAddr())
 		if err != nil {
 			info.Log("handleConnect", "multilog.Has(callee)", "err", err)
@@ -184,7 +252,7 @@ func (g *handler) HandleCall(
 		// dbgLog = level.Warn(hlog)
 
 		// skip this check for self/master or in promisc mode (talk to everyone)
-		if !(g.Id.Equal(remote) || g.promisc) {
+		if !(g.Id.Equal(remote) || g.isPromisc()) {
 			blocks := g.WantList.BlockList()
 
 			if blocks.Has(query.ID) {
@@ -219,6 +287,24 @@ func (g *handler) HandleCall(
 			// } else {
 			// dbgLog.Log("msg", "feed access granted")
 		}
+		// we only have parts of these feeds, the sequences would not match what the remote expects
+		if g.gaps != nil {
+			if m, err := g.gaps.Get(query.ID); err == nil && m != nil {
+				dbgLog.Log("msg", "feed only stored partially", "fr", query.ID.ShortRef())
+				req.Stream.Close()
+				return
+			}
+		}
+
+		// don't send binary encoded messages to peers that told us they can't handle them
+		if query.ID.Algo == refs.RefAlgoFeedGabby && !query.AsJSON && g.formats != nil {
+			if pf, has := g.formats.Get(remote); has {
+				if can, known := pf.CanReplicate(refs.RefAlgoFeedGabby); known && !can {
+					query.AsJSON = true
+				}
+			}
+		}
+
 		// query.Limit = 50
 		// spew.Dump(query)
 		err = g.feedManager.CreateStreamHistory(ctx, req.Stream, query)
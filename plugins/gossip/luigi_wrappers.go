@@ -8,30 +8,26 @@ import (
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/muxrpc/codec"
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message/multimsg"
 	refs "go.mindeco.de/ssb-refs"
 )
 
-func gabbyStreamSink(stream luigi.Sink) luigi.Sink {
+// feedFormatStreamSink sends the transfer encoding of the binary feed format ff
+func feedFormatStreamSink(ff ssb.FeedFormat, stream luigi.Sink) luigi.Sink {
 	return luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
 		if err != nil {
 			return err
 		}
 		mm, ok := v.(*multimsg.MultiMessage)
 		if !ok {
-			return errors.Errorf("binStream: expected []byte - got %T", v)
+			return errors.Errorf("%sStream: expected *multimsg.MultiMessage - got %T", ff.Algo(), v)
 		}
-		tr, ok := mm.AsGabby()
-		if !ok {
-			return errors.Errorf("wrong mm type")
-		}
-
-		trdata, err := tr.MarshalCBOR()
+		data, err := ff.Encode(mm.Message)
 		if err != nil {
-			return errors.Wrap(err, "failed to marshal transfer")
+			return errors.Wrapf(err, "%sStream: failed to encode message", ff.Algo())
 		}
-
-		return stream.Pour(ctx, codec.Body(trdata))
+		return stream.Pour(ctx, codec.Body(data))
 	})
 }
 
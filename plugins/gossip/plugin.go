@@ -13,6 +13,10 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/gaps"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/peerstats"
 	refs "go.mindeco.de/ssb-refs"
 )
 
@@ -22,6 +26,14 @@ type HopCount int
 
 type Promisc bool
 
+// VerifyWorkers sets the number of goroutines that verify the signatures of fetched messages.
+// Zero verifies them one by one as they arrive.
+type VerifyWorkers int
+
+// AuthorizeFormat is asked again with the feed the remote negotiated (see whoami.Negotiate).
+// The connection was accepted before the format was known, if it returns an error it is closed.
+type AuthorizeFormat func(*refs.FeedRef) error
+
 func New(
 	ctx context.Context,
 	log logging.Interface,
@@ -59,6 +71,22 @@ func New(
 			h.hmacSec = v
 		case Promisc:
 			h.promisc = bool(v)
+		case VerifyWorkers:
+			h.verifyWorkers = int(v)
+		case *ssb.FormatTracker:
+			h.formats = v
+		case AuthorizeFormat:
+			h.authorizeFormat = v
+		case *peerstats.Store:
+			h.peerStats = v
+		case PolicyFunc:
+			h.policy = v
+		case *gaps.Store:
+			h.gaps = v
+		case *forks.Store:
+			h.forks = v
+		case *message.FeedLocks:
+			h.feedLocks = v
 		default:
 			log.Log("warning", "unhandled option", "i", i, "type", fmt.Sprintf("%T", o))
 		}
@@ -108,6 +136,22 @@ func NewHist(
 			h.hopCount = int(v)
 		case HMACSecret:
 			h.hmacSec = v
+		case VerifyWorkers:
+			h.verifyWorkers = int(v)
+		case *ssb.FormatTracker:
+			h.formats = v
+		case AuthorizeFormat:
+			h.authorizeFormat = v
+		case *peerstats.Store:
+			h.peerStats = v
+		case PolicyFunc:
+			h.policy = v
+		case *gaps.Store:
+			h.gaps = v
+		case *forks.Store:
+			h.forks = v
+		case *message.FeedLocks:
+			h.feedLocks = v
 		default:
 			log.Log("warning", "unhandled hist option", "i", i, "type", fmt.Sprintf("%T", o))
 		}
@@ -134,6 +178,15 @@ func (p plugin) Handler() muxrpc.Handler {
 	return p.h
 }
 
+// Drain stops starting new feed fetches and waits for the running ones to finish, or until ctx is done.
+// Messages are appended one by one, so a fetch that is cut off afterwards stops at a message boundary.
+func (p plugin) Drain(ctx context.Context) error {
+	return p.h.drain(ctx)
+}
+
+// SetPromisc changes the promiscuous mode for the next connections
+func (p plugin) SetPromisc(yes bool) { p.h.setPromisc(yes) }
+
 type histPlugin struct {
 	h *handler
 }
@@ -151,3 +204,6 @@ func (IgnoreConnectHandler) HandleConnect(ctx context.Context, edp muxrpc.Endpoi
 func (hp histPlugin) Handler() muxrpc.Handler {
 	return IgnoreConnectHandler{hp.h}
 }
+
+// SetPromisc changes if the blocklist is checked for incoming requests
+func (hp histPlugin) SetPromisc(yes bool) { hp.h.setPromisc(yes) }
@@ -0,0 +1,152 @@
+// SPDX-License-Identifier: MIT
+
+package gossip
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/gaps"
+	"go.cryptoscope.co/ssb/message"
+)
+
+// Policy restricts what is fetched of a feed
+type Policy struct {
+	// Latest only fetches the newest N messages (if > 0), using partialReplication.getFeedReverse.
+	// The feed is updated normally from there on.
+	Latest int
+
+	// Types only fetches the messages of these types, using partialReplication.getMessagesOfType.
+	// It takes precedence over Latest.
+	Types []string
+}
+
+// PolicyFunc returns the policy for a feed or nil if it should be fetched completely.
+// It is only asked for (legacy) feeds of which nothing is stored yet.
+type PolicyFunc func(*refs.FeedRef) *Policy
+
+// fetchPartial fetches the feed fr according to policy pol. It expects that nothing of fr is stored yet, unless it is sparse.
+// The stored messages are marked in the gap store, so that the sequence checks and createHistoryStream know about them.
+// It returns the number of stored messages.
+func (g *handler) fetchPartial(
+	ctx context.Context,
+	fr *refs.FeedRef,
+	edp muxrpc.Endpoint,
+	pol Policy,
+	latestMsg refs.Message,
+) (int, error) {
+	info := log.With(g.Info, "event", "gossiprx", "fr", fr.ShortRef(), "partial", true)
+
+	var (
+		msgs   []json.RawMessage
+		marker = gaps.Marker{Feed: fr}
+	)
+	if len(pol.Types) > 0 {
+		marker.Sparse = true
+		marker.Types = pol.Types
+		for _, t := range pol.Types {
+			args := map[string]interface{}{"id": fr.Ref(), "type": t}
+			ofType, err := g.collect(ctx, edp, muxrpc.Method{"partialReplication", "getMessagesOfType"}, args)
+			if err != nil {
+				return 0, errors.Wrapf(err, "fetchPartial(%s): failed to get messages of type %s", fr.ShortRef(), t)
+			}
+			msgs = append(msgs, ofType...)
+		}
+	} else if pol.Latest > 0 {
+		args := message.CreateHistArgs{
+			ID:         fr,
+			StreamArgs: message.StreamArgs{Limit: int64(pol.Latest)},
+		}
+		var err error
+		msgs, err = g.collect(ctx, edp, muxrpc.Method{"partialReplication", "getFeedReverse"}, args)
+		if err != nil {
+			return 0, errors.Wrapf(err, "fetchPartial(%s): failed to get latest messages", fr.ShortRef())
+		}
+	} else {
+		return 0, errors.Errorf("fetchPartial(%s): empty policy", fr.ShortRef())
+	}
+
+	// oldest first, dropping the ones we already have
+	type seqMsg struct {
+		seq int64
+		raw json.RawMessage
+	}
+	var sorted []seqMsg
+	for _, m := range msgs {
+		var hdr struct {
+			Sequence int64 `json:"sequence"`
+		}
+		if err := json.Unmarshal(m, &hdr); err != nil {
+			return 0, errors.Wrapf(err, "fetchPartial(%s): invalid message", fr.ShortRef())
+		}
+		if latestMsg != nil && hdr.Sequence <= latestMsg.Seq() {
+			continue
+		}
+		sorted = append(sorted, seqMsg{hdr.Sequence, m})
+	}
+	if len(sorted) == 0 {
+		return 0, nil
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+
+	if !marker.Sparse {
+		marker.From = sorted[0].seq
+	}
+	// a tail that starts at the beginning is the complete feed
+	if marker.Sparse || marker.From > 1 {
+		if err := g.gaps.Mark(marker); err != nil {
+			return 0, err
+		}
+	} else if err := g.gaps.Clear(fr); err != nil {
+		return 0, err
+	}
+
+	store := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
+		if err != nil {
+			return err
+		}
+		_, err = g.RootLog.Append(val)
+		return errors.Wrap(err, "failed to append verified message to rootLog")
+	})
+	snk := message.NewPartialVerifySink(fr, margaret.BaseSeq(0), latestMsg, store, g.hmacSec, marker.Sparse)
+	for i, m := range sorted {
+		if err := snk.Pour(ctx, m.raw); err != nil {
+			return i, errors.Wrap(err, "partial fetch failed")
+		}
+	}
+	level.Debug(info).Log("stored", len(sorted), "sparse", marker.Sparse, "from", marker.From)
+	return len(sorted), nil
+}
+
+// collect reads all the messages of a source call
+func (g *handler) collect(ctx context.Context, edp muxrpc.Endpoint, method muxrpc.Method, args interface{}) ([]json.RawMessage, error) {
+	src, err := edp.Source(ctx, json.RawMessage{}, method, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []json.RawMessage
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		raw, ok := v.(json.RawMessage)
+		if !ok {
+			return nil, errors.Errorf("expected %T - got %T", raw, v)
+		}
+		msgs = append(msgs, raw)
+	}
+	return msgs, nil
+}
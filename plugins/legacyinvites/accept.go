@@ -0,0 +1,51 @@
+package legacyinvites
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/go-kit/kit/log/level"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/client"
+	"go.cryptoscope.co/ssb/invite"
+)
+
+// AcceptResult are the messages that were published by Accept
+type AcceptResult struct {
+	Follow *refs.MessageRef `json:"follow"`
+	Pub    *refs.MessageRef `json:"pub"`
+}
+
+// Accept redeems the invite tok of another peer for our own feed.
+// Afterwards it follows the peer, publishes its address in a pub message (the address book other peers use, too)
+// and connects to it.
+func (s *Service) Accept(ctx context.Context, tok invite.Token) (*AcceptResult, error) {
+	pubMsg, err := invite.NewPubMessageFromToken(tok)
+	if err != nil {
+		return nil, fmt.Errorf("invite/accept: %w", err)
+	}
+
+	err = invite.Redeem(ctx, tok, s.self, client.WithSHSAppKey(base64.StdEncoding.EncodeToString(s.appKey)))
+	if err != nil {
+		return nil, fmt.Errorf("invite/accept: failed to redeem invite (%w)", err)
+	}
+
+	var res AcceptResult
+	res.Follow, err = s.publish.Publish(refs.NewContactFollow(&tok.Peer))
+	if err != nil {
+		return nil, fmt.Errorf("invite/accept: failed to publish follow (%w)", err)
+	}
+
+	res.Pub, err = s.publish.Publish(pubMsg)
+	if err != nil {
+		return nil, fmt.Errorf("invite/accept: failed to publish pub address (%w)", err)
+	}
+
+	// TODO: add context to tracker to cancel connections (like ctrl.connect)
+	if err := s.network.Connect(context.Background(), tok.Address); err != nil {
+		level.Warn(s.logger).Log("event", "invite accepted", "msg", "failed to connect to peer", "err", err)
+	}
+	return &res, nil
+}
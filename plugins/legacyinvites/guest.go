@@ -19,8 +19,6 @@ type acceptHandler struct {
 func (h acceptHandler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
 func (h acceptHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
-	h.service.mu.Lock()
-	defer h.service.mu.Unlock()
 	if req.Method.String() != "invite.use" {
 		req.CloseWithError(fmt.Errorf("unknown method"))
 		return
@@ -37,75 +35,23 @@ func (h acceptHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 
 	if len(args) != 1 {
 		req.CloseWithError(fmt.Errorf("invalid argument count"))
-	}
-	arg := args[0]
-
-	guestRef, err := ssb.GetFeedRefFromAddr(edp.Remote())
-	if err != nil {
-		req.CloseWithError(errors.Wrap(err, "no guest ref!?"))
 		return
 	}
-
-	// lookup guest key
-	if err := h.service.kv.BeginTransaction(); err != nil {
-		req.CloseWithError(err)
+	arg := args[0]
+	if arg.Feed == nil {
+		req.CloseWithError(fmt.Errorf("invite/use: no feed to follow"))
 		return
 	}
 
-	kvKey := []byte(guestRef.StoredAddr())
-
-	has, err := h.service.kv.Get(nil, kvKey)
+	guestRef, err := ssb.GetFeedRefFromAddr(edp.Remote())
 	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed get guest remote from KV (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-	if has == nil {
-		h.service.kv.Rollback()
-		err = errors.New("not for us")
-		req.CloseWithError(err)
-		return
-	}
-
-	var st inviteState
-	if err := json.Unmarshal(has, &st); err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed to probe new key (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-
-	if st.Used >= st.Uses {
-		h.service.kv.Delete(kvKey)
-		h.service.kv.Commit()
-		err = fmt.Errorf("invite/kv: invite depleeted")
-		req.CloseWithError(err)
+		req.CloseWithError(errors.Wrap(err, "no guest ref!?"))
 		return
 	}
 
-	// count uses
-	st.Used++
-
-	updatedState, err := json.Marshal(st)
-	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed marshal updated state data (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-	err = h.service.kv.Set(kvKey, updatedState)
-	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed save updated state data (%w)", err)
-		req.CloseWithError(err)
-		return
-	}
-	err = h.service.kv.Commit()
+	st, err := h.service.redeem(guestRef, arg.Feed)
 	if err != nil {
-		h.service.kv.Rollback()
-		err = fmt.Errorf("invite/kv: failed to commit kv transaction (%w)", err)
-		req.CloseWithError(err)
+		req.CloseWithError(fmt.Errorf("invite/use: %w", err))
 		return
 	}
 
@@ -132,5 +78,5 @@ func (h acceptHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 	}
 	req.Return(ctx, msgv)
 
-	h.service.logger.Log("invite", "used")
+	h.service.logger.Log("invite", "used", "by", arg.Feed.ShortRef())
 }
@@ -1,14 +1,20 @@
 package legacyinvites
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/invite"
 )
 
-// supplies create, use and other managment calls (maybe list and delete?)
+// supplies create, list, revoke and accept managment calls
 type masterPlug struct {
 	service *Service
 }
@@ -37,19 +43,38 @@ type createArguments struct {
 
 	// a note to organize invites (also posted when used)
 	Note string `json:"note,omitempty"`
+
+	// after how many seconds the invite expires (0 uses the default of the bot)
+	ExpiresIn uint `json:"expiresIn,omitempty"`
 }
 
 func (h createHandler) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
 func (h createHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
-	if req.Method.String() != "invite.create" {
+	switch req.Method.String() {
+	case "invite.create":
+		h.create(ctx, req)
+	case "invite.list":
+		h.list(ctx, req)
+	case "invite.revoke":
+		h.revoke(ctx, req)
+	case "invite.accept":
+		h.accept(ctx, req)
+	default:
 		req.CloseWithError(fmt.Errorf("unknown method"))
-		return
 	}
+}
 
-	// parse passed arguments
+func (h createHandler) create(ctx context.Context, req *muxrpc.Request) {
+	// parse passed arguments, either [{uses, note, expiresIn}] or [uses]
 	var args createArguments
-	if err := json.Unmarshal(req.RawArgs, &args); err != nil {
+	var objArgs []createArguments
+	var numArgs []uint
+	if err := json.Unmarshal(req.RawArgs, &objArgs); err == nil && len(objArgs) == 1 {
+		args = objArgs[0]
+	} else if err := json.Unmarshal(req.RawArgs, &numArgs); err == nil && len(numArgs) == 1 {
+		args.Uses = numArgs[0]
+	} else {
 		args.Uses = 1
 	}
 
@@ -58,7 +83,15 @@ func (h createHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 		return
 	}
 
-	inv, err := h.service.Create(args.Uses, args.Note)
+	var (
+		inv *invite.Token
+		err error
+	)
+	if args.ExpiresIn > 0 {
+		inv, err = h.service.CreateWithExpiry(args.Uses, args.Note, time.Duration(args.ExpiresIn)*time.Second)
+	} else {
+		inv, err = h.service.Create(args.Uses, args.Note)
+	}
 	if err != nil {
 		req.CloseWithError(fmt.Errorf("failed to create invite"))
 		return
@@ -67,3 +100,75 @@ func (h createHandler) HandleCall(ctx context.Context, req *muxrpc.Request, edp
 	req.Return(ctx, inv.String())
 	h.service.logger.Log("invite", "created", "uses", args.Uses)
 }
+
+// list returns the usable invites, or all of them with [{"all": true}]
+func (h createHandler) list(ctx context.Context, req *muxrpc.Request) {
+	var args []struct {
+		All bool `json:"all"`
+	}
+	var all bool
+	if err := json.Unmarshal(req.RawArgs, &args); err == nil && len(args) == 1 {
+		all = args[0].All
+	}
+
+	lst, err := h.service.List(all)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, lst)
+}
+
+// revoke takes the id of an invite (from invite.list) or the invite code itself
+func (h createHandler) revoke(ctx context.Context, req *muxrpc.Request) {
+	var args []string
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil || len(args) != 1 {
+		req.CloseWithError(fmt.Errorf("usage: invite.revoke <id or invite code>"))
+		return
+	}
+
+	id, err := refs.ParseFeedRef(args[0])
+	if err != nil {
+		tok, tokErr := invite.ParseLegacyToken(args[0])
+		if tokErr != nil {
+			req.CloseWithError(fmt.Errorf("invite/revoke: neither an id nor an invite code (%w)", tokErr))
+			return
+		}
+		kp, err := ssb.NewKeyPair(bytes.NewReader(tok.Seed[:]))
+		if err != nil {
+			req.CloseWithError(fmt.Errorf("invite/revoke: failed to derive the invite key (%w)", err))
+			return
+		}
+		id = kp.Id
+	}
+
+	if err := h.service.Revoke(id); err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, "revoked")
+	h.service.logger.Log("invite", "revoked", "id", id.ShortRef())
+}
+
+// accept redeems the invite code of another peer, see Service.Accept
+func (h createHandler) accept(ctx context.Context, req *muxrpc.Request) {
+	var args []string
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil || len(args) != 1 {
+		req.CloseWithError(fmt.Errorf("usage: invite.accept <invite code>"))
+		return
+	}
+
+	tok, err := invite.ParseLegacyToken(args[0])
+	if err != nil {
+		req.CloseWithError(fmt.Errorf("invite/accept: invalid invite code (%w)", err))
+		return
+	}
+
+	res, err := h.service.Accept(ctx, tok)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	req.Return(ctx, res)
+	h.service.logger.Log("invite", "accepted", "peer", tok.Peer.ShortRef())
+}
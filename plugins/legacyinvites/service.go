@@ -5,12 +5,18 @@ import (
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net"
+	"sort"
 	"sync"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
 	refs "go.mindeco.de/ssb-refs"
 	"modernc.org/kv"
 
@@ -25,11 +31,22 @@ type Service struct {
 	self    *refs.FeedRef
 	network ssb.Network
 
+	// appKey is the secret-handshake capability, used to redeem invites of other peers
+	appKey []byte
+
+	// publicAddr is put into the tokens instead of the listen address if set
+	publicAddr net.Addr
+
+	// defaultExpiry is used for invites that are created without an expiry time (0 means never)
+	defaultExpiry time.Duration
+
 	publish    ssb.Publisher
 	receiveLog margaret.Log
 
 	mu sync.Mutex
 	kv *kv.DB
+
+	now func() time.Time
 }
 
 func (s *Service) GuestHandler() muxrpc.Handler {
@@ -48,35 +65,18 @@ func (s *Service) Authorize(to *refs.FeedRef) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	kvKey := []byte(to.StoredAddr())
-
-	if err := s.kv.BeginTransaction(); err != nil {
-		return err
-	}
-
-	has, err := s.kv.Get(nil, kvKey)
+	st, err := s.get([]byte(to.StoredAddr()))
 	if err != nil {
-		s.kv.Rollback()
-		return fmt.Errorf("invite/auth: failed get guest remote from KV (%w)", err)
+		return fmt.Errorf("invite/auth: %w", err)
 	}
-	if has == nil {
-		s.kv.Rollback()
+	if st == nil {
 		return errors.New("not for us")
 	}
 
-	var st inviteState
-	if err := json.Unmarshal(has, &st); err != nil {
-		s.kv.Rollback()
-		return fmt.Errorf("invite/auth: failed to probe new key (%w)", err)
-	}
-
-	if st.Used >= st.Uses {
-		s.kv.Delete(kvKey)
-		s.kv.Commit()
-		return fmt.Errorf("invite/auth: invite depleeted")
+	if err := st.usable(s.now()); err != nil {
+		return fmt.Errorf("invite/auth: %w", err)
 	}
-
-	return s.kv.Commit()
+	return nil
 }
 
 var _ ssb.Authorizer = (*Service)(nil)
@@ -86,6 +86,9 @@ func New(
 	r repo.Interface,
 	self *refs.FeedRef,
 	nw ssb.Network,
+	appKey []byte,
+	publicAddr net.Addr,
+	defaultExpiry time.Duration,
 	publish ssb.Publisher,
 	rlog margaret.Log,
 ) (*Service, error) {
@@ -97,20 +100,31 @@ func New(
 	return &Service{
 		logger: logger,
 
-		self:    self,
-		network: nw,
+		self:          self,
+		network:       nw,
+		appKey:        appKey,
+		publicAddr:    publicAddr,
+		defaultExpiry: defaultExpiry,
 
 		receiveLog: rlog,
 		publish:    publish,
 
 		kv: kv,
+
+		now: time.Now,
 	}, nil
 }
 
 // Close closes the underlying key-value database
-func (s Service) Close() error { return s.kv.Close() }
+func (s *Service) Close() error { return s.kv.Close() }
+
+// Create makes a new invite that can be used uses times and expires after the default expiry time of the service
+func (s *Service) Create(uses uint, note string) (*invite.Token, error) {
+	return s.CreateWithExpiry(uses, note, s.defaultExpiry)
+}
 
-func (s Service) Create(uses uint, note string) (*invite.Token, error) {
+// CreateWithExpiry makes a new invite that can be used uses times and expires after expiresIn (0 means never)
+func (s *Service) CreateWithExpiry(uses uint, note string, expiresIn time.Duration) (*invite.Token, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if err := s.kv.BeginTransaction(); err != nil {
@@ -144,28 +158,259 @@ func (s Service) Create(uses uint, note string) (*invite.Token, error) {
 	st := inviteState{Used: 0}
 	st.Uses = uses
 	st.Note = note
+	st.ID = seedRef
+	st.Created = s.now()
+	if expiresIn > 0 {
+		st.Expires = st.Created.Add(expiresIn)
+	}
 
-	data, err := json.Marshal(st)
+	if err := s.set(&st); err != nil {
+		s.kv.Rollback()
+		return nil, fmt.Errorf("invite/create: %w", err)
+	}
+
+	inv.Peer = *s.self
+	if s.publicAddr != nil {
+		inv.Address = netwrap.WrapAddr(s.publicAddr, secretstream.Addr{PubKey: s.self.PubKey()})
+	} else {
+		inv.Address = s.network.GetListenAddr()
+	}
+
+	return &inv, s.kv.Commit()
+}
+
+// ErrNoSuchInvite is returned by Revoke if the invite isn't known
+var ErrNoSuchInvite = errors.New("invite: no such invite")
+
+// Revoke makes the invite with the passed id unusable, it stays in the list with its redemptions.
+func (s *Service) Revoke(id *refs.FeedRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.kv.BeginTransaction(); err != nil {
+		return err
+	}
+
+	st, err := s.get([]byte(id.StoredAddr()))
 	if err != nil {
 		s.kv.Rollback()
-		return nil, fmt.Errorf("invite/create: failed to marshal state data (%w)", err)
+		return fmt.Errorf("invite/revoke: %w", err)
+	}
+	if st == nil {
+		s.kv.Rollback()
+		return ErrNoSuchInvite
+	}
+	if st.ID == nil { // created before the id was stored
+		st.ID = id
+	}
+
+	st.Revoked = true
+	if err := s.set(st); err != nil {
+		s.kv.Rollback()
+		return fmt.Errorf("invite/revoke: %w", err)
 	}
+	return s.kv.Commit()
+}
+
+// List returns the invites that can still be used, or all of them, oldest first
+func (s *Service) List(all bool) ([]Invite, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	iter, err := s.kv.SeekFirst()
+	if err == io.EOF {
+		return []Invite{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("invite/list: failed to iterate invites (%w)", err)
+	}
+
+	now := s.now()
+	lst := []Invite{}
+	for {
+		_, data, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invite/list: failed to get next invite (%w)", err)
+		}
+
+		var st inviteState
+		if err := json.Unmarshal(data, &st); err != nil {
+			return nil, fmt.Errorf("invite/list: invalid state data (%w)", err)
+		}
+
+		inv := st.invite(now)
+		if !all && inv.Status != StatusActive {
+			continue
+		}
+		lst = append(lst, inv)
+	}
+
+	sort.SliceStable(lst, func(i, j int) bool { return lst[i].Created.Before(lst[j].Created) })
+	return lst, nil
+}
 
-	err = s.kv.Set([]byte(seedRef.StoredAddr()), data)
+// redeem counts a use of the invite with the key guest by feed.
+// It returns the state of the invite before it was used.
+// The check and the count happen under the lock, so concurrent uses can't redeem it more often than allowed.
+func (s *Service) redeem(guest, feed *refs.FeedRef) (*inviteState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.kv.BeginTransaction(); err != nil {
+		return nil, err
+	}
+
+	st, err := s.get([]byte(guest.StoredAddr()))
 	if err != nil {
 		s.kv.Rollback()
-		return nil, fmt.Errorf("invite/create: failed to store state data (%w)", err)
+		return nil, err
+	}
+	if st == nil {
+		s.kv.Rollback()
+		return nil, errors.New("not for us")
 	}
 
-	inv.Peer = *s.self
-	// TODO: external host configuration?
-	inv.Address = s.network.GetListenAddr()
+	now := s.now()
+	if err := st.usable(now); err != nil {
+		s.kv.Rollback()
+		return nil, err
+	}
 
-	return &inv, s.kv.Commit()
+	if st.ID == nil { // created before the id was stored
+		st.ID = guest
+	}
+
+	// count uses
+	st.Used++
+	st.Redemptions = append(st.Redemptions, Redemption{Feed: feed, At: now})
+
+	if err := s.set(st); err != nil {
+		s.kv.Rollback()
+		return nil, err
+	}
+	if err := s.kv.Commit(); err != nil {
+		s.kv.Rollback()
+		return nil, fmt.Errorf("invite/kv: failed to commit kv transaction (%w)", err)
+	}
+	return st, nil
+}
+
+func (s *Service) get(key []byte) (*inviteState, error) {
+	data, err := s.kv.Get(nil, key)
+	if err != nil {
+		return nil, fmt.Errorf("invite/kv: failed get guest remote from KV (%w)", err)
+	}
+	if data == nil {
+		return nil, nil
+	}
+
+	var st inviteState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("invite/kv: failed to unmarshal state data (%w)", err)
+	}
+	return &st, nil
+}
+
+func (s *Service) set(st *inviteState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("invite/kv: failed to marshal state data (%w)", err)
+	}
+
+	if err := s.kv.Set([]byte(st.ID.StoredAddr()), data); err != nil {
+		return fmt.Errorf("invite/kv: failed to store state data (%w)", err)
+	}
+	return nil
 }
 
 type inviteState struct {
 	createArguments
 
 	Used uint // how many times this invite was used already
+
+	// the public key of the invite seed, not set on invites from older versions until they are used or revoked
+	ID *refs.FeedRef `json:",omitempty"`
+
+	Created time.Time
+	Expires time.Time // zero means never
+
+	Revoked bool
+
+	Redemptions []Redemption `json:",omitempty"`
+}
+
+var (
+	errInviteDepleted = errors.New("invite depleeted")
+	errInviteExpired  = errors.New("invite expired")
+	errInviteRevoked  = errors.New("invite revoked")
+)
+
+// usable checks if the invite can still be used at the time now
+func (st inviteState) usable(now time.Time) error {
+	switch {
+	case st.Revoked:
+		return errInviteRevoked
+	case !st.Expires.IsZero() && now.After(st.Expires):
+		return errInviteExpired
+	case st.Used >= st.Uses:
+		return errInviteDepleted
+	}
+	return nil
+}
+
+// the status of an invite in the list
+const (
+	StatusActive  = "active"
+	StatusUsed    = "used"
+	StatusExpired = "expired"
+	StatusRevoked = "revoked"
+)
+
+func (st inviteState) invite(now time.Time) Invite {
+	inv := Invite{
+		ID:          st.ID,
+		Uses:        st.Uses,
+		Used:        st.Used,
+		Note:        st.Note,
+		Created:     st.Created,
+		Expires:     st.Expires,
+		Redemptions: st.Redemptions,
+	}
+
+	switch st.usable(now) {
+	case nil:
+		inv.Status = StatusActive
+	case errInviteRevoked:
+		inv.Status = StatusRevoked
+	case errInviteExpired:
+		inv.Status = StatusExpired
+	default:
+		inv.Status = StatusUsed
+	}
+	return inv
+}
+
+// Invite is an entry of invite.list
+type Invite struct {
+	// ID is the public key of the invite, used to revoke it.
+	// It is only known for invites that were created with this version (or used since).
+	ID *refs.FeedRef `json:"id,omitempty"`
+
+	Status string `json:"status"`
+
+	Uses uint   `json:"uses"`
+	Used uint   `json:"used"`
+	Note string `json:"note,omitempty"`
+
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+
+	Redemptions []Redemption `json:"redemptions,omitempty"`
+}
+
+// Redemption is a use of an invite
+type Redemption struct {
+	Feed *refs.FeedRef `json:"feed"`
+	At   time.Time     `json:"at"`
 }
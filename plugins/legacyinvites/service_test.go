@@ -0,0 +1,231 @@
+package legacyinvites
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/muxrpc"
+	"go.cryptoscope.co/netwrap"
+	"go.cryptoscope.co/secretstream"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/network/netaddr"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestServiceExpiryAndRevoke(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	self := &refs.FeedRef{ID: bytes.Repeat([]byte("p"), 32), Algo: refs.RefAlgoFeedSSB1}
+	alice := &refs.FeedRef{ID: bytes.Repeat([]byte("a"), 32), Algo: refs.RefAlgoFeedSSB1}
+
+	pubAddr := netaddr.HostAddr{Host: "pub.example.com", Port: 8008}
+	s, err := New(kitlog.NewNopLogger(), repo.New(tRepoPath), self, nil, nil, pubAddr, time.Hour, nil, nil)
+	r.NoError(err)
+	defer s.Close()
+
+	now := time.Unix(1600000000, 0)
+	s.now = func() time.Time { return now }
+
+	guestOf := func(seed [32]byte) *refs.FeedRef {
+		kp, err := ssb.NewKeyPair(bytes.NewReader(seed[:]))
+		r.NoError(err)
+		return kp.Id
+	}
+
+	// uses the default expiry of the service
+	tok1, err := s.Create(1, "one hour")
+	r.NoError(err)
+	a.Contains(tok1.String(), "pub.example.com:8008:")
+	guest1 := guestOf(tok1.Seed)
+
+	// no expiry
+	now = now.Add(time.Second)
+	tok2, err := s.CreateWithExpiry(2, "forever", 0)
+	r.NoError(err)
+	guest2 := guestOf(tok2.Seed)
+
+	now = now.Add(time.Second)
+	tok3, err := s.CreateWithExpiry(5, "to revoke", 0)
+	r.NoError(err)
+	guest3 := guestOf(tok3.Seed)
+
+	lst, err := s.List(false)
+	r.NoError(err)
+	a.Len(lst, 3)
+
+	// use one
+	r.NoError(s.Authorize(guest2))
+	_, err = s.redeem(guest2, alice)
+	r.NoError(err)
+
+	// revoke one
+	r.NoError(s.Revoke(guest3))
+	a.Error(s.Authorize(guest3))
+	a.Equal(ErrNoSuchInvite, s.Revoke(alice))
+
+	// let the first one expire
+	now = now.Add(2 * time.Hour)
+	a.Error(s.Authorize(guest1))
+	_, err = s.redeem(guest1, alice)
+	a.Error(err)
+
+	lst, err = s.List(false)
+	r.NoError(err)
+	r.Len(lst, 1, "only the unlimited one is left")
+	a.True(lst[0].ID.Equal(guest2))
+	a.Equal(uint(1), lst[0].Used)
+	r.Len(lst[0].Redemptions, 1)
+	a.True(lst[0].Redemptions[0].Feed.Equal(alice))
+
+	// use it up
+	_, err = s.redeem(guest2, alice)
+	r.NoError(err)
+	a.Error(s.Authorize(guest2))
+
+	lst, err = s.List(true)
+	r.NoError(err)
+	r.Len(lst, 3, "used up invites are kept for their history")
+	a.Equal(StatusExpired, lst[0].Status)
+	a.Equal(StatusUsed, lst[1].Status)
+	a.Len(lst[1].Redemptions, 2)
+	a.Equal(StatusRevoked, lst[2].Status)
+}
+
+func TestServiceConcurrentRedeem(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	self := &refs.FeedRef{ID: bytes.Repeat([]byte("p"), 32), Algo: refs.RefAlgoFeedSSB1}
+
+	pubAddr := netaddr.HostAddr{Host: "pub.example.com", Port: 8008}
+	s, err := New(kitlog.NewNopLogger(), repo.New(tRepoPath), self, nil, nil, pubAddr, 0, nil, nil)
+	r.NoError(err)
+	defer s.Close()
+
+	tok, err := s.Create(1, "once")
+	r.NoError(err)
+	kp, err := ssb.NewKeyPair(bytes.NewReader(tok.Seed[:]))
+	r.NoError(err)
+
+	const n = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		redeemed int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i byte) {
+			defer wg.Done()
+			who := &refs.FeedRef{ID: bytes.Repeat([]byte{i}, 32), Algo: refs.RefAlgoFeedSSB1}
+			if _, err := s.redeem(kp.Id, who); err == nil {
+				mu.Lock()
+				redeemed++
+				mu.Unlock()
+			}
+		}(byte(i))
+	}
+	wg.Wait()
+	a.Equal(1, redeemed, "a single use invite can only be redeemed once")
+
+	lst, err := s.List(true)
+	r.NoError(err)
+	r.Len(lst, 1)
+	a.Equal(uint(1), lst[0].Used)
+	a.Len(lst[0].Redemptions, 1)
+	a.Equal(StatusUsed, lst[0].Status)
+}
+
+// remoteConn lets the muxrpc endpoint report the guest as the remote, like a secret-handshake connection would
+type remoteConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (rc remoteConn) RemoteAddr() net.Addr { return rc.remote }
+
+type nopHandler struct{}
+
+func (nopHandler) HandleConnect(context.Context, muxrpc.Endpoint)               {}
+func (nopHandler) HandleCall(context.Context, *muxrpc.Request, muxrpc.Endpoint) {}
+
+func TestGuestHandlerUse(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	rootLog, err := repo.OpenLog(tRepo)
+	r.NoError(err)
+	uf, _, err := multilogs.OpenUserFeeds(tRepo)
+	r.NoError(err)
+	defer uf.Close()
+
+	self, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	publish, err := message.OpenPublishLog(rootLog, uf, self)
+	r.NoError(err)
+
+	pubAddr := netaddr.HostAddr{Host: "pub.example.com", Port: 8008}
+	s, err := New(kitlog.NewNopLogger(), tRepo, self.Id, nil, nil, pubAddr, 0, publish, rootLog)
+	r.NoError(err)
+	defer s.Close()
+
+	tok, err := s.Create(1, "once")
+	r.NoError(err)
+	guest, err := ssb.NewKeyPair(bytes.NewReader(tok.Seed[:]))
+	r.NoError(err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cliConn, srvConn := net.Pipe()
+	guestAddr := netwrap.WrapAddr(srvConn.RemoteAddr(), secretstream.Addr{PubKey: guest.Id.PubKey()})
+	srv := muxrpc.Handle(muxrpc.NewPacker(remoteConn{Conn: srvConn, remote: guestAddr}), s.GuestHandler())
+	go srv.(muxrpc.Server).Serve(ctx)
+	cli := muxrpc.Handle(muxrpc.NewPacker(cliConn), nopHandler{})
+	go cli.(muxrpc.Server).Serve(ctx)
+
+	alice := &refs.FeedRef{ID: bytes.Repeat([]byte("a"), 32), Algo: refs.RefAlgoFeedSSB1}
+	use := func() error {
+		_, err := cli.Async(ctx, json.RawMessage{}, muxrpc.Method{"invite", "use"}, map[string]interface{}{"feed": alice.Ref()})
+		return err
+	}
+
+	r.NoError(use(), "first use failed")
+	a.NoError(ctx.Err(), "invite.use hung")
+
+	lst, err := s.List(true)
+	r.NoError(err)
+	r.Len(lst, 1)
+	a.Equal(uint(1), lst[0].Used)
+	a.Equal(StatusUsed, lst[0].Status)
+
+	seq, err := publish.Seq().Value()
+	r.NoError(err)
+	a.Equal(margaret.BaseSeq(0), seq, "expected the follow of the new member")
+
+	// it's used up
+	a.Error(use())
+}
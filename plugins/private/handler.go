@@ -23,8 +23,8 @@ import (
 type handler struct {
 	info logging.Interface
 
-	publish ssb.Publisher
-	read    margaret.Log
+	ids  ssb.Identities
+	read ReadFunc
 }
 
 func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
@@ -58,11 +58,25 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 		if req.Type == "" {
 			req.Type = "async"
 		}
-		if n := len(req.Args()); n != 2 {
-			req.CloseWithError(errors.Errorf("private/publish: bad request. expected 2 argument got %d", n))
+		if n := len(req.Args()); n != 2 && n != 3 {
+			req.CloseWithError(errors.Errorf("private/publish: bad request. expected 2 or 3 arguments got %d", n))
 			return
 		}
 
+		// the optional 3rd argument is {as: nick}
+		var as string
+		if len(req.Args()) == 3 {
+			opts, ok := req.Args()[2].(map[string]interface{})
+			if !ok {
+				req.CloseWithError(errors.Errorf("private/publish: wrong argument type. expected options object but got %T", req.Args()[2]))
+				return
+			}
+			if as, ok = opts["as"].(string); !ok && opts["as"] != nil {
+				req.CloseWithError(errors.Errorf("private/publish: as needs to be a string"))
+				return
+			}
+		}
+
 		msg, err := json.Marshal(req.Args()[0])
 		if err != nil {
 			req.CloseWithError(errors.Wrap(err, "failed to encode message"))
@@ -89,7 +103,7 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 			}
 		}
 
-		ref, err := h.privatePublish(msg, rcpsRefs)
+		ref, err := h.privatePublish(as, msg, rcpsRefs)
 		if err != nil {
 			req.CloseWithError(err)
 			return
@@ -119,13 +133,24 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
 
 func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
-	var qry message.CreateHistArgs
+	var (
+		qry message.CreateHistArgs
+		as  string // read the messages of another local identity
+	)
 
 	args := req.Args()
 	if len(args) > 0 {
 
 		switch v := args[0].(type) {
 		case map[string]interface{}:
+			if asv, has := v["as"]; has {
+				var ok bool
+				if as, ok = asv.(string); !ok {
+					req.CloseWithError(errors.Errorf("privateRead: as needs to be a string"))
+					return
+				}
+				delete(v, "as")
+			}
 			q, err := message.NewCreateHistArgsFromMap(v)
 			if err != nil {
 				req.CloseWithError(errors.Wrap(err, "privateRead: bad request"))
@@ -147,7 +172,18 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 	// well, sorry - the client lib needs better handling of receiving types
 	qry.Keys = true
 
-	src, err := h.read.Query(
+	kp, err := h.ids.KeyPairFor(as)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "private/read: unknown identity"))
+		return
+	}
+	read, err := h.read(kp)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "private/read: failed to open unboxed log"))
+		return
+	}
+
+	src, err := read.Query(
 		margaret.Gte(margaret.BaseSeq(qry.Seq)),
 		margaret.Limit(int(qry.Limit)),
 		margaret.Live(qry.Live))
@@ -164,14 +200,19 @@ func (h handler) privateRead(ctx context.Context, req *muxrpc.Request) {
 	req.Close()
 }
 
-func (h handler) privatePublish(msg []byte, recps []*refs.FeedRef) (*refs.MessageRef, error) {
+func (h handler) privatePublish(as string, msg []byte, recps []*refs.FeedRef) (*refs.MessageRef, error) {
+	publish, err := h.ids.PublisherFor(as)
+	if err != nil {
+		return nil, errors.Wrap(err, "private/publish: unknown identity")
+	}
+
 	boxedMsg, err := private.Box(msg, recps...)
 	if err != nil {
 		return nil, errors.Wrap(err, "private/publish: failed to box message")
 
 	}
 
-	ref, err := h.publish.Publish(boxedMsg)
+	ref, err := publish.Publish(boxedMsg)
 	if err != nil {
 		return nil, errors.Wrap(err, "private/publish: pour failed")
 
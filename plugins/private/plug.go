@@ -13,8 +13,12 @@ type privatePlug struct {
 	h muxrpc.Handler
 }
 
-func NewPlug(i logging.Interface, publish ssb.Publisher, readIdx margaret.Log) ssb.Plugin {
-	return &privatePlug{h: handler{publish: publish, read: readIdx, info: i}}
+// ReadFunc returns the private messages one of the local identities can unbox
+type ReadFunc func(*ssb.KeyPair) (margaret.Log, error)
+
+// NewPlug publishes and reads as the main identity of ids, or the one that is passed with the as option
+func NewPlug(i logging.Interface, ids ssb.Identities, read ReadFunc) ssb.Plugin {
+	return &privatePlug{h: handler{ids: ids, read: read, info: i}}
 }
 
 func (p privatePlug) Name() string {
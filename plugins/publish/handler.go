@@ -4,35 +4,79 @@ package publish
 
 import (
 	"context"
+	"encoding/json"
 
 	"github.com/cryptix/go/logging"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/muxrpc"
+	refs "go.mindeco.de/ssb-refs"
 
 	"go.cryptoscope.co/ssb"
 )
 
 type handler struct {
 	publish ssb.Publisher
+	ids     ssb.Identities
 	rootLog margaret.Log // to get the key back
 	info    logging.Interface
 }
 
+// publisherFor returns the publisher of the identity as, the default one if it's empty
+func (h handler) publisherFor(as string) (ssb.Publisher, error) {
+	if as == "" {
+		return h.publish, nil
+	}
+	if h.ids == nil {
+		return nil, errors.New("publish: this bot has only one identity")
+	}
+	return h.ids.PublisherFor(as)
+}
+
+// publishOptions is the optional second argument of publish
+type publishOptions struct {
+	// As is the nick or feed of the local identity to publish as
+	As string `json:"as"`
+}
+
 func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
-	if n := req.Method.String(); n != "publish" {
+	switch n := req.Method.String(); n {
+	case "publish":
+	case "publish.batch", "publishBatch":
+		h.batch(ctx, req)
+		return
+	default:
 		req.CloseWithError(errors.Errorf("publish: bad request name: %s", n))
 		return
 	}
 
 	args := req.Args()
-	if n := len(args); n != 1 {
-		req.CloseWithError(errors.Errorf("publish: bad request. expected 1 argument got %d", n))
+	if n := len(args); n != 1 && n != 2 {
+		req.CloseWithError(errors.Errorf("publish: bad request. expected 1 or 2 arguments got %d", n))
+		return
+	}
+
+	var opts publishOptions
+	if len(args) == 2 {
+		var raw []json.RawMessage
+		if err := json.Unmarshal(req.RawArgs, &raw); err != nil || len(raw) != 2 {
+			req.CloseWithError(errors.New("publish: invalid arguments"))
+			return
+		}
+		if err := json.Unmarshal(raw[1], &opts); err != nil {
+			req.CloseWithError(errors.Wrap(err, "publish: invalid options"))
+			return
+		}
+	}
+
+	pub, err := h.publisherFor(opts.As)
+	if err != nil {
+		req.CloseWithError(err)
 		return
 	}
 
-	ref, err := h.publish.Publish(args[0])
+	ref, err := pub.Publish(args[0])
 	if err != nil {
 		req.CloseWithError(errors.Wrap(err, "publish: pour failed"))
 		return
@@ -47,4 +91,95 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 	}
 }
 
+// batchOptions is the optional second argument of publish.batch, publishOptions plus previous
+type batchOptions struct {
+	// Previous has to be the newest message of the feed if it is set, null for an empty feed
+	Previous *refs.MessageRef `json:"previous"`
+
+	// set by UnmarshalJSON if previous is present, also if it's null
+	checkPrevious bool
+
+	// As is the nick or feed of the local identity to publish as
+	As string `json:"as"`
+}
+
+func (bo *batchOptions) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if as, has := fields["as"]; has {
+		if err := json.Unmarshal(as, &bo.As); err != nil {
+			return err
+		}
+	}
+	prev, has := fields["previous"]
+	if !has {
+		return nil
+	}
+	bo.checkPrevious = true
+	if string(prev) == "null" {
+		return nil
+	}
+	return json.Unmarshal(prev, &bo.Previous)
+}
+
+// batch publishes publish.batch([content, ...], {previous, as}) and returns the keys of the new messages.
+// Manifest based clients call it as publishBatch.
+func (h handler) batch(ctx context.Context, req *muxrpc.Request) {
+	var args []json.RawMessage
+	if err := json.Unmarshal(req.RawArgs, &args); err != nil || len(args) < 1 || len(args) > 2 {
+		req.CloseWithError(errors.New("publish.batch: expected a list of contents and optional options"))
+		return
+	}
+
+	var contents []interface{}
+	if err := json.Unmarshal(args[0], &contents); err != nil {
+		req.CloseWithError(errors.Wrap(err, "publish.batch: contents need to be a list"))
+		return
+	}
+
+	var opts batchOptions
+	if len(args) == 2 {
+		if err := json.Unmarshal(args[1], &opts); err != nil {
+			req.CloseWithError(errors.Wrap(err, "publish.batch: invalid options"))
+			return
+		}
+	}
+
+	pub, err := h.publisherFor(opts.As)
+	if err != nil {
+		req.CloseWithError(err)
+		return
+	}
+	bp, ok := pub.(ssb.BatchPublisher)
+	if !ok {
+		req.CloseWithError(errors.New("publish.batch: not supported by this publisher"))
+		return
+	}
+
+	var keys []*refs.MessageRef
+	if opts.checkPrevious {
+		keys, err = bp.PublishAfter(opts.Previous, contents...)
+	} else {
+		keys, err = bp.PublishBatch(contents...)
+	}
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "publish.batch failed"))
+		return
+	}
+
+	level.Info(h.info).Log("event", "published messages", "count", len(keys))
+
+	refStrs := make([]string, len(keys))
+	for i, k := range keys {
+		refStrs[i] = k.Ref()
+	}
+	err = req.Return(ctx, refStrs)
+	if err != nil {
+		req.CloseWithError(errors.Wrap(err, "publish.batch: return failed"))
+		return
+	}
+}
+
 func (h handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
@@ -13,9 +13,11 @@ type publishPlug struct {
 	h muxrpc.Handler
 }
 
-func NewPlug(i logging.Interface, publish ssb.Publisher, rootLog margaret.Log) ssb.Plugin {
+// NewPlug publishes with publish, or with one of the identities if the call has an as option. ids can be nil.
+func NewPlug(i logging.Interface, publish ssb.Publisher, rootLog margaret.Log, ids ssb.Identities) ssb.Plugin {
 	return &publishPlug{h: handler{
 		publish: publish,
+		ids:     ids,
 		rootLog: rootLog,
 		info:    i,
 	}}
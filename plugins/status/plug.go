@@ -4,12 +4,19 @@ package status
 
 import (
 	"context"
+	"fmt"
 	"log"
 
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/peerstats"
 )
 
+// PeerHistorian is implemented by bots that keep the connection history of their peers, for status.peers
+type PeerHistorian interface {
+	PeerHistory() ([]peerstats.Peer, error)
+}
+
 type Plugin struct {
 	status ssb.Statuser
 }
@@ -27,6 +34,11 @@ func (lt Plugin) Handler() muxrpc.Handler { return lt }
 func (g Plugin) HandleConnect(ctx context.Context, e muxrpc.Endpoint) {}
 
 func (g Plugin) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.Endpoint) {
+	if req.Method.String() == "status.peers" {
+		g.peers(ctx, req)
+		return
+	}
+
 	s, err := g.status.Status()
 	if err != nil {
 		log.Println("statusErr", err)
@@ -41,3 +53,28 @@ func (g Plugin) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc.
 		return
 	}
 }
+
+func (g Plugin) peers(ctx context.Context, req *muxrpc.Request) {
+	ph, ok := g.status.(PeerHistorian)
+	if !ok {
+		req.CloseWithError(fmt.Errorf("status.peers: no connection history kept"))
+		return
+	}
+
+	peers, err := ph.PeerHistory()
+	if err != nil {
+		log.Println("statusErr", err)
+		req.CloseWithError(err)
+		return
+	}
+	if peers == nil {
+		peers = []peerstats.Peer{}
+	}
+
+	err = req.Return(ctx, peers)
+	if err != nil {
+		log.Println("statusErr", err)
+		req.CloseWithError(err)
+		return
+	}
+}
@@ -3,6 +3,7 @@
 package whoami
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 
@@ -34,6 +35,14 @@ func New(log logging.Interface, id *refs.FeedRef) ssb.Plugin {
 	}}
 }
 
+// NewLocal is for local clients, they can pass {as: nick} to get the feed of one of the other local identities
+func NewLocal(log logging.Interface, ids ssb.Identities) ssb.Plugin {
+	return plugin{handler{
+		log: log,
+		ids: ids,
+	}}
+}
+
 type plugin struct {
 	h handler
 }
@@ -51,6 +60,7 @@ func (plugin) WrapEndpoint(edp muxrpc.Endpoint) interface{} {
 type handler struct {
 	log logging.Interface
 	id  *refs.FeedRef
+	ids ssb.Identities
 }
 
 func (handler) HandleConnect(ctx context.Context, edp muxrpc.Endpoint) {}
@@ -64,14 +74,38 @@ func (h handler) HandleCall(ctx context.Context, req *muxrpc.Request, edp muxrpc
 		req.CloseWithError(fmt.Errorf("wrong method"))
 		return
 	}
-	type ret struct {
-		ID string `json:"id"`
+	id := h.id
+	if h.ids != nil {
+		var as string
+		if args := req.Args(); len(args) > 0 {
+			if opts, ok := args[0].(map[string]interface{}); ok {
+				as, _ = opts["as"].(string)
+			}
+		}
+		kp, err := h.ids.KeyPairFor(as)
+		if err != nil {
+			req.CloseWithError(errors.Wrap(err, "whoami"))
+			return
+		}
+		id = kp.Id
 	}
 
-	err := req.Return(ctx, ret{h.id.Ref()})
+	err := req.Return(ctx, reply{
+		ID: id.Ref(),
+		Formats: ssb.PeerFormats{
+			Publishes:  []string{id.Algo},
+			Replicates: ssb.FeedFormats(),
+		},
+	})
 	checkAndLog(h.log, err)
 }
 
+// reply is the id (like the JS implementation) and the formats we support, which other implementations ignore
+type reply struct {
+	ID      string          `json:"id"`
+	Formats ssb.PeerFormats `json:"formats"`
+}
+
 type endpoint struct {
 	edp muxrpc.Endpoint
 }
@@ -90,3 +124,31 @@ func (edp endpoint) WhoAmI(ctx context.Context) (refs.FeedRef, error) {
 
 	return resp.(respType).ID, nil
 }
+
+// Negotiate calls whoami on the remote to find out the feed formats it uses.
+// The passed remote is the reference we got from the secret handshake.
+// It returns the feed reference the remote publishes under and what it told us about its formats.
+func Negotiate(ctx context.Context, edp muxrpc.Endpoint, remote *refs.FeedRef) (*refs.FeedRef, ssb.PeerFormats, error) {
+	type respType struct {
+		ID      refs.FeedRef    `json:"id"`
+		Formats ssb.PeerFormats `json:"formats"`
+	}
+
+	var tResp respType
+	resp, err := edp.Async(ctx, tResp, method)
+	if err != nil {
+		return nil, ssb.PeerFormats{}, errors.Wrap(err, "whoami/negotiate: error making async call")
+	}
+	got := resp.(respType)
+
+	if !bytes.Equal(got.ID.ID, remote.ID) {
+		return nil, ssb.PeerFormats{}, errors.Errorf("whoami/negotiate: remote claims to be %s", got.ID.Ref())
+	}
+
+	if len(got.Formats.Publishes) == 0 {
+		// older peers only tell us their id
+		got.Formats.Publishes = []string{got.ID.Algo}
+	}
+
+	return got.ID.Copy(), got.Formats, nil
+}
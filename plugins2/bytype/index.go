@@ -4,12 +4,12 @@ package bytype
 
 import (
 	"context"
-	"encoding/json"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/ssb/message/multimsg"
 	"go.cryptoscope.co/ssb/repo"
 	refs "go.mindeco.de/ssb-refs"
 )
@@ -33,15 +33,8 @@ func IndexUpdate(ctx context.Context, seq margaret.Seq, msgv interface{}, mlog m
 		return err
 	}
 
-	var typedMsg struct {
-		Content struct {
-			Type string
-		}
-	}
-
-	content := msg.ValueContentJSON()
-	err := json.Unmarshal(content, &typedMsg)
-	typeStr := typedMsg.Content.Type
+	// only reads the type field, messages that were stored as bipf aren't decoded at all
+	typeStr, err := multimsg.SeekContentString(msg, "type")
 	// TODO: maybe check error with more detail - i.e. only drop type errors
 	if err != nil || typeStr == "" {
 		// TODO: special case boxed messages
@@ -4,13 +4,13 @@ package tangles
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog"
+	"go.cryptoscope.co/ssb/message/multimsg"
 	"go.cryptoscope.co/ssb/repo"
 	refs "go.mindeco.de/ssb-refs"
 )
@@ -31,23 +31,23 @@ func (plug *Plugin) MakeMultiLog(r repo.Interface) (multilog.MultiLog, librarian
 			return err
 		}
 
-		var value struct {
-			Root *refs.MessageRef
-		}
-
-		err := json.Unmarshal(msg.ContentBytes(), &value)
+		rootStr, err := multimsg.SeekContentString(msg, "root")
 		// TODO: maybe check error with more detail - i.e. only drop type errors
-		if err != nil || value.Root == nil {
+		if err != nil {
+			return nil
+		}
+		root, err := refs.ParseMessageRef(rootStr)
+		if err != nil {
 			return nil
 		}
 
-		tangleLog, err := mlog.Get(librarian.Addr(value.Root.Hash))
+		tangleLog, err := mlog.Get(librarian.Addr(root.Hash))
 		if err != nil {
 			return errors.Wrap(err, "error opening sublog")
 		}
 
 		_, err = tangleLog.Append(seq)
-		// log.Println(msg.Key.Ref(), value.Root.Ref(), seq)
+		// log.Println(msg.Key.Ref(), root.Ref(), seq)
 		return errors.Wrapf(err, "error appending root message %v", msg.Key())
 	})
 	plug.h.tangle = mlog
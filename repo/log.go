@@ -4,18 +4,29 @@ package repo
 
 import (
 	"github.com/pkg/errors"
+	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/offset2"
 	"go.cryptoscope.co/ssb/message/multimsg"
 )
 
 func OpenLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
+	// TODO use proper log message type here
+	return openLog(r, multimsg.MargaretCodec{}, path...)
+}
+
+// OpenBIPFLog is like OpenLog but stores new legacy messages as bipf (see multimsg.BIPFCodec).
+// Messages that are already in the log are still read.
+func OpenBIPFLog(r Interface, path ...string) (multimsg.AlterableLog, error) {
+	return openLog(r, multimsg.BIPFCodec{}, path...)
+}
+
+func openLog(r Interface, codec margaret.Codec, path ...string) (multimsg.AlterableLog, error) {
 	// prefix path with "logs" if path is not empty, otherwise use "log"
 	path = append([]string{"log"}, path...)
 	if len(path) > 1 {
 		path[0] = "logs"
 	}
 
-	// TODO use proper log message type here
-	log, err := offset2.Open(r.GetPath(path...), multimsg.MargaretCodec{})
+	log, err := offset2.Open(r.GetPath(path...), codec)
 	return multimsg.NewWrappedLog(log), errors.Wrap(err, "failed to open log")
 }
@@ -13,7 +13,7 @@ import (
 func StillUsingBadger(log logging.Interface, r repo.Interface) (bool, error) {
 	v := CurrentVersion(r)
 	switch {
-	case v == 1:
+	case v == 1, v == 2: // the bipf log doesn't change the indexes
 		// do the deed
 	case v < 1:
 		level.Error(log).Log("event", "repo is not version 1 yet", "v", v)
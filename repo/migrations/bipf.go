@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: MIT
+
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cryptix/go/logging"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/message/legacy"
+	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// UpgradeToBIPF rewrites the receive log of a version 1 repo with multimsg.BIPFCodec and sets the version to 2.
+// The sequence numbers of the messages don't change, so the indexes stay valid.
+// The old log is kept as log-bak-v1.
+func UpgradeToBIPF(log logging.Interface, r repo.Interface) (bool, error) {
+	v := CurrentVersion(r)
+	switch {
+	case v == 1:
+		// do the deed
+	case v == 2:
+		log.Log("level", "info", "msg", "repo already uses bipf", "v", v)
+		return false, nil
+	default:
+		return false, errors.Errorf("sbot/repo migrate: can only convert version 1 repos to bipf, not: %d", v)
+	}
+
+	from, err := repo.OpenLog(r)
+	if err != nil {
+		return false, errors.Wrap(err, "error opening current log")
+	}
+
+	to, err := repo.OpenBIPFLog(r, "migrate-bipf")
+	if err != nil {
+		return false, errors.Wrap(err, "error opening new log")
+	}
+
+	got, err := copyMultiMessages(log, from, to)
+	if err != nil {
+		return false, errors.Wrap(err, "error copying log")
+	}
+
+	if err := validateCopiedLog(log, got, to); err != nil {
+		return false, errors.Wrap(err, "error validating new log")
+	}
+
+	if err := from.Close(); err != nil {
+		return false, errors.Wrap(err, "error closing from log")
+	}
+	if err := to.Close(); err != nil {
+		return false, errors.Wrap(err, "error closing to log")
+	}
+
+	err = os.Rename(r.GetPath("log"), r.GetPath("log-bak-v1"))
+	if err != nil {
+		return false, errors.Wrap(err, "error moving old log into backup position")
+	}
+
+	err = os.Rename(r.GetPath("logs", "migrate-bipf"), r.GetPath("log"))
+	if err != nil {
+		return false, errors.Wrap(err, "error moving migrated log into position")
+	}
+
+	return true, SetVersion(r, 2)
+}
+
+// copyMultiMessages copies all entries, including the nulled ones, so that the sequences of both logs line up.
+// It returns the keys of the copied messages, nil for the nulled entries.
+func copyMultiMessages(log logging.Interface, from margaret.Log, to multimsg.AlterableLog) ([]*refs.MessageRef, error) {
+	src, err := from.Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "upgrade-bipf: failed to construct query on from")
+	}
+
+	start := time.Now()
+	var got []*refs.MessageRef
+	snk := luigi.FuncSink(func(ctx context.Context, v interface{}, err error) error {
+		if luigi.IsEOS(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrap(err, "pump failed")
+		}
+
+		if nulled, ok := v.(error); ok {
+			if !margaret.IsErrNulled(nulled) {
+				return nulled
+			}
+			// keep the spot with an empty message and null it right away
+			seq, err := to.Append(multimsg.NewMultiMessageFromLegacy(&legacy.StoredMessage{}))
+			if err != nil {
+				return errors.Wrap(err, "failed to append placeholder")
+			}
+			got = append(got, nil)
+			return to.Null(seq)
+		}
+
+		mm, ok := v.(*multimsg.MultiMessage)
+		if !ok {
+			return errors.Errorf("upgrade-bipf: unexpected value in log: %T", v)
+		}
+		got = append(got, mm.Key())
+
+		_, err = to.Append(mm)
+		if len(got)%10000 == 0 {
+			log.Log("level", "debug", "msg", "copy progress", "copied", len(got), "took", time.Since(start))
+		}
+		return err
+	})
+
+	log.Log("event", "start-copy")
+	err = luigi.Pump(context.TODO(), snk, src)
+	if err != nil {
+		return nil, errors.Wrap(err, "migrate: pumping messages failed")
+	}
+	log.Log("event", "copy-done", "msgs", len(got), "took", time.Since(start))
+	return got, nil
+}
+
+func validateCopiedLog(log logging.Interface, got []*refs.MessageRef, to margaret.Log) error {
+	src, err := to.Query()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	i := 0
+	for {
+		v, err := src.Next(context.TODO())
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return err
+		}
+		if i >= len(got) {
+			return fmt.Errorf("migrate failed - new log has more than %d entries", len(got))
+		}
+
+		if nulled, ok := v.(error); ok {
+			if !margaret.IsErrNulled(nulled) || got[i] != nil {
+				return fmt.Errorf("migrate failed - entry %d diverges: %v", i, nulled)
+			}
+			i++
+			continue
+		}
+
+		msg, ok := v.(refs.Message)
+		if !ok || got[i] == nil || !msg.Key().Equal(*got[i]) {
+			return fmt.Errorf("migrate failed - msg%d diverges", i)
+		}
+		i++
+	}
+	if i != len(got) {
+		return fmt.Errorf("migrate failed - new log has %d entries instead of %d", i, len(got))
+	}
+
+	log.Log("event", "hash-check-done", "took", time.Since(start))
+	return nil
+}
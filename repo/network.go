@@ -0,0 +1,121 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// NetworksFile lists the network profiles of a repo, see LoadNetworkProfiles
+const NetworksFile = "networks.json"
+
+// NetworkProfile is what separates one ssb network from another,
+// like a test network that runs next to the main one.
+// Each profile has its own namespace in the repo (networks/<name>), with its own logs, indexes and identities.
+type NetworkProfile struct {
+	Name string `json:"name"`
+
+	// AppKey is the secret-handshake capability (shscap) of the network
+	AppKey []byte `json:"appKey"`
+
+	// HMACKey signs the messages of the network with the HMAC of the content, if it's set
+	HMACKey []byte `json:"hmacKey,omitempty"`
+
+	// ListenAddr is where the bot of the network listens, it needs to be different from the others
+	ListenAddr string `json:"listenAddr,omitempty"`
+}
+
+// Validate checks the name and the length of the keys
+func (p NetworkProfile) Validate() error {
+	if p.Name == "" || p.Name == "." || p.Name == ".." || strings.ContainsAny(p.Name, `/\`) {
+		return errors.Errorf("repo: invalid network name %q", p.Name)
+	}
+	if n := len(p.AppKey); n != 32 {
+		return errors.Errorf("repo: network %s: app key needs 32 bytes got %d", p.Name, n)
+	}
+	if n := len(p.HMACKey); n != 0 && n != 32 {
+		return errors.Errorf("repo: network %s: hmac key needs 32 bytes got %d", p.Name, n)
+	}
+	return nil
+}
+
+// Repo returns the namespace of the network in r
+func (p NetworkProfile) Repo(r Interface) Interface {
+	return New(r.GetPath("networks", p.Name))
+}
+
+// LoadNetworkProfiles reads the profiles from the networks.json file of r.
+// A repo without the file has no profiles besides the main network, which uses the repo itself.
+func LoadNetworkProfiles(r Interface) ([]NetworkProfile, error) {
+	data, err := ioutil.ReadFile(r.GetPath(NetworksFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "repo: failed to read network profiles")
+	}
+
+	var profiles []NetworkProfile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, errors.Wrap(err, "repo: failed to decode network profiles")
+	}
+	if err := checkNetworkProfiles(profiles); err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
+// SaveNetworkProfiles replaces the networks.json file of r
+func SaveNetworkProfiles(r Interface, profiles []NetworkProfile) error {
+	if err := checkNetworkProfiles(profiles); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profiles, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "repo: failed to encode network profiles")
+	}
+
+	fname := r.GetPath(NetworksFile)
+	if err := os.MkdirAll(r.GetPath(), 0700); err != nil {
+		return errors.Wrap(err, "repo: failed to create repo")
+	}
+	tmp := fname + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return errors.Wrap(err, "repo: failed to write network profiles")
+	}
+	return errors.Wrap(os.Rename(tmp, fname), "repo: failed to replace network profiles")
+}
+
+func checkNetworkProfiles(profiles []NetworkProfile) error {
+	names := make(map[string]struct{}, len(profiles))
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if _, has := names[p.Name]; has {
+			return errors.Errorf("repo: network %s is listed twice", p.Name)
+		}
+		names[p.Name] = struct{}{}
+	}
+	return nil
+}
+
+// FindNetworkProfile returns the profile with the name or the base64 encoded app key nameOrKey
+func FindNetworkProfile(profiles []NetworkProfile, nameOrKey string) (NetworkProfile, bool) {
+	key, err := base64.StdEncoding.DecodeString(nameOrKey)
+	if err != nil {
+		key = nil
+	}
+	for _, p := range profiles {
+		if p.Name == nameOrKey || (key != nil && bytes.Equal(p.AppKey, key)) {
+			return p, true
+		}
+	}
+	return NetworkProfile{}, false
+}
@@ -0,0 +1,71 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNetworkProfiles(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+	repo := New(rpath)
+
+	profiles, err := LoadNetworkProfiles(repo)
+	r.NoError(err)
+	a.Len(profiles, 0, "no file, no profiles")
+
+	testnet := NetworkProfile{
+		Name:       "testnet",
+		AppKey:     bytes.Repeat([]byte{1}, 32),
+		HMACKey:    bytes.Repeat([]byte{2}, 32),
+		ListenAddr: "localhost:8010",
+	}
+	other := NetworkProfile{
+		Name:   "other",
+		AppKey: bytes.Repeat([]byte{3}, 32),
+	}
+	r.NoError(SaveNetworkProfiles(repo, []NetworkProfile{testnet, other}))
+
+	profiles, err = LoadNetworkProfiles(repo)
+	r.NoError(err)
+	a.Equal([]NetworkProfile{testnet, other}, profiles)
+
+	p, ok := FindNetworkProfile(profiles, "testnet")
+	r.True(ok)
+	a.Equal(testnet, p)
+	p, ok = FindNetworkProfile(profiles, base64.StdEncoding.EncodeToString(other.AppKey))
+	r.True(ok)
+	a.Equal(other, p)
+	_, ok = FindNetworkProfile(profiles, "1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=")
+	a.False(ok, "the main network has no profile")
+
+	a.Equal(filepath.Join(rpath, "networks", "testnet", "secret"), testnet.Repo(repo).GetPath("secret"))
+
+	invalid := []NetworkProfile{
+		{Name: "../escape", AppKey: testnet.AppKey},
+		{Name: "", AppKey: testnet.AppKey},
+		{Name: "short", AppKey: []byte("nope")},
+		{Name: "hmac", AppKey: testnet.AppKey, HMACKey: []byte("nope")},
+	}
+	for _, p := range invalid {
+		a.Error(SaveNetworkProfiles(repo, []NetworkProfile{p}), "profile %q", p.Name)
+	}
+	a.Error(SaveNetworkProfiles(repo, []NetworkProfile{testnet, testnet}), "same name twice")
+
+	// the broken ones didn't replace the file
+	profiles, err = LoadNetworkProfiles(repo)
+	r.NoError(err)
+	a.Len(profiles, 2)
+}
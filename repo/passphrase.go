@@ -0,0 +1,84 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PassphraseFunc returns the passphrase of an encrypted secret, see EncryptKeyPair
+type PassphraseFunc func() ([]byte, error)
+
+// the environment variables PassphraseFromEnv looks at
+const (
+	PassphraseEnv     = "SSB_PASSPHRASE"
+	PassphraseFileEnv = "SSB_PASSPHRASE_FILE"
+)
+
+// PassphraseFromEnv returns the value of SSB_PASSPHRASE or the content of the file SSB_PASSPHRASE_FILE points to, without a trailing newline.
+func PassphraseFromEnv() ([]byte, error) {
+	if p, has := os.LookupEnv(PassphraseEnv); has {
+		return []byte(p), nil
+	}
+	if fname, has := os.LookupEnv(PassphraseFileEnv); has {
+		p, err := ioutil.ReadFile(fname)
+		if err != nil {
+			return nil, errors.Wrapf(err, "repo: failed to read passphrase file")
+		}
+		return bytes.TrimRight(p, "\r\n"), nil
+	}
+	return nil, errors.Errorf("repo: neither %s nor %s are set", PassphraseEnv, PassphraseFileEnv)
+}
+
+// PromptPassphrase asks for the passphrase on the terminal, without echoing it
+func PromptPassphrase(prompt string) PassphraseFunc {
+	return func() ([]byte, error) {
+		fd := int(os.Stdin.Fd())
+		if !terminal.IsTerminal(fd) {
+			return nil, errors.New("repo: can't ask for passphrase, stdin is not a terminal")
+		}
+		fmt.Fprint(os.Stderr, prompt)
+		p, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, errors.Wrap(err, "repo: failed to read passphrase")
+		}
+		return p, nil
+	}
+}
+
+// DefaultPassphrase uses PassphraseFromEnv if one of the variables is set and asks on the terminal otherwise
+func DefaultPassphrase() ([]byte, error) {
+	_, hasP := os.LookupEnv(PassphraseEnv)
+	_, hasF := os.LookupEnv(PassphraseFileEnv)
+	if hasP || hasF {
+		return PassphraseFromEnv()
+	}
+	return PromptPassphrase("passphrase: ")()
+}
+
+// MnemonicWords is the length of the mnemonics MnemonicSeed takes, 24 words encode the 32 bytes of an ed25519 seed
+const MnemonicWords = 24
+
+// MnemonicSeed decodes a BIP39 mnemonic (english wordlist) into the seed for NewKeyPair, the same words always give the same keypair.
+// The entropy is used as the seed directly, like ssb-keys-mnemonic does, so keys can be restored from the words of the javascript tools.
+// Case and whitespace don't matter but the words and their checksum do.
+func MnemonicSeed(mnemonic string) (*bytes.Reader, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if n := len(words); n != MnemonicWords {
+		return nil, errors.Errorf("repo: mnemonic needs %d words but got %d", MnemonicWords, n)
+	}
+	entropy, err := bip39.EntropyFromMnemonic(strings.Join(words, " "))
+	if err != nil {
+		return nil, errors.Wrap(err, "repo: invalid mnemonic")
+	}
+	return bytes.NewReader(entropy), nil
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// PeerFormats persists the negotiated feed formats of remote peers, it implements ssb.FormatStore
+type PeerFormats struct {
+	mu sync.Mutex
+	kv *kv.DB
+}
+
+var _ ssb.FormatStore = (*PeerFormats)(nil)
+
+// OpenPeerFormats opens the peer formats of repo r
+func OpenPeerFormats(r Interface) (*PeerFormats, error) {
+	db, err := OpenMKV(r.GetPath("peerformats"))
+	if err != nil {
+		return nil, errors.Wrap(err, "peerformats: failed to open key-value database")
+	}
+	return &PeerFormats{kv: db}, nil
+}
+
+// Close closes the underlying key-value database
+func (pf *PeerFormats) Close() error { return pf.kv.Close() }
+
+// Put stores the formats of the peer with the public key
+func (pf *PeerFormats) Put(pubKey []byte, formats ssb.PeerFormats) error {
+	data, err := json.Marshal(formats)
+	if err != nil {
+		return errors.Wrap(err, "peerformats: failed to encode formats")
+	}
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	return errors.Wrap(pf.kv.Set(pubKey, data), "peerformats: failed to store formats")
+}
+
+// All returns the stored formats by the public key of the peer
+func (pf *PeerFormats) All() (map[string]ssb.PeerFormats, error) {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	all := make(map[string]ssb.PeerFormats)
+	iter, err := pf.kv.SeekFirst()
+	if err == io.EOF {
+		return all, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "peerformats: failed to iterate formats")
+	}
+	for {
+		key, data, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrap(err, "peerformats: failed to get next formats")
+		}
+		var formats ssb.PeerFormats
+		if err := json.Unmarshal(data, &formats); err != nil {
+			return nil, errors.Wrap(err, "peerformats: invalid formats")
+		}
+		all[string(key)] = formats
+	}
+	return all, nil
+}
@@ -0,0 +1,45 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestPeerFormats(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+	repo := New(rpath)
+
+	pf, err := OpenPeerFormats(repo)
+	r.NoError(err)
+
+	all, err := pf.All()
+	r.NoError(err)
+	a.Len(all, 0)
+
+	key := bytes.Repeat([]byte{1}, 32)
+	gabby := ssb.PeerFormats{Publishes: []string{"ggfeed-v1"}, Replicates: []string{"ed25519", "ggfeed-v1"}}
+	r.NoError(pf.Put(key, gabby))
+	r.NoError(pf.Close())
+
+	pf, err = OpenPeerFormats(repo)
+	r.NoError(err)
+	defer pf.Close()
+
+	all, err = pf.All()
+	r.NoError(err)
+	r.Len(all, 1)
+	a.Equal(gabby, all[string(key)])
+}
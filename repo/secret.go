@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/ssb"
@@ -14,8 +16,14 @@ import (
 )
 
 func DefaultKeyPair(r Interface) (*ssb.KeyPair, error) {
+	return DefaultKeyPairWithPassphrase(r, nil)
+}
+
+// DefaultKeyPairWithPassphrase is like DefaultKeyPair but calls pass if the secret is encrypted.
+// Without pass an encrypted secret returns ssb.ErrKeyPairEncrypted.
+func DefaultKeyPairWithPassphrase(r Interface, pass PassphraseFunc) (*ssb.KeyPair, error) {
 	secPath := r.GetPath("secret")
-	keyPair, err := ssb.LoadKeyPair(secPath)
+	keyPair, err := loadKeyPair(secPath, pass)
 	if err != nil {
 		if !os.IsNotExist(errors.Cause(err)) {
 			return nil, errors.Wrap(err, "repo: error opening key pair")
@@ -41,18 +49,12 @@ func NewKeyPairFromSeed(r Interface, name, algo string, seed io.Reader) (*ssb.Ke
 }
 
 func newKeyPair(r Interface, name, algo string, seed io.Reader) (*ssb.KeyPair, error) {
-	var secPath string
-	if name == "-" {
-		secPath = r.GetPath("secret")
-	} else {
-		secPath = r.GetPath("secrets", name)
-		err := os.MkdirAll(filepath.Dir(secPath), 0700)
-		if err != nil && !os.IsExist(errors.Cause(err)) {
-			return nil, err
-		}
+	secPath, err := keyPairPath(r, name)
+	if err != nil {
+		return nil, err
 	}
-	if algo != refs.RefAlgoFeedSSB1 && algo != refs.RefAlgoFeedGabby { //  enums would be nice
-		return nil, errors.Errorf("invalid feed refrence algo")
+	if err := ssb.IsValidFeedFormat(&refs.FeedRef{Algo: algo}); err != nil {
+		return nil, errors.Wrap(err, "invalid feed refrence algo")
 	}
 	if _, err := ssb.LoadKeyPair(secPath); err == nil {
 		return nil, errors.Errorf("new key-pair name already taken")
@@ -70,15 +72,169 @@ func newKeyPair(r Interface, name, algo string, seed io.Reader) (*ssb.KeyPair, e
 }
 
 func LoadKeyPair(r Interface, name string) (*ssb.KeyPair, error) {
+	return LoadKeyPairWithPassphrase(r, name, nil)
+}
+
+// LoadKeyPairWithPassphrase is like LoadKeyPair but calls pass if the secret is encrypted, see EncryptKeyPair.
+func LoadKeyPairWithPassphrase(r Interface, name string, pass PassphraseFunc) (*ssb.KeyPair, error) {
 	secPath := r.GetPath("secrets", name)
-	keyPair, err := ssb.LoadKeyPair(secPath)
+	if name == "-" {
+		secPath = r.GetPath("secret")
+	}
+	keyPair, err := loadKeyPair(secPath, pass)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Load: failed to open %q", secPath)
 	}
 	return keyPair, nil
 }
 
+func loadKeyPair(secPath string, pass PassphraseFunc) (*ssb.KeyPair, error) {
+	keyPair, err := ssb.LoadKeyPair(secPath)
+	if _, encrypted := errors.Cause(err).(ssb.ErrKeyPairEncrypted); encrypted && pass != nil {
+		passphrase, err := pass()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get passphrase")
+		}
+		return ssb.LoadEncryptedKeyPair(secPath, passphrase)
+	}
+	return keyPair, err
+}
+
+// keyPairPath returns the file of the keypair name and makes sure its folder exists.
+// The name - is the main keypair of the repo.
+func keyPairPath(r Interface, name string) (string, error) {
+	if name == "-" {
+		return r.GetPath("secret"), nil
+	}
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", errors.Errorf("repo: invalid key-pair name %q", name)
+	}
+	secPath := r.GetPath("secrets", name)
+	err := os.MkdirAll(filepath.Dir(secPath), 0700)
+	if err != nil && !os.IsExist(errors.Cause(err)) {
+		return "", err
+	}
+	return secPath, nil
+}
+
+// ImportKeyPair stores kp under name, like NewKeyPair does with a fresh one.
+// With a passphrase the secret is stored encrypted.
+func ImportKeyPair(r Interface, name string, kp *ssb.KeyPair, passphrase []byte) error {
+	secPath, err := keyPairPath(r, name)
+	if err != nil {
+		return err
+	}
+	if passphrase != nil {
+		err = ssb.SaveEncryptedKeyPair(kp, secPath, passphrase)
+	} else {
+		err = ssb.SaveKeyPair(kp, secPath)
+	}
+	if err != nil {
+		return errors.Wrap(err, "repo: error saving imported identity file")
+	}
+	log.Printf("saved identity %s to %s", kp.Id.Ref(), secPath)
+	return nil
+}
+
+// EncryptKeyPair replaces the secret of name with one that is encrypted with newPassphrase.
+// oldPass is called if it already is encrypted. A nil newPassphrase stores it in the clear again.
+func EncryptKeyPair(r Interface, name string, oldPass PassphraseFunc, newPassphrase []byte) error {
+	secPath, err := keyPairPath(r, name)
+	if err != nil {
+		return err
+	}
+	kp, err := loadKeyPair(secPath, oldPass)
+	if err != nil {
+		return errors.Wrapf(err, "repo: failed to open %q", secPath)
+	}
+
+	// write the new file next to the old one and swap them, so we never end up without a secret
+	tmpPath := secPath + ".new"
+	os.Remove(tmpPath)
+	if newPassphrase != nil {
+		err = ssb.SaveEncryptedKeyPair(kp, tmpPath, newPassphrase)
+	} else {
+		err = ssb.SaveKeyPair(kp, tmpPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return errors.Wrap(err, "repo: failed to write re-encrypted secret")
+	}
+	return errors.Wrap(os.Rename(tmpPath, secPath), "repo: failed to replace secret")
+}
+
+// KeyPairInfo describes a stored keypair without opening it
+type KeyPairInfo struct {
+	Name      string // - for the main keypair
+	ID        string
+	Encrypted bool
+}
+
+// ListKeyPairs returns the main keypair and all the ones in the secrets folder (sorted by name), if they exist.
+// Unlike AllKeyPairs it includes encrypted ones.
+func ListKeyPairs(r Interface) ([]KeyPairInfo, error) {
+	var infos []KeyPairInfo
+	add := func(name, path string) error {
+		kp, err := ssb.LoadKeyPair(path)
+		if err == nil {
+			infos = append(infos, KeyPairInfo{Name: name, ID: kp.Id.Ref()})
+			return nil
+		}
+		if enc, ok := errors.Cause(err).(ssb.ErrKeyPairEncrypted); ok {
+			infos = append(infos, KeyPairInfo{Name: name, ID: enc.ID, Encrypted: true})
+			return nil
+		}
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+		return err
+	}
+
+	if err := add("-", r.GetPath("secret")); err != nil {
+		return nil, err
+	}
+
+	err := filepath.Walk(r.GetPath("secrets"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if err := add(filepath.Base(path), path); err != nil {
+			log.Printf("skipping %s: %s", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
 func AllKeyPairs(r Interface) (map[string]*ssb.KeyPair, error) {
+	return AllKeyPairsWithPassphrase(r, nil)
+}
+
+// AllKeyPairsWithPassphrase is like AllKeyPairs but also opens the encrypted keypairs.
+// pass is only called once, keypairs it doesn't open are skipped.
+func AllKeyPairsWithPassphrase(r Interface, pass PassphraseFunc) (map[string]*ssb.KeyPair, error) {
+	if pass != nil {
+		var (
+			once       sync.Once
+			passphrase []byte
+			passErr    error
+		)
+		ask := pass
+		pass = func() ([]byte, error) {
+			once.Do(func() { passphrase, passErr = ask() })
+			return passphrase, passErr
+		}
+	}
+
 	kps := make(map[string]*ssb.KeyPair)
 	err := filepath.Walk(r.GetPath("secrets"), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -90,9 +246,15 @@ func AllKeyPairs(r Interface) (map[string]*ssb.KeyPair, error) {
 		if info.IsDir() {
 			return nil
 		}
-		if kp, err := ssb.LoadKeyPair(path); err == nil {
+		kp, err := ssb.LoadKeyPair(path)
+		if _, encrypted := errors.Cause(err).(ssb.ErrKeyPairEncrypted); encrypted && pass != nil {
+			kp, err = loadKeyPair(path, pass)
+			if err != nil {
+				log.Printf("skipping encrypted %s: %s", path, err)
+			}
+		}
+		if err == nil {
 			kps[filepath.Base(path)] = kp
-			return nil
 		}
 		return nil
 	})
@@ -0,0 +1,135 @@
+// SPDX-License-Identifier: MIT
+
+package repo
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+)
+
+func TestKeyPairManagement(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	rpath, err := ioutil.TempDir("", t.Name())
+	r.NoError(err)
+	defer os.RemoveAll(rpath)
+	repo := New(rpath)
+
+	main, err := DefaultKeyPair(repo)
+	r.NoError(err)
+
+	alice, err := NewKeyPair(repo, "alice", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	_, err = NewKeyPair(repo, "../escape", refs.RefAlgoFeedSSB1)
+	r.Error(err)
+
+	// import one from somewhere else, encrypted
+	imported, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	r.NoError(ImportKeyPair(repo, "bob", imported, []byte("secret")))
+	r.Error(ImportKeyPair(repo, "bob", imported, nil), "name already taken")
+
+	infos, err := ListKeyPairs(repo)
+	r.NoError(err)
+	a.Equal([]KeyPairInfo{
+		{Name: "-", ID: main.Id.Ref()},
+		{Name: "alice", ID: alice.Id.Ref()},
+		{Name: "bob", ID: imported.Id.Ref(), Encrypted: true},
+	}, infos)
+
+	_, err = LoadKeyPair(repo, "bob")
+	_, isEnc := errors.Cause(err).(ssb.ErrKeyPairEncrypted)
+	a.True(isEnc, "wrong error: %v", err)
+
+	pass := func(p string) PassphraseFunc {
+		return func() ([]byte, error) { return []byte(p), nil }
+	}
+
+	_, err = LoadKeyPairWithPassphrase(repo, "bob", pass("wrong"))
+	a.Equal(ssb.ErrWrongPassphrase, errors.Cause(err))
+	bob, err := LoadKeyPairWithPassphrase(repo, "bob", pass("secret"))
+	r.NoError(err)
+	a.Equal(imported.Id.Ref(), bob.Id.Ref())
+
+	// encrypt alice, change bobs passphrase and decrypt the main one again
+	r.NoError(EncryptKeyPair(repo, "alice", nil, []byte("alice")))
+	r.NoError(EncryptKeyPair(repo, "bob", pass("secret"), []byte("bob")))
+	r.NoError(EncryptKeyPair(repo, "-", nil, []byte("main")))
+	_, err = DefaultKeyPair(repo)
+	r.Error(err, "should not make a new one if it's encrypted")
+	r.NoError(EncryptKeyPair(repo, "-", pass("main"), nil))
+
+	kp, err := LoadKeyPairWithPassphrase(repo, "alice", pass("alice"))
+	r.NoError(err)
+	a.Equal(alice.Id.Ref(), kp.Id.Ref())
+	kp, err = LoadKeyPairWithPassphrase(repo, "bob", pass("bob"))
+	r.NoError(err)
+	a.Equal(imported.Id.Ref(), kp.Id.Ref())
+	kp, err = DefaultKeyPair(repo)
+	r.NoError(err)
+	a.Equal(main.Id.Ref(), kp.Id.Ref())
+
+	// encrypted keys are left out when they are all loaded
+	all, err := AllKeyPairs(repo)
+	r.NoError(err)
+	a.Len(all, 0)
+
+	// unless there is a passphrase, which is only asked for once and skips the ones it doesn't open
+	var asked int
+	all, err = AllKeyPairsWithPassphrase(repo, func() ([]byte, error) {
+		asked++
+		return []byte("bob"), nil
+	})
+	r.NoError(err)
+	a.Equal(1, asked)
+	r.Len(all, 1)
+	a.Equal(imported.Id.Ref(), all["bob"].Id.Ref())
+}
+
+func TestMnemonicSeed(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	_, err := MnemonicSeed("too short")
+	r.Error(err)
+
+	// a valid mnemonic, but only 16 bytes of entropy
+	_, err = MnemonicSeed("legal winner thank year wave sausage worth useful legal winner thank yellow")
+	r.Error(err)
+
+	// BIP39 test vector for 32 bytes of 0x7f
+	words := "Legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth useful legal winner thank year wave sausage worth title"
+	seed, err := MnemonicSeed(words)
+	r.NoError(err)
+	entropy, err := ioutil.ReadAll(seed)
+	r.NoError(err)
+	a.Equal(bytes.Repeat([]byte{0x7f}, 32), entropy)
+
+	seed, err = MnemonicSeed(words)
+	r.NoError(err)
+	kp1, err := ssb.NewKeyPair(seed)
+	r.NoError(err)
+
+	seed, err = MnemonicSeed("  legal winner thank year wave sausage worth useful legal winner thank year\nwave sausage worth useful legal winner thank year wave sausage worth title ")
+	r.NoError(err)
+	kp2, err := ssb.NewKeyPair(seed)
+	r.NoError(err)
+	a.Equal(kp1.Id.Ref(), kp2.Id.Ref(), "same words, same key")
+
+	// the last word carries the checksum
+	_, err = MnemonicSeed(strings.TrimSuffix(words, "title") + "yellow")
+	r.Error(err)
+
+	_, err = MnemonicSeed(strings.Replace(words, "winner", "winnr", 1))
+	r.Error(err, "not in the wordlist")
+}
@@ -3,6 +3,8 @@
 package ssb
 
 import (
+	"context"
+
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
 	"go.cryptoscope.co/luigi"
@@ -18,6 +20,25 @@ type Publisher interface {
 	Publish(content interface{}) (*refs.MessageRef, error)
 }
 
+// BatchPublisher can publish several messages at once, see message.OpenPublishLog
+type BatchPublisher interface {
+	Publisher
+
+	// PublishBatch publishes the contents as consecutive messages.
+	// It is all or nothing: if one of them is invalid or can't be stored, none of them are published.
+	PublishBatch(contents ...interface{}) ([]*refs.MessageRef, error)
+
+	// PublishAfter is like PublishBatch but fails if previous isn't the newest message of the feed (nil for an empty feed)
+	PublishAfter(previous *refs.MessageRef, contents ...interface{}) ([]*refs.MessageRef, error)
+}
+
+// Identities are the local keypairs of a bot that serves more than one feed.
+// as is the nick of a keypair in the secrets folder of the repo or its feed reference. The empty string is the main identity.
+type Identities interface {
+	KeyPairFor(as string) (*KeyPair, error)
+	PublisherFor(as string) (Publisher, error)
+}
+
 type Getter interface {
 	Get(refs.MessageRef) (refs.Message, error)
 }
@@ -47,6 +68,20 @@ type Replicator interface {
 	Lister() ReplicationLister
 }
 
+// BlockListSubscriber can optionally be implemented by a Replicator.
+// It applies the blocks of other, trusted feeds in addition to our own.
+type BlockListSubscriber interface {
+	SubscribeBlockList(*refs.FeedRef) error
+	UnsubscribeBlockList(*refs.FeedRef) error
+	BlockListSubscriptions() ([]*refs.FeedRef, error)
+}
+
+// Drainer can optionally be implemented by a bot or one of its parts.
+// Drain stops taking on new work, finishes the running work and returns, or returns the error of ctx if it is done first.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
 // ReplicationLister is used by the executing part to get the lists
 // TODO: maybe only pass read-only/copies or slices down
 type ReplicationLister interface {
@@ -70,6 +105,7 @@ type Status struct {
 	Blobs    []BlobWant
 	Root     margaret.BaseSeq
 	Indicies IndexStates
+	Forked   []*refs.FeedRef // feeds that forked and are not replicated anymore
 }
 
 type IndexStates []IndexState
@@ -107,16 +143,9 @@ func FeedsWithSequnce(feedIndex multilog.MultiLog) (luigi.Source, error) {
 	var feedsWithSeqs []interface{}
 
 	for i, author := range storedFeeds {
-		var sr refs.StorageRef
-		err := sr.Unmarshal([]byte(author))
+		authorRef, err := FeedRefFromStoredAddr(author)
 		if err != nil {
 			return nil, errors.Wrapf(err, "feedSrc(%d): invalid storage ref", i)
-
-		}
-		authorRef, err := sr.FeedRef()
-		if err != nil {
-			return nil, errors.Wrapf(err, "feedSrc(%d): stored ref not a feed?", i)
-
 		}
 
 		subLog, err := feedIndex.Get(author)
@@ -0,0 +1,384 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/archive"
+	"go.cryptoscope.co/ssb/blobstore"
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/message"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+// ExportFeeds writes all the stored messages of feeds to w, as an archive that ImportFeed can read.
+// With blobs, the blobs the messages mention are added, if they are in the local store.
+// Feeds that are only stored partially or that have nulled messages can't be verified by the importer, exporting them is an error.
+func (s *Sbot) ExportFeeds(w io.Writer, feeds []*refs.FeedRef, blobs bool) error {
+	_, err := s.exportFeeds(w, feeds, nil, blobs)
+	return err
+}
+
+// exportFeeds is ExportFeeds but if after isn't nil, only the messages of feeds[i] with a sequence above after[i] are written.
+// It returns how many messages were written.
+func (s *Sbot) exportFeeds(w io.Writer, feeds []*refs.FeedRef, after []int64, blobs bool) (int, error) {
+	ctx := context.Background()
+
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return 0, errors.Errorf("export: failed to open multilog")
+	}
+
+	type exporting struct {
+		userLog margaret.Log
+		after   int64
+	}
+	var (
+		infos []archive.Feed
+		exps  []exporting
+	)
+	for i, fr := range feeds {
+		userLog, err := uf.Get(fr.StoredAddr())
+		if err != nil {
+			return 0, errors.Wrapf(err, "export: failed to open log of %s", fr.ShortRef())
+		}
+		first, last, count, err := s.storedRange(userLog)
+		if err != nil {
+			return 0, errors.Wrapf(err, "export: failed to get stored messages of %s", fr.ShortRef())
+		}
+		exp := exporting{userLog: userLog}
+		if after != nil {
+			exp.after = after[i]
+		}
+		if last == 0 || last <= exp.after {
+			continue // nothing (new) stored
+		}
+		partial := first != 1 || count != last
+		if s.Gaps != nil && !partial {
+			marker, err := s.Gaps.Get(fr)
+			if err != nil {
+				return 0, errors.Wrapf(err, "export: failed to get gap marker of %s", fr.ShortRef())
+			}
+			partial = marker != nil
+		}
+		if partial {
+			return 0, errors.Errorf("export: %s is only stored partially", fr.ShortRef())
+		}
+		infos = append(infos, archive.Feed{ID: fr.Ref(), From: exp.after + 1, To: last})
+		exps = append(exps, exp)
+	}
+
+	aw, err := archive.NewWriter(w, infos)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	written := make(map[string]struct{})
+	for i, exp := range exps {
+		// the feed is complete, so the message with sequence n is at n-1 in the sublog
+		src, err := mutil.Indirect(s.RootLog, exp.userLog).Query(margaret.Gte(margaret.BaseSeq(exp.after)))
+		if err != nil {
+			return n, errors.Wrapf(err, "export: failed to query %s", infos[i].ID)
+		}
+		for {
+			v, err := src.Next(ctx)
+			if luigi.IsEOS(err) {
+				break
+			} else if err != nil {
+				return n, err
+			}
+			if err, ok := v.(error); ok {
+				if margaret.IsErrNulled(err) {
+					return n, errors.Errorf("export: %s has nulled messages", infos[i].ID)
+				}
+				return n, err
+			}
+			msg, ok := v.(refs.Message)
+			if !ok {
+				return n, errors.Errorf("export: wrong message type. expected %T - got %T", msg, v)
+			}
+			if msg.Seq() <= exp.after {
+				continue
+			}
+			if err := aw.WriteMessage(msg); err != nil {
+				return n, err
+			}
+			n++
+
+			if !blobs {
+				continue
+			}
+			for _, br := range archive.BlobRefs(msg.ContentBytes()) {
+				if _, has := written[br.Ref()]; has {
+					continue
+				}
+				rd, err := s.BlobStore.Get(br)
+				if errors.Cause(err) == blobstore.ErrNoSuchBlob {
+					continue
+				} else if err != nil {
+					return n, errors.Wrapf(err, "export: failed to open blob %s", br.Ref())
+				}
+				data, err := ioutil.ReadAll(rd)
+				if err != nil {
+					return n, errors.Wrapf(err, "export: failed to read blob %s", br.Ref())
+				}
+				if err := aw.WriteBlob(br, data); err != nil {
+					return n, err
+				}
+				written[br.Ref()] = struct{}{}
+			}
+		}
+	}
+	return n, nil
+}
+
+// storedRange returns the sequences of the first and last stored message of a feed and how many are stored, or zeros if there are none
+func (s *Sbot) storedRange(userLog margaret.Log) (int64, int64, int64, error) {
+	latest, err := userLog.Seq().Value()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	seq, ok := latest.(margaret.Seq)
+	if !ok || seq.Seq() < 0 {
+		return 0, 0, 0, nil
+	}
+	seqOf := func(i int64) (int64, error) {
+		rootSeq, err := userLog.Get(margaret.BaseSeq(i))
+		if err != nil {
+			return 0, err
+		}
+		v, err := s.RootLog.Get(rootSeq.(margaret.Seq))
+		if err != nil {
+			return 0, err
+		}
+		msg, ok := v.(refs.Message)
+		if !ok {
+			return 0, errors.Errorf("wrong message type. expected %T - got %T", msg, v)
+		}
+		return msg.Seq(), nil
+	}
+	first, err := seqOf(0)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	last, err := seqOf(seq.Seq())
+	return first, last, seq.Seq() + 1, err
+}
+
+// ImportSummary counts what ImportFeed stored
+type ImportSummary struct {
+	Messages int // new messages
+	Skipped  int // messages that were already stored
+	Blobs    int
+}
+
+// ImportFeed reads an archive written by ExportFeeds (or ssb-export) and appends the messages that are new to the root log.
+// They are verified like replicated ones and need to continue the stored feeds.
+// Feeds that are blocked or forked (see Sbot.Forks) are skipped.
+func (s *Sbot) ImportFeed(r io.Reader) (ImportSummary, error) {
+	// the stored state of the feeds comes from it
+	s.WaitUntilIndexesAreSynced()
+
+	sum, _, err := s.importArchive(r, importFilter{})
+	return sum, err
+}
+
+// importFilter limits what importArchive stores, the zero value takes everything
+type importFilter struct {
+	feed func(*refs.FeedRef) bool // messages of other feeds are skipped
+	blob func(*refs.BlobRef) bool // other blobs are ignored
+
+	// skipAhead counts messages that don't continue the stored feed instead of failing on them,
+	// another archive might have the ones in between.
+	skipAhead bool
+}
+
+// importArchive is ImportFeed without waiting for the indexes.
+// It also returns the number of messages that were ahead of the stored feeds.
+func (s *Sbot) importArchive(r io.Reader, filter importFilter) (ImportSummary, int, error) {
+	ctx := context.Background()
+	var (
+		sum   ImportSummary
+		ahead int
+	)
+
+	ar, err := archive.NewReader(r)
+	if err != nil {
+		return sum, ahead, err
+	}
+
+	// the feeds stay locked until the archive is read, so that gossip doesn't append to them in between
+	s.importMu.Lock()
+	defer s.importMu.Unlock()
+	var unlocks []func()
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return sum, ahead, errors.Errorf("import: failed to open multilog")
+	}
+
+	var hmacKey *[32]byte
+	if s.signHMACsecret != nil {
+		var k [32]byte
+		copy(k[:], s.signHMACsecret)
+		hmacKey = &k
+	}
+
+	store := luigi.FuncSink(func(ctx context.Context, val interface{}, err error) error {
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil
+			}
+			return err
+		}
+		_, err = s.RootLog.Append(val)
+		if err == nil {
+			sum.Messages++
+		}
+		return errors.Wrap(err, "failed to append verified message to rootLog")
+	})
+
+	// without a network node there is no replicator and nothing is blocked
+	blocked := ssb.NewFeedSet(0)
+	if s.Replicator != nil {
+		blocked = s.Replicator.Lister().BlockList()
+	}
+
+	type importing struct {
+		snk    luigi.Sink
+		latest int64 // the sequence of the newest stored message
+		skip   bool
+	}
+	feeds := make(map[string]*importing)
+
+	for {
+		e, err := ar.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sum, ahead, err
+		}
+
+		if e.IsBlob() {
+			want, err := refs.ParseBlobRef(e.Blob)
+			if err != nil {
+				return sum, ahead, errors.Wrap(err, "import: invalid blob reference")
+			}
+			if filter.blob != nil && !filter.blob(want) {
+				continue
+			}
+			if h := sha256.Sum256(e.Data); !bytes.Equal(h[:], want.Hash) {
+				return sum, ahead, errors.Errorf("import: blob %s has the wrong content", want.Ref())
+			}
+			if _, err := s.BlobStore.Put(bytes.NewReader(e.Data)); err != nil {
+				return sum, ahead, errors.Wrapf(err, "import: failed to store blob %s", want.Ref())
+			}
+			sum.Blobs++
+			continue
+		}
+
+		imp, has := feeds[e.Feed]
+		if !has {
+			fr, err := ssb.ParseFeedRef(e.Feed)
+			if err != nil {
+				return sum, ahead, errors.Wrap(err, "import: invalid feed reference")
+			}
+			forked := s.Forks != nil && s.Forks.Has(fr)
+			imp = &importing{skip: forked || blocked.Has(fr) || (filter.feed != nil && !filter.feed(fr))}
+			if !imp.skip {
+				unlocks = append(unlocks, s.feedLocks.Lock(fr))
+				latestMsg, err := s.latestStored(uf, fr)
+				if err != nil {
+					return sum, ahead, errors.Wrapf(err, "import: failed to get stored state of %s", fr.ShortRef())
+				}
+				var latestSeq margaret.BaseSeq
+				if latestMsg != nil {
+					imp.latest = latestMsg.Seq()
+					latestSeq = margaret.BaseSeq(imp.latest)
+				}
+				imp.snk = message.NewVerifySink(fr, latestSeq, latestMsg, store, hmacKey)
+			}
+			feeds[e.Feed] = imp
+		}
+
+		if imp.skip || e.Sequence <= imp.latest {
+			sum.Skipped++
+			continue
+		}
+		if filter.skipAhead && e.Sequence > imp.latest+1 {
+			ahead++
+			continue
+		}
+		if err := imp.snk.Pour(ctx, e.Transfer()); err != nil {
+			return sum, ahead, errors.Wrapf(err, "import: failed to import %s:%d", e.Feed, e.Sequence)
+		}
+		imp.latest = e.Sequence
+	}
+	return sum, ahead, nil
+}
+
+// latestStored returns the newest message of fr, or nil if none is stored.
+// Feeds that are only stored partially return an error, they can't be continued without a gap.
+func (s *Sbot) latestStored(uf multilog.MultiLog, fr *refs.FeedRef) (refs.Message, error) {
+	if s.Gaps != nil {
+		marker, err := s.Gaps.Get(fr)
+		if err != nil {
+			return nil, err
+		}
+		if marker != nil {
+			return nil, errors.Errorf("feed is only stored partially")
+		}
+	}
+
+	userLog, err := uf.Get(fr.StoredAddr())
+	if err != nil {
+		return nil, err
+	}
+	latest, err := userLog.Seq().Value()
+	if err != nil {
+		return nil, err
+	}
+	switch v := latest.(type) {
+	case librarian.UnsetValue:
+		return nil, nil
+	case margaret.BaseSeq:
+		if v < 0 {
+			return nil, nil
+		}
+		rootSeq, err := userLog.Get(v)
+		if err != nil {
+			return nil, err
+		}
+		msgV, err := s.RootLog.Get(rootSeq.(margaret.Seq))
+		if err != nil {
+			return nil, err
+		}
+		msg, ok := msgV.(refs.Message)
+		if !ok {
+			return nil, errors.Errorf("wrong message type. expected %T - got %T", msg, msgV)
+		}
+		return msg, nil
+	default:
+		return nil, errors.Errorf("unexpected sequence type %T", latest)
+	}
+}
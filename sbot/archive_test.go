@@ -0,0 +1,133 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/gaps"
+	"go.cryptoscope.co/ssb/indexes"
+	"go.cryptoscope.co/ssb/internal/leakcheck"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestExportImport(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+
+	hk := make([]byte, 32)
+	_, err := rand.Read(hk)
+	r.NoError(err)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	logger := testutils.NewRelativeTimeLogger(nil)
+	mkBot := func(name string) *Sbot {
+		bot, err := New(
+			WithInfo(logger),
+			WithRepoPath(filepath.Join(tRepoPath, name)),
+			WithHMACSigning(hk),
+			LateOption(MountSimpleIndex("get", indexes.OpenGet)),
+			DisableNetworkNode(),
+		)
+		r.NoError(err)
+		return bot
+	}
+
+	src := mkBot("src")
+	kpBert, err := repo.NewKeyPair(repo.New(filepath.Join(tRepoPath, "src")), "bert", refs.RefAlgoFeedGabby)
+	r.NoError(err)
+
+	blob, err := src.BlobStore.Put(strings.NewReader("a picture"))
+	r.NoError(err)
+
+	for i := 0; i < 5; i++ {
+		_, err := src.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+		_, err = src.PublishAs("bert", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	_, err = src.PublishLog.Publish(refs.NewPost(fmt.Sprintf("look ![pic](%s)", blob.Ref())))
+	r.NoError(err)
+
+	var exported bytes.Buffer
+	r.NoError(src.ExportFeeds(&exported, []*refs.FeedRef{src.KeyPair.Id, kpBert.Id}, true))
+	srcID := src.KeyPair.Id
+
+	// a feed with a gap can't be verified by the importer
+	r.NoError(src.Gaps.Mark(gaps.Marker{Feed: kpBert.Id, From: 1, Sparse: true}))
+	err = src.ExportFeeds(ioutil.Discard, []*refs.FeedRef{kpBert.Id}, false)
+	r.Error(err)
+	a.Contains(err.Error(), "only stored partially")
+	r.NoError(src.Gaps.Clear(kpBert.Id))
+
+	src.Shutdown()
+	r.NoError(src.Close())
+
+	dst := mkBot("dst")
+
+	sum, err := dst.ImportFeed(bytes.NewReader(exported.Bytes()))
+	r.NoError(err)
+	a.Equal(ImportSummary{Messages: 11, Blobs: 1}, sum)
+
+	_, err = dst.BlobStore.Get(blob)
+	r.NoError(err)
+
+	// nothing new the second time
+	sum, err = dst.ImportFeed(bytes.NewReader(exported.Bytes()))
+	r.NoError(err)
+	a.Equal(ImportSummary{Skipped: 11, Blobs: 1}, sum)
+
+	uf, ok := dst.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	for _, fr := range []*refs.FeedRef{srcID, kpBert.Id} {
+		latest, err := dst.latestStored(uf, fr)
+		r.NoError(err)
+		r.NotNil(latest, "nothing stored for %s", fr.ShortRef())
+	}
+
+	// a changed message doesn't verify
+	third := mkBot("third")
+	tampered := bytes.Replace(exported.Bytes(), []byte(`"i":3`), []byte(`"i":4`), 1)
+	r.NotEqual(exported.Bytes(), tampered)
+	_, err = third.ImportFeed(bytes.NewReader(tampered))
+	r.Error(err)
+
+	_, err = third.ImportFeed(strings.NewReader(`{"format":"something else"}`))
+	r.Error(err)
+
+	// forked feeds aren't continued
+	fourth := mkBot("fourth")
+	_, err = fourth.Forks.Add(forks.Proof{
+		Feed:     kpBert.Id,
+		Sequence: 3,
+		Messages: [2]json.RawMessage{[]byte(`{"a":1}`), []byte(`{"b":2}`)},
+	})
+	r.NoError(err)
+	sum, err = fourth.ImportFeed(bytes.NewReader(exported.Bytes()))
+	r.NoError(err)
+	a.Equal(ImportSummary{Messages: 6, Skipped: 5, Blobs: 1}, sum)
+
+	dst.Shutdown()
+	r.NoError(dst.Close())
+	third.Shutdown()
+	r.NoError(third.Close())
+	fourth.Shutdown()
+	r.NoError(fourth.Close())
+}
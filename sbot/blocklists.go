@@ -0,0 +1,81 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"go.cryptoscope.co/librarian"
+	refs "go.mindeco.de/ssb-refs"
+	"modernc.org/kv"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+// blockListStore persists the feeds whose blocks we subscribed to
+type blockListStore struct {
+	mu sync.Mutex
+	kv *kv.DB
+}
+
+func openBlockListStore(r repo.Interface) (*blockListStore, error) {
+	db, err := repo.OpenMKV(r.GetPath("blocklists"))
+	if err != nil {
+		return nil, fmt.Errorf("blocklists: failed to open key-value database (%w)", err)
+	}
+	return &blockListStore{kv: db}, nil
+}
+
+func (bls *blockListStore) Add(ref *refs.FeedRef) error {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	err := bls.kv.Set([]byte(ssb.StoredAddr(ref)), []byte{1})
+	if err != nil {
+		return fmt.Errorf("blocklists: failed to store subscription (%w)", err)
+	}
+	return nil
+}
+
+func (bls *blockListStore) Remove(ref *refs.FeedRef) error {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+	err := bls.kv.Delete([]byte(ssb.StoredAddr(ref)))
+	if err != nil {
+		return fmt.Errorf("blocklists: failed to delete subscription (%w)", err)
+	}
+	return nil
+}
+
+func (bls *blockListStore) List() ([]*refs.FeedRef, error) {
+	bls.mu.Lock()
+	defer bls.mu.Unlock()
+
+	iter, err := bls.kv.SeekFirst()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("blocklists: failed to iterate subscriptions (%w)", err)
+	}
+
+	var lst []*refs.FeedRef
+	for {
+		k, _, err := iter.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("blocklists: failed to get next subscription (%w)", err)
+		}
+
+		ref, err := ssb.FeedRefFromStoredAddr(librarian.Addr(k))
+		if err != nil {
+			return nil, fmt.Errorf("blocklists: invalid stored ref (%w)", err)
+		}
+		lst = append(lst, ref)
+	}
+	return lst, nil
+}
+
+func (bls *blockListStore) Close() error { return bls.kv.Close() }
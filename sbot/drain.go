@@ -0,0 +1,126 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+)
+
+// gossipPlugin is what the sbot needs from the gossip plugins to drain and reload them
+type gossipPlugin interface {
+	ssb.Plugin
+	SetPromisc(bool)
+}
+
+var _ ssb.Drainer = (*Sbot)(nil)
+
+// Drain shuts the bot down gracefully.
+// It stops accepting new connections, lets the running feed fetches finish,
+// waits for the indexes to catch up with the receive log and then closes the bot, which flushes the indexes and the log.
+// If ctx is done before that, the bot is closed anyway and the error of the context is returned.
+func (s *Sbot) Drain(ctx context.Context) error {
+	drainEvt := kitlog.With(s.info, "event", "sbot draining")
+
+	if s.Network != nil {
+		s.Network.StopAccepting()
+		level.Debug(drainEvt).Log("msg", "stopped accepting connections")
+	}
+
+	var drainErr error
+	for _, gp := range s.gossipPlugs {
+		d, ok := gp.(ssb.Drainer)
+		if !ok {
+			continue
+		}
+		if err := d.Drain(ctx); err != nil {
+			drainErr = errors.Wrapf(err, "sbot: failed to drain %s", gp.Name())
+			break
+		}
+	}
+	level.Debug(drainEvt).Log("msg", "fetches done", "err", drainErr)
+
+	if drainErr == nil {
+		synced := make(chan struct{})
+		go func() {
+			s.WaitUntilIndexesAreSynced()
+			close(synced)
+		}()
+		select {
+		case <-synced:
+			level.Debug(drainEvt).Log("msg", "indexes in sync")
+		case <-ctx.Done():
+			drainErr = errors.Wrap(ctx.Err(), "sbot: indexes did not catch up")
+		}
+	}
+
+	s.Shutdown()
+	err := s.Close()
+	s.drainedOnce.Do(func() { close(s.drained) })
+	if err != nil {
+		return err
+	}
+	if drainErr != nil {
+		level.Warn(drainEvt).Log("msg", "timed out, closed anyway", "err", drainErr)
+		return drainErr
+	}
+	level.Info(drainEvt).Log("msg", "drained and closed")
+	return nil
+}
+
+// Drained is closed once Drain is done
+func (s *Sbot) Drained() <-chan struct{} {
+	return s.drained
+}
+
+// Settings are the parts of the configuration that can be changed while the bot is running, without dropping connections
+type Settings struct {
+	// Hops is the same as WithHops
+	Hops uint
+
+	// Promisc is the same as WithPromisc
+	Promisc bool
+
+	// AllowList replaces the list of WithAllowList
+	AllowList []*refs.FeedRef
+}
+
+// Reload applies the new settings. The replication list is re-calculated if the hop count changed.
+func (s *Sbot) Reload(set Settings) error {
+	allowList := ssb.NewFeedSet(len(set.AllowList))
+	for _, f := range set.AllowList {
+		if err := allowList.AddRef(f); err != nil {
+			return errors.Wrap(err, "sbot: invalid allow list entry")
+		}
+	}
+
+	s.settingsMu.Lock()
+	hopsChanged := s.hopCount != set.Hops
+	s.hopCount = set.Hops
+	s.promisc = set.Promisc
+	s.allowList = allowList
+	s.settingsMu.Unlock()
+
+	for _, gp := range s.gossipPlugs {
+		gp.SetPromisc(set.Promisc)
+	}
+
+	if gr, ok := s.Replicator.(*graphReplicator); ok && hopsChanged {
+		go gr.update()
+	}
+
+	level.Info(s.info).Log("event", "settings reloaded", "hops", set.Hops, "promisc", set.Promisc, "allowed", len(set.AllowList))
+	return nil
+}
+
+func (s *Sbot) currentHops() int {
+	s.settingsMu.RLock()
+	defer s.settingsMu.RUnlock()
+	return int(s.hopCount)
+}
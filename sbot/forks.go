@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"go.cryptoscope.co/ssb/forks"
+)
+
+// handleFork stops replicating a newly forked feed and publishes the proof, if WithForkProofPublishing is set.
+// It is only taken out of the current wants (the updates of graphReplicator leave out forked feeds), so a manual Replicate of it isn't forgotten.
+// The published proof only references the two messages and has their signatures, the messages themselves wouldn't fit into one.
+func (s *Sbot) handleFork(p forks.Proof) {
+	forkLog := level.Warn(s.info)
+	forkLog.Log("event", "feed forked", "feed", p.Feed.Ref(), "seq", p.Sequence)
+
+	if s.Replicator != nil {
+		s.Replicator.Lister().ReplicationList().Delete(p.Feed)
+	}
+
+	if !s.publishForkProofs || s.PublishLog == nil {
+		return
+	}
+
+	if p.Keys[0] == nil || p.Keys[1] == nil {
+		forkLog.Log("msg", "fork proof without message keys, not publishing it")
+		return
+	}
+	sigs, err := p.Signatures()
+	if err != nil {
+		forkLog.Log("msg", "fork proof without signatures, not publishing it", "err", err)
+		return
+	}
+
+	content := map[string]interface{}{
+		"type":       "fork-proof",
+		"feed":       p.Feed.Ref(),
+		"sequence":   p.Sequence,
+		"messages":   []string{p.Keys[0].Ref(), p.Keys[1].Ref()},
+		"signatures": sigs,
+	}
+	ref, err := s.PublishLog.Publish(content)
+	if err != nil {
+		forkLog.Log("msg", "failed to publish fork proof", "err", err)
+		return
+	}
+	if err := s.Forks.SetPublished(p.Feed, ref); err != nil {
+		forkLog.Log("msg", "failed to note published fork proof", "err", err)
+	}
+}
+
+// ForkProofs returns the proofs of all the forked feeds, the most recently detected first
+func (s *Sbot) ForkProofs() ([]forks.Proof, error) {
+	if s.Forks == nil {
+		return nil, errors.New("sbot: no fork proofs")
+	}
+	return s.Forks.List()
+}
@@ -17,6 +17,7 @@ import (
 	refs "go.mindeco.de/ssb-refs"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/gaps"
 	"go.cryptoscope.co/ssb/multilogs"
 )
 
@@ -123,12 +124,22 @@ func (s *Sbot) FSCK(opts ...FSCKOption) error {
 		opt.mode = FSCKModeLength
 	}
 
+	// partially replicated feeds don't start at one
+	var markers map[string]gaps.Marker
+	if s.Gaps != nil {
+		var err error
+		markers, err = s.Gaps.All()
+		if err != nil {
+			return err
+		}
+	}
+
 	switch opt.mode {
 	case FSCKModeLength:
-		return lengthFSCK(opt.feedsIdx, s.RootLog)
+		return lengthFSCK(opt.feedsIdx, s.RootLog, markers)
 
 	case FSCKModeSequences:
-		return sequenceFSCK(s.RootLog, opt.progressFn)
+		return sequenceFSCK(s.RootLog, markers, opt.progressFn)
 
 	default:
 		return errors.New("sbot: unknown fsck mode")
@@ -137,20 +148,16 @@ func (s *Sbot) FSCK(opts ...FSCKOption) error {
 
 // lengthFSCK just checks the length of each stored feed.
 // It expects a multilog as first parameter where each sublog is one feed
-// and each entry maps to another entry in the receiveLog
-func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log) error {
+// and each entry maps to another entry in the receiveLog.
+// The markers of partially stored feeds are keyed by the feed reference.
+func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log, markers map[string]gaps.Marker) error {
 	feeds, err := authorMlog.List()
 	if err != nil {
 		return err
 	}
 
 	for _, author := range feeds {
-		var sr refs.StorageRef
-		err := sr.Unmarshal([]byte(author))
-		if err != nil {
-			return err
-		}
-		authorRef, err := sr.FeedRef()
+		authorRef, err := ssb.FeedRefFromStoredAddr(author)
 		if err != nil {
 			return err
 		}
@@ -183,7 +190,22 @@ func lengthFSCK(authorMlog multilog.MultiLog, receiveLog margaret.Log) error {
 		msg := rv.(refs.Message)
 
 		// margaret indexes are 0-based, therefore +1
-		if msg.Seq() != currentSeqFromIndex.Seq()+1 {
+		expected := currentSeqFromIndex.Seq() + 1
+		if m, has := markers[authorRef.Ref()]; has {
+			if m.Sparse {
+				// can only have less messages than the sequence says
+				if msg.Seq() < expected {
+					return ssb.ErrWrongSequence{
+						Ref:     authorRef,
+						Stored:  currentSeqFromIndex,
+						Logical: msg,
+					}
+				}
+				continue
+			}
+			expected = m.Expected(currentSeqFromIndex.Seq())
+		}
+		if msg.Seq() != expected {
 			return ssb.ErrWrongSequence{
 				Ref:     authorRef,
 				Stored:  currentSeqFromIndex,
@@ -216,8 +238,9 @@ func (p *processedCounter) N() int64 {
 func (p *processedCounter) Err() error { return nil }
 
 // sequenceFSCK goes through every message in the receiveLog
-// and checks tha the sequence of a feed is correctly increasing by one each message
-func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
+// and checks tha the sequence of a feed is correctly increasing by one each message.
+// Partially stored feeds start at the sequence of their marker, sparse ones only need to be increasing.
+func sequenceFSCK(receiveLog margaret.Log, markers map[string]gaps.Marker, progressFn FSCKUpdateFunc) error {
 	ctx := context.Background()
 
 	// the last sequence number we saw of that author
@@ -290,9 +313,15 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 		seqMap.Add(uint32(rxLogSeq))
 
 		currSeq, has := lastSequence[authorRef]
+		marker, partial := markers[authorRef]
+		sparse := partial && marker.Sparse
 
 		if !has {
-			if msgSeq != 1 { // not seen yet, so has to be the first
+			first := int64(1)
+			if partial && !sparse {
+				first = marker.From
+			}
+			if (sparse && msgSeq < 1) || (!sparse && msgSeq != first) { // not seen yet, so has to be the first
 				seqErr := ssb.ErrWrongSequence{
 					Ref:     msg.Author(),
 					Stored:  sw.Seq(),
@@ -302,7 +331,7 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 				lastSequence[authorRef] = -1
 				continue
 			}
-			lastSequence[authorRef] = 1
+			lastSequence[authorRef] = msgSeq
 			continue
 		}
 
@@ -310,7 +339,18 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 			continue
 		}
 
-		if currSeq+1 != msgSeq { // correct next value?
+		if sparse {
+			if msgSeq <= currSeq {
+				seqErr := ssb.ErrWrongSequence{
+					Ref:     msg.Author(),
+					Stored:  margaret.BaseSeq(currSeq + 1),
+					Logical: msg,
+				}
+				consistencyErrors = append(consistencyErrors, seqErr)
+				lastSequence[authorRef] = -1
+				continue
+			}
+		} else if currSeq+1 != msgSeq { // correct next value?
 			seqErr := ssb.ErrWrongSequence{
 				Ref:     msg.Author(),
 				Stored:  margaret.BaseSeq(currSeq + 1),
@@ -320,7 +360,7 @@ func sequenceFSCK(receiveLog margaret.Log, progressFn FSCKUpdateFunc) error {
 			lastSequence[authorRef] = -1
 			continue
 		}
-		lastSequence[authorRef] = currSeq + 1
+		lastSequence[authorRef] = msgSeq
 
 		// bench stats
 		pc.Incr()
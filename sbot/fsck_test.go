@@ -9,12 +9,16 @@ import (
 	"testing"
 
 	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	refs "go.mindeco.de/ssb-refs"
 
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/message/buttwoo"
+	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/repo"
 )
 
@@ -56,6 +60,7 @@ func TestFSCK(t *testing.T) {
 	t.Run("correct", testFSCKcorrect)
 	t.Run("double", testFSCKdouble)
 	t.Run("multipleFeeds", testFSCKmultipleFeeds)
+	t.Run("otherFormats", testFSCKotherFormats)
 	// t.Run("rerpo", testFSCKrerpo)
 }
 
@@ -269,3 +274,59 @@ func testFSCKrepro(t *testing.T) {
 	theBot.Shutdown()
 	r.NoError(theBot.Close())
 }
+
+// feeds in formats without a refs.StorageRef (buttwoo, metafeeds) are decoded through the registry
+func testFSCKotherFormats(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	kpWoo, err := repo.NewKeyPair(repo.New(filepath.Join("testrun", t.Name())), "woo", buttwoo.RefAlgo)
+	r.NoError(err)
+	theBot, _ := makeTestBot(t)
+
+	for i := 0; i < 3; i++ {
+		_, err := theBot.PublishAs("woo", map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	theBot.WaitUntilIndexesAreSynced()
+
+	r.NoError(theBot.FSCK(FSCKWithMode(FSCKModeLength)))
+
+	uf, ok := theBot.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	src, err := ssb.FeedsWithSequnce(uf)
+	r.NoError(err)
+	var found bool
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		}
+		r.NoError(err)
+		upto, ok := v.(ssb.ReplicateUpToResponse)
+		r.True(ok, "wrong type: %T", v)
+		if upto.ID.Equal(kpWoo.Id) {
+			found = true
+			a.EqualValues(3, upto.Sequence)
+		}
+	}
+	a.True(found, "buttwoo feed not listed")
+
+	// block list subscriptions of metafeeds
+	bls, err := openBlockListStore(repo.New(filepath.Join("testrun", t.Name(), "bls")))
+	r.NoError(err)
+	mf := &refs.FeedRef{Algo: ssb.RefAlgoFeedBendyButt, ID: kpWoo.Id.ID}
+	r.NoError(bls.Add(mf))
+	r.NoError(bls.Add(kpWoo.Id))
+	lst, err := bls.List()
+	r.NoError(err)
+	a.Len(lst, 2)
+	for _, ref := range lst {
+		a.True(ref.Equal(mf) || ref.Equal(kpWoo.Id), "unexpected ref %s", ref.Ref())
+	}
+	r.NoError(bls.Close())
+
+	theBot.Shutdown()
+	r.NoError(theBot.Close())
+}
@@ -3,38 +3,176 @@
 package sbot
 
 import (
+	"bytes"
+	"sort"
+	"strings"
+
 	"github.com/pkg/errors"
 	refs "go.mindeco.de/ssb-refs"
 
+	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/repo"
 )
 
-func (sbot *Sbot) PublishAs(nick string, val interface{}) (*refs.MessageRef, error) {
-	r := repo.New(sbot.repoPath)
+var _ ssb.Identities = (*Sbot)(nil)
+
+// PublishAs publishes val on the feed of the local identity as, see KeyPairFor
+func (sbot *Sbot) PublishAs(as string, val interface{}) (*refs.MessageRef, error) {
+	pl, err := sbot.PublisherFor(as)
+	if err != nil {
+		return nil, err
+	}
+	return pl.Publish(val)
+}
+
+// KeyPairFor returns the keypair of a local identity.
+// as is the nick of the keypair in the secrets folder of the repo or its feed reference. The empty string is the main KeyPair.
+func (sbot *Sbot) KeyPairFor(as string) (*ssb.KeyPair, error) {
+	if as == "" {
+		return sbot.KeyPair, nil
+	}
+
+	sbot.identitiesMu.Lock()
+	defer sbot.identitiesMu.Unlock()
+
+	if strings.HasPrefix(as, "@") {
+		ref, err := ssb.ParseFeedRef(as)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: invalid identity reference")
+		}
+		if sbot.KeyPair.Id.Equal(ref) {
+			return sbot.KeyPair, nil
+		}
+		for _, kp := range sbot.identities {
+			if kp.Id.Equal(ref) {
+				return kp, nil
+			}
+		}
+		return nil, errors.Errorf("sbot: %s is not a local identity", ref.ShortRef())
+	}
+
+	if kp, has := sbot.identities[as]; has {
+		return kp, nil
+	}
+
+	// might have been added after we started
+	kp, err := repo.LoadKeyPairWithPassphrase(repo.New(sbot.repoPath), as, sbot.passphrase())
+	if err != nil {
+		return nil, errors.Wrapf(err, "sbot: no local identity %q", as)
+	}
+	sbot.identities[as] = kp
+	return kp, nil
+}
+
+// PublisherFor returns the publish log of a local identity, see KeyPairFor
+func (sbot *Sbot) PublisherFor(as string) (ssb.Publisher, error) {
+	kp, err := sbot.KeyPairFor(as)
+	if err != nil {
+		return nil, err
+	}
+	if kp.Id.Equal(sbot.KeyPair.Id) {
+		return sbot.PublishLog, nil
+	}
+
+	sbot.identitiesMu.Lock()
+	defer sbot.identitiesMu.Unlock()
+
+	addr := string(ssb.StoredAddr(kp.Id))
+	if pl, has := sbot.idPublishers[addr]; has {
+		return pl, nil
+	}
 
 	uf, ok := sbot.GetMultiLog(multilogs.IndexNameFeeds)
 	if !ok {
 		return nil, errors.Errorf("requried idx not present: userFeeds")
 	}
 
-	kp, err := repo.LoadKeyPair(r, nick)
+	pl, err := message.OpenPublishLog(sbot.RootLog, uf, kp, sbot.publishOptions()...)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "publishAs: failed to create publish log")
 	}
+	sbot.idPublishers[addr] = pl
+	return pl, nil
+}
 
-	var pubopts = []message.PublishOption{
-		message.UseNowTimestamps(true),
+// MigrateFeed publishes a ssb.FeedMoved message on the feed of from that points to the feed of to.
+// Both need to be local identities, see KeyPairFor. Use it after a key rotation, to tell followers where the feed continues.
+func (sbot *Sbot) MigrateFeed(from, to string) (*refs.MessageRef, error) {
+	oldKP, err := sbot.KeyPairFor(from)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: old identity")
 	}
-	if sbot.signHMACsecret != nil { // all feeds use the same settings right now
-		pubopts = append(pubopts, message.SetHMACKey(sbot.signHMACsecret))
+	newKP, err := sbot.KeyPairFor(to)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: new identity")
+	}
+	if oldKP.Id.Equal(newKP.Id) {
+		return nil, errors.Errorf("sbot: can't migrate %s to itself", oldKP.Id.ShortRef())
 	}
 
-	pl, err := message.OpenPublishLog(sbot.RootLog, uf, kp, pubopts...)
+	pl, err := sbot.PublisherFor(from)
 	if err != nil {
-		return nil, errors.Wrap(err, "publishAs: failed to create publish log")
+		return nil, err
+	}
+	ref, err := pl.Publish(ssb.NewFeedMoved(oldKP.Id, newKP))
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to publish feed migration")
 	}
+	return ref, nil
+}
 
-	return pl.Publish(val)
+// Identities returns the nicks of the local identities besides the main KeyPair, sorted
+func (sbot *Sbot) Identities() []string {
+	sbot.identitiesMu.Lock()
+	defer sbot.identitiesMu.Unlock()
+	nicks := make([]string, 0, len(sbot.identities))
+	for nick := range sbot.identities {
+		nicks = append(nicks, nick)
+	}
+	sort.Strings(nicks)
+	return nicks
+}
+
+// localKeyPairs returns the main KeyPair and the other local identities, sorted by nick
+func (sbot *Sbot) localKeyPairs() []*ssb.KeyPair {
+	nicks := sbot.Identities()
+
+	sbot.identitiesMu.Lock()
+	defer sbot.identitiesMu.Unlock()
+	kps := []*ssb.KeyPair{sbot.KeyPair}
+	for _, nick := range nicks {
+		kps = append(kps, sbot.identities[nick])
+	}
+	return kps
+}
+
+// isLocalIdentity returns true if remote connected with the key of one of the local identities.
+// These get the master handler, like the main KeyPair.
+func (sbot *Sbot) isLocalIdentity(remote *refs.FeedRef) bool {
+	// shs only tells us the public key, not the format the identity publishes in
+	if bytes.Equal(sbot.KeyPair.Id.ID, remote.ID) {
+		return true
+	}
+	sbot.identitiesMu.Lock()
+	defer sbot.identitiesMu.Unlock()
+	for _, kp := range sbot.identities {
+		if bytes.Equal(kp.Id.ID, remote.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// publishOptions are used for the publish logs of all the identities
+func (sbot *Sbot) publishOptions() []message.PublishOption {
+	var pubopts = []message.PublishOption{
+		message.UseNowTimestamps(true),
+		message.WithFeedLocks(sbot.feedLocks),
+	}
+	if sbot.signHMACsecret != nil { // the identities of a bot are all on its network, see WithNetworkProfile
+		pubopts = append(pubopts, message.SetHMACKey(sbot.signHMACsecret))
+	}
+	return pubopts
 }
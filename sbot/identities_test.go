@@ -159,6 +159,76 @@ func TestMultipleIdentities(t *testing.T) {
 	checkLogSeq(berts, 3)  // self + hello + reply + from arny
 	checkLogSeq(cloes, 3)  // self + hello + reply + from arny
 
+	// the keypairs are found by nick and by reference
+	r.Equal([]string{"arny", "bert", "cloe"}, mainbot.Identities())
+	byRef, err := mainbot.KeyPairFor(kpBert.Id.Ref())
+	r.NoError(err)
+	r.Equal(kpBert, byRef)
+	main, err := mainbot.KeyPairFor("")
+	r.NoError(err)
+	r.Equal(mainbot.KeyPair, main)
+	r.True(mainbot.isLocalIdentity(kpCloe.Id))
+	stranger, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	r.False(mainbot.isLocalIdentity(stranger.Id))
+	_, err = mainbot.KeyPairFor(stranger.Id.Ref())
+	r.Error(err)
+
+	// arny rotates to cloe
+	_, err = mainbot.MigrateFeed("arny", "arny")
+	r.Error(err)
+	movedRef, err := mainbot.MigrateFeed("arny", kpCloe.Id.Ref())
+	r.NoError(err)
+	movedMsg, err := mainbot.Get(*movedRef)
+	r.NoError(err)
+	r.True(movedMsg.Author().Equal(kpArny.Id))
+	var moved ssb.FeedMoved
+	r.NoError(json.Unmarshal(movedMsg.ContentBytes(), &moved))
+	r.NoError(moved.Verify())
+	r.Equal(kpCloe.Id.Ref(), moved.To)
+
 	mainbot.Shutdown()
 	r.NoError(mainbot.Close())
 }
+
+func TestPrivateUnboxingIdentities(t *testing.T) {
+	defer leakcheck.Check(t)
+	r := require.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	kpArny, err := repo.NewKeyPair(repo.New(tRepoPath), "arny", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	bot, err := New(
+		WithInfo(log.NewNopLogger()),
+		WithRepoPath(tRepoPath),
+		EnablePrivateUnboxing(true),
+		DisableNetworkNode(),
+	)
+	r.NoError(err)
+	r.Len(bot.localKeyPairs(), 2)
+
+	// one for the main identity, one for arny and one for both
+	for _, rcpts := range [][]*refs.FeedRef{{bot.KeyPair.Id}, {kpArny.Id}, {bot.KeyPair.Id, kpArny.Id}} {
+		ciph, err := private.Box([]byte(`"psst"`), rcpts...)
+		r.NoError(err)
+		_, err = bot.PublishLog.Publish(ciph)
+		r.NoError(err)
+	}
+	bot.WaitUntilIndexesAreSynced()
+
+	pl, ok := bot.GetMultiLog("privLogs")
+	r.True(ok, "privLogs not mounted")
+	for _, kp := range bot.localKeyPairs() {
+		privs, err := pl.Get(kp.Id.StoredAddr())
+		r.NoError(err)
+		v, err := privs.Seq().Value()
+		r.NoError(err)
+		r.EqualValues(1, v.(margaret.Seq).Seq(), "wrong number of messages for %s", kp.Id.ShortRef())
+	}
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
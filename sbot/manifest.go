@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/pkg/errors"
 	"go.cryptoscope.co/muxrpc"
 )
 
@@ -80,6 +81,11 @@ const manifestBlob = `
 	},
 
 	"publish": "async",
+	"publishBatch": "async",
+	"private": {
+	  "publish": "async",
+	  "read": "source"
+	},
 	"whoami": "sync",
 	"status": "sync",
 	"gossip": {
@@ -89,6 +95,27 @@ const manifestBlob = `
 	"replicate": {
 	  "upto": "source"
 	},
+	"forks": {
+	  "list": "async"
+	},
+
+	"ctrl": {
+	  "connect": "async",
+	  "disconnect": "async",
+	  "replicate": "async",
+	  "block": "async",
+	  "subscribeBlockList": "async",
+	  "blockLists": "async",
+	  "shutdown": "async"
+	},
+
+	"invite": {
+	  "create": "async",
+	  "use": "async",
+	  "accept": "async",
+	  "list": "async",
+	  "revoke": "async"
+	},
 
 	"blobs": {
 	  "get": "source",
@@ -105,3 +132,22 @@ const manifestBlob = `
 	}
   }
   `
+
+// gatewayCalls are handled below calls of manifestBlob, which a nested manifest can't list.
+// The gateway flattens its manifest into dotted names, so it takes them as they are.
+var gatewayCalls = map[string]string{
+	"publish.batch": "async",
+	"status.peers":  "async",
+}
+
+// gatewayManifest is manifestBlob plus the gatewayCalls, for the http gateway which only forwards the calls it knows.
+func gatewayManifest() (json.RawMessage, error) {
+	var manifest map[string]interface{}
+	if err := json.Unmarshal([]byte(manifestBlob), &manifest); err != nil {
+		return nil, errors.Wrap(err, "gateway manifest: invalid manifestBlob")
+	}
+	for name, typ := range gatewayCalls {
+		manifest[name] = typ
+	}
+	return json.Marshal(manifest)
+}
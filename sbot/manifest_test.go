@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGatewayManifest(t *testing.T) {
+	r, a := require.New(t), assert.New(t)
+
+	blob, err := gatewayManifest()
+	r.NoError(err)
+
+	var manifest map[string]interface{}
+	r.NoError(json.Unmarshal(blob, &manifest))
+
+	a.Equal("async", manifest["publish"])
+	a.Equal("async", manifest["publish.batch"])
+	a.Equal("async", manifest["status.peers"])
+
+	ctrl, ok := manifest["ctrl"].(map[string]interface{})
+	r.True(ok, "no ctrl section")
+	a.Equal("async", ctrl["replicate"])
+	a.Equal("async", ctrl["subscribeBlockList"])
+	a.Equal("async", ctrl["blockLists"])
+}
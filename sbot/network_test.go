@@ -0,0 +1,122 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/ssb/internal/leakcheck"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestNetworkProfile(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	testnet := repo.NetworkProfile{
+		Name:       "testnet",
+		AppKey:     make([]byte, 32),
+		HMACKey:    make([]byte, 32),
+		ListenAddr: "localhost:0",
+	}
+	rand.Read(testnet.AppKey)
+	rand.Read(testnet.HMACKey)
+
+	botgroup, ctx := errgroup.WithContext(ctx)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+	mkBot := func(name string, opts ...Option) *Sbot {
+		opts = append([]Option{
+			WithContext(ctx),
+			WithInfo(log.With(mainLog, "unit", name)),
+			WithListenAddr(":0"),
+		}, opts...)
+		bot, err := New(opts...)
+		r.NoError(err)
+		botgroup.Go(func() error {
+			err := bot.Network.Serve(ctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		return bot
+	}
+
+	// one repo with both networks
+	both := filepath.Join(tRepoPath, "both")
+	mainBot := mkBot("main", WithRepoPath(both))
+	testBot := mkBot("test", WithRepoPath(both), WithNetworkProfile(testnet))
+
+	a.Equal(filepath.Join(both, "networks", "testnet"), testBot.repoPath)
+	a.False(mainBot.KeyPair.Id.Equal(testBot.KeyPair.Id), "same identity on both networks")
+	a.NotEqual(mainBot.Network.GetListenAddr().String(), testBot.Network.GetListenAddr().String())
+
+	// another bot on the test network
+	peer := mkBot("peer",
+		WithRepoPath(filepath.Join(tRepoPath, "peer")),
+		WithAppKey(testnet.AppKey),
+		WithHMACSigning(testnet.HMACKey))
+	peer.Replicate(testBot.KeyPair.Id)
+	testBot.Replicate(peer.KeyPair.Id)
+
+	_, err := mainBot.PublishLog.Publish(map[string]interface{}{"type": "test", "network": "main"})
+	r.NoError(err)
+	_, err = testBot.PublishLog.Publish(map[string]interface{}{"type": "test", "network": "testnet"})
+	r.NoError(err)
+
+	// the main network doesn't accept it
+	err = peer.Network.Connect(ctx, mainBot.Network.GetListenAddr())
+	a.Error(err)
+
+	r.NoError(peer.Network.Connect(ctx, testBot.Network.GetListenAddr()))
+
+	uf, ok := peer.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	testLog, err := uf.Get(testBot.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+	var seq interface{}
+	for i := 0; i < 20; i++ {
+		seq, err = testLog.Seq().Value()
+		r.NoError(err)
+		if seq == margaret.BaseSeq(0) {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	a.Equal(margaret.BaseSeq(0), seq, "test network feed not replicated")
+
+	// the logs of the networks stay apart
+	mainFeeds, ok := mainBot.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	has, err := multilog.Has(mainFeeds, testBot.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+	a.False(has, "test network feed in the main log")
+	has, err = multilog.Has(uf, mainBot.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+	a.False(has, "main network feed on the test network")
+
+	cancel()
+	for _, bot := range []*Sbot{mainBot, testBot, peer} {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
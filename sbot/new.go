@@ -5,31 +5,35 @@ package sbot
 import (
 	"io"
 	"net"
-	"net/http"
-	"strings"
 	"time"
 
-	"github.com/rs/cors"
-
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"go.cryptoscope.co/librarian"
+	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/margaret/multilog/roaring"
 	"go.cryptoscope.co/muxrpc"
 	refs "go.mindeco.de/ssb-refs"
 
 	"go.cryptoscope.co/ssb"
 	"go.cryptoscope.co/ssb/blobstore"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/gaps"
+	"go.cryptoscope.co/ssb/gateway"
 	"go.cryptoscope.co/ssb/graph"
 	"go.cryptoscope.co/ssb/indexes"
 	"go.cryptoscope.co/ssb/internal/ctxutils"
 	"go.cryptoscope.co/ssb/internal/mutil"
 	"go.cryptoscope.co/ssb/message"
+	_ "go.cryptoscope.co/ssb/message/buttwoo" // registers the buttwoo feed format
+	"go.cryptoscope.co/ssb/metafeed"
 	"go.cryptoscope.co/ssb/multilogs"
 	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/peerstats"
 	"go.cryptoscope.co/ssb/plugins/blobs"
 	"go.cryptoscope.co/ssb/plugins/control"
+	forksplug "go.cryptoscope.co/ssb/plugins/forks"
 	"go.cryptoscope.co/ssb/plugins/friends"
 	"go.cryptoscope.co/ssb/plugins/get"
 	"go.cryptoscope.co/ssb/plugins/gossip"
@@ -43,6 +47,7 @@ import (
 	"go.cryptoscope.co/ssb/plugins/whoami"
 	"go.cryptoscope.co/ssb/private"
 	"go.cryptoscope.co/ssb/repo"
+	"go.cryptoscope.co/ssb/repo/migrations"
 )
 
 func (s *Sbot) Close() error {
@@ -89,8 +94,12 @@ func initSbot(s *Sbot) (*Sbot, error) {
 
 	r := repo.New(s.repoPath)
 
-	// optionize?!
-	s.RootLog, err = repo.OpenLog(r)
+	// repos that went through the bipf migration keep storing new messages as bipf
+	if s.bipfStorage || migrations.CurrentVersion(r) >= 2 {
+		s.RootLog, err = repo.OpenBIPFLog(r)
+	} else {
+		s.RootLog, err = repo.OpenLog(r)
+	}
 	if err != nil {
 		return nil, errors.Wrap(err, "sbot: failed to open rootlog")
 	}
@@ -155,17 +164,31 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	}
 	*/
 
-	var pubopts = []message.PublishOption{
-		message.UseNowTimestamps(true),
-	}
-	if s.signHMACsecret != nil {
-		pubopts = append(pubopts, message.SetHMACKey(s.signHMACsecret))
-	}
-	s.PublishLog, err = message.OpenPublishLog(s.RootLog, uf, s.KeyPair, pubopts...)
+	s.PublishLog, err = message.OpenPublishLog(s.RootLog, uf, s.KeyPair, s.publishOptions()...)
 	if err != nil {
 		return nil, errors.Wrap(err, "sbot: failed to create publish log")
 	}
 
+	// the other keypairs in the repo are local identities, too
+	s.identities, err = repo.AllKeyPairsWithPassphrase(r, s.passphrase())
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to load local identities")
+	}
+	s.idPublishers = make(map[string]ssb.Publisher)
+
+	if _, mounted := s.mlogIndicies["privLogs"]; s.privateUnboxing && !mounted {
+		mlogPriv := multilogs.NewPrivateRead(kitlog.With(log, "module", "privLogs"), s.localKeyPairs()...)
+		if err := MountMultiLog("privLogs", mlogPriv.OpenRoaring)(s); err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open private messages index")
+		}
+	}
+
+	if announce := s.publicAddress(); announce != nil {
+		if err := s.announcePubAddress(uf, announce); err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to announce public address")
+		}
+	}
+
 	// LogBuilder doesn't fully work yet
 	if mt, _ := s.mlogIndicies["msgTypes"]; false {
 		level.Warn(s.info).Log("event", "bot init", "msg", "using experimental bytype:contact graph implementation")
@@ -178,7 +201,7 @@ func initSbot(s *Sbot) (*Sbot, error) {
 			return nil, errors.Wrap(err, "sbot: NewLogBuilder failed")
 		}
 	} else {
-		gb, seqSetter, updateIdx, err := indexes.OpenContacts(kitlog.With(log, "module", "graph"), r)
+		gb, seqSetter, updateIdx, err := indexes.OpenContacts(kitlog.With(log, "module", "graph"), r, s.localKeyPairs()...)
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: OpenContacts failed")
 		}
@@ -187,10 +210,58 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		s.GraphBuilder = gb
 	}
 
+	mfIdx, mfSeqSetter, mfUpdateIdx, err := indexes.OpenMetaFeeds(kitlog.With(log, "module", "metafeeds"), r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: OpenMetaFeeds failed")
+	}
+	s.serveIndex("metafeeds", mfUpdateIdx)
+	s.closers.addCloser(mfSeqSetter)
+	s.MetaFeeds = mfIdx
+
+	if s.enableMetaFeeds {
+		seed, err := metafeed.LoadOrCreateSeed(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to get metafeed seed")
+		}
+		s.MetaFeedManager, err = metafeed.NewManager(seed, s.RootLog, uf, s.MetaFeeds, s.WaitUntilIndexesAreSynced, s.publishOptions()...)
+		if err != nil {
+			return nil, errors.Wrap(err, "sbot: failed to open metafeed")
+		}
+	}
+
+	s.Gaps, err = gaps.Open(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open gap markers")
+	}
+	s.closers.addCloser(s.Gaps)
+
+	s.Forks, err = forks.Open(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open fork proofs")
+	}
+	s.closers.addCloser(s.Forks)
+	s.Forks.OnFork(s.handleFork)
+
 	if s.disableNetwork {
 		return s, nil
 	}
 
+	formatStore, err := repo.OpenPeerFormats(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open peer formats")
+	}
+	s.closers.addCloser(formatStore)
+	s.peerFormats, err = ssb.LoadFormatTracker(formatStore)
+	if err != nil {
+		return nil, err
+	}
+
+	s.PeerStats, err = peerstats.Open(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open peer stats")
+	}
+	s.closers.addCloser(s.PeerStats)
+
 	if s.Replicator == nil {
 		s.Replicator, err = s.newGraphReplicator()
 		if err != nil {
@@ -198,6 +269,13 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		}
 	}
 
+	if s.sneakernetDir != "" {
+		s.idxDone.Go(func() error {
+			s.runSneakernet(ctx)
+			return nil
+		})
+	}
+
 	// TODO: make plugabble
 	// var peerPlug *peerinvites.Plugin
 	// if mt, ok := s.mlogIndicies[multilogs.IndexNameFeeds]; ok {
@@ -212,6 +290,39 @@ func initSbot(s *Sbot) (*Sbot, error) {
 
 	var inviteService *legacyinvites.Service
 
+	// authorizePeer decides if the feed remote may use the public handlers.
+	// The key of the connection is always allowed if promisc or on the allow list, the rest needs the authorizer.
+	authorizePeer := func(remote *refs.FeedRef) error {
+		key := &refs.FeedRef{ID: remote.ID, Algo: refs.RefAlgoFeedSSB1}
+		s.settingsMu.RLock()
+		promisc, allowed := s.promisc, s.allowList.Has(key)
+		s.settingsMu.RUnlock()
+		if promisc || allowed {
+			return nil
+		}
+
+		auth := s.authorizer
+		if auth == nil {
+			auth = s.Replicator.Lister()
+		}
+
+		if s.latency != nil {
+			start := time.Now()
+			defer func() {
+				s.latency.With("part", "graph_auth").Observe(time.Since(start).Seconds())
+			}()
+		}
+		err := auth.Authorize(remote)
+		if err == nil {
+			return nil
+		}
+		if lst, lerr := uf.List(); lerr == nil && len(lst) == 0 {
+			level.Warn(log).Log("event", "no stored feeds - attempting re-sync with trust-on-first-use")
+			return nil
+		}
+		return err
+	}
+
 	mkHandler := func(conn net.Conn) (muxrpc.Handler, error) {
 		// bypassing badger-close bug to go through with an accept (or not) before closing the bot
 		s.closedMu.Lock()
@@ -221,7 +332,7 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: expected an address containing an shs-bs addr")
 		}
-		if s.KeyPair.Id.Equal(remote) {
+		if s.isLocalIdentity(remote) {
 			return s.master.MakeHandler(conn)
 		}
 
@@ -238,55 +349,42 @@ func initSbot(s *Sbot) (*Sbot, error) {
 			}
 		}
 
-		if s.promisc {
-			return s.public.MakeHandler(conn)
-		}
-
-		auth := s.authorizer
-		if auth == nil {
-			auth = s.Replicator.Lister()
-		}
-
-		if s.latency != nil {
-			start := time.Now()
-			defer func() {
-				s.latency.With("part", "graph_auth").Observe(time.Since(start).Seconds())
-			}()
-		}
-		err = auth.Authorize(remote)
-		if err == nil {
-			return s.public.MakeHandler(conn)
-		}
-
-		// shit - don't see a way to pass being a different feedtype with shs1
-		// we also need to pass this up the stack...!
-		remote.Algo = refs.RefAlgoFeedGabby
-		err = auth.Authorize(remote)
-		if err == nil {
-			level.Debug(log).Log("TODO", "found gg feed, using that. overhaul shs1 to support more payload in the handshake")
-			return s.public.MakeHandler(conn)
-		}
-		if lst, err := uf.List(); err == nil && len(lst) == 0 {
-			level.Warn(log).Log("event", "no stored feeds - attempting re-sync with trust-on-first-use")
-			return s.public.MakeHandler(conn)
+		// shs1 only tells us the public key of the remote, not the format it publishes in.
+		// try the formats it told us about in an earlier whoami negotiation first,
+		// gossip checks the connection again once the format is negotiated (see gossip.AuthorizeFormat)
+		for _, candidate := range s.peerFormats.Candidates(remote) {
+			err = authorizePeer(candidate)
+			if err == nil {
+				return s.public.MakeHandler(conn)
+			}
 		}
 		return nil, err
 	}
 
-	s.master.Register(publish.NewPlug(kitlog.With(log, "plugin", "publish"), s.PublishLog, s.RootLog))
+	publishPlug := publish.NewPlug(kitlog.With(log, "plugin", "publish"), s.PublishLog, s.RootLog, s)
+	s.master.Register(publishPlug)
+	// publish is an async call, so the manifest can't have publish.batch next to it
+	s.master.Register(namedPlugin{h: publishPlug.Handler(), name: "publishBatch"})
 
 	if pl, ok := s.mlogIndicies["privLogs"]; ok {
-		userPrivs, err := pl.Get(s.KeyPair.Id.StoredAddr())
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to open user private index")
+		// every identity reads the messages it can unbox
+		readPrivate := func(kp *ssb.KeyPair) (margaret.Log, error) {
+			userPrivs, err := pl.Get(kp.Id.StoredAddr())
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to open user private index")
+			}
+			return private.NewUnboxerLog(s.RootLog, userPrivs, kp), nil
 		}
-		s.master.Register(privplug.NewPlug(kitlog.With(log, "plugin", "private"), s.PublishLog, private.NewUnboxerLog(s.RootLog, userPrivs, s.KeyPair)))
+		if _, err := readPrivate(s.KeyPair); err != nil {
+			return nil, err
+		}
+		s.master.Register(privplug.NewPlug(kitlog.With(log, "plugin", "private"), s, readPrivate))
 	}
 
-	// whoami
-	whoami := whoami.New(kitlog.With(log, "plugin", "whoami"), s.KeyPair.Id)
-	s.public.Register(whoami)
-	s.master.Register(whoami)
+	// whoami, local clients can ask for the other identities
+	whoamiLog := kitlog.With(log, "plugin", "whoami")
+	s.public.Register(whoami.New(whoamiLog, s.KeyPair.Id))
+	s.master.Register(whoami.NewLocal(whoamiLog, s))
 
 	// blobs
 	blobs := blobs.New(kitlog.With(log, "plugin", "blobs"), *s.KeyPair.Id, s.BlobStore, wm)
@@ -299,6 +397,17 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	var histOpts = []interface{}{
 		gossip.HopCount(s.hopCount),
 		gossip.Promisc(s.promisc),
+		s.peerFormats,
+		gossip.AuthorizeFormat(authorizePeer),
+		s.PeerStats,
+		s.Gaps,
+		s.Forks,
+		s.feedLocks,
+		gossip.VerifyWorkers(s.verifyWorkers),
+	}
+
+	if len(s.replPolicies) > 0 {
+		histOpts = append(histOpts, gossip.PolicyFunc(s.policyFor))
 	}
 
 	if s.systemGauge != nil {
@@ -323,10 +432,11 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		s.systemGauge,
 		s.eventCounter,
 	)
-	s.public.Register(gossip.New(ctx,
+	gossipPlug := gossip.New(ctx,
 		kitlog.With(log, "plugin", "gossip"),
 		s.KeyPair.Id, s.RootLog, uf, fm, s.Replicator.Lister(),
-		histOpts...))
+		histOpts...)
+	s.public.Register(gossipPlug)
 
 	// incoming createHistoryStream handler
 	hist := gossip.NewHist(ctx,
@@ -337,6 +447,7 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		fm,
 		histOpts...)
 	s.public.Register(hist)
+	s.gossipPlugs = append(s.gossipPlugs, gossipPlug, hist)
 
 	s.master.Register(get.New(s, s.RootLog))
 
@@ -373,6 +484,7 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	opts := network.Options{
 		Logger:              s.info,
 		Dialer:              s.dialer,
+		Dialers:             s.dialers,
 		ListenAddr:          s.listenAddr,
 		AdvertsSend:         s.enableAdverts,
 		AdvertsConnectTo:    s.enableDiscovery,
@@ -387,52 +499,54 @@ func initSbot(s *Sbot) (*Sbot, error) {
 		SystemGauge:     s.systemGauge,
 		EndpointWrapper: s.edpWrapper,
 		Latency:         s.latency,
+		PeerQuotas:      s.peerQuotas,
+		PeerStats:       s.PeerStats,
 
-		WebsocketAddr: s.websocketAddr,
+		WebsocketAddr:    s.websocketAddr,
+		WebsocketOrigins: s.websocketOrigins,
 	}
 
 	s.Network, err = network.New(opts)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create network node")
 	}
-	blobsPathPrefix := "/blobs/get/"
-	h := cors.Default().Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !strings.HasPrefix(req.URL.Path, blobsPathPrefix) {
-			http.Error(w, "404", http.StatusNotFound)
-			return
-		}
 
-		rest := strings.TrimPrefix(req.URL.Path, blobsPathPrefix)
-		blobRef, err := refs.ParseBlobRef(rest)
-		if err != nil {
-			level.Error(log).Log("http-err", err.Error())
-			http.Error(w, "bad blob", http.StatusBadRequest)
-			return
-		}
-
-		br, err := s.BlobStore.Get(blobRef)
-		if err != nil {
-			s.WantManager.Want(blobRef)
-			level.Error(log).Log("http-err", err.Error())
-			http.Error(w, "no such blob", http.StatusNotFound)
-			return
+	// the feed of the bot might not have been known when the tokens were set
+	gatewayTokens := make(map[string]*refs.FeedRef, len(s.gatewayTokens))
+	for token, feed := range s.gatewayTokens {
+		if feed == nil {
+			feed = s.KeyPair.Id
 		}
+		gatewayTokens[token] = feed
+	}
 
-		// wh := w.Header()
-		// sniff content-type?
-		w.WriteHeader(http.StatusOK)
-		_, err = io.Copy(w, br)
-		if err != nil {
-			level.Error(log).Log("http-blob", err.Error())
-		}
-	}))
-	s.Network.HandleHTTP(h)
+	gwManifest, err := gatewayManifest()
+	if err != nil {
+		return nil, err
+	}
+	gw, err := gateway.New(gateway.Options{
+		Logger:         kitlog.With(log, "unit", "gateway"),
+		BlobStore:      s.BlobStore,
+		WantManager:    s.WantManager,
+		MakeHandler:    mkHandler,
+		Manifest:       gwManifest,
+		Tokens:         gatewayTokens,
+		AllowedOrigins: s.websocketOrigins,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create http gateway")
+	}
+	// closing the network shuts the gateway down
+	s.Network.HandleHTTP(gw)
 
 	inviteService, err = legacyinvites.New(
 		kitlog.With(log, "plugin", "legacyInvites"),
 		r,
 		s.KeyPair.Id,
 		s.Network,
+		s.appKey,
+		s.publicAddress(),
+		s.inviteExpiry,
 		s.PublishLog,
 		s.RootLog,
 	)
@@ -444,6 +558,7 @@ func initSbot(s *Sbot) (*Sbot, error) {
 	// TODO: should be gossip.connect but conflicts with our namespace assumption
 	s.master.Register(control.NewPlug(kitlog.With(log, "plugin", "ctrl"), s.Network, s))
 	s.master.Register(status.New(s))
+	s.master.Register(forksplug.New(kitlog.With(log, "plugin", "forks"), s))
 
 	return s, nil
 }
@@ -68,6 +68,13 @@ func (s *Sbot) NullFeed(ref *refs.FeedRef) error {
 		return errors.Wrapf(err, "NullFeed: error while deleting feed from graph index")
 	}
 
+	if s.Gaps != nil {
+		err = s.Gaps.Clear(ref)
+		if err != nil {
+			return errors.Wrapf(err, "NullFeed: error while deleting gap marker")
+		}
+	}
+
 	return nil
 }
 
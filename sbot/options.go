@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/cryptix/go/logging"
 	kitlog "github.com/go-kit/kit/log"
@@ -22,13 +23,20 @@ import (
 	"go.cryptoscope.co/margaret/multilog"
 	"go.cryptoscope.co/muxrpc"
 	"go.cryptoscope.co/netwrap"
+	refs "go.mindeco.de/ssb-refs"
 	"golang.org/x/sync/errgroup"
 
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
+	"go.cryptoscope.co/ssb/gaps"
 	"go.cryptoscope.co/ssb/graph"
 	"go.cryptoscope.co/ssb/internal/netwraputil"
+	"go.cryptoscope.co/ssb/message"
 	"go.cryptoscope.co/ssb/message/multimsg"
+	"go.cryptoscope.co/ssb/metafeed"
 	"go.cryptoscope.co/ssb/network"
+	"go.cryptoscope.co/ssb/network/netaddr"
+	"go.cryptoscope.co/ssb/peerstats"
 	"go.cryptoscope.co/ssb/repo"
 )
 
@@ -52,8 +60,17 @@ type Sbot struct {
 	closedMu sync.Mutex
 	closeErr error
 
-	promisc  bool
-	hopCount uint
+	// settingsMu guards the settings that can be changed by Reload
+	settingsMu sync.RWMutex
+	promisc    bool
+	hopCount   uint
+	allowList  *ssb.StrFeedSet
+
+	// the gossip plugins, to drain and reconfigure them
+	gossipPlugs []gossipPlugin
+
+	drained     chan struct{}
+	drainedOnce sync.Once
 
 	// TODO: these should all be options that are applied on the network construction...
 	Network            ssb.Network
@@ -61,7 +78,12 @@ type Sbot struct {
 	appKey             []byte
 	listenAddr         net.Addr
 	dialer             netwrap.Dialer
+	dialers            map[string]netwrap.Dialer
+	onionAddr          *netaddr.OnionAddr
+	pubAddr            net.Addr
+	inviteExpiry       time.Duration
 	edpWrapper         MuxrpcEndpointWrapper
+	peerQuotas         network.PeerQuotas
 	networkConnTracker ssb.ConnTracker
 	preSecureWrappers  []netwrap.ConnWrapper
 	postSecureWrappers []netwrap.ConnWrapper
@@ -71,13 +93,47 @@ type Sbot struct {
 
 	authorizer ssb.Authorizer
 
+	// the negotiated feed formats of remote peers
+	peerFormats *ssb.FormatTracker
+
+	// PeerStats is the connection history of the remote peers
+	PeerStats *peerstats.Store
+
+	// Gaps knows the feeds that are only stored partially, see WithReplicationPolicies
+	Gaps         *gaps.Store
+	replPolicies []ReplicationPolicy
+
+	// Forks has the proofs of forked feeds, which are not replicated anymore
+	Forks             *forks.Store
+	publishForkProofs bool
+
+	verifyWorkers int
+
+	// see WithSneakernet
+	sneakernetDir   string
+	sneakernetEvery time.Duration
+
 	enableAdverts   bool
 	enableDiscovery bool
 
-	websocketAddr string
+	websocketAddr    string
+	websocketOrigins []string
+	gatewayTokens    map[string]*refs.FeedRef
+
+	repoPath      string
+	network       *repo.NetworkProfile
+	KeyPair       *ssb.KeyPair
+	keyPassphrase repo.PassphraseFunc
 
-	repoPath string
-	KeyPair  *ssb.KeyPair
+	// the other local identities by nick (see KeyPairFor), and their publish logs by stored address
+	identitiesMu sync.Mutex
+	identities   map[string]*ssb.KeyPair
+	idPublishers map[string]ssb.Publisher
+
+	// feedLocks is shared by all the writers of the bot: the publish logs (see publishOptions), gossip and the imports
+	feedLocks *message.FeedLocks
+	// importMu serializes the imports, they are the only writers that hold the locks of several feeds
+	importMu sync.Mutex
 
 	RootLog multimsg.AlterableLog
 
@@ -93,6 +149,18 @@ type Sbot struct {
 
 	GraphBuilder graph.Builder
 
+	// MetaFeeds knows the subfeeds of all the metafeeds we have
+	MetaFeeds metafeed.Index
+
+	// MetaFeedManager publishes our own root metafeed, it's nil unless EnableMetaFeeds is used
+	MetaFeedManager *metafeed.Manager
+	enableMetaFeeds bool
+
+	bipfStorage bool
+
+	// privateUnboxing mounts privLogs for all the local identities, see EnablePrivateUnboxing
+	privateUnboxing bool
+
 	BlobStore   ssb.BlobStore
 	WantManager ssb.WantManager
 
@@ -151,6 +219,70 @@ func WithDialer(dial netwrap.Dialer) Option {
 	}
 }
 
+// WithNetworkDialer sets the dialer for one type of address, like "tcp" or netaddr.OnionNetwork, instead of WithDialer.
+func WithNetworkDialer(networkName string, dial netwrap.Dialer) Option {
+	return func(s *Sbot) error {
+		if s.dialers == nil {
+			s.dialers = make(map[string]netwrap.Dialer)
+		}
+		s.dialers[networkName] = dial
+		return nil
+	}
+}
+
+// WithSOCKS5Proxy dials the passed types of addresses through the SOCKS5 proxy at addr, like the one of a Tor daemon.
+// Without networks only onion addresses are proxied, pass "tcp" as well to send all connections through it.
+func WithSOCKS5Proxy(addr string, networks ...string) Option {
+	return func(s *Sbot) error {
+		if len(networks) == 0 {
+			networks = []string{netaddr.OnionNetwork}
+		}
+		dial := network.SOCKS5Dialer(addr)
+		for _, n := range networks {
+			if err := WithNetworkDialer(n, dial)(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// WithOnionAddress sets the onion service that forwards to our listener.
+// It is put into invites instead of the listen address and announced in a pub message, unless EnablePubMode is used.
+func WithOnionAddress(host string, port int) Option {
+	return func(s *Sbot) error {
+		if !netaddr.IsOnionHost(host) {
+			return errors.Errorf("WithOnionAddress: not an onion host: %q", host)
+		}
+		if port <= 0 || port > 65535 {
+			return errors.Errorf("WithOnionAddress: invalid port: %d", port)
+		}
+		s.onionAddr = &netaddr.OnionAddr{Host: host, Port: port}
+		return nil
+	}
+}
+
+// EnablePubMode makes the bot announce host:port in a pub message and put it into the invites it creates.
+// The invites expire after inviteExpiry, unless the call to invite.create sets another time (0 means never).
+// host can be a domain name, it is given to others as is.
+func EnablePubMode(host string, port int, inviteExpiry time.Duration) Option {
+	return func(s *Sbot) error {
+		if host == "" {
+			return errors.Errorf("EnablePubMode: empty host")
+		}
+		if port <= 0 || port > 65535 {
+			return errors.Errorf("EnablePubMode: invalid port: %d", port)
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			s.pubAddr = &net.TCPAddr{IP: ip, Port: port}
+		} else {
+			s.pubAddr = netaddr.HostAddr{Host: host, Port: port}
+		}
+		s.inviteExpiry = inviteExpiry
+		return nil
+	}
+}
+
 func WithNetworkConnTracker(ct ssb.ConnTracker) Option {
 	return func(s *Sbot) error {
 		s.networkConnTracker = ct
@@ -246,6 +378,32 @@ func WithUNIXSocket() Option {
 	}
 }
 
+// WithNoauthWebsocket serves muxrpc over websockets without secret-handshake on the passed loopback address.
+// Like the unix socket, the connections get the master plugins, so local UIs don't need to handle the keys.
+// Non-loopback addresses are refused and browsers are only allowed from the origins set with WithWebsocketOrigins.
+// It needs the keypair, so use it with LateOption.
+func WithNoauthWebsocket(addr string) Option {
+	return func(s *Sbot) error {
+		if s.KeyPair == nil {
+			return errors.Errorf("sbot/noauth websocket: keypair is nil. please use WithNoauthWebsocket with LateOption")
+		}
+
+		nw, err := network.ListenNoauthWebsocket(network.NoauthWebsocketOptions{
+			Logger:      kitlog.With(s.info, "unit", "noauth-websocket"),
+			ListenAddr:  addr,
+			As:          s.KeyPair.Id,
+			MakeHandler: s.master.MakeHandler,
+			Wrappers:    s.postSecureWrappers,
+			Origins:     s.websocketOrigins,
+		})
+		if err != nil {
+			return errors.Wrap(err, "sbot: failed to start noauth websocket")
+		}
+		s.closers.addCloser(nw)
+		return nil
+	}
+}
+
 func WithAppKey(k []byte) Option {
 	return func(s *Sbot) error {
 		if n := len(k); n != 32 {
@@ -256,15 +414,37 @@ func WithAppKey(k []byte) Option {
 	}
 }
 
+// WithNamedKeyPair uses the keypair name from the secrets folder of the repo.
+// If it's encrypted, the passphrase comes from WithKeyPassphrase, so that and WithRepoPath need to be passed before it.
+// With WithNetworkProfile (also before it), it's the secrets folder of the network.
 func WithNamedKeyPair(name string) Option {
 	return func(s *Sbot) error {
 		r := repo.New(s.repoPath)
+		if s.network != nil {
+			r = s.network.Repo(r)
+		}
 		var err error
-		s.KeyPair, err = repo.LoadKeyPair(r, name)
+		s.KeyPair, err = repo.LoadKeyPairWithPassphrase(r, name, s.passphrase())
 		return errors.Wrapf(err, "loading named key-pair %q failed", name)
 	}
 }
 
+// WithKeyPassphrase sets where the passphrase of an encrypted keypair comes from.
+// The default is repo.DefaultPassphrase, which reads SSB_PASSPHRASE or SSB_PASSPHRASE_FILE or asks on the terminal.
+func WithKeyPassphrase(pass repo.PassphraseFunc) Option {
+	return func(s *Sbot) error {
+		s.keyPassphrase = pass
+		return nil
+	}
+}
+
+func (s *Sbot) passphrase() repo.PassphraseFunc {
+	if s.keyPassphrase != nil {
+		return s.keyPassphrase
+	}
+	return repo.DefaultPassphrase
+}
+
 func WithJSONKeyPair(blob string) Option {
 	return func(s *Sbot) error {
 		var err error
@@ -326,6 +506,15 @@ func WithEndpointWrapper(mw MuxrpcEndpointWrapper) Option {
 	}
 }
 
+// WithPeerQuotas limits the concurrent streams, message rate and bandwidth of every connection, see network.PeerQuotas.
+// Throttling is counted in the event counter passed to WithEventMetrics.
+func WithPeerQuotas(q network.PeerQuotas) Option {
+	return func(s *Sbot) error {
+		s.peerQuotas = q
+		return nil
+	}
+}
+
 // EnableAdvertismentBroadcasts controls local peer discovery through sending UDP broadcasts
 func EnableAdvertismentBroadcasts(do bool) Option {
 	return func(s *Sbot) error {
@@ -342,6 +531,19 @@ func EnableAdvertismentDialing(do bool) Option {
 	}
 }
 
+// WithNetworkProfile runs the bot on the network p instead of the main one.
+// It uses the app key, HMAC key and listen address of the profile (over the ones of the other options)
+// and the namespace of the network in the repo, so its logs and identities stay apart from the other networks.
+func WithNetworkProfile(p repo.NetworkProfile) Option {
+	return func(s *Sbot) error {
+		if err := p.Validate(); err != nil {
+			return errors.Wrap(err, "sbot: invalid network profile")
+		}
+		s.network = &p
+		return nil
+	}
+}
+
 func WithHMACSigning(key []byte) Option {
 	return func(s *Sbot) error {
 		if n := len(key); n != 32 {
@@ -360,6 +562,30 @@ func WithWebsocketAddress(addr string) Option {
 	}
 }
 
+// WithWebsocketOrigins sets the browser origins that may use the websocket and the JSON-RPC gateway.
+// "*" allows all of them. Without origins, the shs websocket accepts all and the gateway only its own.
+func WithWebsocketOrigins(origins ...string) Option {
+	return func(s *Sbot) error {
+		s.websocketOrigins = append(s.websocketOrigins, origins...)
+		return nil
+	}
+}
+
+// WithGatewayToken lets HTTP and websocket clients of the gateway make calls as the passed feed, with the same permissions it would have over secret-handshake.
+// A nil feed stands for the feed of the bot, which gets the master plugins.
+func WithGatewayToken(token string, as *refs.FeedRef) Option {
+	return func(s *Sbot) error {
+		if len(token) < 16 {
+			return errors.Errorf("WithGatewayToken: token is too short (%d < 16)", len(token))
+		}
+		if s.gatewayTokens == nil {
+			s.gatewayTokens = make(map[string]*refs.FeedRef)
+		}
+		s.gatewayTokens[token] = as
+		return nil
+	}
+}
+
 // WithHops sets the number of friends (or bi-directionla follows) to walk between two peers
 // controls fetch depth (whos feeds to fetch.
 // 0: only my own follows
@@ -381,6 +607,18 @@ func WithPromisc(yes bool) Option {
 	}
 }
 
+// WithAllowList accepts connections from these peers, even if they are not in range of the hop count
+func WithAllowList(feeds ...*refs.FeedRef) Option {
+	return func(s *Sbot) error {
+		for _, f := range feeds {
+			if err := s.allowList.AddRef(f); err != nil {
+				return errors.Wrap(err, "sbot: invalid allow list entry")
+			}
+		}
+		return nil
+	}
+}
+
 // WithPublicAuthorizer configures who is considered "public" when accepting connections.
 // By default, this is covered by the list of followed and blocked peers using the graph implementation.
 func WithPublicAuthorizer(auth ssb.Authorizer) Option {
@@ -401,6 +639,85 @@ func WithReplicator(r ssb.Replicator) Option {
 	}
 }
 
+// WithReplicationPolicies only fetches parts of the feeds in the hop ranges of the policies, see ReplicationPolicy.
+// The first policy that matches the distance of a feed is used.
+func WithReplicationPolicies(pols ...ReplicationPolicy) Option {
+	return func(s *Sbot) error {
+		for _, p := range pols {
+			if p.MinHops < 0 || p.MaxHops < p.MinHops {
+				return fmt.Errorf("sbot: invalid hop range %d-%d of replication policy", p.MinHops, p.MaxHops)
+			}
+			if p.Latest <= 0 && len(p.Types) == 0 {
+				return fmt.Errorf("sbot: replication policy for hops %d-%d has neither types nor a message count", p.MinHops, p.MaxHops)
+			}
+		}
+		s.replPolicies = append(s.replPolicies, pols...)
+		return nil
+	}
+}
+
+// WithForkProofPublishing publishes a fork-proof message with the keys and signatures of both messages for every newly detected fork, so that other peers can act on it
+func WithForkProofPublishing(yes bool) Option {
+	return func(s *Sbot) error {
+		s.publishForkProofs = yes
+		return nil
+	}
+}
+
+// WithVerifyWorkers verifies the signatures of fetched messages with n goroutines, in batches.
+// Zero (the default) verifies them one by one.
+func WithVerifyWorkers(n int) Option {
+	return func(s *Sbot) error {
+		if n < 0 {
+			return fmt.Errorf("sbot: invalid number of verify workers: %d", n)
+		}
+		s.verifyWorkers = n
+		return nil
+	}
+}
+
+// WithSneakernet syncs with the directory dir every interval, whenever it exists (see SyncDir).
+// It can be a folder on a USB drive that is carried between bots that don't reach each other over the network.
+func WithSneakernet(dir string, every time.Duration) Option {
+	return func(s *Sbot) error {
+		if every <= 0 {
+			return fmt.Errorf("sbot: invalid sneakernet interval: %s", every)
+		}
+		s.sneakernetDir = dir
+		s.sneakernetEvery = every
+		return nil
+	}
+}
+
+// EnableMetaFeeds derives a root metafeed from the seed in the repo (which is created if it doesn't exist)
+// and makes it available as MetaFeedManager, to create subfeeds for different purposes.
+func EnableMetaFeeds(yes bool) Option {
+	return func(s *Sbot) error {
+		s.enableMetaFeeds = yes
+		return nil
+	}
+}
+
+// UseBIPFStorage stores new legacy messages in the receive log as bipf instead of JSON,
+// so that indexes can read their content fields without parsing the JSON (see multimsg.SeekContent).
+// Use ssb-migrate-log -bipf to convert the messages that are already stored.
+func UseBIPFStorage(yes bool) Option {
+	return func(s *Sbot) error {
+		s.bipfStorage = yes
+		return nil
+	}
+}
+
+// EnablePrivateUnboxing indexes the private messages that the local identities can decrypt,
+// the main KeyPair and the other keypairs in the repo. Each of them reads its own with private.read.
+// It does nothing if a privLogs multilog is mounted with a LateOption.
+func EnablePrivateUnboxing(yes bool) Option {
+	return func(s *Sbot) error {
+		s.privateUnboxing = yes
+		return nil
+	}
+}
+
 // LateOption is a bit of a hack, it loads options after the _basic_ inititialisation is done (like repo location and keypair)
 // this is mainly usefull for plugins that want to use a configured bot.
 func LateOption(o Option) Option {
@@ -421,6 +738,10 @@ func New(fopts ...Option) (*Sbot, error) {
 	s.simpleIndex = make(map[string]librarian.Index)
 	s.indexStates = make(map[string]string)
 
+	s.allowList = ssb.NewFeedSet(0)
+	s.drained = make(chan struct{})
+	s.feedLocks = message.NewFeedLocks()
+
 	for i, opt := range fopts {
 		err := opt(&s)
 		if err != nil {
@@ -437,6 +758,17 @@ func New(fopts ...Option) (*Sbot, error) {
 		s.repoPath = filepath.Join(u.HomeDir, ".ssb-go")
 	}
 
+	if p := s.network; p != nil {
+		s.repoPath = p.Repo(repo.New(s.repoPath)).GetPath()
+		s.appKey = p.AppKey
+		s.signHMACsecret = p.HMACKey // without one the network uses plain signatures
+		if p.ListenAddr != "" {
+			if err := WithListenAddr(p.ListenAddr)(&s); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	if s.appKey == nil {
 		ak, err := base64.StdEncoding.DecodeString("1KHLiKZvAvjbY1ziZEHMXawbCEIM6qwjCDm3VYRan/s=")
 		if err != nil {
@@ -467,7 +799,7 @@ func New(fopts ...Option) (*Sbot, error) {
 
 	if s.KeyPair == nil {
 		var err error
-		s.KeyPair, err = repo.DefaultKeyPair(r)
+		s.KeyPair, err = repo.DefaultKeyPairWithPassphrase(r, s.passphrase())
 		if err != nil {
 			return nil, errors.Wrap(err, "sbot: failed to get keypair")
 		}
@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/gaps"
+	"go.cryptoscope.co/ssb/plugins/gossip"
+)
+
+// ReplicationPolicy applies a gossip.Policy to the feeds which are MinHops to MaxHops away.
+// The hops are counted like the ones of WithHops, 0 are the feeds we follow ourselves.
+//
+// Feeds which are fetched partially are noted in Sbot.Gaps and are not served to other peers.
+// Once there are messages of a feed, the policy isn't asked again: a tail is updated like a complete feed
+// and a feed with only some types of messages stays like that.
+// If the distance of a partially stored feed changes so that another policy (or none) applies,
+// its messages are dropped with NullFeed and it is fetched again.
+type ReplicationPolicy struct {
+	MinHops, MaxHops int
+
+	gossip.Policy
+}
+
+// policyFor returns the policy for the distance of ref or nil if it should be replicated completely
+func (s *Sbot) policyFor(ref *refs.FeedRef) *gossip.Policy {
+	pol, _ := s.replicationPolicy(ref)
+	return pol
+}
+
+// replicationPolicy is like policyFor but also tells if the distance of ref is known
+func (s *Sbot) replicationPolicy(ref *refs.FeedRef) (*gossip.Policy, bool) {
+	gr, ok := s.Replicator.(*graphReplicator)
+	if !ok {
+		return nil, false
+	}
+
+	// manually replicated feeds and subfeeds don't have a distance
+	dist, has := gr.distance(ref)
+	if !has {
+		return nil, false
+	}
+	for _, p := range s.replPolicies {
+		if p.MinHops <= dist && dist <= p.MaxHops {
+			pol := p.Policy
+			return &pol, true
+		}
+	}
+	return nil, true
+}
+
+// replacePartialFeeds drops the partially stored feeds which don't match the policy of their current distance,
+// so that they are fetched again. Feeds which are out of range are left alone.
+func (s *Sbot) replacePartialFeeds(log log.Logger) {
+	if s.Gaps == nil {
+		return
+	}
+	markers, err := s.Gaps.All()
+	if err != nil {
+		level.Warn(log).Log("msg", "failed to list gap markers", "err", err)
+		return
+	}
+
+	for _, m := range markers {
+		pol, known := s.replicationPolicy(m.Feed)
+		if !known || policyMatches(pol, m) {
+			continue
+		}
+		if err := s.NullFeed(m.Feed); err != nil {
+			level.Warn(log).Log("msg", "failed to drop partial feed", "feed", m.Feed.Ref(), "err", err)
+			continue
+		}
+		level.Info(log).Log("msg", "dropped partial feed, its replication policy changed", "feed", m.Feed.ShortRef())
+	}
+}
+
+// policyMatches returns true if the stored part of the feed, described by m, is what pol would fetch
+func policyMatches(pol *gossip.Policy, m gaps.Marker) bool {
+	if pol == nil {
+		return false
+	}
+	if len(pol.Types) == 0 {
+		return !m.Sparse
+	}
+	if !m.Sparse || len(pol.Types) != len(m.Types) {
+		return false
+	}
+	have := make(map[string]struct{}, len(m.Types))
+	for _, t := range m.Types {
+		have[t] = struct{}{}
+	}
+	for _, t := range pol.Types {
+		if _, ok := have[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.cryptoscope.co/margaret"
+	refs "go.mindeco.de/ssb-refs"
+	"golang.org/x/sync/errgroup"
+
+	"go.cryptoscope.co/ssb/gaps"
+	"go.cryptoscope.co/ssb/internal/leakcheck"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/multilogs"
+	"go.cryptoscope.co/ssb/plugins/gossip"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestReplicationPolicyLatest(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+	ctx, cancel := context.WithCancel(context.TODO())
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+
+	botgroup, ctx := errgroup.WithContext(ctx)
+	mainLog := testutils.NewRelativeTimeLogger(nil)
+	mkBot := func(name string, opts ...Option) *Sbot {
+		opts = append([]Option{
+			WithContext(ctx),
+			WithInfo(log.With(mainLog, "unit", name)),
+			WithRepoPath(filepath.Join(tRepoPath, name)),
+			WithListenAddr(":0"),
+		}, opts...)
+		bot, err := New(opts...)
+		r.NoError(err)
+		botgroup.Go(func() error {
+			err := bot.Network.Serve(ctx)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		})
+		return bot
+	}
+
+	// ali only wants the newest three messages of the feeds it follows directly
+	ali := mkBot("ali", WithHops(1), WithReplicationPolicies(ReplicationPolicy{
+		MinHops: 0,
+		MaxHops: 0,
+		Policy:  gossip.Policy{Latest: 3},
+	}))
+	bob := mkBot("bob")
+
+	for i := 0; i < 10; i++ {
+		_, err := bob.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+		r.NoError(err)
+	}
+	bob.Replicate(ali.KeyPair.Id)
+
+	_, err := ali.PublishLog.Publish(refs.NewContactFollow(bob.KeyPair.Id))
+	r.NoError(err)
+	ali.WaitUntilIndexesAreSynced()
+	gr, ok := ali.Replicator.(*graphReplicator)
+	r.True(ok)
+	gr.update()
+	r.True(ali.Replicator.Lister().ReplicationList().Has(bob.KeyPair.Id))
+
+	r.NoError(ali.Network.Connect(ctx, bob.Network.GetListenAddr()))
+
+	uf, ok := ali.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	bobsLog, err := uf.Get(bob.KeyPair.Id.StoredAddr())
+	r.NoError(err)
+	var seq interface{}
+	for i := 0; i < 20; i++ {
+		seq, err = bobsLog.Seq().Value()
+		r.NoError(err)
+		if seq == margaret.BaseSeq(2) {
+			break
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	a.Equal(margaret.BaseSeq(2), seq, "expected three messages of bob")
+
+	marker, err := ali.Gaps.Get(bob.KeyPair.Id)
+	r.NoError(err)
+	r.NotNil(marker, "no gap marker for bob")
+	a.False(marker.Sparse)
+	a.EqualValues(8, marker.From)
+
+	cancel()
+	for _, bot := range []*Sbot{ali, bob} {
+		bot.Shutdown()
+		r.NoError(bot.Close())
+	}
+	r.NoError(botgroup.Wait())
+}
+
+func TestReplicationPolicyDistanceChange(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	kpBert, err := repo.NewKeyPair(tRepo, "bert", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpCloe, err := repo.NewKeyPair(tRepo, "cloe", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+
+	bot, err := New(
+		WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		WithRepoPath(tRepoPath),
+		WithHops(1),
+		WithListenAddr(":0"),
+		WithReplicationPolicies(ReplicationPolicy{
+			MinHops: 1,
+			MaxHops: 1,
+			Policy:  gossip.Policy{Latest: 3},
+		}),
+	)
+	r.NoError(err)
+
+	gr, ok := bot.Replicator.(*graphReplicator)
+	r.True(ok)
+
+	// bert is a friend, cloe a friend of bert
+	intros := []struct {
+		as string
+		c  interface{}
+	}{
+		{"", refs.NewContactFollow(kpBert.Id)},
+		{"bert", refs.NewContactFollow(bot.KeyPair.Id)},
+		{"bert", refs.NewContactFollow(kpCloe.Id)},
+	}
+	for i, intro := range intros {
+		_, err := bot.PublishAs(intro.as, intro.c)
+		r.NoError(err, "publish %d failed", i)
+	}
+	bot.WaitUntilIndexesAreSynced()
+
+	// the distances are known before the updater ran
+	if pol := bot.policyFor(kpCloe.Id); a.NotNil(pol) {
+		a.Equal(3, pol.Latest)
+	}
+	gr.update()
+
+	d, has := gr.distance(kpBert.Id)
+	a.True(has)
+	a.Equal(0, d)
+	d, has = gr.distance(kpCloe.Id)
+	a.True(has)
+	a.Equal(1, d)
+	a.Nil(bot.policyFor(kpBert.Id))
+	if pol := bot.policyFor(kpCloe.Id); a.NotNil(pol) {
+		a.Equal(3, pol.Latest)
+	}
+
+	// cloe was fetched with the policy
+	r.NoError(bot.Gaps.Mark(gaps.Marker{Feed: kpCloe.Id, From: 5}))
+	gr.update()
+	marker, err := bot.Gaps.Get(kpCloe.Id)
+	r.NoError(err)
+	a.NotNil(marker, "matching marker was cleared")
+
+	// now that cloe is followed directly, the feed is replicated completely
+	_, err = bot.PublishLog.Publish(refs.NewContactFollow(kpCloe.Id))
+	r.NoError(err)
+	bot.WaitUntilIndexesAreSynced()
+	gr.update()
+
+	d, has = gr.distance(kpCloe.Id)
+	a.True(has)
+	a.Equal(0, d)
+	marker, err = bot.Gaps.Get(kpCloe.Id)
+	r.NoError(err)
+	a.Nil(marker, "marker of the tail was kept")
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
@@ -0,0 +1,95 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret"
+	"go.cryptoscope.co/margaret/multilog"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb/internal/mutil"
+	"go.cryptoscope.co/ssb/network/netaddr"
+)
+
+// publicAddress returns the address others should use to reach us, if one is configured.
+// The one of the pub mode is preferred over the onion address.
+func (s *Sbot) publicAddress() net.Addr {
+	if s.pubAddr != nil {
+		return s.pubAddr
+	}
+	if s.onionAddr != nil {
+		return *s.onionAddr
+	}
+	return nil
+}
+
+// announcePubAddress publishes a pub message with the address,
+// unless the latest pub message of our feed already has it.
+func (s *Sbot) announcePubAddress(uf multilog.MultiLog, addr net.Addr) error {
+	announced, err := s.latestPubAddress(uf)
+	if err != nil {
+		return err
+	}
+
+	want := refs.OldAddress{Key: *s.KeyPair.Id}
+	switch a := addr.(type) {
+	case netaddr.OnionAddr:
+		want.Host, want.Port = a.Host, a.Port
+	case netaddr.HostAddr:
+		want.Host, want.Port = a.Host, a.Port
+	case *net.TCPAddr:
+		want.Host, want.Port = a.IP.String(), a.Port
+	default:
+		return errors.Errorf("can't announce address of type %T", addr)
+	}
+	if announced != nil && announced.Host == want.Host && announced.Port == want.Port && announced.Key.Equal(&want.Key) {
+		return nil
+	}
+
+	_, err = s.PublishLog.Publish(refs.OldPubMessage{
+		Type:    "pub",
+		Address: want,
+	})
+	return errors.Wrap(err, "failed to publish pub address")
+}
+
+func (s *Sbot) latestPubAddress(uf multilog.MultiLog) (*refs.OldAddress, error) {
+	ctx := context.Background()
+
+	userSeqs, err := uf.Get(s.KeyPair.Id.StoredAddr())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open own feed")
+	}
+
+	src, err := mutil.Indirect(s.RootLog, userSeqs).Query(margaret.Reverse(true))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query own feed")
+	}
+
+	for {
+		v, err := src.Next(ctx)
+		if err != nil {
+			if luigi.IsEOS(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		msg, ok := v.(refs.Message)
+		if !ok { // nulled or broken entries
+			continue
+		}
+
+		var pub refs.OldPubMessage
+		if err := json.Unmarshal(msg.ContentBytes(), &pub); err != nil || pub.Type != "pub" {
+			continue
+		}
+		return &pub.Address, nil
+	}
+}
@@ -3,6 +3,7 @@ package sbot
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
@@ -12,7 +13,10 @@ import (
 	"go.cryptoscope.co/luigi"
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/forks"
 	"go.cryptoscope.co/ssb/graph"
+	"go.cryptoscope.co/ssb/metafeed"
+	"go.cryptoscope.co/ssb/repo"
 	refs "go.mindeco.de/ssb-refs"
 )
 
@@ -21,36 +25,118 @@ var _ ssb.Replicator = (*Sbot)(nil)
 type graphReplicator struct {
 	builder graph.Builder
 	current *lister
+
+	// manual blocks (through Block()) and replications (through Replicate()) which are kept across updates
+	manualBlocks *ssb.StrFeedSet
+	manualWants  *ssb.StrFeedSet
+
+	// the feeds whose blocks we also apply
+	blockLists *blockListStore
+
+	// used to follow the metafeeds of wanted feeds to their subfeeds
+	metafeeds metafeed.Index
+
+	// forked feeds are not replicated
+	forks *forks.Store
+
+	// the hop distance of the wanted feeds, only kept if there are replication policies
+	trackDistance bool
+	distMu        sync.Mutex
+	distances     map[string]int
+
+	// distancesChanged is called by the updater when the hop distances changed, see Sbot.replacePartialFeeds
+	distancesChanged func()
+
+	self *refs.FeedRef
+	hops func() int
+
+	update func()
 }
 
 func (s *Sbot) newGraphReplicator() (*graphReplicator, error) {
 	var r graphReplicator
 	r.builder = s.GraphBuilder
 	r.current = newLister()
+	r.manualBlocks = ssb.NewFeedSet(0)
+	r.manualWants = ssb.NewFeedSet(0)
+	r.metafeeds = s.MetaFeeds
+	r.trackDistance = len(s.replPolicies) > 0
+	r.forks = s.Forks
+
+	var err error
+	r.blockLists, err = openBlockListStore(repo.New(s.repoPath))
+	if err != nil {
+		return nil, errors.Wrap(err, "sbot: failed to open block list subscriptions")
+	}
+	s.closers.addCloser(r.blockLists)
 
 	replicateEvt := log.With(s.info, "event", "update-replicate")
-	update := r.makeUpdater(replicateEvt, s.KeyPair.Id, int(s.hopCount))
+	r.self = s.KeyPair.Id
+	r.hops = s.currentHops
+	if r.trackDistance {
+		r.distancesChanged = func() { s.replacePartialFeeds(replicateEvt) }
+	}
+	r.update = r.makeUpdater(replicateEvt, s.KeyPair.Id, s.currentHops)
 
 	// update for new messages but only every 15seconds
-	go debounce(s.rootCtx, 15*time.Second, s.RootLog.Seq(), update)
+	go debounce(s.rootCtx, 15*time.Second, s.RootLog.Seq(), r.update)
 
 	return &r, nil
 }
 
-// makeUpdater returns a func that does the hop-walk and block checks, used together with debounce
-func (r *graphReplicator) makeUpdater(log log.Logger, self *refs.FeedRef, hopCount int) func() {
+// makeUpdater returns a func that does the hop-walk and block checks, used together with debounce.
+// hops is asked for the current hop count on every run, since it can be changed by Reload.
+// The wanted feeds are built from scratch every time, so feeds that are out of range aren't fetched anymore.
+func (r *graphReplicator) makeUpdater(log log.Logger, self *refs.FeedRef, hops func() int) func() {
+	var (
+		mu        sync.Mutex
+		prevDists map[string]int
+	)
 	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		hopCount := hops()
+
 		start := time.Now()
 		newWants := r.builder.Hops(self, hopCount)
+		if newWants == nil {
+			level.Error(log).Log("msg", "hop walk failed, keeping the current wants")
+			return
+		}
 		level.Debug(log).Log("feed-want-count", newWants.Count(), "hops", hopCount, "took", time.Since(start))
 
-		refs, err := newWants.List()
+		manual, err := r.manualWants.List()
 		if err != nil {
-			level.Error(log).Log("msg", "want list failed", "err", err, "wants", newWants.Count())
+			level.Error(log).Log("msg", "manual want list failed", "err", err)
 			return
 		}
-		for _, ref := range refs {
-			r.current.feedWants.AddRef(ref)
+		for _, ref := range manual {
+			newWants.AddRef(ref)
+		}
+
+		if r.trackDistance {
+			dists := r.updateDistances(self, hopCount)
+			if prevDists == nil || !reflect.DeepEqual(prevDists, dists) {
+				if r.distancesChanged != nil {
+					r.distancesChanged()
+				}
+			}
+			prevDists = dists
+		}
+
+		if r.metafeeds != nil {
+			r.followMetaFeeds(log, newWants)
+		}
+
+		if r.forks != nil {
+			forked, err := r.forks.List()
+			if err != nil {
+				level.Warn(log).Log("msg", "failed to list forked feeds", "err", err)
+			}
+			for _, p := range forked {
+				newWants.Delete(p.Feed)
+			}
 		}
 
 		// make sure we dont fetch and allow blocked feeds
@@ -61,16 +147,184 @@ func (r *graphReplicator) makeUpdater(log log.Logger, self *refs.FeedRef, hopCou
 		}
 
 		newBlocked := g.BlockedList(self)
+
+		// the union of all the block lists we subscribed to
+		subscribed, err := r.blockLists.List()
+		if err != nil {
+			level.Error(log).Log("msg", "failed to list block list subscriptions", "err", err)
+		}
+		for _, sub := range subscribed {
+			if !newBlocked.Has(sub) {
+				newWants.AddRef(sub)
+			}
+
+			subBlocks, err := g.BlockedList(sub).List()
+			if err != nil {
+				level.Warn(log).Log("msg", "failed to get blocks of subscribed list", "list", sub.Ref(), "err", err)
+				continue
+			}
+			for _, bf := range subBlocks {
+				if bf.Equal(self) {
+					continue
+				}
+				newBlocked.AddRef(bf)
+			}
+		}
+
+		manualBlocks, err := r.manualBlocks.List()
+		if err == nil {
+			for _, bf := range manualBlocks {
+				newBlocked.AddRef(bf)
+			}
+		}
+
+		// drop blocks which are no longer on any list
+		oldBlocked, err := r.current.blocked.List()
+		if err == nil {
+			for _, bf := range oldBlocked {
+				if !newBlocked.Has(bf) {
+					r.current.blocked.Delete(bf)
+				}
+			}
+		}
+
 		lst, err := newBlocked.List()
 		if err == nil {
 			for _, bf := range lst {
 				r.current.blocked.AddRef(bf)
-				r.current.feedWants.Delete(bf)
+				newWants.Delete(bf)
 			}
 		}
+
+		// swap in the new wants, the set is shared with the gossip handlers
+		wanted, err := newWants.List()
+		if err != nil {
+			level.Error(log).Log("msg", "want list failed", "err", err, "wants", newWants.Count())
+			return
+		}
+		for _, ref := range wanted {
+			r.current.feedWants.AddRef(ref)
+		}
+		oldWants, err := r.current.feedWants.List()
+		if err == nil {
+			for _, ref := range oldWants {
+				if !newWants.Has(ref) {
+					r.current.feedWants.Delete(ref)
+				}
+			}
+		}
+		level.Debug(log).Log("blocked-count", r.current.blocked.Count(), "block-lists", len(subscribed), "wants", newWants.Count())
 	}
 }
 
+// updateDistances finds out how far away each feed is and returns the new distances
+func (r *graphReplicator) updateDistances(self *refs.FeedRef, hopCount int) map[string]int {
+	dists := hopDistances(r.builder, self, hopCount)
+
+	r.distMu.Lock()
+	r.distances = dists
+	r.distMu.Unlock()
+	return dists
+}
+
+// hopDistances walks the graph breadth-first like Builder.Hops does,
+// only going on from the feeds that follow back, and notes the hop at which each feed is found first.
+func hopDistances(b graph.Builder, self *refs.FeedRef, hopCount int) map[string]int {
+	dists := make(map[string]int)
+	walked := map[string]struct{}{self.Ref(): {}}
+
+	current := []*refs.FeedRef{self}
+	for hop := 0; hop <= hopCount && len(current) > 0; hop++ {
+		var next []*refs.FeedRef
+		for _, from := range current {
+			follows, err := b.Follows(from)
+			if err != nil {
+				continue
+			}
+			lst, err := follows.List()
+			if err != nil {
+				continue
+			}
+			for _, ref := range lst {
+				if ref.Equal(self) {
+					continue
+				}
+				if _, has := dists[ref.Ref()]; !has {
+					dists[ref.Ref()] = hop
+				}
+
+				if hop == hopCount {
+					continue
+				}
+				if _, has := walked[ref.Ref()]; has {
+					continue
+				}
+				back, err := b.Follows(ref)
+				if err != nil || !back.Has(from) {
+					continue
+				}
+				walked[ref.Ref()] = struct{}{}
+				next = append(next, ref)
+			}
+		}
+		current = next
+	}
+	return dists
+}
+
+// distance returns the hop distance of ref. The distances are computed here if the updater didn't run yet.
+func (r *graphReplicator) distance(ref *refs.FeedRef) (int, bool) {
+	r.distMu.Lock()
+	known := r.distances != nil
+	r.distMu.Unlock()
+	if !known {
+		r.updateDistances(r.self, r.hops())
+	}
+
+	r.distMu.Lock()
+	defer r.distMu.Unlock()
+	d, has := r.distances[ref.Ref()]
+	return d, has
+}
+
+// followMetaFeeds adds the announced metafeeds of the feeds in wants and all their subfeeds which are not tombstoned
+func (r *graphReplicator) followMetaFeeds(log log.Logger, wants *ssb.StrFeedSet) {
+	wanted, err := wants.List()
+	if err != nil {
+		level.Error(log).Log("msg", "want list failed", "err", err)
+		return
+	}
+
+	var roots []*refs.FeedRef
+	for _, ref := range wanted {
+		if ref.Algo == ssb.RefAlgoFeedBendyButt {
+			roots = append(roots, ref)
+			continue
+		}
+		mf, has, err := r.metafeeds.Announced(ref)
+		if err != nil {
+			level.Warn(log).Log("msg", "failed to look up announced metafeed", "feed", ref.Ref(), "err", err)
+			continue
+		}
+		if has {
+			wants.AddRef(mf)
+			roots = append(roots, mf)
+		}
+	}
+
+	for _, root := range roots {
+		subfeeds, err := metafeed.Follow(r.metafeeds, root)
+		if err != nil {
+			level.Warn(log).Log("msg", "failed to follow metafeed", "metafeed", root.Ref(), "err", err)
+			continue
+		}
+		for _, sf := range subfeeds {
+			wants.AddRef(sf)
+		}
+	}
+	level.Debug(log).Log("metafeeds", len(roots))
+}
+
 func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable, work func()) {
 	var seqMu sync.Mutex
 	var seq = margaret.SeqEmpty
@@ -109,14 +363,81 @@ func debounce(ctx context.Context, interval time.Duration, obs luigi.Observable,
 	}
 }
 
-func (r *graphReplicator) Block(ref *refs.FeedRef)   { r.current.blocked.AddRef(ref) }
-func (r *graphReplicator) Unblock(ref *refs.FeedRef) { r.current.blocked.Delete(ref) }
+func (r *graphReplicator) Block(ref *refs.FeedRef) {
+	r.manualBlocks.AddRef(ref)
+	r.current.blocked.AddRef(ref)
+}
+
+func (r *graphReplicator) Unblock(ref *refs.FeedRef) {
+	r.manualBlocks.Delete(ref)
+	r.current.blocked.Delete(ref)
+}
 
-func (r *graphReplicator) Replicate(ref *refs.FeedRef)     { r.current.feedWants.AddRef(ref) }
-func (r *graphReplicator) DontReplicate(ref *refs.FeedRef) { r.current.feedWants.Delete(ref) }
+func (r *graphReplicator) Replicate(ref *refs.FeedRef) {
+	r.manualWants.AddRef(ref)
+	r.current.feedWants.AddRef(ref)
+}
+
+func (r *graphReplicator) DontReplicate(ref *refs.FeedRef) {
+	r.manualWants.Delete(ref)
+	r.current.feedWants.Delete(ref)
+}
 
 func (r *graphReplicator) Lister() ssb.ReplicationLister { return r.current }
 
+var _ ssb.BlockListSubscriber = (*graphReplicator)(nil)
+
+func (r *graphReplicator) SubscribeBlockList(ref *refs.FeedRef) error {
+	if err := r.blockLists.Add(ref); err != nil {
+		return err
+	}
+	go r.update()
+	return nil
+}
+
+func (r *graphReplicator) UnsubscribeBlockList(ref *refs.FeedRef) error {
+	if err := r.blockLists.Remove(ref); err != nil {
+		return err
+	}
+	go r.update()
+	return nil
+}
+
+func (r *graphReplicator) BlockListSubscriptions() ([]*refs.FeedRef, error) {
+	return r.blockLists.List()
+}
+
+var errNoBlockLists = errors.New("sbot: replicator does not support block list subscriptions")
+
+var _ ssb.BlockListSubscriber = (*Sbot)(nil)
+
+// SubscribeBlockList adds the blocks of ref to the ones that are applied to replication and connections
+func (s *Sbot) SubscribeBlockList(ref *refs.FeedRef) error {
+	bls, ok := s.Replicator.(ssb.BlockListSubscriber)
+	if !ok {
+		return errNoBlockLists
+	}
+	return bls.SubscribeBlockList(ref)
+}
+
+// UnsubscribeBlockList stops applying the blocks of ref
+func (s *Sbot) UnsubscribeBlockList(ref *refs.FeedRef) error {
+	bls, ok := s.Replicator.(ssb.BlockListSubscriber)
+	if !ok {
+		return errNoBlockLists
+	}
+	return bls.UnsubscribeBlockList(ref)
+}
+
+// BlockListSubscriptions returns the feeds whose blocks are applied
+func (s *Sbot) BlockListSubscriptions() ([]*refs.FeedRef, error) {
+	bls, ok := s.Replicator.(ssb.BlockListSubscriber)
+	if !ok {
+		return nil, errNoBlockLists
+	}
+	return bls.BlockListSubscriptions()
+}
+
 type lister struct {
 	feedWants *ssb.StrFeedSet
 	blocked   *ssb.StrFeedSet
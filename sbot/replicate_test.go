@@ -0,0 +1,172 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/internal/leakcheck"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/repo"
+)
+
+func TestReplicateLowerHops(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	kpBert, err := repo.NewKeyPair(tRepo, "bert", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpCloe, err := repo.NewKeyPair(tRepo, "cloe", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpDora, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	bot, err := New(
+		WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		WithRepoPath(tRepoPath),
+		WithHops(1),
+		WithListenAddr(":0"),
+	)
+	r.NoError(err)
+
+	// bert is a friend, cloe a friend of bert
+	intros := []struct {
+		as string
+		c  interface{}
+	}{
+		{"", refs.NewContactFollow(kpBert.Id)},
+		{"bert", refs.NewContactFollow(bot.KeyPair.Id)},
+		{"bert", refs.NewContactFollow(kpCloe.Id)},
+	}
+	for i, intro := range intros {
+		_, err := bot.PublishAs(intro.as, intro.c)
+		r.NoError(err, "publish %d failed", i)
+	}
+	bot.WaitUntilIndexesAreSynced()
+
+	gr, ok := bot.Replicator.(*graphReplicator)
+	r.True(ok)
+	wants := bot.Replicator.Lister().ReplicationList()
+
+	bot.Replicate(kpDora.Id)
+	gr.update()
+	a.True(wants.Has(kpBert.Id))
+	a.True(wants.Has(kpCloe.Id))
+	a.True(wants.Has(kpDora.Id))
+
+	// cloe is out of range, dora was added by hand
+	r.NoError(bot.Reload(Settings{Hops: 0}))
+	gr.update()
+	a.True(wants.Has(kpBert.Id))
+	a.False(wants.Has(kpCloe.Id))
+	a.True(wants.Has(kpDora.Id))
+
+	bot.DontReplicate(kpDora.Id)
+	gr.update()
+	a.False(wants.Has(kpDora.Id))
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
+
+func TestBlockListSubscriptions(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	tRepo := repo.New(tRepoPath)
+
+	kpList1, err := repo.NewKeyPair(tRepo, "list1", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpList2, err := repo.NewKeyPair(tRepo, "list2", refs.RefAlgoFeedSSB1)
+	r.NoError(err)
+	kpBert, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	kpCloe, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+	kpDora, err := ssb.NewKeyPair(nil)
+	r.NoError(err)
+
+	bot, err := New(
+		WithInfo(testutils.NewRelativeTimeLogger(nil)),
+		WithRepoPath(tRepoPath),
+		WithHops(0),
+		WithListenAddr(":0"),
+	)
+	r.NoError(err)
+
+	// we follow all three, the lists block one each (and us, which is ignored)
+	intros := []struct {
+		as string
+		c  interface{}
+	}{
+		{"", refs.NewContactFollow(kpBert.Id)},
+		{"", refs.NewContactFollow(kpCloe.Id)},
+		{"", refs.NewContactFollow(kpDora.Id)},
+		{"list1", refs.NewContactBlock(kpBert.Id)},
+		{"list1", refs.NewContactBlock(bot.KeyPair.Id)},
+		{"list2", refs.NewContactBlock(kpCloe.Id)},
+	}
+	for i, intro := range intros {
+		_, err := bot.PublishAs(intro.as, intro.c)
+		r.NoError(err, "publish %d failed", i)
+	}
+	bot.WaitUntilIndexesAreSynced()
+
+	gr, ok := bot.Replicator.(*graphReplicator)
+	r.True(ok)
+	wants := bot.Replicator.Lister().ReplicationList()
+	blocked := bot.Replicator.Lister().BlockList()
+
+	gr.update()
+	a.True(wants.Has(kpBert.Id))
+	a.True(wants.Has(kpCloe.Id))
+	a.False(wants.Has(kpList1.Id))
+
+	// the list feed is fetched and its blocks are applied
+	r.NoError(bot.SubscribeBlockList(kpList1.Id))
+	gr.update()
+	a.True(wants.Has(kpList1.Id))
+	a.True(blocked.Has(kpBert.Id))
+	a.False(wants.Has(kpBert.Id))
+	a.True(wants.Has(kpCloe.Id))
+	a.False(blocked.Has(bot.KeyPair.Id))
+
+	// the blocks of all the lists are applied
+	r.NoError(bot.SubscribeBlockList(kpList2.Id))
+	gr.update()
+	subs, err := bot.BlockListSubscriptions()
+	r.NoError(err)
+	a.Len(subs, 2)
+	a.True(wants.Has(kpList1.Id))
+	a.True(wants.Has(kpList2.Id))
+	a.True(blocked.Has(kpBert.Id))
+	a.True(blocked.Has(kpCloe.Id))
+	a.False(wants.Has(kpBert.Id))
+	a.False(wants.Has(kpCloe.Id))
+	a.True(wants.Has(kpDora.Id))
+
+	// unsubscribing drops the list feed and its blocks
+	r.NoError(bot.UnsubscribeBlockList(kpList1.Id))
+	gr.update()
+	a.False(wants.Has(kpList1.Id))
+	a.True(wants.Has(kpList2.Id))
+	a.False(blocked.Has(kpBert.Id))
+	a.True(wants.Has(kpBert.Id))
+	a.True(blocked.Has(kpCloe.Id))
+
+	bot.Shutdown()
+	r.NoError(bot.Close())
+}
@@ -0,0 +1,435 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"go.cryptoscope.co/luigi"
+	"go.cryptoscope.co/margaret/multilog"
+	refs "go.mindeco.de/ssb-refs"
+
+	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/archive"
+	"go.cryptoscope.co/ssb/blobstore"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+// the layout of a sneakernet directory:
+// archives/ has the archives the bots wrote, blobs/ the blobs they brought (named by the hex of the hash)
+// and wants/ one file per bot with the blobs it is looking for.
+const (
+	sneakernetArchives = "archives"
+	sneakernetBlobs    = "blobs"
+	sneakernetWants    = "wants"
+
+	sneakernetExt = ".ssb-archive"
+)
+
+// SyncSummary counts what SyncDir moved
+type SyncSummary struct {
+	Imported ImportSummary
+
+	Exported      int // messages written to the directory
+	ExportedBlobs int // blobs written for other bots
+}
+
+// SyncDir exchanges feeds and blobs with other bots through dir, like a USB drive that is carried between them.
+//
+// The replicated feeds are compared like replicate.upto does it over the network, against what the archives in dir have.
+// Newer messages from the archives are imported and the ones dir doesn't have yet are written to a new archive,
+// so repeated syncs only move what changed. Each bot also leaves a list of the blobs it wants,
+// which the others copy into dir if they have them.
+func (s *Sbot) SyncDir(dir string) (SyncSummary, error) {
+	// the stored state of the feeds comes from it
+	s.WaitUntilIndexesAreSynced()
+	return s.syncDir(dir)
+}
+
+func (s *Sbot) syncDir(dir string) (SyncSummary, error) {
+	var sum SyncSummary
+	if s.Replicator == nil {
+		return sum, errors.New("sneakernet: no replicator (the network node is disabled)")
+	}
+
+	for _, sub := range []string{sneakernetArchives, sneakernetBlobs, sneakernetWants} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0700); err != nil {
+			return sum, errors.Wrap(err, "sneakernet: failed to create directory")
+		}
+	}
+
+	uf, ok := s.GetMultiLog(multilogs.IndexNameFeeds)
+	if !ok {
+		return sum, errors.Errorf("sneakernet: failed to open multilog")
+	}
+
+	lister := s.Replicator.Lister()
+	replicated, blocked := lister.ReplicationList(), lister.BlockList()
+	// feeds with gaps can't be continued, like in gossip they are left to the replication policies
+	wanted := func(fr *refs.FeedRef) bool {
+		if blocked.Has(fr) {
+			return false
+		}
+		if !fr.Equal(s.KeyPair.Id) && !replicated.Has(fr) {
+			return false
+		}
+		if s.Gaps != nil {
+			marker, err := s.Gaps.Get(fr)
+			if err != nil || marker != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	ours, err := s.replicationState(uf, wanted)
+	if err != nil {
+		return sum, err
+	}
+
+	archives, theirs, err := s.readSneakernetArchives(filepath.Join(dir, sneakernetArchives))
+	if err != nil {
+		return sum, err
+	}
+
+	// import the archives that have something new.
+	// if one continues where another one ends, the second pass gets it.
+	var pending []sneakernetArchive
+	for _, a := range archives {
+		for _, f := range a.header.Feeds {
+			fr, err := ssb.ParseFeedRef(f.ID)
+			if err == nil && wanted(fr) && f.To > ours[f.ID].Sequence {
+				pending = append(pending, a)
+				break
+			}
+		}
+	}
+	filter := importFilter{
+		feed:      wanted,
+		blob:      s.WantManager.Wants,
+		skipAhead: true,
+	}
+	for len(pending) > 0 {
+		var (
+			again    []sneakernetArchive
+			progress bool
+		)
+		for _, a := range pending {
+			isum, ahead, err := s.importSneakernetArchive(a.path, filter)
+			if err != nil {
+				// what was verified before the error is stored, the rest of it is left alone
+				level.Warn(s.info).Log("event", "sneakernet", "msg", "import failed", "file", a.path, "err", err)
+				ahead = 0
+			}
+			sum.Imported.Messages += isum.Messages
+			sum.Imported.Skipped += isum.Skipped
+			sum.Imported.Blobs += isum.Blobs
+			if isum.Messages > 0 {
+				progress = true
+			}
+			if ahead > 0 {
+				again = append(again, a)
+			}
+		}
+		if !progress {
+			break
+		}
+		pending = again
+	}
+
+	n, err := s.importSneakernetBlobs(filepath.Join(dir, sneakernetBlobs))
+	sum.Imported.Blobs += n
+	if err != nil {
+		return sum, err
+	}
+
+	// what we had before the import is enough here, the imported messages came from the directory
+	var ids []string
+	for id, upto := range ours {
+		if upto.Sequence > theirs[id].Sequence {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		sort.Strings(ids)
+		newer := make([]*refs.FeedRef, len(ids))
+		after := make([]int64, len(ids))
+		for i, id := range ids {
+			fr := ours[id].ID
+			newer[i] = &fr
+			after[i] = theirs[id].Sequence
+		}
+
+		name := fmt.Sprintf("%d-%s%s", time.Now().UnixNano(), hex.EncodeToString(s.KeyPair.Id.ID[:4]), sneakernetExt)
+		err = writeFileAtomic(filepath.Join(dir, sneakernetArchives, name), func(w io.Writer) error {
+			n, err := s.exportFeeds(w, newer, after, false)
+			sum.Exported = n
+			return err
+		})
+		if err != nil {
+			return sum, errors.Wrap(err, "sneakernet: failed to write archive")
+		}
+	}
+
+	sum.ExportedBlobs, err = s.exportSneakernetBlobs(dir)
+	if err != nil {
+		return sum, err
+	}
+
+	return sum, s.writeSneakernetWants(dir)
+}
+
+// replicationState returns the ReplicateUpToResponse of every stored feed that wanted returns true for, by the feed reference.
+// It's the same state that replicate.upto sends.
+func (s *Sbot) replicationState(uf multilog.MultiLog, wanted func(*refs.FeedRef) bool) (map[string]ssb.ReplicateUpToResponse, error) {
+	ctx := context.Background()
+
+	src, err := ssb.FeedsWithSequnce(uf)
+	if err != nil {
+		return nil, errors.Wrap(err, "sneakernet: failed to get stored feeds")
+	}
+
+	state := make(map[string]ssb.ReplicateUpToResponse)
+	for {
+		v, err := src.Next(ctx)
+		if luigi.IsEOS(err) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		upto, ok := v.(ssb.ReplicateUpToResponse)
+		if !ok {
+			return nil, errors.Errorf("sneakernet: wrong state type. expected %T - got %T", upto, v)
+		}
+		if !wanted(&upto.ID) {
+			continue
+		}
+		state[upto.ID.Ref()] = upto
+	}
+	return state, nil
+}
+
+type sneakernetArchive struct {
+	path   string
+	header archive.Header
+}
+
+// readSneakernetArchives returns the archives in dir, oldest first, and the newest message of each feed in them.
+// Unreadable ones are left out, another bot might still be writing them.
+func (s *Sbot) readSneakernetArchives(dir string) ([]sneakernetArchive, map[string]ssb.ReplicateUpToResponse, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+sneakernetExt))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "sneakernet: failed to list archives")
+	}
+	sort.Strings(names)
+
+	var (
+		archives []sneakernetArchive
+		state    = make(map[string]ssb.ReplicateUpToResponse)
+	)
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "sneakernet: failed to open archive")
+		}
+		ar, err := archive.NewReader(f)
+		f.Close()
+		if err != nil {
+			level.Warn(s.info).Log("event", "sneakernet", "msg", "skipping archive", "file", name, "err", err)
+			continue
+		}
+		archives = append(archives, sneakernetArchive{path: name, header: ar.Header})
+
+		for _, feed := range ar.Header.Feeds {
+			fr, err := ssb.ParseFeedRef(feed.ID)
+			if err != nil {
+				continue
+			}
+			if feed.To > state[feed.ID].Sequence {
+				state[feed.ID] = ssb.ReplicateUpToResponse{ID: *fr, Sequence: feed.To}
+			}
+		}
+	}
+	return archives, state, nil
+}
+
+func (s *Sbot) importSneakernetArchive(name string, filter importFilter) (ImportSummary, int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return ImportSummary{}, 0, errors.Wrap(err, "sneakernet: failed to open archive")
+	}
+	defer f.Close()
+
+	sum, ahead, err := s.importArchive(f, filter)
+	return sum, ahead, errors.Wrapf(err, "sneakernet: failed to import %s", filepath.Base(name))
+}
+
+// importSneakernetBlobs stores the blobs we want that are in dir
+func (s *Sbot) importSneakernetBlobs(dir string) (int, error) {
+	var n int
+	for _, w := range s.WantManager.AllWants() {
+		if w.Dist >= 0 {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, hex.EncodeToString(w.Ref.Hash)))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return n, errors.Wrapf(err, "sneakernet: failed to read blob %s", w.Ref.Ref())
+		}
+		if h := sha256.Sum256(data); !bytes.Equal(h[:], w.Ref.Hash) {
+			level.Warn(s.info).Log("event", "sneakernet", "msg", "blob has the wrong content", "blob", w.Ref.Ref())
+			continue
+		}
+		if _, err := s.BlobStore.Put(bytes.NewReader(data)); err != nil {
+			return n, errors.Wrapf(err, "sneakernet: failed to store blob %s", w.Ref.Ref())
+		}
+		n++
+	}
+	return n, nil
+}
+
+// exportSneakernetBlobs copies the blobs that other bots want and we have to dir
+func (s *Sbot) exportSneakernetBlobs(dir string) (int, error) {
+	ownWants := s.sneakernetWantsFile(dir)
+	wantFiles, err := filepath.Glob(filepath.Join(dir, sneakernetWants, "*.json"))
+	if err != nil {
+		return 0, errors.Wrap(err, "sneakernet: failed to list wants")
+	}
+
+	var n int
+	for _, wf := range wantFiles {
+		if wf == ownWants {
+			continue
+		}
+		var wants []string
+		data, err := ioutil.ReadFile(wf)
+		if err == nil {
+			err = json.Unmarshal(data, &wants)
+		}
+		if err != nil {
+			level.Warn(s.info).Log("event", "sneakernet", "msg", "skipping wants", "file", wf, "err", err)
+			continue
+		}
+
+		for _, w := range wants {
+			ref, err := refs.ParseBlobRef(w)
+			if err != nil {
+				continue
+			}
+			name := filepath.Join(dir, sneakernetBlobs, hex.EncodeToString(ref.Hash))
+			if _, err := os.Stat(name); err == nil {
+				continue
+			}
+			rd, err := s.BlobStore.Get(ref)
+			if errors.Cause(err) == blobstore.ErrNoSuchBlob {
+				continue
+			} else if err != nil {
+				return n, errors.Wrapf(err, "sneakernet: failed to open blob %s", ref.Ref())
+			}
+			err = writeFileAtomic(name, func(w io.Writer) error {
+				_, err := io.Copy(w, rd)
+				return err
+			})
+			if err != nil {
+				return n, errors.Wrapf(err, "sneakernet: failed to write blob %s", ref.Ref())
+			}
+			n++
+		}
+	}
+	return n, nil
+}
+
+// writeSneakernetWants replaces our list of wanted blobs in dir
+func (s *Sbot) writeSneakernetWants(dir string) error {
+	wants := []string{}
+	for _, w := range s.WantManager.AllWants() {
+		if w.Dist < 0 {
+			wants = append(wants, w.Ref.Ref())
+		}
+	}
+	sort.Strings(wants)
+
+	err := writeFileAtomic(s.sneakernetWantsFile(dir), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(wants)
+	})
+	return errors.Wrap(err, "sneakernet: failed to write wants")
+}
+
+func (s *Sbot) sneakernetWantsFile(dir string) string {
+	return filepath.Join(dir, sneakernetWants, hex.EncodeToString(s.KeyPair.Id.ID)+".json")
+}
+
+// writeFileAtomic writes to a temporary file next to name and renames it once write is done,
+// so that nobody reads half of it.
+func writeFileAtomic(name string, write func(io.Writer) error) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// runSneakernet syncs with the directory of WithSneakernet until ctx is done.
+// The directory not being there isn't an error, it's the drive that isn't plugged in.
+func (s *Sbot) runSneakernet(ctx context.Context) {
+	evt := kitlog.With(s.info, "event", "sneakernet", "dir", s.sneakernetDir)
+
+	synced := make(chan struct{})
+	go func() {
+		s.WaitUntilIndexesAreSynced()
+		close(synced)
+	}()
+	select {
+	case <-synced:
+	case <-ctx.Done():
+		return
+	}
+
+	tick := time.NewTicker(s.sneakernetEvery)
+	defer tick.Stop()
+	for {
+		if fi, err := os.Stat(s.sneakernetDir); err == nil && fi.IsDir() {
+			start := time.Now()
+			sum, err := s.syncDir(s.sneakernetDir)
+			if err != nil {
+				level.Warn(evt).Log("msg", "sync failed", "err", err)
+			} else if sum.Imported.Messages+sum.Imported.Blobs+sum.Exported+sum.ExportedBlobs > 0 {
+				level.Info(evt).Log("msg", "synced",
+					"imported", sum.Imported.Messages, "imported-blobs", sum.Imported.Blobs,
+					"exported", sum.Exported, "exported-blobs", sum.ExportedBlobs,
+					"took", time.Since(start))
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+		}
+	}
+}
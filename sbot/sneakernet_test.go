@@ -0,0 +1,108 @@
+// SPDX-License-Identifier: MIT
+
+package sbot
+
+import (
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"go.cryptoscope.co/ssb/internal/leakcheck"
+	"go.cryptoscope.co/ssb/internal/testutils"
+	"go.cryptoscope.co/ssb/multilogs"
+)
+
+func TestSneakernet(t *testing.T) {
+	defer leakcheck.Check(t)
+	r, a := require.New(t), assert.New(t)
+
+	hk := make([]byte, 32)
+	_, err := rand.Read(hk)
+	r.NoError(err)
+
+	tRepoPath := filepath.Join("testrun", t.Name())
+	os.RemoveAll(tRepoPath)
+	drive := filepath.Join(tRepoPath, "drive")
+
+	logger := testutils.NewRelativeTimeLogger(nil)
+	mkBot := func(name string) *Sbot {
+		bot, err := New(
+			WithInfo(logger),
+			WithRepoPath(filepath.Join(tRepoPath, name)),
+			WithHMACSigning(hk),
+			WithListenAddr(":0"),
+		)
+		r.NoError(err)
+		return bot
+	}
+
+	ali, bob := mkBot("ali"), mkBot("bob")
+	ali.Replicate(bob.KeyPair.Id)
+	bob.Replicate(ali.KeyPair.Id)
+
+	publish := func(bot *Sbot, n int) {
+		for i := 0; i < n; i++ {
+			_, err := bot.PublishLog.Publish(map[string]interface{}{"type": "test", "i": i})
+			r.NoError(err)
+		}
+	}
+	publish(ali, 3)
+	publish(bob, 2)
+
+	blob, err := ali.BlobStore.Put(strings.NewReader("carried over"))
+	r.NoError(err)
+	r.NoError(bob.WantManager.Want(blob))
+
+	// ali brings her feed, bob takes it and leaves his and the want
+	sum, err := ali.SyncDir(drive)
+	r.NoError(err)
+	a.Equal(SyncSummary{Exported: 3}, sum)
+
+	sum, err = bob.SyncDir(drive)
+	r.NoError(err)
+	a.Equal(SyncSummary{Imported: ImportSummary{Messages: 3}, Exported: 2}, sum)
+
+	// ali gets bobs feed and brings the blob
+	sum, err = ali.SyncDir(drive)
+	r.NoError(err)
+	a.Equal(SyncSummary{Imported: ImportSummary{Messages: 2}, ExportedBlobs: 1}, sum)
+
+	// only the new messages are written the next time
+	publish(ali, 2)
+	sum, err = ali.SyncDir(drive)
+	r.NoError(err)
+	a.Equal(SyncSummary{Exported: 2}, sum)
+
+	sum, err = bob.SyncDir(drive)
+	r.NoError(err)
+	a.Equal(SyncSummary{Imported: ImportSummary{Messages: 2, Blobs: 1}}, sum)
+
+	_, err = bob.BlobStore.Get(blob)
+	r.NoError(err)
+
+	uf, ok := bob.GetMultiLog(multilogs.IndexNameFeeds)
+	r.True(ok)
+	latest, err := bob.latestStored(uf, ali.KeyPair.Id)
+	r.NoError(err)
+	r.NotNil(latest)
+	a.EqualValues(5, latest.Seq())
+
+	// nothing left to do
+	sum, err = bob.SyncDir(drive)
+	r.NoError(err)
+	a.Equal(SyncSummary{}, sum)
+
+	archives, err := filepath.Glob(filepath.Join(drive, sneakernetArchives, "*"+sneakernetExt))
+	r.NoError(err)
+	a.Len(archives, 3)
+
+	ali.Shutdown()
+	bob.Shutdown()
+	r.NoError(ali.Close())
+	r.NoError(bob.Close())
+}
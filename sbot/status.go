@@ -13,6 +13,7 @@ import (
 	"go.cryptoscope.co/margaret"
 	"go.cryptoscope.co/netwrap"
 	"go.cryptoscope.co/ssb"
+	"go.cryptoscope.co/ssb/peerstats"
 	multiserver "go.mindeco.de/ssb-multiserver"
 )
 
@@ -59,9 +60,27 @@ func (sbot *Sbot) Status() (ssb.Status, error) {
 	sort.Sort(byName(idxState))
 	s.Indicies = idxState
 
+	if sbot.Forks != nil {
+		proofs, err := sbot.Forks.List()
+		if err != nil {
+			return s, err
+		}
+		for _, p := range proofs {
+			s.Forked = append(s.Forked, p.Feed)
+		}
+	}
+
 	return s, nil
 }
 
+// PeerHistory returns the connection records of all the peers the bot knows about, the most recently seen first
+func (sbot *Sbot) PeerHistory() ([]peerstats.Peer, error) {
+	if sbot.PeerStats == nil {
+		return nil, errors.New("sbot: network disabled, no peer stats")
+	}
+	return sbot.PeerStats.All()
+}
+
 type byConnTime []ssb.EndpointStat
 
 func (bct byConnTime) Len() int { return len(bct) }